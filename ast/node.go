@@ -152,6 +152,7 @@ type CallNode struct {
 	base
 	Callee    Node   // Node of the call. Like "foo" in "foo()".
 	Arguments []Node // Arguments of the call.
+	Spread    bool   // If true then the last argument is spread. Like "xs" in "foo(xs...)".
 }
 
 // BuiltinNode represents a builtin function call.
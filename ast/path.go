@@ -0,0 +1,31 @@
+package ast
+
+// MemberPath returns the path of the env variable or its member, like
+// ["User", "Address", "City"] for User.Address.City. Variables accessed
+// through $env, like $env.User, start the path too. Member access with a
+// dynamic property, like User[key], is not a path.
+func MemberPath(node Node) ([]string, bool) {
+	switch n := node.(type) {
+	case *IdentifierNode:
+		if n.Value == "$env" {
+			return nil, false
+		}
+		return []string{n.Value}, true
+	case *MemberNode:
+		property, ok := n.Property.(*StringNode)
+		if !ok {
+			return nil, false
+		}
+		if id, ok := n.Node.(*IdentifierNode); ok && id.Value == "$env" {
+			return []string{property.Value}, true
+		}
+		path, ok := MemberPath(n.Node)
+		if !ok {
+			return nil, false
+		}
+		return append(path[:len(path):len(path)], property.Value), true
+	case *ChainNode:
+		return MemberPath(n.Node)
+	}
+	return nil, false
+}
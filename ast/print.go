@@ -151,6 +151,9 @@ func (n *CallNode) String() string {
 	for i, arg := range n.Arguments {
 		arguments[i] = arg.String()
 	}
+	if n.Spread {
+		arguments[len(arguments)-1] += "..."
+	}
 	return fmt.Sprintf("%s(%s)", n.Callee.String(), strings.Join(arguments, ", "))
 }
 
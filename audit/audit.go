@@ -0,0 +1,144 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/vm"
+)
+
+// Report is an auditable description of a compiled program. It is meant
+// to be archived together with every deployed version of an expression.
+type Report struct {
+	Source       string            `json:"source"`
+	Hash         string            `json:"hash"`
+	Schema       map[string]string `json:"schema"`
+	Capabilities []string          `json:"capabilities"`
+	Functions    []string          `json:"functions"`
+	Fields       []string          `json:"fields"`
+	Bytecode     []string          `json:"bytecode"`
+}
+
+// New collects audit information about the program: environment fields
+// the program reads together with their types, functions it calls and
+// builtins and operators it uses.
+func New(program *vm.Program) *Report {
+	r := &Report{
+		Source:       program.Source().String(),
+		Hash:         program.Hash(),
+		Schema:       make(map[string]string),
+		Capabilities: []string{},
+		Functions:    []string{},
+		Fields:       []string{},
+		Bytecode:     strings.Split(strings.TrimSpace(program.Disassemble()), "\n"),
+	}
+
+	node := program.Node()
+	if node == nil {
+		return r
+	}
+
+	c := &collector{
+		callees:      make(map[ast.Node]bool),
+		bases:        make(map[ast.Node]bool),
+		variables:    make(map[string]bool),
+		capabilities: make(map[string]bool),
+		functions:    make(map[string]bool),
+		fields:       make(map[string]bool),
+		schema:       r.Schema,
+	}
+	ast.Walk(&node, visitorFunc(c.prepare))
+	ast.Walk(&node, visitorFunc(c.collect))
+
+	r.Capabilities = sortedKeys(c.capabilities)
+	r.Functions = sortedKeys(c.functions)
+	r.Fields = sortedKeys(c.fields)
+	return r
+}
+
+// Export renders the program audit report as indented JSON.
+func Export(program *vm.Program) ([]byte, error) {
+	return json.MarshalIndent(New(program), "", "  ")
+}
+
+type visitorFunc func(node *ast.Node)
+
+func (fn visitorFunc) Visit(node *ast.Node) {
+	fn(node)
+}
+
+type collector struct {
+	callees      map[ast.Node]bool
+	bases        map[ast.Node]bool
+	variables    map[string]bool
+	capabilities map[string]bool
+	functions    map[string]bool
+	fields       map[string]bool
+	schema       map[string]string
+}
+
+func (c *collector) prepare(node *ast.Node) {
+	switch n := (*node).(type) {
+	case *ast.CallNode:
+		c.callees[n.Callee] = true
+	case *ast.MemberNode:
+		if _, ok := n.Property.(*ast.StringNode); ok {
+			c.bases[n.Node] = true
+		}
+	case *ast.VariableDeclaratorNode:
+		c.variables[n.Name] = true
+	}
+}
+
+func (c *collector) collect(node *ast.Node) {
+	switch n := (*node).(type) {
+	case *ast.CallNode:
+		if name, ok := c.path(n.Callee); ok {
+			c.functions[name] = true
+		}
+	case *ast.BuiltinNode:
+		c.capabilities[n.Name] = true
+	case *ast.BinaryNode:
+		switch n.Operator {
+		case "matches", "contains", "startsWith", "endsWith", "in", "..", "??":
+			c.capabilities[n.Operator] = true
+		}
+	case *ast.IdentifierNode, *ast.MemberNode:
+		if c.callees[n] || c.bases[n] {
+			// Only report full paths, like "User.Name", but not "User".
+			return
+		}
+		if name, ok := c.path(n); ok {
+			c.fields[name] = true
+			c.schema[name] = typeName(n)
+		}
+	}
+}
+
+// path returns dotted path of the env field, like "User.Address.City".
+func (c *collector) path(node ast.Node) (string, bool) {
+	path, ok := ast.MemberPath(node)
+	if !ok || c.variables[path[0]] {
+		return "", false
+	}
+	return strings.Join(path, "."), true
+}
+
+func typeName(node ast.Node) string {
+	if node.Type() == nil {
+		return "nil"
+	}
+	return fmt.Sprintf("%v", node.Type())
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,51 @@
+package audit_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/expr-lang/expr/internal/testify/assert"
+	"github.com/expr-lang/expr/internal/testify/require"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/audit"
+	"github.com/expr-lang/expr/test/mock"
+)
+
+func TestNew(t *testing.T) {
+	program, err := expr.Compile(`let x = Foo.Bar.Baz; x startsWith "a" && len(ArrayOfFoo) > 0 && Foo.Method().Baz != "" && Add(Int, 1) > 0`, expr.Env(mock.Env{}))
+	require.NoError(t, err)
+
+	r := audit.New(program)
+	assert.Equal(t, program.Source().String(), r.Source)
+	assert.Len(t, r.Hash, 64)
+	assert.Equal(t, []string{"len", "startsWith"}, r.Capabilities)
+	assert.Equal(t, []string{"Add", "Foo.Method"}, r.Functions)
+	assert.Equal(t, []string{"ArrayOfFoo", "Foo.Bar.Baz", "Int"}, r.Fields)
+	assert.Equal(t, map[string]string{
+		"Foo.Bar.Baz": "string",
+		"Int":         "int",
+		"ArrayOfFoo":  "[]*mock.Foo",
+	}, r.Schema)
+	assert.NotEmpty(t, r.Bytecode)
+}
+
+func TestExport(t *testing.T) {
+	program, err := expr.Compile(`user.Age >= 18`, expr.Env(map[string]any{
+		"user": map[string]any{"Age": 0},
+	}))
+	require.NoError(t, err)
+
+	b, err := audit.Export(program)
+	require.NoError(t, err)
+
+	var out map[string]any
+	require.NoError(t, json.Unmarshal(b, &out))
+	assert.Equal(t, "user.Age >= 18", out["source"])
+	assert.Equal(t, []any{"user.Age"}, out["fields"])
+	assert.Equal(t, map[string]any{"user.Age": "interface {}"}, out["schema"])
+
+	other, err := expr.Compile(`user.Age >= 21`)
+	require.NoError(t, err)
+	assert.NotEqual(t, audit.New(program).Hash, audit.New(other).Hash)
+}
@@ -0,0 +1,94 @@
+package expr
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/expr-lang/expr/vm"
+)
+
+// RunBatch evaluates the program against each env and returns the results in
+// the same order. All envs are evaluated on one virtual machine, which is
+// faster than calling Run for each env. The first error stops the batch and
+// is returned with the index of its env.
+func RunBatch[T any](program *vm.Program, envs []T, opts ...vm.Option) ([]any, error) {
+	if program == nil {
+		return nil, fmt.Errorf("program is nil")
+	}
+	results := make([]any, len(envs))
+	if err := runBatch(vm.New(opts...), program, envs, results, 0); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// RunBatchParallel is RunBatch which splits envs into contiguous shards and
+// evaluates them concurrently, each worker on its own virtual machine.
+// Programs are read-only, so they can be shared by workers. Programs compiled
+// with profiling are not, as runs update their spans, so they are rejected.
+// If workers is zero or less, GOMAXPROCS workers are used. If several envs
+// fail, the error of the first one is returned.
+func RunBatchParallel[T any](program *vm.Program, envs []T, workers int, opts ...vm.Option) ([]any, error) {
+	if program == nil {
+		return nil, fmt.Errorf("program is nil")
+	}
+	if vm.GetSpan(program) != nil {
+		return nil, fmt.Errorf("cannot run program with profiling in parallel")
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(envs) {
+		workers = len(envs)
+	}
+	results := make([]any, len(envs))
+	if workers <= 1 {
+		if err := runBatch(vm.New(opts...), program, envs, results, 0); err != nil {
+			return nil, err
+		}
+		return results, nil
+	}
+
+	size := (len(envs) + workers - 1) / workers
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		from := w * size
+		if from >= len(envs) {
+			break
+		}
+		to := from + size
+		if to > len(envs) {
+			to = len(envs)
+		}
+		wg.Add(1)
+		go func(w, from, to int) {
+			defer wg.Done()
+			errs[w] = runBatch(vm.New(opts...), program, envs[from:to], results[from:to], from)
+		}(w, from, to)
+	}
+	wg.Wait()
+
+	// Shards are in the order of envs, so the first error of the first
+	// failed shard is the first error of the batch.
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// runBatch evaluates envs into results; offset is the index of the first
+// env in the whole batch, which is reported in errors.
+func runBatch[T any](v *vm.VM, program *vm.Program, envs []T, results []any, offset int) error {
+	for i := range envs {
+		out, err := v.Run(program, envs[i])
+		if err != nil {
+			return fmt.Errorf("env %d: %w", offset+i, err)
+		}
+		results[i] = out
+	}
+	return nil
+}
@@ -455,6 +455,28 @@ func Benchmark_largeNestedArrayAccess(b *testing.B) {
 	require.True(b, out.(bool))
 }
 
+func Benchmark_deepStructAccess(b *testing.B) {
+	type Address struct{ City string }
+	type Customer struct{ Address *Address }
+	type Order struct{ Customer Customer }
+	type Env struct{ Order *Order }
+
+	program, err := expr.Compile(`Order.Customer.Address.City == "Paris"`, expr.Env(Env{}))
+	require.NoError(b, err)
+
+	env := Env{Order: &Order{Customer: Customer{Address: &Address{City: "Paris"}}}}
+
+	var out any
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		out, err = vm.Run(program, env)
+	}
+	b.StopTimer()
+
+	require.NoError(b, err)
+	require.True(b, out.(bool))
+}
+
 func Benchmark_sort(b *testing.B) {
 	env := map[string]any{
 		"arr": []any{55, 58, 42, 61, 75, 52, 64, 62, 16, 79, 40, 14, 50, 76, 23, 2, 5, 80, 89, 51, 21, 96, 91, 13, 71, 82, 65, 63, 11, 17, 94, 81, 74, 4, 97, 1, 39, 3, 28, 8, 84, 90, 47, 85, 7, 56, 49, 93, 33, 12, 19, 60, 86, 100, 44, 45, 36, 72, 95, 77, 34, 92, 24, 73, 18, 38, 43, 26, 41, 69, 67, 57, 9, 27, 66, 87, 46, 35, 59, 70, 10, 20, 53, 15, 32, 98, 68, 31, 54, 25, 83, 88, 22, 48, 29, 37, 6, 78, 99, 30},
@@ -527,3 +549,69 @@ func Benchmark_reduce(b *testing.B) {
 
 	require.Equal(b, 5050, out.(int))
 }
+
+func Benchmark_compileStructAccess(b *testing.B) {
+	type Inner struct {
+		Value int
+	}
+	type Outer struct {
+		Inner
+		Name string
+	}
+	type Env struct {
+		Outer Outer
+		Items []Outer
+	}
+
+	var err error
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_, err = expr.Compile(`Outer.Value > 0 && Outer.Name != "" && all(Items, .Value > 1)`, expr.Env(Env{}))
+	}
+	b.StopTimer()
+
+	require.NoError(b, err)
+}
+
+func Benchmark_concat(b *testing.B) {
+	env := map[string]any{
+		"first": "Ada",
+		"last":  "Lovelace",
+		"city":  "London",
+	}
+
+	program, err := expr.Compile(`"Dear " + first + " " + last + ", welcome to " + city + "!"`, expr.Env(env))
+	require.NoError(b, err)
+
+	var out any
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		out, err = vm.Run(program, env)
+	}
+	b.StopTimer()
+
+	require.NoError(b, err)
+	require.Equal(b, "Dear Ada Lovelace, welcome to London!", out)
+}
+
+func Benchmark_runBatch(b *testing.B) {
+	envs := make([]map[string]any, 1000)
+	for i := range envs {
+		envs[i] = map[string]any{"Value": i, "Country": "RU"}
+	}
+
+	program, err := expr.Compile(`Value >= 100 && Country == "RU"`, expr.Env(envs[0]))
+	require.NoError(b, err)
+
+	var out []any
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		out, err = expr.RunBatch(program, envs)
+	}
+	b.StopTimer()
+
+	require.NoError(b, err)
+	require.Len(b, out, len(envs))
+}
@@ -0,0 +1,39 @@
+package builtin
+
+import (
+	"github.com/expr-lang/expr/vm/runtime"
+)
+
+// WithAccess returns the implementation of the builtin which fetches fields
+// of structs by names with the access options of the program, like the tag
+// naming fields, or false if the builtin does not depend on the options.
+// Serializers, like toJSON(), reject values with denied members.
+func WithAccess(name string, access *runtime.Access) (func(args ...any) (any, error), bool) {
+	switch name {
+	case "toJSON", "string":
+		if !access.Restricted() {
+			return nil, false
+		}
+		fn := Builtins[Index[name]]
+		return func(args ...any) (any, error) {
+			access.CheckValue(args[0])
+			if fn.Fast != nil {
+				return fn.Fast(args[0]), nil
+			}
+			return fn.Func(args...)
+		}, true
+	case "get":
+		return func(args ...any) (any, error) {
+			return get(access, args...)
+		}, true
+	case "has":
+		return func(args ...any) (any, error) {
+			return has(access, args...)
+		}, true
+	case "pluck":
+		return func(args ...any) (any, error) {
+			return pluckArgs(access, args...)
+		}, true
+	}
+	return nil, false
+}
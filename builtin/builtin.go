@@ -1,11 +1,20 @@
 package builtin
 
 import (
+	"crypto/md5"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"math"
+	"net/netip"
+	"net/url"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -53,6 +62,16 @@ var Builtins = []*Function{
 		Predicate: true,
 		Types:     types(new(func([]any, func(any) bool) []any)),
 	},
+	{
+		Name:      "takeWhile",
+		Predicate: true,
+		Types:     types(new(func([]any, func(any) bool) []any)),
+	},
+	{
+		Name:      "dropWhile",
+		Predicate: true,
+		Types:     types(new(func([]any, func(any) bool) []any)),
+	},
 	{
 		Name:      "map",
 		Predicate: true,
@@ -93,6 +112,26 @@ var Builtins = []*Function{
 		Predicate: true,
 		Types:     types(new(func([]any, func(any) any) map[any][]any)),
 	},
+	{
+		Name:      "countBy",
+		Predicate: true,
+		Types:     types(new(func([]any, func(any) any) map[any]int)),
+	},
+	{
+		Name:      "mapValues",
+		Predicate: true,
+		Types:     types(new(func(map[any]any, func(any) any) map[any]any)),
+	},
+	{
+		Name:      "mapKeys",
+		Predicate: true,
+		Types:     types(new(func(map[any]any, func(any) any) map[any]any)),
+	},
+	{
+		Name:      "partition",
+		Predicate: true,
+		Types:     types(new(func([]any, func(any) bool) [][]any)),
+	},
 	{
 		Name:      "sortBy",
 		Predicate: true,
@@ -105,6 +144,7 @@ var Builtins = []*Function{
 	},
 	{
 		Name: "len",
+		Pure: true,
 		Fast: Len,
 		Validate: func(args []reflect.Type) (reflect.Type, error) {
 			if len(args) != 1 {
@@ -122,13 +162,22 @@ var Builtins = []*Function{
 		Fast:  Type,
 		Types: types(new(func(any) string)),
 	},
+	{
+		Name:  "kind",
+		Fast:  Kind,
+		Types: types(new(func(any) string)),
+	},
 	{
 		Name: "abs",
+		Pure: true,
 		Fast: Abs,
 		Validate: func(args []reflect.Type) (reflect.Type, error) {
 			if len(args) != 1 {
 				return anyType, fmt.Errorf("invalid number of arguments (expected 1, got %d)", len(args))
 			}
+			if args[0] == bigIntType {
+				return bigIntType, nil
+			}
 			switch kind(args[0]) {
 			case reflect.Float32, reflect.Float64, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Interface:
 				return args[0], nil
@@ -138,6 +187,7 @@ var Builtins = []*Function{
 	},
 	{
 		Name: "ceil",
+		Pure: true,
 		Fast: Ceil,
 		Validate: func(args []reflect.Type) (reflect.Type, error) {
 			return validateRoundFunc("ceil", args)
@@ -145,6 +195,7 @@ var Builtins = []*Function{
 	},
 	{
 		Name: "floor",
+		Pure: true,
 		Fast: Floor,
 		Validate: func(args []reflect.Type) (reflect.Type, error) {
 			return validateRoundFunc("floor", args)
@@ -152,6 +203,7 @@ var Builtins = []*Function{
 	},
 	{
 		Name: "round",
+		Pure: true,
 		Fast: Round,
 		Validate: func(args []reflect.Type) (reflect.Type, error) {
 			return validateRoundFunc("round", args)
@@ -159,11 +211,15 @@ var Builtins = []*Function{
 	},
 	{
 		Name: "int",
+		Pure: true,
 		Fast: Int,
 		Validate: func(args []reflect.Type) (reflect.Type, error) {
 			if len(args) != 1 {
 				return anyType, fmt.Errorf("invalid number of arguments (expected 1, got %d)", len(args))
 			}
+			if args[0] == bigIntType {
+				return integerType, nil
+			}
 			switch kind(args[0]) {
 			case reflect.Interface:
 				return integerType, nil
@@ -177,11 +233,15 @@ var Builtins = []*Function{
 	},
 	{
 		Name: "float",
+		Pure: true,
 		Fast: Float,
 		Validate: func(args []reflect.Type) (reflect.Type, error) {
 			if len(args) != 1 {
 				return anyType, fmt.Errorf("invalid number of arguments (expected 1, got %d)", len(args))
 			}
+			if args[0] == bigIntType {
+				return floatType, nil
+			}
 			switch kind(args[0]) {
 			case reflect.Interface:
 				return floatType, nil
@@ -193,13 +253,41 @@ var Builtins = []*Function{
 			return anyType, fmt.Errorf("invalid argument for float (type %s)", args[0])
 		},
 	},
+	{
+		Name: "bigint",
+		Pure: true,
+		Fast: BigInt,
+		Validate: func(args []reflect.Type) (reflect.Type, error) {
+			if len(args) != 1 {
+				return anyType, fmt.Errorf("invalid number of arguments (expected 1, got %d)", len(args))
+			}
+			if args[0] == bigIntType {
+				return bigIntType, nil
+			}
+			switch kind(args[0]) {
+			case reflect.Interface, reflect.String:
+				return bigIntType, nil
+			case reflect.Float32, reflect.Float64, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				return bigIntType, nil
+			}
+			return anyType, fmt.Errorf("invalid argument for bigint (type %s)", args[0])
+		},
+	},
+	{
+		Name:  "bool",
+		Pure:  true,
+		Fast:  Bool,
+		Types: types(new(func(any any) bool)),
+	},
 	{
 		Name:  "string",
+		Pure:  true,
 		Fast:  String,
 		Types: types(new(func(any any) string)),
 	},
 	{
 		Name: "trim",
+		Pure: true,
 		Func: func(args ...any) (any, error) {
 			if len(args) == 1 {
 				return strings.TrimSpace(args[0].(string)), nil
@@ -216,6 +304,7 @@ var Builtins = []*Function{
 	},
 	{
 		Name: "trimPrefix",
+		Pure: true,
 		Func: func(args ...any) (any, error) {
 			s := " "
 			if len(args) == 2 {
@@ -230,6 +319,7 @@ var Builtins = []*Function{
 	},
 	{
 		Name: "trimSuffix",
+		Pure: true,
 		Func: func(args ...any) (any, error) {
 			s := " "
 			if len(args) == 2 {
@@ -244,6 +334,7 @@ var Builtins = []*Function{
 	},
 	{
 		Name: "upper",
+		Pure: true,
 		Fast: func(arg any) any {
 			return strings.ToUpper(arg.(string))
 		},
@@ -251,6 +342,7 @@ var Builtins = []*Function{
 	},
 	{
 		Name: "lower",
+		Pure: true,
 		Fast: func(arg any) any {
 			return strings.ToLower(arg.(string))
 		},
@@ -258,6 +350,7 @@ var Builtins = []*Function{
 	},
 	{
 		Name: "split",
+		Pure: true,
 		Func: func(args ...any) (any, error) {
 			if len(args) == 2 {
 				return strings.Split(args[0].(string), args[1].(string)), nil
@@ -274,6 +367,7 @@ var Builtins = []*Function{
 	},
 	{
 		Name: "splitAfter",
+		Pure: true,
 		Func: func(args ...any) (any, error) {
 			if len(args) == 2 {
 				return strings.SplitAfter(args[0].(string), args[1].(string)), nil
@@ -290,6 +384,7 @@ var Builtins = []*Function{
 	},
 	{
 		Name: "replace",
+		Pure: true,
 		Func: func(args ...any) (any, error) {
 			if len(args) == 4 {
 				return strings.Replace(args[0].(string), args[1].(string), args[2].(string), runtime.ToInt(args[3])), nil
@@ -319,8 +414,29 @@ var Builtins = []*Function{
 		},
 		Types: types(strings.Repeat),
 	},
+	{
+		Name: "padLeft",
+		Safe: func(args ...any) (any, uint, error) {
+			return pad("padLeft", args, true)
+		},
+		Types: types(
+			new(func(string, int) string),
+			new(func(string, int, string) string),
+		),
+	},
+	{
+		Name: "padRight",
+		Safe: func(args ...any) (any, uint, error) {
+			return pad("padRight", args, false)
+		},
+		Types: types(
+			new(func(string, int) string),
+			new(func(string, int, string) string),
+		),
+	},
 	{
 		Name: "join",
+		Pure: true,
 		Func: func(args ...any) (any, error) {
 			glue := ""
 			if len(args) == 2 {
@@ -348,6 +464,7 @@ var Builtins = []*Function{
 	},
 	{
 		Name: "indexOf",
+		Pure: true,
 		Func: func(args ...any) (any, error) {
 			return strings.Index(args[0].(string), args[1].(string)), nil
 		},
@@ -355,6 +472,7 @@ var Builtins = []*Function{
 	},
 	{
 		Name: "lastIndexOf",
+		Pure: true,
 		Func: func(args ...any) (any, error) {
 			return strings.LastIndex(args[0].(string), args[1].(string)), nil
 		},
@@ -362,6 +480,7 @@ var Builtins = []*Function{
 	},
 	{
 		Name: "hasPrefix",
+		Pure: true,
 		Func: func(args ...any) (any, error) {
 			return strings.HasPrefix(args[0].(string), args[1].(string)), nil
 		},
@@ -369,13 +488,51 @@ var Builtins = []*Function{
 	},
 	{
 		Name: "hasSuffix",
+		Pure: true,
 		Func: func(args ...any) (any, error) {
 			return strings.HasSuffix(args[0].(string), args[1].(string)), nil
 		},
 		Types: types(strings.HasSuffix),
 	},
+	matchAny("containsAny", (*Matcher).Contains),
+	matchAny("startsWithAny", (*Matcher).HasPrefix),
+	matchAny("endsWithAny", (*Matcher).HasSuffix),
+	{
+		Name: "format",
+		Func: func(args ...any) (any, error) {
+			return fmt.Sprintf(args[0].(string), args[1:]...), nil
+		},
+		Types: types(new(func(string, ...any) string)),
+	},
+	{
+		Name: "findAll",
+		Func: func(args ...any) (any, error) {
+			re, err := toRegexp(args[1])
+			if err != nil {
+				return nil, err
+			}
+			matches := re.FindAllString(args[0].(string), -1)
+			if matches == nil {
+				return []string{}, nil
+			}
+			return matches, nil
+		},
+		Types: types(new(func(string, string) []string)),
+	},
+	{
+		Name: "matchGroups",
+		Func: func(args ...any) (any, error) {
+			re, err := toRegexp(args[1])
+			if err != nil {
+				return nil, err
+			}
+			return matchGroups(re, args[0].(string)), nil
+		},
+		Types: types(new(func(string, string) map[string]string)),
+	},
 	{
 		Name: "max",
+		Pure: true,
 		Func: func(args ...any) (any, error) {
 			return minMax("max", runtime.Less, args...)
 		},
@@ -385,6 +542,7 @@ var Builtins = []*Function{
 	},
 	{
 		Name: "min",
+		Pure: true,
 		Func: func(args ...any) (any, error) {
 			return minMax("min", runtime.More, args...)
 		},
@@ -392,8 +550,152 @@ var Builtins = []*Function{
 			return validateAggregateFunc("min", args)
 		},
 	},
+	{
+		Name: "clamp",
+		Pure: true,
+		Func: func(args ...any) (any, error) {
+			if len(args) != 3 {
+				return nil, fmt.Errorf("invalid number of arguments (expected 3, got %d)", len(args))
+			}
+			x, lo, hi := args[0], args[1], args[2]
+			if runtime.Less(hi, lo) {
+				return nil, fmt.Errorf("invalid argument for clamp (lower bound %v is greater than upper bound %v)", lo, hi)
+			}
+			out := x
+			if runtime.Less(x, lo) {
+				out = lo
+			} else if runtime.More(x, hi) {
+				out = hi
+			}
+			// Result has the same type regardless of which argument was returned.
+			t := reflect.TypeOf(x)
+			if t == reflect.TypeOf(lo) && t == reflect.TypeOf(hi) {
+				return out, nil
+			}
+			if isFloat(reflect.TypeOf(x)) || isFloat(reflect.TypeOf(lo)) || isFloat(reflect.TypeOf(hi)) {
+				return runtime.ToFloat64(out), nil
+			}
+			return runtime.ToInt(out), nil
+		},
+		Validate: func(args []reflect.Type) (reflect.Type, error) {
+			if len(args) != 3 {
+				return anyType, fmt.Errorf("invalid number of arguments (expected 3, got %d)", len(args))
+			}
+			if err := validateNumbers("clamp", args); err != nil {
+				return anyType, err
+			}
+			switch {
+			case isAny(args[0]) || isAny(args[1]) || isAny(args[2]):
+				return anyType, nil
+			case args[0] == args[1] && args[0] == args[2]:
+				return args[0], nil
+			case isFloat(args[0]) || isFloat(args[1]) || isFloat(args[2]):
+				return floatType, nil
+			}
+			return integerType, nil
+		},
+	},
+	{
+		Name: "inRange",
+		Pure: true,
+		Func: func(args ...any) (any, error) {
+			if len(args) != 3 {
+				return nil, fmt.Errorf("invalid number of arguments (expected 3, got %d)", len(args))
+			}
+			return runtime.LessOrEqual(args[1], args[0]) && runtime.LessOrEqual(args[0], args[2]), nil
+		},
+		Validate: func(args []reflect.Type) (reflect.Type, error) {
+			if len(args) != 3 {
+				return anyType, fmt.Errorf("invalid number of arguments (expected 3, got %d)", len(args))
+			}
+			if err := validateNumbers("inRange", args); err != nil {
+				return anyType, err
+			}
+			return boolType, nil
+		},
+	},
+	{
+		Name: "parseInt",
+		Pure: true,
+		Func: func(args ...any) (any, error) {
+			base := 10
+			if len(args) == 2 {
+				base = args[1].(int)
+			}
+			i, err := strconv.ParseInt(args[0].(string), base, 64)
+			if err != nil {
+				return nil, err
+			}
+			return int(i), nil
+		},
+		Types: types(
+			new(func(string) int),
+			new(func(string, int) int),
+		),
+	},
+	{
+		Name: "parseFloat",
+		Pure: true,
+		Func: func(args ...any) (any, error) {
+			if len(args) == 2 {
+				return parseFloatLocale(args[0].(string), args[1].(string))
+			}
+			return strconv.ParseFloat(strings.TrimSpace(args[0].(string)), 64)
+		},
+		Types: types(
+			new(func(string) float64),
+			new(func(string, string) float64),
+		),
+	},
+	{
+		Name: "formatNumber",
+		Func: func(args ...any) (any, error) {
+			if len(args) != 2 && len(args) != 3 {
+				return nil, fmt.Errorf("invalid number of arguments (expected 2 or 3, got %d)", len(args))
+			}
+			var n float64
+			switch x := args[0].(type) {
+			case float32, float64, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+				n = runtime.ToFloat64(x)
+			default:
+				return nil, fmt.Errorf("invalid argument for formatNumber (type %T)", args[0])
+			}
+			pattern, ok := args[1].(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid argument for formatNumber (type %T)", args[1])
+			}
+			locale := "en"
+			if len(args) == 3 {
+				if locale, ok = args[2].(string); !ok {
+					return nil, fmt.Errorf("invalid argument for formatNumber (type %T)", args[2])
+				}
+			}
+			return formatNumber(n, pattern, locale)
+		},
+		Validate: func(args []reflect.Type) (reflect.Type, error) {
+			if len(args) != 2 && len(args) != 3 {
+				return anyType, fmt.Errorf("invalid number of arguments (expected 2 or 3, got %d)", len(args))
+			}
+			switch kind(args[0]) {
+			case reflect.Interface, reflect.Float32, reflect.Float64,
+				reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			default:
+				return anyType, fmt.Errorf("invalid argument for formatNumber (type %s)", args[0])
+			}
+			for _, arg := range args[1:] {
+				switch kind(arg) {
+				case reflect.Interface, reflect.String:
+				default:
+					return anyType, fmt.Errorf("invalid argument for formatNumber (type %s)", arg)
+				}
+			}
+			return reflect.TypeOf(""), nil
+		},
+	},
 	{
 		Name: "mean",
+		Pure: true,
 		Func: func(args ...any) (any, error) {
 			count, sum, err := mean(args...)
 			if err != nil {
@@ -410,8 +712,9 @@ var Builtins = []*Function{
 	},
 	{
 		Name: "median",
+		Pure: true,
 		Func: func(args ...any) (any, error) {
-			values, err := median(args...)
+			values, err := floats("median", args...)
 			if err != nil {
 				return nil, err
 			}
@@ -428,6 +731,76 @@ var Builtins = []*Function{
 			return validateAggregateFunc("median", args)
 		},
 	},
+	{
+		Name: "percentile",
+		Func: func(args ...any) (any, error) {
+			if len(args) != 2 {
+				return nil, fmt.Errorf("invalid number of arguments (expected 2, got %d)", len(args))
+			}
+			values, err := floats("percentile", args[0])
+			if err != nil {
+				return nil, err
+			}
+			var p float64
+			switch x := args[1].(type) {
+			case float32, float64, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+				p = runtime.ToFloat64(x)
+			default:
+				return nil, fmt.Errorf("invalid argument for percentile (type %T)", args[1])
+			}
+			if math.IsNaN(p) || p < 0 || p > 100 {
+				return nil, fmt.Errorf("invalid argument for percentile (p must be between 0 and 100, got %v)", p)
+			}
+			sort.Float64s(values)
+			return percentile(values, p), nil
+		},
+		Validate: func(args []reflect.Type) (reflect.Type, error) {
+			if len(args) != 2 {
+				return anyType, fmt.Errorf("invalid number of arguments (expected 2, got %d)", len(args))
+			}
+			switch kind(deref.Type(args[0])) {
+			case reflect.Interface, reflect.Array, reflect.Slice:
+			default:
+				return anyType, fmt.Errorf("invalid argument for percentile (type %s)", args[0])
+			}
+			if err := validateNumbers("percentile", args[1:]); err != nil {
+				return anyType, err
+			}
+			return floatType, nil
+		},
+	},
+	{
+		Name: "variance",
+		Func: func(args ...any) (any, error) {
+			values, err := floats("variance", args...)
+			if err != nil {
+				return nil, err
+			}
+			return variance(values), nil
+		},
+		Validate: func(args []reflect.Type) (reflect.Type, error) {
+			if _, err := validateAggregateFunc("variance", args); err != nil {
+				return anyType, err
+			}
+			return floatType, nil
+		},
+	},
+	{
+		Name: "stddev",
+		Func: func(args ...any) (any, error) {
+			values, err := floats("stddev", args...)
+			if err != nil {
+				return nil, err
+			}
+			return math.Sqrt(variance(values)), nil
+		},
+		Validate: func(args []reflect.Type) (reflect.Type, error) {
+			if _, err := validateAggregateFunc("stddev", args); err != nil {
+				return anyType, err
+			}
+			return floatType, nil
+		},
+	},
 	{
 		Name: "toJSON",
 		Func: func(args ...any) (any, error) {
@@ -453,6 +826,7 @@ var Builtins = []*Function{
 	},
 	{
 		Name: "toBase64",
+		Pure: true,
 		Func: func(args ...any) (any, error) {
 			return base64.StdEncoding.EncodeToString([]byte(args[0].(string))), nil
 		},
@@ -460,6 +834,7 @@ var Builtins = []*Function{
 	},
 	{
 		Name: "fromBase64",
+		Pure: true,
 		Func: func(args ...any) (any, error) {
 			b, err := base64.StdEncoding.DecodeString(args[0].(string))
 			if err != nil {
@@ -469,6 +844,179 @@ var Builtins = []*Function{
 		},
 		Types: types(new(func(string) string)),
 	},
+	{
+		Name: "toHex",
+		Pure: true,
+		Func: func(args ...any) (any, error) {
+			return hex.EncodeToString([]byte(args[0].(string))), nil
+		},
+		Types: types(new(func(string) string)),
+	},
+	{
+		Name: "fromHex",
+		Pure: true,
+		Func: func(args ...any) (any, error) {
+			b, err := hex.DecodeString(args[0].(string))
+			if err != nil {
+				return nil, err
+			}
+			return string(b), nil
+		},
+		Types: types(new(func(string) string)),
+	},
+	{
+		Name: "urlEncode",
+		Pure: true,
+		Func: func(args ...any) (any, error) {
+			return url.QueryEscape(args[0].(string)), nil
+		},
+		Types: types(new(func(string) string)),
+	},
+	{
+		Name: "urlDecode",
+		Pure: true,
+		Func: func(args ...any) (any, error) {
+			return url.QueryUnescape(args[0].(string))
+		},
+		Types: types(new(func(string) string)),
+	},
+	{
+		Name: "parseURL",
+		Func: func(args ...any) (any, error) {
+			return parseURL(args[0].(string))
+		},
+		Types: types(new(func(string) map[string]any)),
+	},
+	{
+		Name: "ip",
+		Pure: true,
+		Func: func(args ...any) (any, error) {
+			return netip.ParseAddr(args[0].(string))
+		},
+		Types: types(new(func(string) netip.Addr)),
+	},
+	{
+		// Constant networks are parsed by the compiler.
+		Name: "cidr",
+		Pure: true,
+		Func: func(args ...any) (any, error) {
+			return ParseCIDR(args[0].(string))
+		},
+		Types: types(new(func(string) CIDR)),
+	},
+	{
+		Name: "isPrivateIP",
+		Func: func(args ...any) (any, error) {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("invalid number of arguments (expected 1, got %d)", len(args))
+			}
+			addr, err := toAddr("isPrivateIP", args[0])
+			if err != nil {
+				return nil, err
+			}
+			return addr.IsPrivate(), nil
+		},
+		Validate: func(args []reflect.Type) (reflect.Type, error) {
+			if len(args) != 1 {
+				return anyType, fmt.Errorf("invalid number of arguments (expected 1, got %d)", len(args))
+			}
+			if err := validateAddrs("isPrivateIP", args); err != nil {
+				return anyType, err
+			}
+			return boolType, nil
+		},
+	},
+	{
+		Name: "ipBetween",
+		Func: func(args ...any) (any, error) {
+			if len(args) != 3 {
+				return nil, fmt.Errorf("invalid number of arguments (expected 3, got %d)", len(args))
+			}
+			var addrs [3]netip.Addr
+			for i, arg := range args {
+				addr, err := toAddr("ipBetween", arg)
+				if err != nil {
+					return nil, err
+				}
+				addrs[i] = addr
+			}
+			if addrs[0].BitLen() != addrs[1].BitLen() || addrs[0].BitLen() != addrs[2].BitLen() {
+				return false, nil
+			}
+			return addrs[0].Compare(addrs[1]) >= 0 && addrs[0].Compare(addrs[2]) <= 0, nil
+		},
+		Validate: func(args []reflect.Type) (reflect.Type, error) {
+			if len(args) != 3 {
+				return anyType, fmt.Errorf("invalid number of arguments (expected 3, got %d)", len(args))
+			}
+			if err := validateAddrs("ipBetween", args); err != nil {
+				return anyType, err
+			}
+			return boolType, nil
+		},
+	},
+	{
+		Name: "semver",
+		Pure: true,
+		Func: func(args ...any) (any, error) {
+			return ParseVersion(args[0].(string))
+		},
+		Types: types(new(func(string) Version)),
+	},
+	{
+		Name: "semverSatisfies",
+		Pure: true,
+		Func: func(args ...any) (any, error) {
+			v, ok := args[0].(Version)
+			if !ok {
+				var err error
+				if v, err = ParseVersion(args[0].(string)); err != nil {
+					return nil, err
+				}
+			}
+			return satisfies(v, args[1].(string))
+		},
+		Types: types(
+			new(func(string, string) bool),
+			new(func(Version, string) bool),
+		),
+	},
+	{
+		Name: "sha256",
+		Pure: true,
+		Fast: func(arg any) any {
+			sum := sha256.Sum256(hashInput(arg))
+			return hex.EncodeToString(sum[:])
+		},
+		Types: types(new(func(any) string)),
+	},
+	{
+		Name: "md5",
+		Pure: true,
+		Fast: func(arg any) any {
+			sum := md5.Sum(hashInput(arg))
+			return hex.EncodeToString(sum[:])
+		},
+		Types: types(new(func(any) string)),
+	},
+	{
+		Name: "fnv",
+		Pure: true,
+		Fast: func(arg any) any {
+			h := fnv.New32a()
+			h.Write(hashInput(arg))
+			return int(h.Sum32())
+		},
+		Types: types(new(func(any) int)),
+	},
+	{
+		Name: "crc32",
+		Pure: true,
+		Fast: func(arg any) any {
+			return int(crc32.ChecksumIEEE(hashInput(arg)))
+		},
+		Types: types(new(func(any) int)),
+	},
 	{
 		Name: "now",
 		Func: func(args ...any) (any, error) {
@@ -494,8 +1042,129 @@ var Builtins = []*Function{
 			return anyType, fmt.Errorf("invalid number of arguments (expected 0, got %d)", len(args))
 		},
 	},
+	{
+		Name: "uuid",
+		Func: func(args ...any) (any, error) {
+			r, args := randArgs(args)
+			if len(args) != 0 {
+				return nil, fmt.Errorf("invalid number of arguments (expected 0, got %d)", len(args))
+			}
+			return uuid(r), nil
+		},
+		Validate: func(args []reflect.Type) (reflect.Type, error) {
+			args = randTypes(args)
+			if len(args) != 0 {
+				return anyType, fmt.Errorf("invalid number of arguments (expected 0, got %d)", len(args))
+			}
+			return reflect.TypeOf(""), nil
+		},
+	},
+	{
+		Name: "random",
+		Func: func(args ...any) (any, error) {
+			r, args := randArgs(args)
+			switch len(args) {
+			case 0:
+				return r.Float64(), nil
+			case 1:
+				n, ok := args[0].(int)
+				if !ok {
+					return nil, fmt.Errorf("invalid argument for random (type %T)", args[0])
+				}
+				if n <= 0 {
+					return nil, fmt.Errorf("invalid argument for random (n must be positive, got %d)", n)
+				}
+				return r.Intn(n), nil
+			}
+			return nil, fmt.Errorf("invalid number of arguments (expected 0 or 1, got %d)", len(args))
+		},
+		Validate: func(args []reflect.Type) (reflect.Type, error) {
+			args = randTypes(args)
+			switch len(args) {
+			case 0:
+				return floatType, nil
+			case 1:
+				switch kind(args[0]) {
+				case reflect.Interface, reflect.Int:
+					return integerType, nil
+				}
+				return anyType, fmt.Errorf("invalid argument for random (type %s)", args[0])
+			}
+			return anyType, fmt.Errorf("invalid number of arguments (expected 0 or 1, got %d)", len(args))
+		},
+	},
+	{
+		Name: "shuffle",
+		Func: func(args ...any) (any, error) {
+			r, args := randArgs(args)
+			if len(args) != 1 {
+				return nil, fmt.Errorf("invalid number of arguments (expected 1, got %d)", len(args))
+			}
+			out, err := shuffled("shuffle", r, args[0])
+			if err != nil {
+				return nil, err
+			}
+			return out.Interface(), nil
+		},
+		Validate: func(args []reflect.Type) (reflect.Type, error) {
+			args = randTypes(args)
+			if len(args) != 1 {
+				return anyType, fmt.Errorf("invalid number of arguments (expected 1, got %d)", len(args))
+			}
+			switch kind(args[0]) {
+			case reflect.Interface:
+				return arrayType, nil
+			case reflect.Slice, reflect.Array:
+				return reflect.SliceOf(args[0].Elem()), nil
+			}
+			return anyType, fmt.Errorf("invalid argument for shuffle (type %s)", args[0])
+		},
+	},
+	{
+		Name: "sample",
+		Func: func(args ...any) (any, error) {
+			r, args := randArgs(args)
+			if len(args) != 2 {
+				return nil, fmt.Errorf("invalid number of arguments (expected 2, got %d)", len(args))
+			}
+			n, ok := args[1].(int)
+			if !ok {
+				return nil, fmt.Errorf("invalid argument for sample (type %T)", args[1])
+			}
+			if n < 0 {
+				return nil, fmt.Errorf("invalid argument for sample (n must not be negative, got %d)", n)
+			}
+			out, err := shuffled("sample", r, args[0])
+			if err != nil {
+				return nil, err
+			}
+			if n > out.Len() {
+				n = out.Len()
+			}
+			return out.Slice(0, n).Interface(), nil
+		},
+		Validate: func(args []reflect.Type) (reflect.Type, error) {
+			args = randTypes(args)
+			if len(args) != 2 {
+				return anyType, fmt.Errorf("invalid number of arguments (expected 2, got %d)", len(args))
+			}
+			switch kind(args[1]) {
+			case reflect.Interface, reflect.Int:
+			default:
+				return anyType, fmt.Errorf("invalid argument for sample (type %s)", args[1])
+			}
+			switch kind(args[0]) {
+			case reflect.Interface:
+				return arrayType, nil
+			case reflect.Slice, reflect.Array:
+				return reflect.SliceOf(args[0].Elem()), nil
+			}
+			return anyType, fmt.Errorf("invalid argument for sample (type %s)", args[0])
+		},
+	},
 	{
 		Name: "duration",
+		Pure: true,
 		Func: func(args ...any) (any, error) {
 			return time.ParseDuration(args[0].(string))
 		},
@@ -579,6 +1248,27 @@ var Builtins = []*Function{
 		},
 		Types: types(time.LoadLocation),
 	},
+	{
+		Name: "dateFormat",
+		Func: func(args ...any) (any, error) {
+			return args[0].(time.Time).Format(args[1].(string)), nil
+		},
+		Types: types(new(func(time.Time, string) string)),
+	},
+	{
+		Name: "dateAdd",
+		Func: func(args ...any) (any, error) {
+			return dateAdd(args[0].(time.Time), runtime.ToInt(args[1]), args[2].(string))
+		},
+		Types: types(new(func(time.Time, int, string) time.Time)),
+	},
+	{
+		Name: "startOf",
+		Func: func(args ...any) (any, error) {
+			return startOf(args[0].(time.Time), args[1].(string))
+		},
+		Types: types(new(func(time.Time, string) time.Time)),
+	},
 	{
 		Name: "first",
 		Func: func(args ...any) (any, error) {
@@ -627,15 +1317,73 @@ var Builtins = []*Function{
 	},
 	{
 		Name: "get",
-		Func: func(args ...any) (out any, err error) {
-			defer func() {
-				if r := recover(); r != nil {
-					return
+		Func: func(args ...any) (any, error) {
+			return get(nil, args...)
+		},
+	},
+	{
+		Name: "has",
+		Func: func(args ...any) (any, error) {
+			return has(nil, args...)
+		},
+		Validate: func(args []reflect.Type) (reflect.Type, error) {
+			if len(args) != 2 {
+				return anyType, fmt.Errorf("invalid number of arguments (expected 2, got %d)", len(args))
+			}
+			switch kind(args[1]) {
+			case reflect.Interface, reflect.String, reflect.Int:
+				return boolType, nil
+			}
+			return anyType, fmt.Errorf("invalid argument for has (type %s)", args[1])
+		},
+	},
+	{
+		Name: "hasKey",
+		Func: func(args ...any) (any, error) {
+			if len(args) != 2 {
+				return nil, fmt.Errorf("invalid number of arguments (expected 2, got %d)", len(args))
+			}
+			v := deref.Value(reflect.ValueOf(args[0]))
+			if v.Kind() != reflect.Map {
+				return nil, fmt.Errorf("invalid argument for hasKey (type %T)", args[0])
+			}
+			_, ok := fetchSegment(nil, v.Interface(), args[1])
+			return ok, nil
+		},
+		Validate: func(args []reflect.Type) (reflect.Type, error) {
+			if len(args) != 2 {
+				return anyType, fmt.Errorf("invalid number of arguments (expected 2, got %d)", len(args))
+			}
+			switch kind(deref.Type(args[0])) {
+			case reflect.Interface, reflect.Map:
+				return boolType, nil
+			}
+			return anyType, fmt.Errorf("invalid argument for hasKey (type %s)", args[0])
+		},
+	},
+	{
+		// Compiled by the compiler into short-circuit jumps, so arguments
+		// after the first non-nil one are not evaluated.
+		Name: "coalesce",
+		Func: func(args ...any) (any, error) {
+			for _, arg := range args {
+				if !runtime.IsNil(arg) {
+					return arg, nil
 				}
-			}()
-			return runtime.Fetch(args[0], args[1]), nil
+			}
+			return nil, nil
 		},
 	},
+	{
+		// Compiled by the compiler, as it needs to parse the expression.
+		Name:  "references",
+		Types: types(new(func(string) []string)),
+	},
+	{
+		// Compiled by the compiler, as it needs the config and the env.
+		Name:  "unquote",
+		Types: types(new(func(string) any)),
+	},
 	{
 		Name: "take",
 		Func: func(args ...any) (any, error) {
@@ -672,6 +1420,55 @@ var Builtins = []*Function{
 			return args[0], nil
 		},
 	},
+	{
+		Name: "chunk",
+		Func: func(args ...any) (any, error) {
+			if len(args) != 2 {
+				return nil, fmt.Errorf("invalid number of arguments (expected 2, got %d)", len(args))
+			}
+			v := reflect.ValueOf(args[0])
+			if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+				return nil, fmt.Errorf("cannot chunk %s", v.Kind())
+			}
+			size := runtime.ToInt(args[1])
+			if size <= 0 {
+				return nil, fmt.Errorf("invalid argument for chunk (expected positive integer, got %d)", size)
+			}
+			if v.Kind() == reflect.Array {
+				array := reflect.MakeSlice(reflect.SliceOf(v.Type().Elem()), v.Len(), v.Len())
+				reflect.Copy(array, v)
+				v = array
+			}
+			chunks := reflect.MakeSlice(reflect.SliceOf(v.Type()), 0, (v.Len()+size-1)/size)
+			for i := 0; i < v.Len(); i += size {
+				end := i + size
+				if end > v.Len() {
+					end = v.Len()
+				}
+				chunks = reflect.Append(chunks, v.Slice3(i, end, end))
+			}
+			return chunks.Interface(), nil
+		},
+		Validate: func(args []reflect.Type) (reflect.Type, error) {
+			if len(args) != 2 {
+				return anyType, fmt.Errorf("invalid number of arguments (expected 2, got %d)", len(args))
+			}
+			switch kind(args[1]) {
+			case reflect.Interface, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			default:
+				return anyType, fmt.Errorf("cannot chunk by %s", args[1])
+			}
+			switch kind(args[0]) {
+			case reflect.Interface:
+				return anyType, nil
+			case reflect.Slice:
+				return reflect.SliceOf(args[0]), nil
+			case reflect.Array:
+				return reflect.SliceOf(reflect.SliceOf(args[0].Elem())), nil
+			}
+			return anyType, fmt.Errorf("cannot chunk %s", args[0])
+		},
+	},
 	{
 		Name: "keys",
 		Func: func(args ...any) (any, error) {
@@ -796,6 +1593,57 @@ var Builtins = []*Function{
 			return anyType, fmt.Errorf("cannot transform %s from pairs", args[0])
 		},
 	},
+	{
+		Name: "merge",
+		Func: func(args ...any) (any, error) {
+			if len(args) != 2 && len(args) != 3 {
+				return nil, fmt.Errorf("invalid number of arguments (expected 2 or 3, got %d)", len(args))
+			}
+			appendSlices := false
+			if len(args) == 3 {
+				switch args[2] {
+				case "replace":
+				case "append":
+					appendSlices = true
+				default:
+					return nil, fmt.Errorf("unknown slice strategy %v for merge (use replace or append)", args[2])
+				}
+			}
+			for _, arg := range args[:2] {
+				if arg != nil && deref.Value(reflect.ValueOf(arg)).Kind() != reflect.Map {
+					return nil, fmt.Errorf("invalid argument for merge (type %T)", arg)
+				}
+			}
+			return mergeMaps(args[0], args[1], appendSlices), nil
+		},
+		Validate: func(args []reflect.Type) (reflect.Type, error) {
+			if len(args) != 2 && len(args) != 3 {
+				return anyType, fmt.Errorf("invalid number of arguments (expected 2 or 3, got %d)", len(args))
+			}
+			if len(args) == 3 {
+				switch kind(args[2]) {
+				case reflect.Interface, reflect.String:
+				default:
+					return anyType, fmt.Errorf("invalid argument for merge (type %s)", args[2])
+				}
+			}
+			stringKeys := true
+			for _, arg := range args[:2] {
+				switch kind(deref.Type(arg)) {
+				case reflect.Interface:
+					return anyType, nil
+				case reflect.Map:
+					stringKeys = stringKeys && deref.Type(arg).Key().Kind() == reflect.String
+				default:
+					return anyType, fmt.Errorf("invalid argument for merge (type %s)", arg)
+				}
+			}
+			if stringKeys {
+				return reflect.TypeOf(map[string]any{}), nil
+			}
+			return mapType, nil
+		},
+	},
 	{
 		Name: "reverse",
 		Func: func(args ...any) (any, error) {
@@ -830,6 +1678,65 @@ var Builtins = []*Function{
 			}
 		},
 	},
+	{
+		Name: "pluck",
+		Func: func(args ...any) (any, error) {
+			return pluckArgs(nil, args...)
+		},
+		Types: types(new(func([]any, string) []any)),
+	},
+	{
+		Name: "frequencies",
+		Func: func(args ...any) (any, error) {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("invalid number of arguments (expected 1, got %d)", len(args))
+			}
+			v := reflect.ValueOf(args[0])
+			if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+				return nil, fmt.Errorf("cannot count frequencies of %s", v.Kind())
+			}
+			out := make(map[any]int)
+			for i := 0; i < v.Len(); i++ {
+				item := v.Index(i).Interface()
+				if t := reflect.TypeOf(item); t != nil && !t.Comparable() {
+					return nil, fmt.Errorf("cannot count frequencies of unhashable %v", t)
+				}
+				out[item]++
+			}
+			return out, nil
+		},
+		Validate: func(args []reflect.Type) (reflect.Type, error) {
+			if len(args) != 1 {
+				return anyType, fmt.Errorf("invalid number of arguments (expected 1, got %d)", len(args))
+			}
+			switch kind(args[0]) {
+			case reflect.Interface, reflect.Slice, reflect.Array:
+				return reflect.TypeOf(map[any]int{}), nil
+			}
+			return anyType, fmt.Errorf("cannot count frequencies of %s", args[0])
+		},
+	},
+	{
+		Name: "union",
+		Func: func(args ...any) (any, error) {
+			return setOperation("union", args, func(inA, inB bool) bool { return true })
+		},
+		Validate: validateSetOperation("union"),
+	},
+	{
+		Name: "intersect",
+		Func: func(args ...any) (any, error) {
+			return setOperation("intersect", args, func(inA, inB bool) bool { return inA && inB })
+		},
+		Validate: validateSetOperation("intersect"),
+	},
+	{
+		Name: "difference",
+		Func: func(args ...any) (any, error) {
+			return setOperation("difference", args, func(inA, inB bool) bool { return inA && !inB })
+		},
+		Validate: validateSetOperation("difference"),
+	},
 	{
 		Name: "concat",
 		Safe: func(args ...any) (any, uint, error) {
@@ -2,6 +2,7 @@ package builtin_test
 
 import (
 	"fmt"
+	"math/rand"
 	"reflect"
 	"strings"
 	"testing"
@@ -67,6 +68,12 @@ func TestBuiltin(t *testing.T) {
 		{`replace("foo,bar,baz", ",", ";")`, "foo;bar;baz"},
 		{`replace("foo,bar,baz,goo", ",", ";", 2)`, "foo;bar;baz,goo"},
 		{`repeat("foo", 3)`, "foofoofoo"},
+		{`padLeft("42", 5)`, "   42"},
+		{`padLeft("42", 5, "0")`, "00042"},
+		{`padLeft("42", 7, "ab")`, "ababa42"},
+		{`padLeft("hello", 3)`, "hello"},
+		{`padRight("ключ", 6, ".")`, "ключ.."},
+		{`padRight("id", 4) + "|"`, "id  |"},
 		{`join(ArrayOfString, ",")`, "foo,bar,baz"},
 		{`join(ArrayOfString)`, "foobarbaz"},
 		{`join(["foo", "bar", "baz"], ",")`, "foo,bar,baz"},
@@ -92,6 +99,16 @@ func TestBuiltin(t *testing.T) {
 		{`sum([1, 2, 3.0, 4])`, 10.0},
 		{`mean(1..9)`, 5.0},
 		{`mean([.5, 1.5, 2.5])`, 1.5},
+		{`clamp(5, 1, 10)`, 5},
+		{`clamp(-5, 1, 10)`, 1},
+		{`clamp(50, 1, 10)`, 10},
+		{`clamp(50, 1, 9.5)`, 9.5},
+		{`clamp(5, 1.0, 10)`, 5.0},
+		{`clamp(0.5, 0, 1)`, 0.5},
+		{`inRange(5, 1, 10)`, true},
+		{`inRange(10, 1, 10)`, true},
+		{`inRange(10.5, 1, 10)`, false},
+		{`inRange(0, 1, 10)`, false},
 		{`mean([])`, 0.0},
 		{`mean([1, 2, 3.0, 4])`, 2.5},
 		{`mean(10, [1, 2, 3], 1..9)`, 4.6923076923076925},
@@ -109,6 +126,34 @@ func TestBuiltin(t *testing.T) {
 		{`fromJSON("[1, 2, 3]")`, []any{1.0, 2.0, 3.0}},
 		{`toBase64("hello")`, "aGVsbG8="},
 		{`fromBase64("aGVsbG8=")`, "hello"},
+		{`toHex("hello")`, "68656c6c6f"},
+		{`fromHex("68656c6c6f")`, "hello"},
+		{`fromHex(toHex("ключ"))`, "ключ"},
+		{`format("%s is %d years old", "Bob", 30)`, "Bob is 30 years old"},
+		{`format("%.2f%%", 99.5)`, "99.50%"},
+		{`format("%*d|%-4s|", 3, 7, "ab")`, "  7|ab  |"},
+		{`format("%v, %v", nil, [1, 2])`, "<nil>, [1 2]"},
+		{`let f = "%d"; format(f, 1)`, "1"},
+		{`findAll("a1b22c333", "[0-9]+")`, []string{"1", "22", "333"}},
+		{`findAll("abc", "[0-9]+")`, []string{}},
+		{`let p = "[a-z]"; findAll("a1b", p)`, []string{"a", "b"}},
+		{`matchGroups("v1.22", "v(?P<major>[0-9]+)\\.([0-9]+)")`, map[string]string{"0": "v1.22", "1": "1", "2": "22", "major": "1"}},
+		{`matchGroups("abc", "[0-9]")`, nil},
+		{`matchGroups("key=value", "(\\w+)=(\\w+)")["2"]`, "value"},
+		{`urlEncode("a b&c=d")`, "a+b%26c%3Dd"},
+		{`urlDecode("a+b%26c%3Dd")`, "a b&c=d"},
+		{`parseURL("https://user@example.com:8080/api/v1?id=42&q=a+b#top").hostname`, "example.com"},
+		{`parseURL("https://user@example.com:8080/api/v1?id=42&q=a+b#top").port`, "8080"},
+		{`parseURL("https://user@example.com:8080/api/v1?id=42&q=a+b#top").path`, "/api/v1"},
+		{`parseURL("https://user@example.com:8080/api/v1?id=42&q=a+b#top").query.q`, "a b"},
+		{`parseURL("https://user@example.com:8080/api/v1?id=42&q=a+b#top").fragment`, "top"},
+		{`parseURL("/path").user`, ""},
+		{`sha256("hello")`, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+		{`md5("hello")`, "5d41402abc4b2a76b9719d911017c592"},
+		{`fnv("hello")`, 1335831723},
+		{`crc32("hello")`, 907060870},
+		{`crc32(42) == crc32("42")`, true},
+		{`crc32(42) % 100`, 88},
 		{`now().Format("2006-01-02T15:04Z")`, time.Now().Format("2006-01-02T15:04Z")},
 		{`duration("1h")`, time.Hour},
 		{`date("2006-01-02T15:04:05Z")`, time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)},
@@ -118,6 +163,14 @@ func TestBuiltin(t *testing.T) {
 		{`date("2023-04-23", "2006-01-02", "America/Chicago").Format("2006-01-02")`, "2023-04-23"},
 		{`timezone("UTC").String()`, "UTC"},
 		{`timezone("Europe/Moscow").String()`, "Europe/Moscow"},
+		{`dateFormat(date("2024-03-15 10:20:30"), "02.01.2006 15:04")`, "15.03.2024 10:20"},
+		{`dateAdd(date("2024-01-31"), 1, "month")`, time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)},
+		{`dateAdd(date("2024-01-02"), 3, "days")`, time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)},
+		{`dateAdd(date("2024-01-02"), -2, "hours")`, time.Date(2024, 1, 1, 22, 0, 0, 0, time.UTC)},
+		{`startOf(date("2024-03-15 10:20:30"), "month")`, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{`startOf(date("2024-03-15 10:20:30"), "week")`, time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)},
+		{`startOf(date("2024-03-15 10:20:30"), "hour")`, time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC)},
+		{`startOf(date("2024-03-15 10:20:30"), "year")`, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
 		{`first(ArrayOfString)`, "foo"},
 		{`first(ArrayOfInt)`, 1},
 		{`first(ArrayOfAny)`, 1},
@@ -133,6 +186,49 @@ func TestBuiltin(t *testing.T) {
 		{`get(ArrayOfAny, 1)`, "2"},
 		{`get({foo: 1, bar: 2}, "foo")`, 1},
 		{`get({foo: 1, bar: 2}, "unknown")`, nil},
+		{`parseInt("ff", 16)`, 255},
+		{`parseInt("-42")`, -42},
+		{`parseInt("0x1f", 0)`, 31},
+		{`parseFloat("3.14")`, 3.14},
+		{`parseFloat("3,14", "de")`, 3.14},
+		{`parseFloat("1.234.567,5", "de-DE")`, 1234567.5},
+		{`parseFloat("1 234,5", "fr")`, 1234.5},
+		{`parseFloat("1,234.5", "en")`, 1234.5},
+		{`formatNumber(1234567.891, "#,##0.00")`, "1,234,567.89"},
+		{`formatNumber(1234567.891, "#,##0.00", "de")`, "1.234.567,89"},
+		{`formatNumber(1234567, "#,##0", "fr")`, "1\u202f234\u202f567"},
+		{`formatNumber(-1234, "$#,##0")`, "-$1,234"},
+		{`formatNumber(5, "$#,##0.00")`, "$5.00"},
+		{`formatNumber(0.256, "0.#%")`, "25.6%"},
+		{`formatNumber(1.5, "0.##")`, "1.5"},
+		{`formatNumber(7, "000")`, "007"},
+		{`formatNumber(-0.001, "0.00")`, "0.00"},
+		{`containsAny("hello world", ["foo", "wor"])`, true},
+		{`containsAny("hello world", ["foo", "bar"])`, false},
+		{`containsAny("hello", [])`, false},
+		{`containsAny("hello", [""])`, true},
+		{`containsAny("foobar", ArrayOfString)`, true},
+		{`startsWithAny("/api/v1", ["/api", "/internal"])`, true},
+		{`startsWithAny("/public", ["/api", "/internal"])`, false},
+		{`startsWithAny("", ["/api"])`, false},
+		{`endsWithAny("image.png", [".jpg", ".png"])`, true},
+		{`endsWithAny("image.gif", [".jpg", ".png"])`, false},
+		{`takeWhile(ArrayOfInt, # < 3)`, []any{1, 2}},
+		{`takeWhile(ArrayOfInt, # > 3)`, []any{}},
+		{`dropWhile(ArrayOfInt, # < 3)`, []any{3}},
+		{`dropWhile(ArrayOfInt, # < 5)`, []any{}},
+		{`dropWhile([3, 1, 2], # < 3)`, []any{3, 1, 2}},
+		{`percentile([1, 2, 3, 4, 5], 50)`, 3.0},
+		{`percentile([5, 1, 4, 2, 3], 0)`, 1.0},
+		{`percentile([5, 1, 4, 2, 3], 100)`, 5.0},
+		{`percentile([1, 2, 3, 4], 25)`, 1.75},
+		{`percentile([10.5], 99)`, 10.5},
+		{`percentile([], 99)`, 0.0},
+		{`variance([2, 4, 4, 4, 5, 5, 7, 9])`, 4.0},
+		{`variance(1, 1, 1)`, 0.0},
+		{`variance([])`, 0.0},
+		{`stddev([2, 4, 4, 4, 5, 5, 7, 9])`, 2.0},
+		{`stddev([1.5], [1.5, 1.5])`, 0.0},
 		{`take(ArrayOfString, 2)`, []string{"foo", "bar"}},
 		{`take(ArrayOfString, 99)`, []string{"foo", "bar", "baz"}},
 		{`"foo" in keys({foo: 1, bar: 2})`, true},
@@ -141,6 +237,25 @@ func TestBuiltin(t *testing.T) {
 		{`len(toPairs({}))`, 0},
 		{`fromPairs([["foo", 1], ["bar", 2]])`, map[any]any{"foo": 1, "bar": 2}},
 		{`fromPairs(toPairs({foo: 1, bar: 2}))`, map[any]any{"foo": 1, "bar": 2}},
+		{`countBy(["a", "bb", "cc", "d"], len(#))`, map[any]int{1: 2, 2: 2}},
+		{`countBy(ArrayOfFoo, .Value).b`, 1},
+		{`countBy([], #)`, map[any]int{}},
+		{`frequencies(["a", "b", "a"])`, map[any]int{"a": 2, "b": 1}},
+		{`frequencies([1, 1, 2])[1]`, 2},
+		{`partition(1..5, # % 2 == 0)`, [][]int{{2, 4}, {1, 3, 5}}},
+		{`partition(ArrayOfFoo, .Value == "b")[1]`, []mock.Foo{{Value: "a"}, {Value: "c"}}},
+		{`partition([], true)`, [][]any{{}, {}}},
+		{`union([1, 2, 2], [3, 1])`, []any{1, 2, 3}},
+		{`union(["read"], ["write", "read"])`, []any{"read", "write"}},
+		{`intersect(["read", "write", "read"], ["admin", "read"])`, []any{"read"}},
+		{`intersect([1, 2], [3])`, []any{}},
+		{`difference([1, 2, 3, 2], [2])`, []any{1, 3}},
+		{`difference(1..4, [1.0, 4])`, []any{2, 3}},
+		{`intersect([1, "1", true, nil], [nil, 1.0, true])`, []any{1, true, nil}},
+		{`union([[1], {a: 1}], [[1], {a: 1}])`, []any{[]any{1}, map[string]any{"a": 1}}},
+		{`chunk(1..5, 2)`, [][]int{{1, 2}, {3, 4}, {5}}},
+		{`chunk(["a", "b"], 5)`, [][]any{{"a", "b"}}},
+		{`chunk([], 2)`, [][]any{}},
 		{`groupBy(1..9, # % 2)`, map[any][]any{0: {2, 4, 6, 8}, 1: {1, 3, 5, 7, 9}}},
 		{`groupBy(1..9, # % 2)[0]`, []any{2, 4, 6, 8}},
 		{`groupBy(1..3, # > 1)[true]`, []any{2, 3}},
@@ -170,10 +285,28 @@ func TestBuiltin_works_with_any(t *testing.T) {
 	config := map[string]struct {
 		arity int
 	}{
-		"now":    {0},
-		"get":    {2},
-		"take":   {2},
-		"sortBy": {2},
+		"now":           {0},
+		"uuid":          {0},
+		"random":        {0},
+		"sample":        {2},
+		"get":           {2},
+		"has":           {2},
+		"hasKey":        {2},
+		"take":          {2},
+		"chunk":         {2},
+		"clamp":         {3},
+		"inRange":       {3},
+		"union":         {2},
+		"intersect":     {2},
+		"merge":         {2},
+		"percentile":    {2},
+		"ipBetween":     {3},
+		"containsAny":   {2},
+		"startsWithAny": {2},
+		"endsWithAny":   {2},
+		"formatNumber":  {2},
+		"difference":    {2},
+		"sortBy":        {2},
 	}
 
 	for _, b := range builtin.Builtins {
@@ -224,7 +357,71 @@ func TestBuiltin_errors(t *testing.T) {
 		{`mean("s", 1..9)`, "invalid argument for mean (type string)"},
 		{`duration("error")`, `invalid duration`},
 		{`date("error")`, `invalid date`},
-		{`get()`, `invalid number of arguments (expected 2, got 0)`},
+		{`fromHex("zz")`, `encoding/hex: invalid byte`},
+		{`padLeft("a", 3, "")`, `invalid argument for padLeft (pad string is empty)`},
+		{`padRight("a", 10000000)`, `memory budget exceeded`},
+		{`format("%s is %d", "Bob")`, `format "%s is %d" expects 2 arguments, got 1 (1:1)`},
+		{`format("%d", "Bob")`, `verb %d of format "%d" cannot be used with string (1:14)`},
+		{`format("%f", 1)`, `verb %f of format "%f" cannot be used with int (1:14)`},
+		{`format("100%")`, `format "100%" ends with incomplete verb (1:8)`},
+		{`union([1], 2)`, `cannot union int`},
+		{`frequencies(1)`, `cannot count frequencies of int`},
+		{`countBy(1, #)`, `builtin countBy takes only array (got int)`},
+		{`frequencies([[1]])`, `cannot count frequencies of unhashable []interface {}`},
+		{`sortBy([1], [#, #], ["asc"])`, `expected 2 orders, got 1`},
+		{`let orders = ["asc"]; sortBy([1], [#, #], orders)`, `expected 2 orders, got 1`},
+		{`let orders = []; sortBy([1], [#], orders)`, `expected 1 orders, got 0`},
+		{`sortBy([1], #, ["asc"])`, `order should be a string for sorting by a single key`},
+		{`sortBy([1, 2], [#], ["up"])`, `unknown order, use asc or desc`},
+		{`clamp(1, 10, 0)`, `invalid argument for clamp (lower bound 10 is greater than upper bound 0)`},
+		{`clamp("a", 1, 2)`, `invalid argument for clamp (type string)`},
+		{`inRange(1, 2)`, `invalid number of arguments (expected 3, got 2)`},
+		{`chunk([1, 2], 0)`, `invalid argument for chunk (expected positive integer, got 0)`},
+		{`chunk(1, 2)`, `cannot chunk int`},
+		{`partition(1..3, #)`, `predicate should return boolean (got int)`},
+		{`takeWhile(1..3, #)`, `predicate should return boolean (got int)`},
+		{`dropWhile(1, true)`, `builtin dropWhile takes only array (got int)`},
+		{`findAll("abc", "[")`, "error parsing regexp: missing closing ]: `[` (1:16)"},
+		{`let p = "("; matchGroups("abc", p)`, "error parsing regexp: missing closing ): `(`"},
+		{`urlDecode("%zz")`, `invalid URL escape "%zz"`},
+		{`parseURL(":foo")`, `missing protocol scheme`},
+		{`dateAdd(now(), 1, "fortnight")`, `unknown unit "fortnight" for dateAdd`},
+		{`startOf(now(), "decade")`, `unknown unit "decade" for startOf`},
+		{`get()`, `invalid number of arguments (expected 2 or 3, got 0)`},
+		{`get(1, "a", 2)`, `type int does not support indexing`},
+		{`get({}, true, 2)`, `path should be a string or an integer (got bool)`},
+		{`has({}, true)`, `invalid argument for has (type bool)`},
+		{`merge({}, [])`, `invalid argument for merge (type []interface {})`},
+		{`parseInt("zz", 16)`, `strconv.ParseInt: parsing "zz": invalid syntax`},
+		{`parseFloat("1,5", "xx")`, `unknown locale "xx"`},
+		{`formatNumber("1", "0")`, `invalid argument for formatNumber (type string)`},
+		{`formatNumber(1, "abc")`, `invalid number pattern "abc"`},
+		{`containsAny("a", ["b", 1])`, `invalid argument for containsAny (type int)`},
+		{`startsWithAny("a", "b")`, `invalid argument for startsWithAny (type string)`},
+		{`endsWithAny(1, ["b"])`, `invalid argument for endsWithAny (type int)`},
+		{`mapValues([1, 2], #)`, `builtin mapValues takes only map (got []interface {})`},
+		{`mapKeys({a: 1}, [#])`, `cannot use []interface {} as map key`},
+		{`map([1], #key)`, `unknown pointer #key`},
+		{`count("abc")`, `builtin count requires a predicate for string`},
+		{`all(1, true)`, `builtin all takes only array, map or string (got int)`},
+		{`percentile([1], 101)`, `invalid argument for percentile (p must be between 0 and 100, got 101)`},
+		{`percentile([1, 2], 0/0)`, `invalid argument for percentile (p must be between 0 and 100, got NaN)`},
+		{`percentile(1, 50)`, `invalid argument for percentile (type int)`},
+		{`percentile([1], "50")`, `invalid argument for percentile (type string)`},
+		{`variance(["a"])`, `invalid argument for variance (type string)`},
+		{`stddev()`, `not enough arguments to call stddev`},
+		{`ip("x")`, `ParseAddr("x"): unable to parse IP`},
+		{`cidr("10.0.0.0/33")`, `netip.ParsePrefix("10.0.0.0/33"): prefix length out of range`},
+		{`isPrivateIP(1)`, `invalid argument for isPrivateIP (type int)`},
+		{`ipBetween("10.0.0.1", "x", "10.0.0.2")`, `ParseAddr("x"): unable to parse IP`},
+		{`semver("1.x")`, `invalid version "1.x"`},
+		{`semverSatisfies("1.0.0", "=>1.0")`, `invalid constraint "=>1.0": unknown operator "=>"`},
+		{`random(0)`, `invalid argument for random (n must be positive, got 0)`},
+		{`random(1.5)`, `invalid argument for random (type float64)`},
+		{`shuffle(1)`, `invalid argument for shuffle (type int)`},
+		{`sample([1], -1)`, `invalid argument for sample (n must not be negative, got -1)`},
+		{`merge({}, {}, "concat")`, `unknown slice strategy concat for merge (use replace or append)`},
+		{`hasKey([], 1)`, `invalid argument for hasKey (type []interface {})`},
 		{`get(1, 2)`, `type int does not support indexing`},
 		{`bitnot("1")`, "cannot use string as argument (type int) to call bitnot  (1:8)"},
 		{`bitand("1", 1)`, "cannot use string as argument (type int) to call bitand  (1:8)"},
@@ -267,6 +464,9 @@ func TestBuiltin_types(t *testing.T) {
 		{`get($env, 'str')`, reflect.String},
 		{`get($env, 'num')`, reflect.Int},
 		{`get($env, 'ArrayOfString')`, reflect.Slice},
+		{`clamp(num, 0, 10)`, reflect.Int},
+		{`clamp(num, 0, 10.5)`, reflect.Float64},
+		{`inRange(num, 0, 10)`, reflect.Bool},
 	}
 
 	for _, test := range tests {
@@ -442,7 +642,26 @@ func TestBuiltin_DisableBuiltin(t *testing.T) {
 func TestBuiltin_DisableAllBuiltins(t *testing.T) {
 	_, err := expr.Compile(`len("foo")`, expr.Env(nil), expr.DisableAllBuiltins())
 	require.Error(t, err)
-	assert.Contains(t, err.Error(), "unknown name len")
+	assert.Contains(t, err.Error(), "builtin len is disabled")
+}
+
+func TestBuiltin_DisableBuiltin_error(t *testing.T) {
+	tests := []struct {
+		input string
+		name  string
+	}{
+		{`upper("foo")`, "upper"},
+		{`"foo" | upper()`, "upper"},
+		{`filter([1, 2], # > 1)`, "filter"},
+		{`[1, 2] | map(# * 2)`, "map"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := expr.Compile(tt.input, expr.DisableBuiltin(tt.name))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), fmt.Sprintf("builtin %v is disabled", tt.name))
+		})
+	}
 }
 
 func TestBuiltin_EnableBuiltin(t *testing.T) {
@@ -513,6 +732,42 @@ func TestBuiltin_type(t *testing.T) {
 	}
 }
 
+func TestBuiltin_kind(t *testing.T) {
+	type Foo struct{}
+	tests := []struct {
+		obj  any
+		want string
+	}{
+		{nil, "nil"},
+		{true, "bool"},
+		{1, "int"},
+		{int8(1), "int8"},
+		{uint(1), "uint"},
+		{1.0, "float64"},
+		{"string", "string"},
+		{[]string{"foo", "bar"}, "slice"},
+		{[2]int{1, 2}, "array"},
+		{map[string]any{"foo": "bar"}, "map"},
+		{func() {}, "func"},
+		{time.Second, "int64"},
+		{Foo{}, "struct"},
+		{&Foo{}, "ptr"},
+	}
+	for _, test := range tests {
+		t.Run(test.want, func(t *testing.T) {
+			env := map[string]any{
+				"obj": test.obj,
+			}
+			program, err := expr.Compile(`kind(obj)`, expr.Env(env))
+			require.NoError(t, err)
+
+			out, err := expr.Run(program, env)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, out)
+		})
+	}
+}
+
 func TestBuiltin_reverse(t *testing.T) {
 	env := map[string]any{
 		"ArrayOfString": []string{"foo", "bar", "baz"},
@@ -545,12 +800,449 @@ func TestBuiltin_reverse(t *testing.T) {
 	}
 }
 
+func TestBuiltin_pluck(t *testing.T) {
+	env := map[string]any{
+		"ArrayOfFoo": []*mock.Foo{{Value: "a"}, {Value: "b"}},
+		"ArrayOfMap": []map[string]int{{"id": 1}, {"id": 2}},
+		"ArrayOfAny": []any{map[string]any{"id": 1}},
+	}
+	tests := []struct {
+		input string
+		want  any
+	}{
+		{`pluck(ArrayOfFoo, "Value")`, []string{"a", "b"}},
+		{`pluck(ArrayOfFoo, "Bar")`, []mock.Bar{{}, {}}},
+		{`pluck(ArrayOfMap, "id")`, []int{1, 2}},
+		{`pluck(ArrayOfAny, "id")`, []any{1}},
+		{`pluck([], "id")`, []any{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			program, err := expr.Compile(test.input, expr.Env(env))
+			require.NoError(t, err)
+
+			out, err := expr.Run(program, env)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, out)
+			assert.Equal(t, reflect.TypeOf(test.want), program.Node().Type())
+		})
+	}
+
+	_, err := expr.Compile(`pluck(ArrayOfFoo, "Email")`, expr.Env(env))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "type mock.Foo has no field Email (1:19)")
+}
+
+func TestBuiltin_get_path(t *testing.T) {
+	env := map[string]any{
+		"data": map[string]any{
+			"a": map[string]any{
+				"b": []any{1, 2, map[string]any{"c": "deep"}},
+				"n": nil,
+			},
+			"ids":  map[int]string{1: "one"},
+			"foo":  &mock.Foo{Bar: mock.Bar{Baz: "baz"}},
+			"list": []int{10, 20, 30},
+		},
+	}
+	tests := []struct {
+		input string
+		want  any
+	}{
+		{`get(data, "a.b[2].c", "default")`, "deep"},
+		{`get(data, "a.b[0]", 0)`, 1},
+		{`get(data, "a.b[-1].c", "default")`, "deep"},
+		{`get(data, "a.b[9].c", "default")`, "default"},
+		{`get(data, "a.x.c", "default")`, "default"},
+		{`get(data, "a.n", "default")`, "default"},
+		{`get(data, "a.b.c", "default")`, "default"},
+		{`get(data, "ids[1]", "default")`, "one"},
+		{`get(data, "ids[2]", "default")`, "default"},
+		{`get(data, "foo.Bar.Baz", "default")`, "baz"},
+		{`get(data, "foo.Bar.Qux", "default")`, "default"},
+		{`get(data.list, 1, 0)`, 20},
+		{`get(data.list, 5, 0)`, 0},
+		{`get({"a.b": 1}, "a.b")`, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			out, err := expr.Eval(test.input, env)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, out)
+		})
+	}
+
+	for _, path := range []string{"a..b", "a.b[1", "a[x]", ".a", "a.", ""} {
+		t.Run(path, func(t *testing.T) {
+			_, err := expr.Eval(fmt.Sprintf(`get(data, %q, nil)`, path), env)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "invalid path")
+		})
+	}
+}
+
+func TestBuiltin_has(t *testing.T) {
+	env := map[string]any{
+		"data": map[string]any{
+			"a":   map[string]any{"b": []any{1, map[string]any{"c": nil}}},
+			"ids": map[int]string{1: "one"},
+			"foo": &mock.Foo{},
+		},
+		"nilMap": map[string]int(nil),
+	}
+	tests := []struct {
+		input string
+		want  any
+	}{
+		{`has(data, "a")`, true},
+		{`has(data, "a.b[1].c")`, true},
+		{`has(data, "a.b[2].c")`, false},
+		{`has(data, "a.x")`, false},
+		{`has(data, "ids[1]")`, true},
+		{`has(data, "ids[2]")`, false},
+		{`has(data, "foo.Bar.Baz")`, true},
+		{`has(data, "foo.Qux")`, false},
+		{`has(data.a.b, 1)`, true},
+		{`has(data.a.b, 2)`, false},
+		{`hasKey(data, "a")`, true},
+		{`hasKey(data, "a.b")`, false},
+		{`hasKey(data.ids, 1)`, true},
+		{`hasKey(data.ids, "1")`, false},
+		{`hasKey(nilMap, "a")`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			program, err := expr.Compile(test.input, expr.Env(env))
+			require.NoError(t, err)
+
+			out, err := expr.Run(program, env)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, out)
+		})
+	}
+}
+
+func TestBuiltin_merge(t *testing.T) {
+	env := map[string]any{
+		"base": map[string]any{
+			"name": "app",
+			"db":   map[string]any{"host": "localhost", "port": 5432},
+			"tags": []string{"a"},
+		},
+		"override": map[string]any{
+			"db":   map[string]any{"host": "db.local"},
+			"tags": []string{"b"},
+			"new":  nil,
+		},
+		"ids": map[int]string{1: "one"},
+	}
+	tests := []struct {
+		input string
+		want  any
+	}{
+		{`merge(base, override)`, map[string]any{
+			"name": "app",
+			"db":   map[string]any{"host": "db.local", "port": 5432},
+			"tags": []string{"b"},
+			"new":  nil,
+		}},
+		{`merge(base, override, "append").tags`, []any{"a", "b"}},
+		{`merge(base, override, "replace").tags`, []string{"b"}},
+		{`merge({a: {b: 1}}, {a: {c: 2}})`, map[string]any{"a": map[string]any{"b": 1, "c": 2}}},
+		{`merge({a: 1}, {a: {b: 2}})`, map[string]any{"a": map[string]any{"b": 2}}},
+		{`merge(ids, {b: 2})`, map[any]any{1: "one", "b": 2}},
+		{`merge({}, {})`, map[string]any{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			out, err := expr.Eval(test.input, env)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, out)
+		})
+	}
+
+	_, err := expr.Eval(`merge(base, override)`, env)
+	require.NoError(t, err)
+	assert.Equal(t, "localhost", env["base"].(map[string]any)["db"].(map[string]any)["host"], "base must not be modified")
+}
+
+func TestBuiltin_random(t *testing.T) {
+	env := map[string]any{
+		"ArrayOfInt": []int{1, 2, 3, 4, 5},
+	}
+	tests := []struct {
+		input string
+		check func(t *testing.T, out any)
+	}{
+		{`uuid()`, func(t *testing.T, out any) {
+			assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, out)
+		}},
+		{`random()`, func(t *testing.T, out any) {
+			assert.True(t, out.(float64) >= 0 && out.(float64) < 1)
+		}},
+		{`random(10)`, func(t *testing.T, out any) {
+			assert.True(t, out.(int) >= 0 && out.(int) < 10)
+		}},
+		{`shuffle(ArrayOfInt)`, func(t *testing.T, out any) {
+			assert.ElementsMatch(t, []int{1, 2, 3, 4, 5}, out)
+		}},
+		{`sample(ArrayOfInt, 2)`, func(t *testing.T, out any) {
+			assert.Len(t, out, 2)
+			assert.Subset(t, []int{1, 2, 3, 4, 5}, out)
+		}},
+		{`sample(ArrayOfInt, 10)`, func(t *testing.T, out any) {
+			assert.ElementsMatch(t, []int{1, 2, 3, 4, 5}, out)
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			program, err := expr.Compile(test.input, expr.Env(env))
+			require.NoError(t, err)
+
+			out, err := expr.Run(program, env)
+			require.NoError(t, err)
+			test.check(t, out)
+		})
+	}
+
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, env["ArrayOfInt"], "input must not be modified")
+}
+
+func TestBuiltin_random_seed(t *testing.T) {
+	run := func(seed int64) any {
+		program, err := expr.Compile(
+			`[uuid(), random(), random(100), shuffle(1..10), sample(1..10, 3)]`,
+			expr.RandSource(rand.NewSource(seed)),
+		)
+		require.NoError(t, err)
+
+		out, err := expr.Run(program, nil)
+		require.NoError(t, err)
+		return out
+	}
+
+	assert.Equal(t, run(42), run(42))
+	assert.NotEqual(t, run(42), run(43))
+}
+
+func TestBuiltin_mapValues_mapKeys(t *testing.T) {
+	env := map[string]any{
+		"Prices": map[string]int{"apple": 1, "pear": 2},
+		"Any":    map[string]any{"a": 1, "b": "2"},
+	}
+	tests := []struct {
+		input string
+		want  any
+	}{
+		{`mapValues(Prices, # * 2)`, map[string]int{"apple": 2, "pear": 4}},
+		{`mapValues(Prices, #key + ":" + string(#))`, map[string]string{"apple": "apple:1", "pear": "pear:2"}},
+		{`mapValues(Any, string(#))`, map[string]string{"a": "1", "b": "2"}},
+		{`mapValues({}, #)`, map[string]any{}},
+		{`mapKeys(Prices, upper(#))`, map[string]int{"APPLE": 1, "PEAR": 2}},
+		{`mapKeys(Prices, len(#))`, map[int]int{5: 1, 4: 2}},
+		{`mapKeys(Any, # == "a")`, map[bool]any{true: 1, false: "2"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			program, err := expr.Compile(test.input, expr.Env(env))
+			require.NoError(t, err)
+
+			out, err := expr.Run(program, env)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, out)
+			assert.Equal(t, reflect.TypeOf(test.want), program.Node().Type())
+		})
+	}
+}
+
+func TestBuiltin_predicates_over_maps_and_strings(t *testing.T) {
+	env := map[string]any{
+		"Scores":  map[string]int{"alice": 90, "bob": 70},
+		"Flags":   map[string]bool{"a": true, "b": false, "c": true},
+		"Word":    "héllo",
+		"Any":     any("abc"),
+		"AnyWord": any("héllo"),
+		"AnyMap":  any(map[string]int{"alice": 90, "bob": 70}),
+		"Yes":     true,
+	}
+	tests := []struct {
+		input string
+		want  any
+	}{
+		{`all(Scores, # >= 70)`, true},
+		{`any(Scores, #key == "bob" && # < 80)`, true},
+		{`none(Scores, # > 90)`, true},
+		{`one(Scores, # > 80)`, true},
+		{`count(Scores, # > 60)`, 2},
+		{`count(Flags)`, 2},
+		{`all({}, false)`, true},
+		{`count(Word, # == "l")`, 2},
+		{`any(Word, # == "é")`, true},
+		{`all(Word, # != "x")`, true},
+		{`one(Word, # == "h")`, true},
+		{`none("", true)`, true},
+		{`count(Any, # != "b")`, 2},
+		{`count(AnyWord, # == "é")`, 1},
+		{`all(AnyWord, # in ["h", "é", "l", "o"])`, true},
+		{`any(AnyMap, #key == "bob" && # < 80)`, true},
+		{`count(AnyMap, #key startsWith "a")`, 1},
+		// Strings which are not known to be strings are iterated by bytes.
+		{`count(Yes ? "héllo" : 1, # == 108)`, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			program, err := expr.Compile(test.input, expr.Env(env))
+			require.NoError(t, err)
+
+			out, err := expr.Run(program, env)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, out)
+		})
+	}
+}
+
+func TestBuiltin_ip(t *testing.T) {
+	env := map[string]any{
+		"Addr":     "10.1.2.3",
+		"Networks": []string{"192.168.0.0/16", "10.0.0.0/8"},
+	}
+	tests := []struct {
+		input string
+		want  any
+	}{
+		{`ip("10.0.0.1") in cidr("10.0.0.0/8")`, true},
+		{`"192.168.1.1" in cidr("10.0.0.0/8")`, false},
+		{`"::ffff:10.0.0.1" in cidr("10.0.0.0/8")`, true},
+		{`"2001:db8::1" in cidr("2001:db8::/32")`, true},
+		{`"invalid" in cidr("10.0.0.0/8")`, false},
+		{`Addr not in cidr("10.0.0.0/8")`, false},
+		{`any(Networks, Addr in cidr(#))`, true},
+		{`ip("10.0.0.1") == ip("10.0.0.1")`, true},
+		{`string(cidr("10.1.0.0/8"))`, "10.0.0.0/8"},
+		{`isPrivateIP("10.1.1.1")`, true},
+		{`isPrivateIP(ip("8.8.8.8"))`, false},
+		{`isPrivateIP("fd00::1")`, true},
+		{`ipBetween("10.0.0.5", "10.0.0.1", "10.0.0.10")`, true},
+		{`ipBetween("10.0.0.10", "10.0.0.1", "10.0.0.10")`, true},
+		{`ipBetween("10.0.0.11", "10.0.0.1", "10.0.0.10")`, false},
+		{`ipBetween("::1", "10.0.0.1", "10.0.0.10")`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			program, err := expr.Compile(test.input, expr.Env(env))
+			require.NoError(t, err)
+
+			out, err := expr.Run(program, env)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, out)
+		})
+	}
+}
+
+func TestBuiltin_semver(t *testing.T) {
+	env := map[string]any{
+		"Version": "1.10.0",
+	}
+	tests := []struct {
+		input string
+		want  any
+	}{
+		{`semver(Version) >= semver("1.2.0")`, true},
+		{`semver(Version) > "1.9"`, true},
+		{`semver("v1.2") == semver("1.2.0")`, true},
+		{`semver("1.0.0+build.1") == semver("1.0.0+build.2")`, true},
+		{`semver("1.2.0") == "1.2"`, true},
+		{`"1.2.0" == semver("v1.2.0")`, true},
+		{`semver("1.2.0") != "1.3.0"`, true},
+		{`semver(Version) == Version`, true},
+		{`semver("1.0.0-alpha") < semver("1.0.0")`, true},
+		{`semver("1.0.0-alpha.1") < semver("1.0.0-alpha.beta")`, true},
+		{`semver("1.0.0-rc.2") < semver("1.0.0-rc.10")`, true},
+		{`string(semver("1.2.3-rc.1+b5"))`, "1.2.3-rc.1+b5"},
+		{`semverSatisfies(Version, ">=1.2 <2.0")`, true},
+		{`semverSatisfies("2.0.0", ">=1.2, <2.0")`, false},
+		{`semverSatisfies("2.1.0", ">=1.2 <2.0 || ^2.0")`, true},
+		{`semverSatisfies("1.9.0", "^1.2.3")`, true},
+		{`semverSatisfies("0.3.0", "^0.2.3")`, false},
+		{`semverSatisfies("1.2.9", "~1.2.3")`, true},
+		{`semverSatisfies(semver("1.3.0"), "~1.2.3")`, false},
+		{`semverSatisfies("1.2.3", "1.2.3")`, true},
+		{`semverSatisfies("1.2.3", "!=1.2.3")`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			program, err := expr.Compile(test.input, expr.Env(env))
+			require.NoError(t, err)
+
+			out, err := expr.Run(program, env)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, out)
+		})
+	}
+}
+
+func TestBuiltin_coalesce(t *testing.T) {
+	env := map[string]any{
+		"nilString": (*string)(nil),
+		"str":       "str",
+		"boom":      func() any { panic("must not be called") },
+	}
+	tests := []struct {
+		input string
+		want  any
+	}{
+		{`coalesce(nil)`, nil},
+		{`coalesce(nil, nil)`, nil},
+		{`coalesce(nil, 1)`, 1},
+		{`coalesce(1, nil)`, 1},
+		{`coalesce(nil, nilString, str)`, "str"},
+		{`coalesce(nil, 1, "two")`, 1},
+		{`coalesce(str, boom())`, "str"},
+		{`coalesce(nil, str, boom(), boom())`, "str"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			out, err := expr.Eval(test.input, env)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, out)
+		})
+	}
+
+	program, err := expr.Compile(`coalesce(nil, 1, 2)`)
+	require.NoError(t, err)
+	assert.Equal(t, reflect.TypeOf(0), program.Node().Type())
+
+	program, err = expr.Compile(`coalesce(1, "two")`)
+	require.NoError(t, err)
+	assert.Equal(t, reflect.Interface, program.Node().Type().Kind())
+
+	_, err = expr.Compile(`coalesce()`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not enough arguments to call coalesce")
+}
+
 func TestBuiltin_sort(t *testing.T) {
 	env := map[string]any{
 		"ArrayOfString": []string{"foo", "bar", "baz"},
 		"ArrayOfInt":    []int{3, 2, 1},
 		"ArrayOfFloat":  []float64{3.0, 2.0, 1.0},
 		"ArrayOfFoo":    []mock.Foo{{Value: "c"}, {Value: "a"}, {Value: "b"}},
+		"Users": []map[string]any{
+			{"First": "b", "Last": "x", "Age": 3},
+			{"First": "a", "Last": "x", "Age": 2},
+			{"First": "c", "Last": "a", "Age": 1},
+			{"First": "a", "Last": "x", "Age": 9},
+		},
 	}
 	tests := []struct {
 		input string
@@ -562,6 +1254,10 @@ func TestBuiltin_sort(t *testing.T) {
 		{`sort(ArrayOfInt, 'desc')`, []any{3, 2, 1}},
 		{`sortBy(ArrayOfFoo, .Value)`, []any{mock.Foo{Value: "a"}, mock.Foo{Value: "b"}, mock.Foo{Value: "c"}}},
 		{`sortBy([{id: "a"}, {id: "b"}], .id, "desc")`, []any{map[string]any{"id": "b"}, map[string]any{"id": "a"}}},
+		{`map(sortBy(Users, [.Last, .First]), .Age)`, []any{1, 2, 9, 3}},
+		{`map(sortBy(Users, [.Last, .First], ["desc", "asc"]), .Age)`, []any{2, 9, 3, 1}},
+		{`map(sortBy(Users, [.Last, .First], "desc"), .Age)`, []any{3, 2, 9, 1}},
+		{`map(sortBy(Users, .Last), .Age)`, []any{1, 3, 2, 9}},
 	}
 
 	for _, test := range tests {
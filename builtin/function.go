@@ -10,8 +10,10 @@ type Function struct {
 	Func      func(args ...any) (any, error)
 	Safe      func(args ...any) (any, uint, error)
 	Types     []reflect.Type
+	Overloads []func(args ...any) (any, error) // Implementations of Types, bound by compiler.
 	Validate  func(args []reflect.Type) (reflect.Type, error)
 	Predicate bool
+	Pure      bool // Calls with constant arguments are evaluated at compile time.
 }
 
 func (f *Function) Type() reflect.Type {
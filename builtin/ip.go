@@ -0,0 +1,70 @@
+package builtin
+
+import (
+	"fmt"
+	"net/netip"
+	"reflect"
+)
+
+var addrType = reflect.TypeOf(netip.Addr{})
+
+// CIDR is a network returned by the cidr() function. It supports the "in"
+// operator for IP addresses and strings.
+type CIDR struct {
+	Prefix netip.Prefix
+}
+
+// ParseCIDR parses a network in CIDR notation, like "10.0.0.0/8".
+func ParseCIDR(s string) (CIDR, error) {
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return CIDR{}, err
+	}
+	return CIDR{Prefix: p.Masked()}, nil
+}
+
+func (c CIDR) Contains(needle any) bool {
+	var addr netip.Addr
+	switch x := needle.(type) {
+	case netip.Addr:
+		addr = x
+	case string:
+		var err error
+		addr, err = netip.ParseAddr(x)
+		if err != nil {
+			return false
+		}
+	default:
+		panic(fmt.Sprintf("cannot use %T as IP address", needle))
+	}
+	return c.Prefix.Contains(addr.Unmap())
+}
+
+func (c CIDR) String() string {
+	return c.Prefix.String()
+}
+
+func toAddr(name string, arg any) (netip.Addr, error) {
+	switch x := arg.(type) {
+	case netip.Addr:
+		return x.Unmap(), nil
+	case string:
+		addr, err := netip.ParseAddr(x)
+		if err != nil {
+			return netip.Addr{}, err
+		}
+		return addr.Unmap(), nil
+	}
+	return netip.Addr{}, fmt.Errorf("invalid argument for %s (type %T)", name, arg)
+}
+
+func validateAddrs(name string, args []reflect.Type) error {
+	for _, arg := range args {
+		switch {
+		case isAny(arg), kind(arg) == reflect.String, arg == addrType:
+		default:
+			return fmt.Errorf("invalid argument for %s (type %s)", name, arg)
+		}
+	}
+	return nil
+}
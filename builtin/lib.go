@@ -3,10 +3,17 @@ package builtin
 import (
 	"fmt"
 	"math"
+	"math/big"
+	"net/url"
 	"reflect"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/expr-lang/expr/internal/deref"
+	"github.com/expr-lang/expr/vm/runtime"
 )
 
 func Len(x any) any {
@@ -62,6 +69,13 @@ func Type(arg any) any {
 	}
 }
 
+func Kind(arg any) any {
+	if arg == nil {
+		return "nil"
+	}
+	return reflect.TypeOf(arg).Kind().String()
+}
+
 func Abs(x any) any {
 	switch x := x.(type) {
 	case float32:
@@ -136,6 +150,8 @@ func Abs(x any) any {
 		} else {
 			return x
 		}
+	case *big.Int:
+		return new(big.Int).Abs(x)
 	}
 	panic(fmt.Sprintf("invalid argument for abs (type %T)", x))
 }
@@ -202,6 +218,11 @@ func Int(x any) any {
 		return int(x)
 	case uint64:
 		return int(x)
+	case *big.Int:
+		if !x.IsInt64() {
+			panic(fmt.Sprintf("invalid operation: int(%v) overflows int", x))
+		}
+		return int(x.Int64())
 	case string:
 		i, err := strconv.Atoi(x)
 		if err != nil {
@@ -243,6 +264,9 @@ func Float(x any) any {
 		return float64(x)
 	case uint64:
 		return float64(x)
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(x).Float64()
+		return f
 	case string:
 		f, err := strconv.ParseFloat(x, 64)
 		if err != nil {
@@ -254,13 +278,50 @@ func Float(x any) any {
 	}
 }
 
+func BigInt(x any) any {
+	return runtime.ToBigInt(x)
+}
+
 func String(arg any) any {
 	return fmt.Sprintf("%v", arg)
 }
 
+// Bool returns the truthiness of the value: nil, false, zero numbers, empty
+// strings and empty collections are false, everything else is true.
+func Bool(arg any) any {
+	v := reflect.ValueOf(arg)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return false
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Invalid:
+		return false
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() != 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() != 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() != 0
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() > 0
+	}
+	return true
+}
+
 func minMax(name string, fn func(any, any) bool, args ...any) (any, error) {
 	var val any
 	for _, arg := range args {
+		if n, ok := arg.(*big.Int); ok {
+			if val == nil || fn(val, n) {
+				val = n
+			}
+			continue
+		}
 		rv := reflect.ValueOf(deref.Deref(arg))
 		switch rv.Kind() {
 		case reflect.Array, reflect.Slice:
@@ -273,7 +334,7 @@ func minMax(name string, fn func(any, any) bool, args ...any) (any, error) {
 				switch elemVal.(type) {
 				case int, int8, int16, int32, int64,
 					uint, uint8, uint16, uint32, uint64,
-					float32, float64:
+					float32, float64, *big.Int:
 					if elemVal != nil && (val == nil || fn(val, elemVal)) {
 						val = elemVal
 					}
@@ -332,7 +393,8 @@ func mean(args ...any) (int, float64, error) {
 	return count, total, nil
 }
 
-func median(args ...any) ([]float64, error) {
+// floats collects numbers from the arguments and nested arrays.
+func floats(name string, args ...any) ([]float64, error) {
 	var values []float64
 
 	for _, arg := range args {
@@ -341,7 +403,7 @@ func median(args ...any) ([]float64, error) {
 		case reflect.Array, reflect.Slice:
 			size := rv.Len()
 			for i := 0; i < size; i++ {
-				elems, err := median(rv.Index(i).Interface())
+				elems, err := floats(name, rv.Index(i).Interface())
 				if err != nil {
 					return nil, err
 				}
@@ -354,8 +416,537 @@ func median(args ...any) ([]float64, error) {
 		case reflect.Float32, reflect.Float64:
 			values = append(values, rv.Float())
 		default:
-			return nil, fmt.Errorf("invalid argument for median (type %T)", arg)
+			return nil, fmt.Errorf("invalid argument for %s (type %T)", name, arg)
 		}
 	}
 	return values, nil
 }
+
+// variance returns the population variance of the values.
+func variance(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	m := sum / float64(len(values))
+	var squares float64
+	for _, v := range values {
+		squares += (v - m) * (v - m)
+	}
+	return squares / float64(len(values))
+}
+
+// percentile returns the p-th percentile of the sorted values, interpolating
+// linearly between the closest ranks.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+func dateAdd(t time.Time, n int, unit string) (time.Time, error) {
+	switch unit {
+	case "nanosecond", "nanoseconds":
+		return t.Add(time.Duration(n)), nil
+	case "microsecond", "microseconds":
+		return t.Add(time.Duration(n) * time.Microsecond), nil
+	case "millisecond", "milliseconds":
+		return t.Add(time.Duration(n) * time.Millisecond), nil
+	case "second", "seconds":
+		return t.Add(time.Duration(n) * time.Second), nil
+	case "minute", "minutes":
+		return t.Add(time.Duration(n) * time.Minute), nil
+	case "hour", "hours":
+		return t.Add(time.Duration(n) * time.Hour), nil
+	case "day", "days":
+		return t.AddDate(0, 0, n), nil
+	case "week", "weeks":
+		return t.AddDate(0, 0, 7*n), nil
+	case "month", "months":
+		return t.AddDate(0, n, 0), nil
+	case "year", "years":
+		return t.AddDate(n, 0, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("unknown unit %q for dateAdd", unit)
+}
+
+func startOf(t time.Time, unit string) (time.Time, error) {
+	switch unit {
+	case "second":
+		return t.Truncate(time.Second), nil
+	case "minute":
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, t.Location()), nil
+	case "hour":
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location()), nil
+	case "day":
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()), nil
+	case "week":
+		// Weeks start on Monday.
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, t.Location()), nil
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location()), nil
+	case "year":
+		return time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, t.Location()), nil
+	}
+	return time.Time{}, fmt.Errorf("unknown unit %q for startOf", unit)
+}
+
+func parseURL(s string) (map[string]any, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	query := map[string]any{}
+	for key, values := range u.Query() {
+		// Repeated parameters are available via the "rawQuery" field.
+		query[key] = values[0]
+	}
+	return map[string]any{
+		"scheme":   u.Scheme,
+		"user":     u.User.Username(),
+		"host":     u.Host,
+		"hostname": u.Hostname(),
+		"port":     u.Port(),
+		"path":     u.Path,
+		"query":    query,
+		"rawQuery": u.RawQuery,
+		"fragment": u.Fragment,
+	}, nil
+}
+
+// hashInput returns bytes of the value to hash. Non-string values are hashed
+// by their string representation, so crc32(42) == crc32("42").
+func hashInput(arg any) []byte {
+	switch v := arg.(type) {
+	case string:
+		return []byte(v)
+	case []byte:
+		return v
+	}
+	return []byte(fmt.Sprintf("%v", arg))
+}
+
+// toRegexp accepts a pattern string or a regexp precompiled by the compiler
+// for constant patterns.
+func toRegexp(pattern any) (*regexp.Regexp, error) {
+	switch p := pattern.(type) {
+	case *regexp.Regexp:
+		return p, nil
+	case string:
+		return regexp.Compile(p)
+	}
+	return nil, fmt.Errorf("invalid pattern (type %T)", pattern)
+}
+
+// matchGroups returns capture groups of the first match by their position,
+// like "1", and by their name for named groups. Group "0" is the whole match.
+func matchGroups(re *regexp.Regexp, s string) any {
+	match := re.FindStringSubmatch(s)
+	if match == nil {
+		return nil
+	}
+	groups := make(map[string]string, len(match))
+	for i, name := range re.SubexpNames() {
+		groups[strconv.Itoa(i)] = match[i]
+		if name != "" {
+			groups[name] = match[i]
+		}
+	}
+	return groups
+}
+
+// pad pads the string with spaces, or with the given pad string, until it is
+// n characters long. Longer strings are returned as is.
+func pad(name string, args []any, left bool) (any, uint, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, 0, fmt.Errorf("invalid number of arguments for %s (expected 2 or 3, got %d)", name, len(args))
+	}
+	s := args[0].(string)
+	n := runtime.ToInt(args[1])
+	padding := " "
+	if len(args) == 3 {
+		padding = args[2].(string)
+	}
+	if padding == "" {
+		return nil, 0, fmt.Errorf("invalid argument for %s (pad string is empty)", name)
+	}
+	if n > 1e6 {
+		return nil, 0, fmt.Errorf("memory budget exceeded")
+	}
+
+	size := n - utf8.RuneCountInString(s)
+	if size <= 0 {
+		return s, 0, nil
+	}
+	runes := []rune(strings.Repeat(padding, size/utf8.RuneCountInString(padding)+1))
+	fill := string(runes[:size])
+	if left {
+		return fill + s, uint(len(fill)), nil
+	}
+	return s + fill, uint(len(fill)), nil
+}
+
+// setOperation returns unique elements of both arrays, in order of their first
+// appearance, for which keep returns true.
+func setOperation(name string, args []any, keep func(inA, inB bool) bool) ([]any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("invalid number of arguments (expected 2, got %d)", len(args))
+	}
+	a := reflect.ValueOf(args[0])
+	b := reflect.ValueOf(args[1])
+	for _, v := range []reflect.Value{a, b} {
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			return nil, fmt.Errorf("cannot %s %s", name, v.Kind())
+		}
+	}
+
+	inA, inB := newValueSet(a), newValueSet(b)
+	seen := newValueSet(reflect.Value{})
+	out := make([]any, 0)
+	for _, v := range []reflect.Value{a, b} {
+		for i := 0; i < v.Len(); i++ {
+			item := v.Index(i).Interface()
+			if keep(inA.contains(item), inB.contains(item)) && !seen.contains(item) {
+				seen.add(item)
+				out = append(out, item)
+			}
+		}
+	}
+	return out, nil
+}
+
+// valueSet is a set of values compared with runtime.Equal. Plain numbers,
+// strings and bools are hashed, other values are compared one by one.
+type valueSet struct {
+	hashed map[any][]any
+	others []any
+	all    []any
+}
+
+// newValueSet returns the set of elements of the array, or an empty set
+// for the invalid value.
+func newValueSet(array reflect.Value) *valueSet {
+	s := &valueSet{hashed: make(map[any][]any)}
+	if array.IsValid() {
+		for i := 0; i < array.Len(); i++ {
+			s.add(array.Index(i).Interface())
+		}
+	}
+	return s
+}
+
+func (s *valueSet) add(item any) {
+	s.all = append(s.all, item)
+	if key, ok := hashKey(item); ok {
+		s.hashed[key] = append(s.hashed[key], item)
+	} else {
+		s.others = append(s.others, item)
+	}
+}
+
+func (s *valueSet) contains(item any) bool {
+	candidates := s.all
+	if key, ok := hashKey(item); ok {
+		for _, x := range s.hashed[key] {
+			if runtime.Equal(x, item) {
+				return true
+			}
+		}
+		// Values which are not hashed, like big numbers, may still be
+		// equal to the item.
+		candidates = s.others
+	}
+	for _, x := range candidates {
+		if runtime.Equal(x, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// hashKey returns the key of the plain number, string or bool in sets. Numbers
+// are keyed by the float64 runtime.Equal compares them as, so 1 and 1.0 share
+// the key. Integers which wrap around as int are not hashed.
+func hashKey(item any) (any, bool) {
+	v := reflect.ValueOf(item)
+	if !v.IsValid() || v.Type().Name() != v.Kind().String() {
+		return nil, false
+	}
+	switch v.Kind() {
+	case reflect.String, reflect.Bool:
+		return item, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, false
+		}
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return nil, false
+}
+
+func validateSetOperation(name string) func(args []reflect.Type) (reflect.Type, error) {
+	return func(args []reflect.Type) (reflect.Type, error) {
+		if len(args) != 2 {
+			return anyType, fmt.Errorf("invalid number of arguments (expected 2, got %d)", len(args))
+		}
+		for _, arg := range args {
+			switch kind(arg) {
+			case reflect.Interface, reflect.Slice, reflect.Array:
+			default:
+				return anyType, fmt.Errorf("cannot %s %s", name, arg)
+			}
+		}
+		return arrayType, nil
+	}
+}
+
+// get returns the value of the key or of the path, like get(user, "name")
+// or get(user, "address.city", "unknown"), or nil if it is missing.
+func get(access *runtime.Access, args ...any) (out any, err error) {
+	if len(args) == 3 {
+		return getPath(access, args[0], args[1], args[2])
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			if denied, ok := r.(*runtime.AccessError); ok {
+				err = denied
+			}
+		}
+	}()
+	return access.Fetch(args[0], args[1]), nil
+}
+
+func has(access *runtime.Access, args ...any) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("invalid number of arguments (expected 2, got %d)", len(args))
+	}
+	return hasPath(access, args[0], args[1])
+}
+
+func pluckArgs(access *runtime.Access, args ...any) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("invalid number of arguments (expected 2, got %d)", len(args))
+	}
+	name, ok := args[1].(string)
+	if !ok {
+		return nil, fmt.Errorf("cannot pluck %T", args[1])
+	}
+	return pluck(access, args[0], name)
+}
+
+// pluck returns values of the field or the map key of each element. The result
+// is typed by the field type, if the array element type is known.
+func pluck(access *runtime.Access, collection any, name string) (any, error) {
+	v := reflect.ValueOf(collection)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("cannot pluck from %s", v.Kind())
+	}
+
+	t := anyType
+	switch elem := deref.Type(v.Type().Elem()); elem.Kind() {
+	case reflect.Struct:
+		field, ok := access.FieldByName(elem, name)
+		if ok {
+			t = field.Type
+		}
+	case reflect.Map:
+		t = elem.Elem()
+	}
+
+	out := reflect.MakeSlice(reflect.SliceOf(t), v.Len(), v.Len())
+	for i := 0; i < v.Len(); i++ {
+		value := access.Fetch(v.Index(i).Interface(), name)
+		if value != nil {
+			out.Index(i).Set(reflect.ValueOf(value))
+		}
+	}
+	return out.Interface(), nil
+}
+
+// getPath traverses the value by the path, like "a.b[2].c". Returns the
+// default if any of the path segments is missing or nil.
+func getPath(access *runtime.Access, from, path, def any) (any, error) {
+	var segments []any
+	if p, ok := path.(string); ok {
+		var err error
+		segments, err = parsePath(p)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		segments = []any{path}
+	}
+
+	for _, segment := range segments {
+		var ok bool
+		from, ok = fetchSegment(access, from, segment)
+		if !ok || runtime.IsNil(from) {
+			return def, nil
+		}
+	}
+	return from, nil
+}
+
+// hasPath reports whether every segment of the path exists, even if the
+// value at the end of the path is nil.
+func hasPath(access *runtime.Access, from, path any) (bool, error) {
+	segments := []any{path}
+	if p, ok := path.(string); ok {
+		var err error
+		segments, err = parsePath(p)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	for _, segment := range segments {
+		var ok bool
+		from, ok = fetchSegment(access, from, segment)
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// parsePath splits the path into string keys and int indexes.
+func parsePath(path string) ([]any, error) {
+	var segments []any
+	for i := 0; i < len(path); {
+		switch path[i] {
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %q (unclosed bracket)", path)
+			}
+			index, err := strconv.Atoi(path[i+1 : i+end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid path %q (non-integer index %q)", path, path[i+1:i+end])
+			}
+			segments = append(segments, index)
+			i += end + 1
+		case '.':
+			if i == 0 || i == len(path)-1 || path[i+1] == '.' || path[i+1] == '[' {
+				return nil, fmt.Errorf("invalid path %q (empty key)", path)
+			}
+			i++
+		default:
+			end := strings.IndexAny(path[i:], ".[")
+			if end < 0 {
+				end = len(path) - i
+			}
+			segments = append(segments, path[i:i+end])
+			i += end
+		}
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("invalid path %q (empty key)", path)
+	}
+	return segments, nil
+}
+
+func fetchSegment(access *runtime.Access, from, key any) (out any, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, denied := r.(*runtime.AccessError); denied {
+				panic(r)
+			}
+			out, ok = nil, false
+		}
+	}()
+
+	v := deref.Value(reflect.ValueOf(from))
+	if v.Kind() == reflect.Map {
+		k := reflect.ValueOf(key)
+		kt := v.Type().Key()
+		switch {
+		case k.Type() == kt:
+		case k.Kind() == kt.Kind():
+			k = k.Convert(kt)
+		case kt.Kind() == reflect.Interface:
+		default:
+			return nil, false
+		}
+		value := v.MapIndex(k)
+		if !value.IsValid() {
+			return nil, false
+		}
+		return value.Interface(), true
+	}
+	return access.Fetch(from, key), true
+}
+
+// mergeMaps deeply merges the override into a copy of the base. Nested maps
+// are merged recursively, other values of the override win. Slices are
+// replaced, or concatenated if appendSlices is set.
+func mergeMaps(base, override any, appendSlices bool) any {
+	b := deref.Value(reflect.ValueOf(base))
+	o := deref.Value(reflect.ValueOf(override))
+
+	outType := reflect.TypeOf(map[string]any{})
+	for _, v := range []reflect.Value{b, o} {
+		if v.Kind() == reflect.Map && v.Type().Key().Kind() != reflect.String {
+			outType = mapType
+		}
+	}
+
+	out := reflect.MakeMap(outType)
+	key := func(k reflect.Value) reflect.Value {
+		if outType.Key().Kind() == reflect.String {
+			return k.Convert(outType.Key())
+		}
+		return k
+	}
+	if b.Kind() == reflect.Map {
+		iter := b.MapRange()
+		for iter.Next() {
+			out.SetMapIndex(key(iter.Key()), iter.Value())
+		}
+	}
+	if o.Kind() == reflect.Map {
+		iter := o.MapRange()
+		for iter.Next() {
+			k := key(iter.Key())
+			value := iter.Value().Interface()
+			if prev := out.MapIndex(k); prev.IsValid() {
+				value = mergeValues(prev.Interface(), value, appendSlices)
+			}
+			out.SetMapIndex(k, reflect.ValueOf(&value).Elem())
+		}
+	}
+	return out.Interface()
+}
+
+func mergeValues(prev, next any, appendSlices bool) any {
+	p := deref.Value(reflect.ValueOf(prev))
+	n := deref.Value(reflect.ValueOf(next))
+	switch {
+	case p.Kind() == reflect.Map && n.Kind() == reflect.Map:
+		return mergeMaps(prev, next, appendSlices)
+	case appendSlices && isSlice(p) && isSlice(n):
+		out := make([]any, 0, p.Len()+n.Len())
+		for _, v := range []reflect.Value{p, n} {
+			for i := 0; i < v.Len(); i++ {
+				out = append(out, v.Index(i).Interface())
+			}
+		}
+		return out
+	}
+	return next
+}
+
+func isSlice(v reflect.Value) bool {
+	return v.Kind() == reflect.Slice || v.Kind() == reflect.Array
+}
@@ -0,0 +1,158 @@
+package builtin
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Matcher is a list of strings indexed by the first and the last byte, used
+// by containsAny(), startsWithAny() and endsWithAny(). For constant lists
+// the compiler creates the matcher once, instead of on every call.
+type Matcher struct {
+	empty bool
+	first map[byte][]string
+	last  map[byte][]string
+}
+
+// NewMatcher creates a Matcher for the strings.
+func NewMatcher(strs []string) *Matcher {
+	m := &Matcher{
+		first: make(map[byte][]string),
+		last:  make(map[byte][]string),
+	}
+	for _, s := range strs {
+		if s == "" {
+			m.empty = true
+			continue
+		}
+		m.first[s[0]] = append(m.first[s[0]], s)
+		m.last[s[len(s)-1]] = append(m.last[s[len(s)-1]], s)
+	}
+	return m
+}
+
+// Strings returns the strings of the matcher, sorted.
+func (m *Matcher) Strings() []string {
+	var out []string
+	if m.empty {
+		out = append(out, "")
+	}
+	for _, strs := range m.first {
+		out = append(out, strs...)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Contains reports whether any of the strings is a substring of s.
+func (m *Matcher) Contains(s string) bool {
+	if m.empty {
+		return true
+	}
+	for i := 0; i < len(s); i++ {
+		for _, needle := range m.first[s[i]] {
+			if strings.HasPrefix(s[i:], needle) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasPrefix reports whether s starts with any of the strings.
+func (m *Matcher) HasPrefix(s string) bool {
+	if m.empty {
+		return true
+	}
+	if s == "" {
+		return false
+	}
+	for _, prefix := range m.first[s[0]] {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasSuffix reports whether s ends with any of the strings.
+func (m *Matcher) HasSuffix(s string) bool {
+	if m.empty {
+		return true
+	}
+	if s == "" {
+		return false
+	}
+	for _, suffix := range m.last[s[len(s)-1]] {
+		if strings.HasSuffix(s, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func toMatcher(name string, list any) (*Matcher, error) {
+	if m, ok := list.(*Matcher); ok {
+		return m, nil
+	}
+	if strs, ok := list.([]string); ok {
+		return NewMatcher(strs), nil
+	}
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("invalid argument for %s (type %T)", name, list)
+	}
+	strs := make([]string, v.Len())
+	for i := range strs {
+		s, ok := v.Index(i).Interface().(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid argument for %s (type %T)", name, v.Index(i).Interface())
+		}
+		strs[i] = s
+	}
+	return NewMatcher(strs), nil
+}
+
+func matchAny(name string, match func(*Matcher, string) bool) *Function {
+	return &Function{
+		Name: name,
+		Func: func(args ...any) (any, error) {
+			if len(args) != 2 {
+				return nil, fmt.Errorf("invalid number of arguments (expected 2, got %d)", len(args))
+			}
+			s, ok := args[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid argument for %s (type %T)", name, args[0])
+			}
+			m, err := toMatcher(name, args[1])
+			if err != nil {
+				return nil, err
+			}
+			return match(m, s), nil
+		},
+		Validate: func(args []reflect.Type) (reflect.Type, error) {
+			if len(args) != 2 {
+				return anyType, fmt.Errorf("invalid number of arguments (expected 2, got %d)", len(args))
+			}
+			switch kind(args[0]) {
+			case reflect.Interface, reflect.String:
+			default:
+				return anyType, fmt.Errorf("invalid argument for %s (type %s)", name, args[0])
+			}
+			switch kind(args[1]) {
+			case reflect.Interface:
+			case reflect.Slice, reflect.Array:
+				switch kind(args[1].Elem()) {
+				case reflect.Interface, reflect.String:
+				default:
+					return anyType, fmt.Errorf("invalid argument for %s (type %s)", name, args[1])
+				}
+			default:
+				return anyType, fmt.Errorf("invalid argument for %s (type %s)", name, args[1])
+			}
+			return boolType, nil
+		},
+	}
+}
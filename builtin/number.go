@@ -0,0 +1,137 @@
+package builtin
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type numberLocale struct {
+	decimal string
+	group   string
+}
+
+// locales maps base language codes to separators used in numbers.
+var locales = map[string]numberLocale{
+	"en": {".", ","},
+	"de": {",", "."},
+	"es": {",", "."},
+	"it": {",", "."},
+	"nl": {",", "."},
+	"pt": {",", "."},
+	"fr": {",", "\u202f"},
+	"ru": {",", "\u00a0"},
+	"pl": {",", "\u00a0"},
+	"ch": {".", "'"},
+}
+
+func findLocale(name string) (numberLocale, error) {
+	lang := strings.ToLower(name)
+	if i := strings.IndexAny(lang, "-_"); i >= 0 {
+		lang = lang[:i]
+	}
+	l, ok := locales[lang]
+	if !ok {
+		return numberLocale{}, fmt.Errorf("unknown locale %q", name)
+	}
+	return l, nil
+}
+
+func parseFloatLocale(s, locale string) (float64, error) {
+	l, err := findLocale(locale)
+	if err != nil {
+		return 0, err
+	}
+	s = strings.TrimSpace(s)
+	if strings.TrimSpace(l.group) == "" {
+		// Any kind of space is accepted, as they are hard to tell apart.
+		s = strings.NewReplacer(" ", "", "\u00a0", "", "\u202f", "").Replace(s)
+	} else {
+		s = strings.ReplaceAll(s, l.group, "")
+	}
+	s = strings.ReplaceAll(s, l.decimal, ".")
+	return strconv.ParseFloat(s, 64)
+}
+
+// formatNumber formats the number by the pattern, like "#,##0.00". The
+// pattern consists of an optional prefix, grouping separator, minimal
+// number of integer digits ("0"), minimal and maximal number of fraction
+// digits ("0" and "#" after the dot) and an optional suffix. A "%" suffix
+// multiplies the number by 100.
+func formatNumber(n float64, pattern, locale string) (string, error) {
+	l, err := findLocale(locale)
+	if err != nil {
+		return "", err
+	}
+
+	start := strings.IndexAny(pattern, "#0,.")
+	end := strings.LastIndexAny(pattern, "#0,.")
+	if start < 0 {
+		return "", fmt.Errorf("invalid number pattern %q", pattern)
+	}
+	prefix, number, suffix := pattern[:start], pattern[start:end+1], pattern[end+1:]
+	if strings.Contains(suffix, "%") {
+		n *= 100
+	}
+
+	integer, fraction := number, ""
+	if i := strings.IndexByte(number, '.'); i >= 0 {
+		integer, fraction = number[:i], number[i+1:]
+	}
+	if strings.ContainsAny(fraction, ".,") {
+		return "", fmt.Errorf("invalid number pattern %q", pattern)
+	}
+	minInt := strings.Count(integer, "0")
+	minFrac := strings.Count(fraction, "0")
+	maxFrac := len(fraction)
+	groupSize := 0
+	if i := strings.LastIndexByte(integer, ','); i >= 0 {
+		groupSize = len(integer) - i - 1
+	}
+
+	if math.IsNaN(n) || math.IsInf(n, 0) {
+		return prefix + strconv.FormatFloat(n, 'f', -1, 64) + suffix, nil
+	}
+	sign := ""
+	if n < 0 {
+		sign = "-"
+		n = -n
+	}
+
+	digits := strconv.FormatFloat(n, 'f', maxFrac, 64)
+	intDigits, fracDigits := digits, ""
+	if i := strings.IndexByte(digits, '.'); i >= 0 {
+		intDigits, fracDigits = digits[:i], digits[i+1:]
+	}
+	fracDigits = strings.TrimRight(fracDigits, "0")
+	if len(fracDigits) < minFrac {
+		fracDigits += strings.Repeat("0", minFrac-len(fracDigits))
+	}
+	intDigits = strings.TrimLeft(intDigits, "0")
+	if len(intDigits) < minInt {
+		intDigits = strings.Repeat("0", minInt-len(intDigits)) + intDigits
+	}
+	if intDigits == "" && fracDigits == "" {
+		intDigits = "0"
+	}
+	if strings.Trim(intDigits+fracDigits, "0") == "" {
+		sign = ""
+	}
+
+	var out strings.Builder
+	out.WriteString(sign)
+	out.WriteString(prefix)
+	for i, d := range intDigits {
+		if groupSize > 0 && i > 0 && (len(intDigits)-i)%groupSize == 0 {
+			out.WriteString(l.group)
+		}
+		out.WriteRune(d)
+	}
+	if fracDigits != "" {
+		out.WriteString(l.decimal)
+		out.WriteString(fracDigits)
+	}
+	out.WriteString(suffix)
+	return out.String(), nil
+}
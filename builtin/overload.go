@@ -0,0 +1,126 @@
+package builtin
+
+import (
+	"fmt"
+	"reflect"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// Overload returns a function with several implementations of different
+// signatures, like func(time.Time) string and func(float64, int) string.
+// The checker selects the overload by types of arguments and the compiler
+// binds it. Calls with arguments of unknown types are dispatched by types
+// of argument values.
+func Overload(name string, fns ...any) *Function {
+	values := make([]reflect.Value, len(fns))
+	types := make([]reflect.Type, len(fns))
+	overloads := make([]func(args ...any) (any, error), len(fns))
+	for i, fn := range fns {
+		v := reflect.ValueOf(fn)
+		if v.Kind() != reflect.Func {
+			panic(fmt.Sprintf("expr: overload of %s is not a function (got %T)", name, fn))
+		}
+		switch t := v.Type(); {
+		case t.NumOut() == 0 || t.NumOut() > 2:
+			panic(fmt.Sprintf("expr: overload of %s must return a value and an optional error (got %v)", name, t))
+		case t.NumOut() == 2 && t.Out(1) != errorType:
+			panic(fmt.Sprintf("expr: second return value of %s must be an error (got %v)", name, t))
+		}
+		values[i] = v
+		types[i] = v.Type()
+		overloads[i] = func(args ...any) (any, error) {
+			in, ok := overloadArgs(v.Type(), args)
+			if !ok {
+				return nil, fmt.Errorf("cannot call %v with arguments %v", name, typesOf(args))
+			}
+			return call(v, in)
+		}
+	}
+	return &Function{
+		Name:      name,
+		Types:     types,
+		Overloads: overloads,
+		Func: func(args ...any) (any, error) {
+			for _, fn := range values {
+				if in, ok := overloadArgs(fn.Type(), args); ok {
+					return call(fn, in)
+				}
+			}
+			return nil, fmt.Errorf("no overload of %v matches arguments %v", name, typesOf(args))
+		},
+	}
+}
+
+func call(fn reflect.Value, in []reflect.Value) (any, error) {
+	out := fn.Call(in)
+	if len(out) == 2 && !out[1].IsNil() {
+		return nil, out[1].Interface().(error)
+	}
+	return out[0].Interface(), nil
+}
+
+// overloadArgs returns arguments for the call of the function of type fn,
+// if types of argument values match its parameters. Arguments are converted
+// by the rules of the checker, which selected the overload.
+func overloadArgs(fn reflect.Type, args []any) ([]reflect.Value, bool) {
+	numIn := fn.NumIn()
+	if fn.IsVariadic() {
+		if len(args) < numIn-1 {
+			return nil, false
+		}
+	} else if len(args) != numIn {
+		return nil, false
+	}
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var param reflect.Type
+		if fn.IsVariadic() && i >= numIn-1 {
+			param = fn.In(numIn - 1).Elem()
+		} else {
+			param = fn.In(i)
+		}
+		if arg == nil {
+			switch param.Kind() {
+			case reflect.Interface, reflect.Ptr, reflect.Slice, reflect.Map, reflect.Func, reflect.Chan:
+				in[i] = reflect.Zero(param)
+				continue
+			}
+			return nil, false
+		}
+		v := reflect.ValueOf(arg)
+		switch {
+		case v.Type().AssignableTo(param):
+		case isInteger(v.Type()) && isInteger(param):
+			// Integers of any kind are accepted, like by the checker,
+			// if the value fits the parameter.
+			c := v.Convert(param)
+			if c.Convert(v.Type()).Interface() != arg || isNegative(v) != isNegative(c) {
+				return nil, false
+			}
+			v = c
+		case isInteger(v.Type()) && isFloat(param):
+			v = v.Convert(param)
+		default:
+			return nil, false
+		}
+		in[i] = v
+	}
+	return in, true
+}
+
+func isNegative(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() < 0
+	}
+	return false
+}
+
+func typesOf(args []any) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		out[i] = fmt.Sprintf("%T", arg)
+	}
+	return out
+}
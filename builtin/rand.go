@@ -0,0 +1,86 @@
+package builtin
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+)
+
+var (
+	randType    = reflect.TypeOf(new(rand.Rand))
+	defaultRand = NewRand(rand.NewSource(randomSeed()))
+)
+
+// NewRand returns a random number generator backed by the source, which is
+// safe for concurrent use by multiple programs.
+func NewRand(source rand.Source) *rand.Rand {
+	return rand.New(&lockedSource{source: source})
+}
+
+type lockedSource struct {
+	mu     sync.Mutex
+	source rand.Source
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.source.Int63()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.source.Seed(seed)
+}
+
+func randomSeed() int64 {
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return int64(binary.LittleEndian.Uint64(b[:]))
+}
+
+// randArgs splits the generator passed by the patcher.WithRand from the rest
+// of the arguments. Functions use the default generator without it.
+func randArgs(args []any) (*rand.Rand, []any) {
+	if len(args) > 0 {
+		if r, ok := args[0].(*rand.Rand); ok {
+			return r, args[1:]
+		}
+	}
+	return defaultRand, args
+}
+
+func randTypes(args []reflect.Type) []reflect.Type {
+	if len(args) > 0 && args[0] == randType {
+		return args[1:]
+	}
+	return args
+}
+
+// Doesn't use rand.Read, as it is not safe for concurrent use.
+func uuid(r *rand.Rand) string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], r.Uint64())
+	binary.BigEndian.PutUint64(b[8:], r.Uint64())
+	b[6] = b[6]&0x0f | 0x40 // Version 4.
+	b[8] = b[8]&0x3f | 0x80 // Variant 10.
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// shuffled returns a shuffled copy of the array.
+func shuffled(name string, r *rand.Rand, array any) (reflect.Value, error) {
+	v := reflect.ValueOf(array)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return reflect.Value{}, fmt.Errorf("invalid argument for %s (type %T)", name, array)
+	}
+	out := reflect.MakeSlice(reflect.SliceOf(v.Type().Elem()), v.Len(), v.Len())
+	reflect.Copy(out, v)
+	r.Shuffle(out.Len(), reflect.Swapper(out.Interface()))
+	return out, nil
+}
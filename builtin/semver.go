@@ -0,0 +1,185 @@
+package builtin
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version returned by the semver() function. It
+// supports comparison operators with other versions and version strings.
+type Version struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	Prerelease string
+	Build      string
+}
+
+// ParseVersion parses a semantic version, like "1.2.3-rc.1". A leading "v"
+// is allowed, and missing minor and patch numbers default to zero.
+func ParseVersion(s string) (Version, error) {
+	var v Version
+	str := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexByte(str, '+'); i >= 0 {
+		str, v.Build = str[:i], str[i+1:]
+	}
+	if i := strings.IndexByte(str, '-'); i >= 0 {
+		str, v.Prerelease = str[:i], str[i+1:]
+		if v.Prerelease == "" {
+			return Version{}, fmt.Errorf("invalid version %q", s)
+		}
+	}
+	parts := strings.Split(str, ".")
+	if len(parts) > 3 {
+		return Version{}, fmt.Errorf("invalid version %q", s)
+	}
+	numbers := []*uint64{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return Version{}, fmt.Errorf("invalid version %q", s)
+		}
+		*numbers[i] = n
+	}
+	return v, nil
+}
+
+// Compare compares versions by the semantic versioning precedence. Build
+// metadata is ignored.
+func (v Version) Compare(other any) int {
+	var o Version
+	switch x := other.(type) {
+	case Version:
+		o = x
+	case string:
+		var err error
+		if o, err = ParseVersion(x); err != nil {
+			panic(err.Error())
+		}
+	default:
+		panic(fmt.Sprintf("cannot compare %T with %T", v, other))
+	}
+
+	for _, c := range [][2]uint64{{v.Major, o.Major}, {v.Minor, o.Minor}, {v.Patch, o.Patch}} {
+		if c[0] != c[1] {
+			if c[0] < c[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return comparePrerelease(v.Prerelease, o.Prerelease)
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+func comparePrerelease(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, xErr := strconv.ParseUint(as[i], 10, 64)
+		y, yErr := strconv.ParseUint(bs[i], 10, 64)
+		switch {
+		case xErr == nil && yErr == nil:
+			if x != y {
+				if x < y {
+					return -1
+				}
+				return 1
+			}
+		case xErr == nil:
+			return -1 // Numeric identifiers have lower precedence.
+		case yErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+// satisfies checks the version against the constraint, like ">=1.2 <2.0".
+// Comparators separated by spaces or commas must all match, and any of the
+// ranges separated by "||" must match.
+func satisfies(v Version, constraint string) (bool, error) {
+	for _, r := range strings.Split(constraint, "||") {
+		ok := true
+		comparators := strings.FieldsFunc(r, func(c rune) bool { return c == ' ' || c == ',' })
+		if len(comparators) == 0 {
+			return false, fmt.Errorf("invalid constraint %q", constraint)
+		}
+		for _, c := range comparators {
+			match, err := satisfiesComparator(v, c)
+			if err != nil {
+				return false, fmt.Errorf("invalid constraint %q: %w", constraint, err)
+			}
+			ok = ok && match
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func satisfiesComparator(v Version, c string) (bool, error) {
+	op := strings.TrimRight(c, "0123456789.v-+abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	bound, err := ParseVersion(c[len(op):])
+	if err != nil {
+		return false, err
+	}
+	cmp := v.Compare(bound)
+	switch op {
+	case "", "=", "==":
+		return cmp == 0, nil
+	case "!=":
+		return cmp != 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case "~":
+		// Allows patch updates: ~1.2.3 is >=1.2.3 <1.3.0.
+		return cmp >= 0 && v.Major == bound.Major && v.Minor == bound.Minor, nil
+	case "^":
+		// Allows updates which don't change the first non-zero number:
+		// ^1.2.3 is >=1.2.3 <2.0.0, ^0.2.3 is >=0.2.3 <0.3.0.
+		if cmp < 0 || v.Major != bound.Major {
+			return false, nil
+		}
+		if bound.Major == 0 {
+			return v.Minor == bound.Minor, nil
+		}
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown operator %q", op)
+}
@@ -2,18 +2,21 @@ package builtin
 
 import (
 	"fmt"
+	"math/big"
 	"reflect"
 	"time"
 )
 
 var (
 	anyType      = reflect.TypeOf(new(any)).Elem()
+	boolType     = reflect.TypeOf(true)
 	integerType  = reflect.TypeOf(0)
 	floatType    = reflect.TypeOf(float64(0))
 	arrayType    = reflect.TypeOf([]any{})
 	mapType      = reflect.TypeOf(map[any]any{})
 	timeType     = reflect.TypeOf(new(time.Time)).Elem()
 	locationType = reflect.TypeOf(new(time.Location))
+	bigIntType   = reflect.TypeOf(new(big.Int))
 )
 
 func kind(t reflect.Type) reflect.Kind {
@@ -85,3 +88,24 @@ func bitFunc(name string, fn func(x, y int) (any, error)) *Function {
 		Types: types(new(func(int, int) int)),
 	}
 }
+
+func isAny(t reflect.Type) bool {
+	return kind(t) == reflect.Interface
+}
+
+func isInteger(t reflect.Type) bool {
+	switch kind(t) {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+func isFloat(t reflect.Type) bool {
+	switch kind(t) {
+	case reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
@@ -12,7 +12,12 @@ func validateAggregateFunc(name string, args []reflect.Type) (reflect.Type, erro
 	case 0:
 		return anyType, fmt.Errorf("not enough arguments to call %s", name)
 	default:
+		out := args[0]
 		for _, arg := range args {
+			if arg == bigIntType {
+				out = anyType // The result is either a big integer or a number.
+				continue
+			}
 			switch kind(deref.Type(arg)) {
 			case reflect.Interface, reflect.Array, reflect.Slice:
 				return anyType, nil
@@ -21,7 +26,7 @@ func validateAggregateFunc(name string, args []reflect.Type) (reflect.Type, erro
 				return anyType, fmt.Errorf("invalid argument for %s (type %s)", name, arg)
 			}
 		}
-		return args[0], nil
+		return out, nil
 	}
 }
 
@@ -36,3 +41,14 @@ func validateRoundFunc(name string, args []reflect.Type) (reflect.Type, error) {
 		return anyType, fmt.Errorf("invalid argument for %s (type %s)", name, args[0])
 	}
 }
+
+func validateNumbers(name string, args []reflect.Type) error {
+	for _, arg := range args {
+		switch kind(arg) {
+		case reflect.Interface, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		default:
+			return fmt.Errorf("invalid argument for %s (type %s)", name, arg)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package checker
+
+import (
+	"reflect"
+
+	"github.com/expr-lang/expr/vm/runtime"
+)
+
+// restricted reports whether the config has access rules.
+func (v *checker) restricted() bool {
+	return len(v.config.Allowed) > 0 || len(v.config.Denied) > 0
+}
+
+// denied reports whether access to the name is rejected. Names of the
+// environment must be allowed, if the allowlist is set, while functions
+// and builtins are only checked against the denylist.
+func (v *checker) denied(name string) bool {
+	if v.config.Denied[name] {
+		return true
+	}
+	if len(v.config.Allowed) == 0 || v.config.Allowed[name] {
+		return false
+	}
+	if _, ok := v.config.Types[name]; ok {
+		return true
+	}
+	if _, ok := v.config.Functions[name]; ok {
+		return false
+	}
+	if _, ok := v.config.Builtins[name]; ok {
+		return false
+	}
+	return true
+}
+
+// memberDenied reports whether access to the field or method of the named
+// type is rejected. Members are named by runtime.MemberName, so the checker
+// and the vm apply the same rules.
+func (v *checker) memberDenied(base reflect.Type, name string) bool {
+	access := &runtime.Access{Allowed: v.config.Allowed, Denied: v.config.Denied}
+	return access.Forbids(base, name)
+}
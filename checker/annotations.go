@@ -0,0 +1,40 @@
+package checker
+
+import (
+	"reflect"
+
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/file"
+	"github.com/expr-lang/expr/parser"
+)
+
+// Annotation is the type inferred by the checker for a node of the tree.
+type Annotation struct {
+	Node     ast.Node
+	Type     reflect.Type
+	Location file.Location // Location of the node token, like the operator of a binary node.
+}
+
+// Annotations returns types of all nodes of the checked tree, children
+// before parents, so tools like autocomplete, hover info or query planners
+// can reuse the work of the checker.
+func Annotations(tree *parser.Tree) []Annotation {
+	if tree == nil {
+		return nil
+	}
+	a := &annotations{}
+	ast.Walk(&tree.Node, a)
+	return a.list
+}
+
+type annotations struct {
+	list []Annotation
+}
+
+func (a *annotations) Visit(node *ast.Node) {
+	a.list = append(a.list, Annotation{
+		Node:     *node,
+		Type:     (*node).Type(),
+		Location: (*node).Location(),
+	})
+}
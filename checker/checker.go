@@ -11,6 +11,7 @@ import (
 	"github.com/expr-lang/expr/file"
 	"github.com/expr-lang/expr/internal/deref"
 	"github.com/expr-lang/expr/parser"
+	"github.com/expr-lang/expr/vm"
 )
 
 // ParseCheck parses input expression and checks its types. Also, it applies
@@ -47,11 +48,20 @@ func ParseCheck(input string, config *conf.Config) (*parser.Tree, error) {
 		return tree, err
 	}
 
+	if config.OnWarning != nil {
+		for _, warning := range Warnings(tree, config) {
+			config.OnWarning(warning)
+		}
+	}
+
 	return tree, nil
 }
 
 // Check checks types of the expression tree. It returns type of the expression
 // and error if any. If config is nil, then default configuration will be used.
+//
+// All type errors are collected in one pass. A single error is returned as
+// *file.Error, several errors are returned as file.ErrorList.
 func Check(tree *parser.Tree, config *conf.Config) (t reflect.Type, err error) {
 	if config == nil {
 		config = conf.New(nil)
@@ -61,8 +71,27 @@ func Check(tree *parser.Tree, config *conf.Config) (t reflect.Type, err error) {
 
 	t, _ = v.visit(tree.Node)
 
-	if v.err != nil {
-		return t, v.err.Bind(tree.Source)
+	switch len(v.errors) {
+	case 0:
+	case 1:
+		return t, v.errors[0].Bind(tree.Source)
+	default:
+		for _, err := range v.errors {
+			err.Bind(tree.Source)
+		}
+		return t, v.errors
+	}
+
+	if v.config.ExpectCoerce {
+		if name, ok := coercion(t, v.config.Expect); ok {
+			node := &ast.BuiltinNode{Name: name, Arguments: []ast.Node{tree.Node}}
+			node.SetLocation(tree.Node.Location())
+			tree.Node = node
+			t, _ = v.visit(tree.Node)
+			if len(v.errors) > 0 {
+				return t, v.errors[0].Bind(tree.Source)
+			}
+		}
 	}
 
 	if v.config.Expect != reflect.Invalid {
@@ -94,7 +123,12 @@ type checker struct {
 	config          *conf.Config
 	predicateScopes []predicateScope
 	varScopes       []varScope
-	err             *file.Error
+	errors          file.ErrorList
+	nonNil          map[nilFact]int // expressions checked for nil
+	depth           int
+	nodes           map[ast.Node]bool // visited nodes, if MaxNodes is set
+	limited         bool              // MaxDepth or MaxNodes is exceeded
+	ifaces          []reflect.Type    // interfaces of the environment
 }
 
 type predicateScope struct {
@@ -106,6 +140,7 @@ type varScope struct {
 	name  string
 	vtype reflect.Type
 	info  info
+	depth int // number of predicate scopes of the declaration
 }
 
 type info struct {
@@ -117,9 +152,33 @@ type info struct {
 	// we would like to detect expressions
 	// like `42 in ["a"]` as invalid.
 	elem reflect.Type
+
+	// typed is true if all elements are known to be of
+	// the elem type, like in the result of filter().
+	typed bool
 }
 
 func (v *checker) visit(node ast.Node) (reflect.Type, info) {
+	if v.limited {
+		return anyType, info{}
+	}
+	v.depth++
+	defer func() { v.depth-- }()
+	if max := v.config.MaxDepth; max > 0 && v.depth > max {
+		v.limited = true
+		return v.error(node, "expression is too deeply nested (max depth is %d)", max)
+	}
+	if max := v.config.MaxNodes; max > 0 {
+		if v.nodes == nil {
+			v.nodes = make(map[ast.Node]bool)
+		}
+		v.nodes[node] = true
+		if len(v.nodes) > max {
+			v.limited = true
+			return v.error(node, "expression is too complex (max %d nodes)", max)
+		}
+	}
+
 	var t reflect.Type
 	var i info
 	switch n := node.(type) {
@@ -173,12 +232,10 @@ func (v *checker) visit(node ast.Node) (reflect.Type, info) {
 }
 
 func (v *checker) error(node ast.Node, format string, args ...any) (reflect.Type, info) {
-	if v.err == nil { // show first error
-		v.err = &file.Error{
-			Location: node.Location(),
-			Message:  fmt.Sprintf(format, args...),
-		}
-	}
+	v.errors = append(v.errors, &file.Error{
+		Location: node.Location(),
+		Message:  fmt.Sprintf(format, args...),
+	})
 	return anyType, info{} // interface represent undefined type
 }
 
@@ -191,8 +248,14 @@ func (v *checker) IdentifierNode(node *ast.IdentifierNode) (reflect.Type, info)
 		return s.vtype, s.info
 	}
 	if node.Value == "$env" {
+		if v.restricted() {
+			return v.error(node, "access denied to %v", node.Value)
+		}
 		return mapType, info{}
 	}
+	if v.denied(node.Value) {
+		return v.error(node, "access denied to %v", node.Value)
+	}
 	return v.ident(node, node.Value, true, true)
 }
 
@@ -211,8 +274,21 @@ func (v *checker) ident(node ast.Node, name string, strict, builtins bool) (refl
 		if fn, ok := v.config.Builtins[name]; ok {
 			return fn.Type(), info{fn: fn}
 		}
+		if _, ok := builtin.Index[name]; ok && v.config.Disabled[name] {
+			return v.error(node, "builtin %v is disabled", name)
+		}
+	}
+	if v.config.IdentifierResolver != nil {
+		if t, ok := v.config.IdentifierResolver(name); ok {
+			return t, info{}
+		}
 	}
 	if v.config.Strict && strict {
+		if env := deref.Type(reflect.TypeOf(v.config.Env)); kind(env) == reflect.Struct {
+			if field, ok := fetchField(env, name, v.config.FieldTag); ok && !field.IsExported() {
+				return v.unexportedField(node, env, field)
+			}
+		}
 		return v.error(node, "unknown name %v", name)
 	}
 	if v.config.DefaultType != nil {
@@ -221,6 +297,10 @@ func (v *checker) ident(node ast.Node, name string, strict, builtins bool) (refl
 	return anyType, info{}
 }
 
+func (v *checker) unexportedField(node ast.Node, t reflect.Type, field reflect.StructField) (reflect.Type, info) {
+	return v.error(node, "cannot access unexported field %v of type %v (export the field or add an accessor method)", field.Name, t)
+}
+
 func (v *checker) IntegerNode(*ast.IntegerNode) (reflect.Type, info) {
 	return integerType, info{}
 }
@@ -245,6 +325,10 @@ func (v *checker) UnaryNode(node *ast.UnaryNode) (reflect.Type, info) {
 	t, _ := v.visit(node.Node)
 	t = deref.Type(t)
 
+	if msg, ok := constOverflow(node); ok {
+		return v.error(node, "%v", msg)
+	}
+
 	switch node.Operator {
 
 	case "!", "not":
@@ -257,8 +341,14 @@ func (v *checker) UnaryNode(node *ast.UnaryNode) (reflect.Type, info) {
 
 	case "+", "-":
 		if isNumber(t) {
+			if node.Operator == "-" {
+				return v.promoted(t), info{}
+			}
 			return t, info{}
 		}
+		if isBigInt(t) {
+			return bigIntType, info{}
+		}
 		if isAny(t) {
 			return anyType, info{}
 		}
@@ -272,16 +362,55 @@ func (v *checker) UnaryNode(node *ast.UnaryNode) (reflect.Type, info) {
 
 func (v *checker) BinaryNode(node *ast.BinaryNode) (reflect.Type, info) {
 	l, _ := v.visit(node.Left)
-	r, ri := v.visit(node.Right)
+
+	var r reflect.Type
+	var ri info
+	switch node.Operator {
+	case "and", "&&":
+		r, ri = v.visitNonNil(node.Right, nilChecks(node.Left, true))
+	case "or", "||":
+		r, ri = v.visitNonNil(node.Right, nilChecks(node.Left, false))
+	default:
+		r, ri = v.visit(node.Right)
+	}
 
 	l = deref.Type(l)
 	r = deref.Type(r)
 
+	if msg, ok := constOverflow(node); ok && !v.division(node.Operator) {
+		return v.error(node, "%v", msg)
+	}
+
+	if (isBigInt(l) || isBigInt(r)) && isBigIntOperand(l) && isBigIntOperand(r) {
+		// Arithmetic of big integers with floats is float arithmetic.
+		float := isFloat(l) || isFloat(r)
+		switch node.Operator {
+		case "+", "-", "*":
+			if float {
+				return floatType, info{}
+			}
+			return bigIntType, info{}
+		case "%":
+			if !float {
+				return v.quotient(bigIntType), info{}
+			}
+		case "/":
+			return v.quotient(floatType), info{}
+		case "**", "^":
+			return floatType, info{}
+		case "==", "!=", "<", ">", "<=", ">=":
+			return boolType, info{}
+		}
+	}
+
 	switch node.Operator {
 	case "==", "!=":
 		if isComparable(l, r) {
 			return boolType, info{}
 		}
+		if isComparer(l) && isString(r) || isString(l) && isComparer(r) {
+			return boolType, info{}
+		}
 
 	case "or", "||", "and", "&&":
 		if isBool(l) && isBool(r) {
@@ -304,10 +433,16 @@ func (v *checker) BinaryNode(node *ast.BinaryNode) (reflect.Type, info) {
 		if or(l, r, isNumber, isString, isTime) {
 			return boolType, info{}
 		}
+		if isComparer(l) && (l == r || isString(r) || isAny(r)) {
+			return boolType, info{}
+		}
+		if isAny(l) && isComparer(r) {
+			return boolType, info{}
+		}
 
 	case "-":
 		if isNumber(l) && isNumber(r) {
-			return combined(l, r), info{}
+			return v.promoted(combined(l, r)), info{}
 		}
 		if isTime(l) && isTime(r) {
 			return durationType, info{}
@@ -320,8 +455,11 @@ func (v *checker) BinaryNode(node *ast.BinaryNode) (reflect.Type, info) {
 		}
 
 	case "*":
+		if isDuration(l) && isInteger(r) || isInteger(l) && isDuration(r) {
+			return durationType, info{}
+		}
 		if isNumber(l) && isNumber(r) {
-			return combined(l, r), info{}
+			return v.promoted(combined(l, r)), info{}
 		}
 		if or(l, r, isNumber) {
 			return anyType, info{}
@@ -329,10 +467,10 @@ func (v *checker) BinaryNode(node *ast.BinaryNode) (reflect.Type, info) {
 
 	case "/":
 		if isNumber(l) && isNumber(r) {
-			return floatType, info{}
+			return v.quotient(floatType), info{}
 		}
 		if or(l, r, isNumber) {
-			return floatType, info{}
+			return v.quotient(floatType), info{}
 		}
 
 	case "**", "^":
@@ -345,7 +483,7 @@ func (v *checker) BinaryNode(node *ast.BinaryNode) (reflect.Type, info) {
 
 	case "%":
 		if isInteger(l) && isInteger(r) {
-			return combined(l, r), info{}
+			return v.quotient(combined(l, r)), info{}
 		}
 		if or(l, r, isInteger) {
 			return anyType, info{}
@@ -353,7 +491,7 @@ func (v *checker) BinaryNode(node *ast.BinaryNode) (reflect.Type, info) {
 
 	case "+":
 		if isNumber(l) && isNumber(r) {
-			return combined(l, r), info{}
+			return v.promoted(combined(l, r)), info{}
 		}
 		if isString(l) && isString(r) {
 			return stringType, info{}
@@ -369,6 +507,9 @@ func (v *checker) BinaryNode(node *ast.BinaryNode) (reflect.Type, info) {
 		}
 
 	case "in":
+		if r != nil && r.Implements(containerType) {
+			return boolType, info{}
+		}
 		if (isString(l) || isAny(l)) && isStruct(r) {
 			return boolType, info{}
 		}
@@ -379,6 +520,9 @@ func (v *checker) BinaryNode(node *ast.BinaryNode) (reflect.Type, info) {
 			if !isAny(l) && !l.AssignableTo(r.Key()) {
 				return v.error(node, "cannot use %v as type %v in map key", l, r.Key())
 			}
+			if !isAny(l) && !l.Comparable() {
+				return v.error(node, "invalid map key type %v", l)
+			}
 			return boolType, info{}
 		}
 		if isArray(r) {
@@ -451,9 +595,38 @@ func (v *checker) BinaryNode(node *ast.BinaryNode) (reflect.Type, info) {
 
 	}
 
+	for _, fn := range v.config.OperatorTypes[node.Operator] {
+		if l != nil && r != nil && l.AssignableTo(fn.In(0)) && r.AssignableTo(fn.In(1)) {
+			return fn.Out(0), info{}
+		}
+	}
+
 	return v.error(node, `invalid operation: %v (mismatched types %v and %v)`, node.Operator, l, r)
 }
 
+// division reports whether the operator is `/` or `%` and division by zero
+// is handled by the policy of the program, instead of failing.
+func (v *checker) division(operator string) bool {
+	return (operator == "/" || operator == "%") && v.config.DivisionByZero != vm.DivisionDefault
+}
+
+// promoted returns the type of integer `+`, `-`, `*` and negation, which may
+// be *big.Int if the program is compiled for OverflowPromote.
+func (v *checker) promoted(t reflect.Type) reflect.Type {
+	if v.config.PromoteOverflow && isInteger(t) {
+		return anyType
+	}
+	return t
+}
+
+// quotient returns the type of `/` or `%`, which may be nil with DivisionNil.
+func (v *checker) quotient(t reflect.Type) reflect.Type {
+	if v.config.DivisionByZero == vm.DivisionNil {
+		return anyType
+	}
+	return t
+}
+
 func (v *checker) ChainNode(node *ast.ChainNode) (reflect.Type, info) {
 	return v.visit(node.Node)
 }
@@ -462,6 +635,9 @@ func (v *checker) MemberNode(node *ast.MemberNode) (reflect.Type, info) {
 	// $env variable
 	if an, ok := node.Node.(*ast.IdentifierNode); ok && an.Value == "$env" {
 		if name, ok := node.Property.(*ast.StringNode); ok {
+			if v.denied(name.Value) {
+				return v.error(node, "access denied to %v", name.Value)
+			}
 			strict := v.config.Strict
 			if node.Optional {
 				// If user explicitly set optional flag, then we should not
@@ -472,19 +648,38 @@ func (v *checker) MemberNode(node *ast.MemberNode) (reflect.Type, info) {
 			}
 			return v.ident(node, name.Value, strict, false /* no builtins and no functions */)
 		}
+		if v.restricted() {
+			return v.error(node, "access denied to %v", an.Value)
+		}
 		return anyType, info{}
 	}
 
 	base, _ := v.visit(node.Node)
 	prop, _ := v.visit(node.Property)
 
+	if base == nil && node.Optional {
+		// Optional chaining on nil value is always nil.
+		return nilType, info{}
+	}
+
+	if v.config.StrictNil && !node.Optional && v.nilable(node.Node) {
+		prop := node.Property.String()
+		if name, ok := node.Property.(*ast.StringNode); ok {
+			prop = name.Value
+		}
+		return v.error(node, "cannot fetch %v from %v, which may be nil (use ?. or check it for nil)", prop, node.Node)
+	}
+
 	if name, ok := node.Property.(*ast.StringNode); ok {
 		if base == nil {
 			return v.error(node, "type %v has no field %v", base, name.Value)
 		}
+		if v.memberDenied(base, name.Value) {
+			return v.error(node, "access denied to %v.%v", deref.Type(base).Name(), name.Value)
+		}
 		// First, check methods defined on base type itself,
 		// independent of which type it is. Without dereferencing.
-		if m, ok := base.MethodByName(name.Value); ok {
+		if m, ok := fetchMethod(base, name.Value); ok {
 			if kind(base) == reflect.Interface {
 				// In case of interface type method will not have a receiver,
 				// and to prevent checker decreasing numbers of in arguments
@@ -500,6 +695,23 @@ func (v *checker) MemberNode(node *ast.MemberNode) (reflect.Type, info) {
 		}
 	}
 
+	if base == nil {
+		return v.error(node, "cannot fetch %v from nil", node.Property)
+	}
+
+	if v.config.FieldResolver != nil {
+		var name string
+		if s, ok := node.Property.(*ast.StringNode); ok {
+			name = s.Value
+		}
+		if t, ok := v.config.FieldResolver(base, name); ok {
+			if t == nil {
+				return v.error(node, "type %v has no field %v", base, name)
+			}
+			return t, info{}
+		}
+	}
+
 	if kind(base) == reflect.Ptr {
 		base = base.Elem()
 	}
@@ -512,6 +724,9 @@ func (v *checker) MemberNode(node *ast.MemberNode) (reflect.Type, info) {
 		if prop != nil && !prop.AssignableTo(base.Key()) && !isAny(prop) {
 			return v.error(node.Property, "cannot use %v to get an element from %v", prop, base)
 		}
+		if prop != nil && !isAny(prop) && !prop.Comparable() {
+			return v.error(node.Property, "invalid map key type %v", prop)
+		}
 		return base.Elem(), info{}
 
 	case reflect.Array, reflect.Slice:
@@ -523,7 +738,10 @@ func (v *checker) MemberNode(node *ast.MemberNode) (reflect.Type, info) {
 	case reflect.Struct:
 		if name, ok := node.Property.(*ast.StringNode); ok {
 			propertyName := name.Value
-			if field, ok := fetchField(base, propertyName); ok {
+			if field, ok := fetchField(base, propertyName, v.config.FieldTag); ok {
+				if !field.IsExported() {
+					return v.unexportedField(node, base, field)
+				}
 				return field.Type, info{}
 			}
 			if node.Method {
@@ -544,6 +762,8 @@ func (v *checker) SliceNode(node *ast.SliceNode) (reflect.Type, info) {
 		// ok
 	case reflect.String, reflect.Array, reflect.Slice:
 		// ok
+	case reflect.Invalid:
+		return v.error(node, "cannot slice nil")
 	default:
 		return v.error(node, "cannot slice %v", t)
 	}
@@ -611,9 +831,7 @@ func (v *checker) functionReturnType(node *ast.CallNode) (reflect.Type, info) {
 	case reflect.Func:
 		outType, err := v.checkArguments(fnName, fn, fnInfo.method, node.Arguments, node)
 		if err != nil {
-			if v.err == nil {
-				v.err = err
-			}
+			v.errors = append(v.errors, err)
 			return anyType, info{}
 		}
 		return outType, info{}
@@ -622,14 +840,16 @@ func (v *checker) functionReturnType(node *ast.CallNode) (reflect.Type, info) {
 }
 
 func (v *checker) BuiltinNode(node *ast.BuiltinNode) (reflect.Type, info) {
+	if v.config.Denied[node.Name] {
+		return v.error(node, "access denied to %v", node.Name)
+	}
 	switch node.Name {
 	case "all", "none", "any", "one":
-		collection, _ := v.visit(node.Arguments[0])
-		if !isArray(collection) && !isAny(collection) {
-			return v.error(node.Arguments[0], "builtin %v takes only array (got %v)", node.Name, collection)
+		collection, collectionInfo := v.visit(node.Arguments[0])
+		if !v.beginIterable(withElem(collection, collectionInfo)) {
+			return v.error(node.Arguments[0], "builtin %v takes only array, map or string (got %v)", node.Name, collection)
 		}
 
-		v.begin(collection)
 		closure, _ := v.visit(node.Arguments[1])
 		v.end()
 
@@ -644,13 +864,13 @@ func (v *checker) BuiltinNode(node *ast.BuiltinNode) (reflect.Type, info) {
 		}
 		return v.error(node.Arguments[1], "predicate should has one input and one output param")
 
-	case "filter":
-		collection, _ := v.visit(node.Arguments[0])
+	case "filter", "takeWhile", "dropWhile":
+		collection, collectionInfo := v.visit(node.Arguments[0])
 		if !isArray(collection) && !isAny(collection) {
 			return v.error(node.Arguments[0], "builtin %v takes only array (got %v)", node.Name, collection)
 		}
 
-		v.begin(collection)
+		v.begin(withElem(collection, collectionInfo))
 		closure, _ := v.visit(node.Arguments[1])
 		v.end()
 
@@ -661,20 +881,17 @@ func (v *checker) BuiltinNode(node *ast.BuiltinNode) (reflect.Type, info) {
 			if !isBool(closure.Out(0)) && !isAny(closure.Out(0)) {
 				return v.error(node.Arguments[1], "predicate should return boolean (got %v)", closure.Out(0).String())
 			}
-			if isAny(collection) {
-				return arrayType, info{}
-			}
-			return arrayType, info{}
+			return arrayType, info{elem: elemOf(withElem(collection, collectionInfo)), typed: true}
 		}
 		return v.error(node.Arguments[1], "predicate should has one input and one output param")
 
 	case "map":
-		collection, _ := v.visit(node.Arguments[0])
+		collection, collectionInfo := v.visit(node.Arguments[0])
 		if !isArray(collection) && !isAny(collection) {
 			return v.error(node.Arguments[0], "builtin %v takes only array (got %v)", node.Name, collection)
 		}
 
-		v.begin(collection, scopeVar{"index", integerType})
+		v.begin(withElem(collection, collectionInfo), scopeVar{"index", integerType})
 		closure, _ := v.visit(node.Arguments[1])
 		v.end()
 
@@ -682,21 +899,24 @@ func (v *checker) BuiltinNode(node *ast.BuiltinNode) (reflect.Type, info) {
 			closure.NumOut() == 1 &&
 			closure.NumIn() == 1 && isAny(closure.In(0)) {
 
-			return arrayType, info{}
+			return arrayType, info{elem: closure.Out(0), typed: true}
 		}
 		return v.error(node.Arguments[1], "predicate should has one input and one output param")
 
 	case "count":
-		collection, _ := v.visit(node.Arguments[0])
-		if !isArray(collection) && !isAny(collection) {
-			return v.error(node.Arguments[0], "builtin %v takes only array (got %v)", node.Name, collection)
+		collection, collectionInfo := v.visit(node.Arguments[0])
+		if !v.beginIterable(withElem(collection, collectionInfo)) {
+			return v.error(node.Arguments[0], "builtin %v takes only array, map or string (got %v)", node.Name, collection)
 		}
 
 		if len(node.Arguments) == 1 {
+			v.end()
+			if kind(collection) == reflect.String {
+				return v.error(node.Arguments[0], "builtin %v requires a predicate for string", node.Name)
+			}
 			return integerType, info{}
 		}
 
-		v.begin(collection)
 		closure, _ := v.visit(node.Arguments[1])
 		v.end()
 
@@ -712,13 +932,13 @@ func (v *checker) BuiltinNode(node *ast.BuiltinNode) (reflect.Type, info) {
 		return v.error(node.Arguments[1], "predicate should has one input and one output param")
 
 	case "sum":
-		collection, _ := v.visit(node.Arguments[0])
+		collection, collectionInfo := v.visit(node.Arguments[0])
 		if !isArray(collection) && !isAny(collection) {
 			return v.error(node.Arguments[0], "builtin %v takes only array (got %v)", node.Name, collection)
 		}
 
 		if len(node.Arguments) == 2 {
-			v.begin(collection)
+			v.begin(withElem(collection, collectionInfo))
 			closure, _ := v.visit(node.Arguments[1])
 			v.end()
 
@@ -735,12 +955,12 @@ func (v *checker) BuiltinNode(node *ast.BuiltinNode) (reflect.Type, info) {
 		}
 
 	case "find", "findLast":
-		collection, _ := v.visit(node.Arguments[0])
+		collection, collectionInfo := v.visit(node.Arguments[0])
 		if !isArray(collection) && !isAny(collection) {
 			return v.error(node.Arguments[0], "builtin %v takes only array (got %v)", node.Name, collection)
 		}
 
-		v.begin(collection)
+		v.begin(withElem(collection, collectionInfo))
 		closure, _ := v.visit(node.Arguments[1])
 		v.end()
 
@@ -754,17 +974,17 @@ func (v *checker) BuiltinNode(node *ast.BuiltinNode) (reflect.Type, info) {
 			if isAny(collection) {
 				return anyType, info{}
 			}
-			return collection.Elem(), info{}
+			return withElem(collection, collectionInfo).Elem(), info{}
 		}
 		return v.error(node.Arguments[1], "predicate should has one input and one output param")
 
 	case "findIndex", "findLastIndex":
-		collection, _ := v.visit(node.Arguments[0])
+		collection, collectionInfo := v.visit(node.Arguments[0])
 		if !isArray(collection) && !isAny(collection) {
 			return v.error(node.Arguments[0], "builtin %v takes only array (got %v)", node.Name, collection)
 		}
 
-		v.begin(collection)
+		v.begin(withElem(collection, collectionInfo))
 		closure, _ := v.visit(node.Arguments[1])
 		v.end()
 
@@ -780,12 +1000,12 @@ func (v *checker) BuiltinNode(node *ast.BuiltinNode) (reflect.Type, info) {
 		return v.error(node.Arguments[1], "predicate should has one input and one output param")
 
 	case "groupBy":
-		collection, _ := v.visit(node.Arguments[0])
+		collection, collectionInfo := v.visit(node.Arguments[0])
 		if !isArray(collection) && !isAny(collection) {
 			return v.error(node.Arguments[0], "builtin %v takes only array (got %v)", node.Name, collection)
 		}
 
-		v.begin(collection)
+		v.begin(withElem(collection, collectionInfo))
 		closure, _ := v.visit(node.Arguments[1])
 		v.end()
 
@@ -797,35 +1017,132 @@ func (v *checker) BuiltinNode(node *ast.BuiltinNode) (reflect.Type, info) {
 		}
 		return v.error(node.Arguments[1], "predicate should has one input and one output param")
 
-	case "sortBy":
+	case "countBy":
+		collection, collectionInfo := v.visit(node.Arguments[0])
+		if !isArray(collection) && !isAny(collection) {
+			return v.error(node.Arguments[0], "builtin %v takes only array (got %v)", node.Name, collection)
+		}
+
+		v.begin(withElem(collection, collectionInfo))
+		closure, _ := v.visit(node.Arguments[1])
+		v.end()
+
+		if isFunc(closure) &&
+			closure.NumOut() == 1 &&
+			closure.NumIn() == 1 && isAny(closure.In(0)) {
+
+			return reflect.TypeOf(map[any]int{}), info{}
+		}
+		return v.error(node.Arguments[1], "predicate should has one input and one output param")
+
+	case "mapValues", "mapKeys":
 		collection, _ := v.visit(node.Arguments[0])
+		if kind(collection) != reflect.Map && !isAny(collection) {
+			return v.error(node.Arguments[0], "builtin %v takes only map (got %v)", node.Name, collection)
+		}
+
+		key, elem := anyType, anyType
+		if kind(collection) == reflect.Map {
+			key, elem = collection.Key(), collection.Elem()
+		}
+		if node.Name == "mapValues" {
+			v.begin(reflect.SliceOf(elem), scopeVar{"key", key})
+		} else {
+			v.begin(reflect.SliceOf(key), scopeVar{"key", key})
+		}
+		closure, _ := v.visit(node.Arguments[1])
+		v.end()
+
+		if isFunc(closure) &&
+			closure.NumOut() == 1 &&
+			closure.NumIn() == 1 && isAny(closure.In(0)) {
+
+			out := closure.Out(0)
+			if out == nil || out == nilType {
+				out = anyType
+			}
+			if node.Name == "mapValues" {
+				return reflect.MapOf(key, out), info{}
+			}
+			if !out.Comparable() {
+				return v.error(node.Arguments[1], "cannot use %v as map key", out)
+			}
+			return reflect.MapOf(out, elem), info{}
+		}
+		return v.error(node.Arguments[1], "predicate should has one input and one output param")
+
+	case "partition":
+		collection, collectionInfo := v.visit(node.Arguments[0])
 		if !isArray(collection) && !isAny(collection) {
 			return v.error(node.Arguments[0], "builtin %v takes only array (got %v)", node.Name, collection)
 		}
 
-		v.begin(collection)
+		v.begin(withElem(collection, collectionInfo))
+		closure, _ := v.visit(node.Arguments[1])
+		v.end()
+
+		if isFunc(closure) &&
+			closure.NumOut() == 1 &&
+			closure.NumIn() == 1 && isAny(closure.In(0)) {
+
+			if !isBool(closure.Out(0)) && !isAny(closure.Out(0)) {
+				return v.error(node.Arguments[1], "predicate should return boolean (got %v)", closure.Out(0).String())
+			}
+			if isAny(collection) {
+				return anyType, info{}
+			}
+			collection = deref.Type(collection)
+			if collection.Kind() == reflect.Array {
+				collection = reflect.SliceOf(collection.Elem())
+			}
+			return reflect.SliceOf(collection), info{}
+		}
+		return v.error(node.Arguments[1], "predicate should has one input and one output param")
+
+	case "sortBy":
+		collection, collectionInfo := v.visit(node.Arguments[0])
+		if !isArray(collection) && !isAny(collection) {
+			return v.error(node.Arguments[0], "builtin %v takes only array (got %v)", node.Name, collection)
+		}
+
+		v.begin(withElem(collection, collectionInfo))
 		closure, _ := v.visit(node.Arguments[1])
 		v.end()
 
 		if len(node.Arguments) == 3 {
 			_, _ = v.visit(node.Arguments[2])
+
+			predicate, ok := node.Arguments[1].(*ast.ClosureNode)
+			if !ok {
+				return v.error(node.Arguments[1], "predicate should has one input and one output param")
+			}
+
+			// Number of orders should match number of keys.
+			keys, multiKey := predicate.Node.(*ast.ArrayNode)
+			orders, multiOrder := node.Arguments[2].(*ast.ArrayNode)
+			if multiOrder && !multiKey {
+				return v.error(node.Arguments[2], "order should be a string for sorting by a single key")
+			}
+			if multiOrder && len(keys.Nodes) != len(orders.Nodes) {
+				return v.error(node.Arguments[2], "expected %d orders, got %d", len(keys.Nodes), len(orders.Nodes))
+			}
 		}
 
 		if isFunc(closure) &&
 			closure.NumOut() == 1 &&
 			closure.NumIn() == 1 && isAny(closure.In(0)) {
 
-			return reflect.TypeOf([]any{}), info{}
+			return reflect.TypeOf([]any{}), info{elem: elemOf(withElem(collection, collectionInfo)), typed: true}
 		}
 		return v.error(node.Arguments[1], "predicate should has one input and one output param")
 
 	case "reduce":
-		collection, _ := v.visit(node.Arguments[0])
+		collection, collectionInfo := v.visit(node.Arguments[0])
 		if !isArray(collection) && !isAny(collection) {
 			return v.error(node.Arguments[0], "builtin %v takes only array (got %v)", node.Name, collection)
 		}
 
-		v.begin(collection, scopeVar{"index", integerType}, scopeVar{"acc", anyType})
+		v.begin(withElem(collection, collectionInfo), scopeVar{"index", integerType}, scopeVar{"acc", anyType})
 		closure, _ := v.visit(node.Arguments[1])
 		v.end()
 
@@ -844,6 +1161,39 @@ func (v *checker) BuiltinNode(node *ast.BuiltinNode) (reflect.Type, info) {
 		switch node.Name {
 		case "get":
 			return v.checkBuiltinGet(node)
+		case "coalesce":
+			return v.checkBuiltinCoalesce(node)
+		case "format":
+			return v.checkBuiltinFormat(node)
+		case "pluck":
+			return v.checkBuiltinPluck(node)
+		case "containsAny", "startsWithAny", "endsWithAny":
+			if len(node.Arguments) == 2 {
+				if array, ok := node.Arguments[1].(*ast.ArrayNode); ok {
+					for _, n := range array.Nodes {
+						t, _ := v.visit(n)
+						if !isString(t) && !isAny(t) {
+							return v.error(n, "invalid argument for %v (type %v)", node.Name, t)
+						}
+					}
+				}
+			}
+		case "cidr":
+			if len(node.Arguments) == 1 {
+				if s, ok := node.Arguments[0].(*ast.StringNode); ok {
+					if _, err := builtin.ParseCIDR(s.Value); err != nil {
+						return v.error(s, err.Error())
+					}
+				}
+			}
+		case "findAll", "matchGroups":
+			if len(node.Arguments) == 2 {
+				if s, ok := node.Arguments[1].(*ast.StringNode); ok {
+					if _, err := regexp.Compile(s.Value); err != nil {
+						return v.error(s, err.Error())
+					}
+				}
+			}
 		}
 		return v.checkFunction(builtin.Builtins[id], node, node.Arguments)
 	}
@@ -864,13 +1214,81 @@ func (v *checker) begin(vtype reflect.Type, vars ...scopeVar) {
 	v.predicateScopes = append(v.predicateScopes, scope)
 }
 
+// withElem returns the array type with the element type tracked in info.
+// Builtins like filter() and map() return []any, but elements of the result
+// are known to be of the same type, so closures can check them.
+func withElem(collection reflect.Type, i info) reflect.Type {
+	if !i.typed || i.elem == nil || i.elem == nilType || isAny(i.elem) {
+		return collection
+	}
+	if kind(collection) != reflect.Slice || !isAny(collection.Elem()) {
+		return collection
+	}
+	return reflect.SliceOf(i.elem)
+}
+
+// elemOf returns the element type of the array, or nil if it is unknown.
+func elemOf(collection reflect.Type) reflect.Type {
+	if kind(collection) != reflect.Slice || isAny(collection.Elem()) {
+		return nil
+	}
+	return collection.Elem()
+}
+
+// beginIterable begins a predicate scope over an array, a map or a string.
+// Maps are iterated by values, with keys available as #key, and strings are
+// iterated by runes.
+func (v *checker) beginIterable(collection reflect.Type) bool {
+	switch {
+	case isArray(collection):
+		v.begin(collection)
+	case isAny(collection):
+		// Maps reached through interfaces have keys as well.
+		v.begin(collection, scopeVar{"key", anyType})
+	case kind(collection) == reflect.Map:
+		v.begin(reflect.SliceOf(collection.Elem()), scopeVar{"key", collection.Key()})
+	case kind(collection) == reflect.String:
+		v.begin(reflect.SliceOf(stringType))
+	default:
+		return false
+	}
+	return true
+}
+
 func (v *checker) end() {
 	v.predicateScopes = v.predicateScopes[:len(v.predicateScopes)-1]
 }
 
+// checkBuiltinCoalesce returns the type shared by all non-nil arguments,
+// or any if they differ.
+func (v *checker) checkBuiltinCoalesce(node *ast.BuiltinNode) (reflect.Type, info) {
+	if len(node.Arguments) == 0 {
+		return v.error(node, "not enough arguments to call coalesce")
+	}
+
+	var out reflect.Type
+	for _, arg := range node.Arguments {
+		t, _ := v.visit(arg)
+		switch {
+		case t == nil || t == nilType:
+		case out == nil:
+			out = t
+		case out != t:
+			out = anyType
+		}
+	}
+	if out == nil {
+		return nilType, info{}
+	}
+	return out, info{}
+}
+
 func (v *checker) checkBuiltinGet(node *ast.BuiltinNode) (reflect.Type, info) {
+	if len(node.Arguments) == 3 {
+		return v.checkBuiltinGetPath(node)
+	}
 	if len(node.Arguments) != 2 {
-		return v.error(node, "invalid number of arguments (expected 2, got %d)", len(node.Arguments))
+		return v.error(node, "invalid number of arguments (expected 2 or 3, got %d)", len(node.Arguments))
 	}
 
 	val := node.Arguments[0]
@@ -909,8 +1327,66 @@ func (v *checker) checkBuiltinGet(node *ast.BuiltinNode) (reflect.Type, info) {
 	return v.error(val, "type %v does not support indexing", t)
 }
 
+// checkBuiltinGetPath checks get(v, path, default). Segments of the path
+// are resolved at runtime, so the result is always of any type.
+func (v *checker) checkBuiltinGetPath(node *ast.BuiltinNode) (reflect.Type, info) {
+	t, _ := v.visit(node.Arguments[0])
+	p, _ := v.visit(node.Arguments[1])
+	_, _ = v.visit(node.Arguments[2])
+
+	switch kind(t) {
+	case reflect.Interface, reflect.Slice, reflect.Array, reflect.Map, reflect.Struct, reflect.Ptr:
+	default:
+		return v.error(node.Arguments[0], "type %v does not support indexing", t)
+	}
+	if !isString(p) && !isInteger(p) && !isAny(p) {
+		return v.error(node.Arguments[1], "path should be a string or an integer (got %v)", p)
+	}
+	return anyType, info{}
+}
+
+func (v *checker) checkBuiltinPluck(node *ast.BuiltinNode) (reflect.Type, info) {
+	if len(node.Arguments) != 2 {
+		return v.error(node, "invalid number of arguments (expected 2, got %d)", len(node.Arguments))
+	}
+
+	collection, _ := v.visit(node.Arguments[0])
+	prop, _ := v.visit(node.Arguments[1])
+	if !isArray(collection) && !isAny(collection) {
+		return v.error(node.Arguments[0], "builtin %v takes only array (got %v)", node.Name, collection)
+	}
+	if !isString(prop) && !isAny(prop) {
+		return v.error(node.Arguments[1], "cannot pluck %v", prop)
+	}
+
+	name, ok := node.Arguments[1].(*ast.StringNode)
+	if !ok || isAny(collection) {
+		return anyType, info{}
+	}
+
+	elem := deref.Type(deref.Type(collection).Elem())
+	switch kind(elem) {
+	case reflect.Struct:
+		field, ok := fetchField(elem, name.Value, v.config.FieldTag)
+		if !ok {
+			return v.error(node.Arguments[1], "type %v has no field %v", elem, name.Value)
+		}
+		if !field.IsExported() {
+			return v.unexportedField(node.Arguments[1], elem, field)
+		}
+		return reflect.SliceOf(field.Type), info{}
+	case reflect.Map:
+		return reflect.SliceOf(elem.Elem()), info{}
+	case reflect.Interface:
+		return arrayType, info{}
+	}
+	return v.error(node.Arguments[0], "cannot pluck %v from %v", name.Value, elem)
+}
+
 func (v *checker) checkFunction(f *builtin.Function, node ast.Node, arguments []ast.Node) (reflect.Type, info) {
-	if f.Validate != nil {
+	if f.Validate != nil && spread(node) {
+		return v.error(node, "cannot use ... in call to %v", f.Name)
+	} else if f.Validate != nil {
 		args := make([]reflect.Type, len(arguments))
 		for i, arg := range arguments {
 			args[i], _ = v.visit(arg)
@@ -923,31 +1399,84 @@ func (v *checker) checkFunction(f *builtin.Function, node ast.Node, arguments []
 	} else if len(f.Types) == 0 {
 		t, err := v.checkArguments(f.Name, f.Type(), false, arguments, node)
 		if err != nil {
-			if v.err == nil {
-				v.err = err
-			}
+			v.errors = append(v.errors, err)
 			return anyType, info{}
 		}
 		// No type was specified, so we assume the function returns any.
 		return t, info{}
 	}
-	var lastErr *file.Error
+	// Overload is selected before checkArguments, which replaces integer
+	// nodes with the types of parameters. Arguments are visited again by
+	// checkArguments, which reports their errors.
+	errs := len(v.errors)
+	for _, arg := range arguments {
+		v.visit(arg)
+	}
+	v.errors = v.errors[:errs]
+	fn := f.Types[len(f.Types)-1] // Report errors of the last overload.
 	for _, t := range f.Types {
-		outType, err := v.checkArguments(f.Name, t, false, arguments, node)
-		if err != nil {
-			lastErr = err
-			continue
+		if v.matchArguments(t, arguments, spread(node)) {
+			fn = t
+			break
 		}
-		return outType, info{}
 	}
-	if lastErr != nil {
-		if v.err == nil {
-			v.err = lastErr
-		}
+	t, err := v.checkArguments(f.Name, fn, false, arguments, node)
+	if err != nil {
+		v.errors = append(v.errors, err)
 		return anyType, info{}
 	}
+	if call, ok := node.(*ast.CallNode); ok {
+		// Type of the callee is the selected overload, it is bound by compiler.
+		call.Callee.SetType(fn)
+	}
+	return t, info{}
+}
 
-	return v.error(node, "no matching overload for %v", f.Name)
+// matchArguments reports whether visited arguments can be used to call
+// the function of type fn, without changing arguments.
+func (v *checker) matchArguments(fn reflect.Type, arguments []ast.Node, spread bool) bool {
+	if isAny(fn) {
+		return true
+	}
+	if fn.NumOut() == 0 || fn.NumOut() > 2 {
+		return false
+	}
+	numIn := fn.NumIn()
+	if spread {
+		last := len(arguments) - 1
+		if !fn.IsVariadic() || last < numIn-1 || !spreadable(arguments[last].Type(), fn.In(numIn-1)) {
+			return false
+		}
+		arguments = arguments[:last]
+	}
+	if fn.IsVariadic() {
+		if len(arguments) < numIn-1 {
+			return false
+		}
+	} else if len(arguments) != numIn {
+		return false
+	}
+	for i, arg := range arguments {
+		var in reflect.Type
+		if fn.IsVariadic() && i >= numIn-1 {
+			in = fn.In(numIn - 1).Elem()
+		} else {
+			in = fn.In(i)
+		}
+		t := arg.Type()
+		switch {
+		case t == nil:
+		case isFloat(in) && isInteger(t):
+		case isInteger(in) && isInteger(t):
+		case t.AssignableTo(in) || deref.Type(t).AssignableTo(in) || kind(t) == reflect.Interface:
+		default:
+			array, ok := arg.(*ast.ArrayNode)
+			if !ok || !v.fitArray(array, in, false) {
+				return false
+			}
+		}
+	}
+	return true
 }
 
 func (v *checker) checkArguments(
@@ -986,6 +1515,10 @@ func (v *checker) checkArguments(
 		fnInOffset = 1
 	}
 
+	if spread(node) {
+		return v.checkSpread(name, fn, fnNumIn, fnInOffset, arguments, node)
+	}
+
 	var err *file.Error
 	if fn.IsVariadic() {
 		if len(arguments) < fnNumIn-1 {
@@ -1030,31 +1563,208 @@ func (v *checker) checkArguments(
 			in = fn.In(i + fnInOffset)
 		}
 
-		if isFloat(in) && isInteger(t) {
-			traverseAndReplaceIntegerNodesWithFloatNodes(&arguments[i], in)
-			continue
+		if err := v.checkArgument(name, in, t, &arguments[i]); err != nil {
+			return anyType, err
 		}
+	}
 
-		if isInteger(in) && isInteger(t) && kind(t) != kind(in) {
-			traverseAndReplaceIntegerNodesWithIntegerNodes(&arguments[i], in)
-			continue
+	return fn.Out(0), nil
+}
+
+// checkArgument checks the visited argument of type t against the parameter
+// of type in. Integer nodes and array literals are retyped to the parameter.
+func (v *checker) checkArgument(name string, in, t reflect.Type, arg *ast.Node) *file.Error {
+	if isFloat(in) && isInteger(t) {
+		traverseAndReplaceIntegerNodesWithFloatNodes(arg, in)
+		return nil
+	}
+
+	if isInteger(in) && isInteger(t) && kind(t) != kind(in) {
+		traverseAndReplaceIntegerNodesWithIntegerNodes(arg, in)
+		return nil
+	}
+
+	if t == nil {
+		return nil
+	}
+
+	if array, ok := (*arg).(*ast.ArrayNode); ok && t != in && v.fitArray(array, in, false) {
+		v.fitArray(array, in, true)
+		return nil
+	}
+
+	if !(t.AssignableTo(in) || deref.Type(t).AssignableTo(in)) && kind(t) != reflect.Interface {
+		return &file.Error{
+			Location: (*arg).Location(),
+			Message:  fmt.Sprintf("cannot use %v as argument (type %v) to call %v ", t, in, name),
 		}
+	}
+	return nil
+}
 
-		if t == nil {
-			continue
+// checkSpread checks the call with the spread last argument, like
+// `foo(1, xs...)`. Elements of the slice are the rest of variadic
+// arguments, so their type is checked against the variadic parameter.
+func (v *checker) checkSpread(
+	name string,
+	fn reflect.Type,
+	fnNumIn, fnInOffset int,
+	arguments []ast.Node,
+	node ast.Node,
+) (reflect.Type, *file.Error) {
+	if !fn.IsVariadic() {
+		for _, arg := range arguments {
+			_, _ = v.visit(arg)
+		}
+		return fn.Out(0), &file.Error{
+			Location: node.Location(),
+			Message:  fmt.Sprintf("cannot use ... in call to non-variadic %v", name),
+		}
+	}
+	if len(arguments)-1 < fnNumIn-1 {
+		for _, arg := range arguments {
+			_, _ = v.visit(arg)
 		}
+		return fn.Out(0), &file.Error{
+			Location: node.Location(),
+			Message:  fmt.Sprintf("not enough arguments to call %v", name),
+		}
+	}
 
-		if !(t.AssignableTo(in) || deref.Type(t).AssignableTo(in)) && kind(t) != reflect.Interface {
-			return anyType, &file.Error{
-				Location: arg.Location(),
-				Message:  fmt.Sprintf("cannot use %v as argument (type %v) to call %v ", t, in, name),
-			}
+	last := len(arguments) - 1
+	variadic := fn.In(fn.NumIn() - 1)
+	for i, arg := range arguments[:last] {
+		t, _ := v.visit(arg)
+		in := variadic.Elem()
+		if i < fnNumIn-1 {
+			in = fn.In(i + fnInOffset)
+		}
+		if err := v.checkArgument(name, in, t, &arguments[i]); err != nil {
+			return anyType, err
 		}
 	}
 
+	t, _ := v.visit(arguments[last])
+	if array, ok := arguments[last].(*ast.ArrayNode); ok && v.fitArray(array, variadic, false) {
+		v.fitArray(array, variadic, true)
+		return fn.Out(0), nil
+	}
+	if !spreadable(t, variadic) {
+		return anyType, &file.Error{
+			Location: arguments[last].Location(),
+			Message:  fmt.Sprintf("cannot use %v as argument (type %v) to call %v", t, variadic, name),
+		}
+	}
 	return fn.Out(0), nil
 }
 
+// spread reports whether the last argument of the call is spread.
+func spread(node ast.Node) bool {
+	call, ok := node.(*ast.CallNode)
+	return ok && call.Spread
+}
+
+// spreadable reports whether elements of the type t can be spread as
+// arguments of the variadic parameter. Elements of unknown type, and
+// numbers, are converted at runtime.
+func spreadable(t, variadic reflect.Type) bool {
+	switch kind(t) {
+	case reflect.Invalid, reflect.Interface:
+		return true
+	case reflect.Slice, reflect.Array:
+	default:
+		return false
+	}
+	elem, in := t.Elem(), variadic.Elem()
+	switch {
+	case elem.AssignableTo(in), kind(elem) == reflect.Interface:
+		return true
+	case isNumber(elem) && isNumber(in):
+		return true
+	}
+	return false
+}
+
+// fitArray reports whether the array literal can be used as a value of type t,
+// like [1, 2] as []float64 or ["a", "b"] as []any. If apply is set, elements
+// and the array itself are retyped to t. Elements of typed arrays are stored
+// as is, so only elements of exactly known runtime type fit: literals and
+// struct fields of the element type.
+func (v *checker) fitArray(array *ast.ArrayNode, t reflect.Type, apply bool) bool {
+	if kind(t) != reflect.Slice {
+		return false
+	}
+	elem := t.Elem()
+	for i, node := range array.Nodes {
+		nt := node.Type()
+		switch {
+		case isAny(elem):
+		case isNumberLiteral(node) && isFloat(elem) && isInteger(nt):
+			if apply {
+				traverseAndReplaceIntegerNodesWithFloatNodes(&array.Nodes[i], elem)
+			}
+		case isNumberLiteral(node) && isInteger(elem) && isInteger(nt) && kind(elem) != kind(nt):
+			if apply {
+				traverseAndReplaceIntegerNodesWithIntegerNodes(&array.Nodes[i], elem)
+			}
+		case isNumberLiteral(node) && isFloat(elem) && isFloat(nt) && kind(elem) != kind(nt):
+			if apply {
+				retypeFloatLiteral(node, elem)
+			}
+		default:
+			if nested, ok := node.(*ast.ArrayNode); ok {
+				if !v.fitArray(nested, elem, apply) {
+					return false
+				}
+			} else if !v.exactElem(node, elem) {
+				return false
+			}
+		}
+	}
+	if apply {
+		array.SetType(t)
+	}
+	return true
+}
+
+// exactElem reports whether the runtime value of the node is of type elem.
+func (v *checker) exactElem(node ast.Node, elem reflect.Type) bool {
+	switch n := node.(type) {
+	case *ast.NilNode:
+		switch kind(elem) {
+		case reflect.Interface, reflect.Ptr, reflect.Slice, reflect.Map, reflect.Func:
+			return true
+		}
+		return false
+	case *ast.StringNode, *ast.BoolNode, *ast.IntegerNode, *ast.FloatNode:
+		return n.Type() == elem
+	case *ast.UnaryNode:
+		return isNumberLiteral(n) && n.Type() == elem
+	case *ast.IdentifierNode, *ast.MemberNode:
+		ok, _, _ := FieldIndex(v.config, n)
+		return ok && n.Type() != nil && n.Type().AssignableTo(elem)
+	}
+	return false
+}
+
+// isNumberLiteral reports whether the node is a number literal, like 1 or -1.5.
+func isNumberLiteral(node ast.Node) bool {
+	switch n := node.(type) {
+	case *ast.IntegerNode, *ast.FloatNode:
+		return true
+	case *ast.UnaryNode:
+		return (n.Operator == "-" || n.Operator == "+") && isNumberLiteral(n.Node)
+	}
+	return false
+}
+
+func retypeFloatLiteral(node ast.Node, t reflect.Type) {
+	if n, ok := node.(*ast.UnaryNode); ok {
+		retypeFloatLiteral(n.Node, t)
+	}
+	node.SetType(t)
+}
+
 func traverseAndReplaceIntegerNodesWithFloatNodes(node *ast.Node, newType reflect.Type) {
 	switch (*node).(type) {
 	case *ast.IntegerNode:
@@ -1136,7 +1846,7 @@ func (v *checker) VariableDeclaratorNode(node *ast.VariableDeclaratorNode) (refl
 		return v.error(node, "cannot redeclare variable %v", node.Name)
 	}
 	vtype, vinfo := v.visit(node.Value)
-	v.varScopes = append(v.varScopes, varScope{node.Name, vtype, vinfo})
+	v.varScopes = append(v.varScopes, varScope{node.Name, vtype, vinfo, len(v.predicateScopes)})
 	t, i := v.visit(node.Expr)
 	v.varScopes = v.varScopes[:len(v.varScopes)-1]
 	return t, i
@@ -1157,8 +1867,8 @@ func (v *checker) ConditionalNode(node *ast.ConditionalNode) (reflect.Type, info
 		return v.error(node.Cond, "non-bool expression (type %v) used as condition", c)
 	}
 
-	t1, _ := v.visit(node.Exp1)
-	t2, _ := v.visit(node.Exp2)
+	t1, _ := v.visitNonNil(node.Exp1, nilChecks(node.Cond, true))
+	t2, _ := v.visitNonNil(node.Exp2, nilChecks(node.Cond, false))
 
 	if t1 == nil && t2 != nil {
 		return t2, info{}
@@ -1169,10 +1879,86 @@ func (v *checker) ConditionalNode(node *ast.ConditionalNode) (reflect.Type, info
 	if t1 == nil && t2 == nil {
 		return nilType, info{}
 	}
-	if t1.AssignableTo(t2) {
-		return t1, info{}
+	return v.unify(t1, t2), info{}
+}
+
+// unify returns the least upper bound of types of the conditional branches:
+// the interface for its implementation, or the most specific interface of
+// the environment implemented by both. Branches of different number types,
+// like `ok ? 1 : 0.5`, are typed as interface, as values are not converted.
+func (v *checker) unify(t1, t2 reflect.Type) reflect.Type {
+	switch {
+	case t1 == t2:
+		return t1
+	case kind(t2) == reflect.Interface && t1.AssignableTo(t2):
+		return t2
+	case kind(t1) == reflect.Interface && t2.AssignableTo(t1):
+		return t1
+	case t1.AssignableTo(t2):
+		return t1
+	}
+
+	var common reflect.Type
+	ambiguous := false
+	for _, iface := range v.interfaces() {
+		if !t1.Implements(iface) || !t2.Implements(iface) {
+			continue
+		}
+		switch {
+		case common == nil || iface.NumMethod() > common.NumMethod():
+			common, ambiguous = iface, false
+		case iface.NumMethod() == common.NumMethod():
+			ambiguous = true
+		}
 	}
-	return anyType, info{}
+	if common == nil || ambiguous {
+		return anyType
+	}
+	return common
+}
+
+// interfaces returns non-empty interface types used by the environment,
+// as types of variables, elements, and parameters and results of functions.
+func (v *checker) interfaces() []reflect.Type {
+	if v.ifaces != nil {
+		return v.ifaces
+	}
+	v.ifaces = []reflect.Type{}
+	seen := make(map[reflect.Type]bool)
+	var collect func(t reflect.Type)
+	collect = func(t reflect.Type) {
+		if t == nil || seen[t] {
+			return
+		}
+		seen[t] = true
+		switch t.Kind() {
+		case reflect.Interface:
+			if t.NumMethod() > 0 {
+				v.ifaces = append(v.ifaces, t)
+			}
+		case reflect.Ptr, reflect.Slice, reflect.Array:
+			collect(t.Elem())
+		case reflect.Map:
+			collect(t.Key())
+			collect(t.Elem())
+		case reflect.Func:
+			for i := 0; i < t.NumIn(); i++ {
+				collect(t.In(i))
+			}
+			for i := 0; i < t.NumOut(); i++ {
+				collect(t.Out(i))
+			}
+		}
+	}
+	for _, t := range v.config.Types {
+		collect(t.Type)
+	}
+	for _, fn := range v.config.Functions {
+		for _, t := range fn.Types {
+			collect(t)
+		}
+	}
+	return v.ifaces
 }
 
 func (v *checker) ArrayNode(node *ast.ArrayNode) (reflect.Type, info) {
@@ -1,11 +1,13 @@
 package checker_test
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/expr-lang/expr/internal/testify/assert"
 	"github.com/expr-lang/expr/internal/testify/require"
@@ -14,6 +16,7 @@ import (
 	"github.com/expr-lang/expr/ast"
 	"github.com/expr-lang/expr/checker"
 	"github.com/expr-lang/expr/conf"
+	"github.com/expr-lang/expr/file"
 	"github.com/expr-lang/expr/parser"
 	"github.com/expr-lang/expr/test/mock"
 )
@@ -96,6 +99,7 @@ func TestCheck(t *testing.T) {
 		{"EmbedString == ''"},
 		{"{id: Foo.Bar.Baz, 'str': String} == {}"},
 		{"Variadic(0, 1, 2) || Variadic(0)"},
+		{"Variadic(0, ArrayOfInt...) || Variadic(0, 1, ArrayOfAny...)"},
 		{"count(1..30, {# % 3 == 0}) > 0"},
 		{"map(1..3, {#}) == [1,2,3]"},
 		{"map(1..3, #index) == [0,1,2]"},
@@ -295,6 +299,16 @@ type <nil> has no field Foo (1:6)
  | (nil)['Foo']
  | .....^
 
+(nil)[0]
+cannot fetch 0 from nil (1:6)
+ | (nil)[0]
+ | .....^
+
+(nil)[1:]
+cannot slice nil (1:6)
+ | (nil)[1:]
+ | .....^
+
 1 and false
 invalid operation: and (mismatched types int and bool) (1:3)
  | 1 and false
@@ -379,6 +393,9 @@ NilFn() and BoolFn()
 func NilFn doesn't return value (1:1)
  | NilFn() and BoolFn()
  | ^
+unknown name BoolFn (1:13)
+ | NilFn() and BoolFn()
+ | ............^
 
 'str' in String
 invalid operation: in (mismatched types string and string) (1:7)
@@ -410,8 +427,23 @@ cannot use string as argument (type int) to call Variadic  (1:13)
  | Variadic(0, '')
  | ............^
 
+Variadic(0, ArrayOfString...)
+cannot use []string as argument (type []int) to call Variadic (1:13)
+ | Variadic(0, ArrayOfString...)
+ | ............^
+
+Variadic(ArrayOfInt...)
+not enough arguments to call Variadic (1:1)
+ | Variadic(ArrayOfInt...)
+ | ^
+
+FuncInt(ArrayOfInt...)
+cannot use ... in call to non-variadic FuncInt (1:1)
+ | FuncInt(ArrayOfInt...)
+ | ^
+
 count(1, {#})
-builtin count takes only array (got int) (1:7)
+builtin count takes only array, map or string (got int) (1:7)
  | count(1, {#})
  | ......^
 
@@ -471,7 +503,7 @@ invalid argument for len (type int) (1:1)
  | ^
 
 any(42, {#})
-builtin any takes only array (got int) (1:5)
+builtin any takes only array, map or string (got int) (1:5)
  | any(42, {#})
  | ....^
 
@@ -505,6 +537,16 @@ cannot use float64 as type int in map key (1:5)
  | 1/2 in MapIntAny
  | ....^
 
+groupBy(ArrayOfInt, #)[ArrayOfInt]
+invalid map key type []int (1:24)
+ | groupBy(ArrayOfInt, #)[ArrayOfInt]
+ | .......................^
+
+[1] in groupBy(ArrayOfInt, #)
+invalid map key type []interface {} (1:5)
+ | [1] in groupBy(ArrayOfInt, #)
+ | ....^
+
 0.5 in ArrayOfFoo
 cannot use float64 as type *mock.Foo in array (1:5)
  | 0.5 in ArrayOfFoo
@@ -515,6 +557,26 @@ cannot use float64 as argument (type int) to call repeat  (1:14)
  | repeat("0", 1/0)
  | .............^
 
+9223372036854775807 + 1
+constant 9223372036854775807 + 1 overflows int (1:21)
+ | 9223372036854775807 + 1
+ | ....................^
+
+Int + 2 * 4611686018427387904
+constant 2 * 4611686018427387904 overflows int (1:9)
+ | Int + 2 * 4611686018427387904
+ | ........^
+
+-(-9223372036854775807 - 1)
+constant -(-9223372036854775807 - 1) overflows int (1:1)
+ | -(-9223372036854775807 - 1)
+ | ^
+
+Int % (2 - 2) + 1 % (2 - 2)
+integer divide by zero (1:19)
+ | Int % (2 - 2) + 1 % (2 - 2)
+ | ..................^
+
 let map = 42; map
 cannot redeclare builtin map (1:5)
  | let map = 42; map
@@ -545,6 +607,21 @@ cannot use int as type string in array (1:4)
  | 42 in ["a", "b", "c"]
  | ...^
 
+map(filter(ArrayOfFoo, .Value != ""), .Vaule)
+type mock.Foo has no field Vaule (1:40)
+ | map(filter(ArrayOfFoo, .Value != ""), .Vaule)
+ | .......................................^
+
+map(ArrayOfFoo, .Bar) | filter(.Bax == "")
+type mock.Bar has no field Bax (1:33)
+ | map(ArrayOfFoo, .Bar) | filter(.Bax == "")
+ | ................................^
+
+find(sortBy(ArrayOfFoo, .Value), true).Vaule
+type mock.Foo has no field Vaule (1:40)
+ | find(sortBy(ArrayOfFoo, .Value), true).Vaule
+ | .......................................^
+
 "foo" matches "[+"
 error parsing regexp: missing closing ]: ` + "`[+`" + ` (1:7)
  | "foo" matches "[+"
@@ -573,6 +650,151 @@ func TestCheck_error(t *testing.T) {
 	}
 }
 
+func TestCheck_error_list(t *testing.T) {
+	tree, err := parser.Parse(`Foo.Baz + 1 && len(42) > "a" || Unknown`)
+	require.NoError(t, err)
+
+	_, err = checker.Check(tree, conf.New(mock.Env{}))
+	require.Error(t, err)
+
+	var list file.ErrorList
+	require.True(t, errors.As(err, &list))
+	require.Len(t, list, 3)
+	assert.Equal(t, "type mock.Foo has no field Baz", list[0].Message)
+	assert.Equal(t, "invalid argument for len (type int)", list[1].Message)
+	assert.Equal(t, "unknown name Unknown", list[2].Message)
+	assert.Equal(t, 32, list[2].Column)
+}
+
+func TestCheck_StrictNil(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{`NilStruct.Value`, "cannot fetch Value from NilStruct, which may be nil (use ?. or check it for nil)"},
+		{`NilStruct.Method()`, "cannot fetch Method from NilStruct, which may be nil (use ?. or check it for nil)"},
+		{`Abstract.Method()`, "cannot fetch Method from Abstract, which may be nil (use ?. or check it for nil)"},
+		{`filter(ArrayOfFoo, .Value == "")`, "cannot fetch Value from #, which may be nil (use ?. or check it for nil)"},
+		{`NilStruct == nil && NilStruct.Value == ""`, "cannot fetch Value from NilStruct, which may be nil (use ?. or check it for nil)"},
+		{`NilStruct != nil || NilStruct.Value == ""`, "cannot fetch Value from NilStruct, which may be nil (use ?. or check it for nil)"},
+		{`NilStruct?.Value`, ""},
+		{`NilStruct?.Method()`, ""},
+		{`NilStruct?.Value ?? ""`, ""},
+		{`(NilStruct ?? NilStruct).Value`, "cannot fetch Value from NilStruct ?? NilStruct, which may be nil (use ?. or check it for nil)"},
+		{`(NilStruct ?? Foo).Value`, ""},
+		{`NilStruct != nil && NilStruct.Value == ""`, ""},
+		{`nil == NilStruct || NilStruct.Value == ""`, ""},
+		{`not (NilStruct == nil) and NilStruct.Bar.Baz == ""`, ""},
+		{`NilStruct == nil ? "" : NilStruct.Value`, ""},
+		{`NilStruct != nil ? NilStruct.Value : ""`, ""},
+		{`filter(ArrayOfFoo, # != nil && #.Value == "")`, ""},
+		{`filter(ArrayOfFoo, # != nil && any(ArrayOfFoo, #.Value == ""))`, "cannot fetch Value from #, which may be nil (use ?. or check it for nil)"},
+		{`NilStruct != nil && all(ArrayOfFoo, NilStruct.Value == "")`, ""},
+		{`let foo = NilStruct; foo != nil && foo.Value == ""`, ""},
+		{`Foo.Value`, ""},
+		{`Any.Value`, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			tree, err := parser.Parse(test.input)
+			require.NoError(t, err)
+
+			config := conf.New(mock.Env{})
+			config.StrictNil = true
+			_, err = checker.Check(tree, config)
+			if test.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				assert.Equal(t, test.err, err.(*file.Error).Message)
+			}
+		})
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	tests := []struct {
+		input    string
+		warnings []string
+	}{
+		{`1 == 1`, []string{"condition 1 == 1 is always true"}},
+		{`"a" > "b"`, []string{`condition "a" > "b" is always false`}},
+		{`Int != nil`, []string{"condition Int != nil is always true (Int is never nil)"}},
+		{`nil == Foo`, []string{"condition nil == Foo is always false (Foo is never nil)"}},
+		{`Int > 10 && Int < 5`, []string{"condition Int > 10 && Int < 5 is always false (Int can not satisfy all comparisons)"}},
+		{`Int >= 1 and String != "" and 1 > Int`, []string{`condition Int >= 1 and String != "" and 1 > Int is always false (Int can not satisfy all comparisons)`}},
+		{`Int == 1 && Int == 2`, []string{"condition Int == 1 && Int == 2 is always false (Int can not satisfy all comparisons)"}},
+		{`Int >= 1 && Int <= 1`, nil},
+		{`Int > 1 && Float < 0`, nil},
+		{`Int > 10 || Int < 5`, nil},
+		{`NilStruct != nil`, nil},
+		{`Any == nil`, nil},
+		{`Int == Int64`, nil},
+		{`let x = 1; let y = 2; x`, []string{"variable y is never used"}},
+		{`let x = 1; map(ArrayOfInt, # + x)`, nil},
+		{`let x = 1; $env.Int`, []string{"variable x is never used"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			tree, err := parser.Parse(test.input)
+			require.NoError(t, err)
+
+			_, err = checker.Check(tree, conf.New(mock.Env{}))
+			require.NoError(t, err)
+
+			var got []string
+			for _, w := range checker.Warnings(tree, nil) {
+				got = append(got, w.Message)
+			}
+			assert.Equal(t, test.warnings, got)
+		})
+	}
+}
+
+func TestWarnings_deprecated(t *testing.T) {
+	config := conf.New(mock.Env{})
+	config.Deprecated = map[string]string{
+		"Int":       "use Int64 instead",
+		"Foo.Value": "",
+		"upper":     "use lower",
+	}
+
+	tree, err := parser.Parse(`Int + $env.Int > 0 && Foo.Value == upper(Foo.Bar.Baz) && ArrayOfFoo[0].Value != ""`)
+	require.NoError(t, err)
+	_, err = checker.Check(tree, config)
+	require.NoError(t, err)
+
+	var got []string
+	for _, w := range checker.Warnings(tree, config) {
+		got = append(got, w.Message)
+	}
+	assert.Equal(t, []string{
+		"Int is deprecated: use Int64 instead",
+		"Int is deprecated: use Int64 instead",
+		"Foo.Value is deprecated",
+		"upper is deprecated: use lower",
+		"Foo.Value is deprecated",
+	}, got)
+}
+
+func TestWarnings_shadowed_variable(t *testing.T) {
+	config := conf.New(mock.Env{})
+	config.IdentifierResolver = func(name string) (reflect.Type, bool) {
+		return reflect.TypeOf(0), name == "price"
+	}
+
+	tree, err := parser.Parse(`let price = 1; price * Int`)
+	require.NoError(t, err)
+	_, err = checker.Check(tree, config)
+	require.NoError(t, err)
+
+	warnings := checker.Warnings(tree, config)
+	require.Len(t, warnings, 1)
+	assert.Equal(t, "variable price shadows an identifier of the environment", warnings[0].Message)
+}
+
 func TestCheck_FloatVsInt(t *testing.T) {
 	tree, err := parser.Parse(`Int + Float`)
 	require.NoError(t, err)
@@ -634,8 +856,8 @@ func TestCheck_TaggedFieldName(t *testing.T) {
 
 	config := conf.CreateNew()
 	expr.Env(struct {
-		x struct {
-			y bool `expr:"bar"`
+		X struct {
+			Y bool `expr:"bar"`
 		} `expr:"foo"`
 	}{})(config)
 	expr.AsBool()(config)
@@ -644,6 +866,39 @@ func TestCheck_TaggedFieldName(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestCheck_UnexportedField(t *testing.T) {
+	type User struct {
+		Name   string
+		secret string
+	}
+	type Env struct {
+		User  User
+		token string
+	}
+
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{`User.secret`, "cannot access unexported field secret of type checker_test.User (export the field or add an accessor method) (1:6)"},
+		{`token`, "cannot access unexported field token of type checker_test.Env (export the field or add an accessor method) (1:1)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tree, err := parser.Parse(tt.input)
+			require.NoError(t, err)
+
+			_, err = checker.Check(tree, conf.New(Env{}))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+
+	types := conf.CreateTypesTable(Env{})
+	assert.Contains(t, types, "User")
+	assert.NotContains(t, types, "token")
+}
+
 func TestCheck_Ambiguous(t *testing.T) {
 	type A struct {
 		Ambiguous bool
@@ -974,3 +1229,115 @@ func TestCheck_builtin_without_call(t *testing.T) {
 		})
 	}
 }
+
+func TestCheck_access(t *testing.T) {
+	const foo = "github.com/expr-lang/expr/test/mock.Foo"
+	tests := []struct {
+		input   string
+		allowed []string
+		denied  []string
+		err     string
+	}{
+		{`Foo.Value`, nil, []string{foo + ".Bar"}, ""},
+		{`Foo.Bar`, nil, []string{"Foo.Bar"}, ""},
+		{`Foo.Bar`, nil, []string{foo + ".Bar"}, "access denied to Foo.Bar"},
+		{`Foo.Method()`, nil, []string{foo + ".Method"}, "access denied to Foo.Method"},
+		{`all(ArrayOfFoo, .Bar.Baz != "")`, nil, []string{foo + ".Bar"}, "access denied to Foo.Bar"},
+		{`Int + 1`, nil, []string{"Int"}, "access denied to Int"},
+		{`$env.Int`, nil, []string{"Int"}, "access denied to Int"},
+		{`$env[String]`, nil, []string{"Int"}, "access denied to $env"},
+		{`upper(String)`, nil, []string{"upper"}, "access denied to upper"},
+		{`map(ArrayOfFoo, .Value)`, nil, []string{"map"}, "access denied to map"},
+		{`Int + len(String)`, []string{"Int", "String"}, nil, ""},
+		{`Int + Int64`, []string{"Int", "String"}, nil, "access denied to Int64"},
+		{`Foo.Value + Foo.Bar.Baz`, []string{"Foo", foo + ".Value"}, nil, "access denied to Foo.Bar"},
+		{`Foo.Bar.Baz`, []string{"Foo"}, nil, ""},
+		{`$env`, []string{"Foo"}, nil, "access denied to $env"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			tree, err := parser.Parse(test.input)
+			require.NoError(t, err)
+
+			config := conf.New(mock.Env{})
+			config.Allowed = make(map[string]bool)
+			for _, name := range test.allowed {
+				config.Allowed[name] = true
+			}
+			config.Denied = make(map[string]bool)
+			for _, name := range test.denied {
+				config.Denied[name] = true
+			}
+
+			_, err = checker.Check(tree, config)
+			if test.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), test.err)
+			}
+		})
+	}
+}
+
+type shape interface {
+	Area() float64
+}
+
+type circle struct{ R float64 }
+
+func (c circle) Area() float64 { return c.R * c.R * 3 }
+
+type square struct{ S float64 }
+
+func (s square) Area() float64 { return s.S * s.S }
+
+func TestCheck_conditional_type(t *testing.T) {
+	env := map[string]any{
+		"ok":     true,
+		"i":      1,
+		"f":      0.5,
+		"d":      time.Second,
+		"circle": circle{},
+		"square": square{},
+		"shapes": []shape{},
+	}
+
+	tests := []struct {
+		input string
+		want  reflect.Type
+	}{
+		{`ok ? i : i`, reflect.TypeOf(0)},
+		{`ok ? i : f`, reflect.TypeOf(new(any)).Elem()},
+		{`ok ? 0.5 : 1`, reflect.TypeOf(new(any)).Elem()},
+		{`ok ? i : d`, reflect.TypeOf(new(any)).Elem()},
+		{`ok ? circle : shapes[0]`, reflect.TypeOf(new(shape)).Elem()},
+		{`ok ? circle : square`, reflect.TypeOf(new(shape)).Elem()},
+		{`(ok ? circle : square).Area()`, reflect.TypeOf(0.0)},
+		{`ok ? circle : "circle"`, reflect.TypeOf(new(any)).Elem()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			tree, err := parser.Parse(test.input)
+			require.NoError(t, err)
+
+			rtype, err := checker.Check(tree, conf.New(env))
+			require.NoError(t, err)
+			assert.Equal(t, test.want.String(), rtype.String())
+		})
+	}
+}
+
+func TestCheck_sortBy_predicate(t *testing.T) {
+	tree, err := parser.Parse(`sortBy([1, 2], #, "desc")`)
+	require.NoError(t, err)
+
+	// Trees built or patched outside the parser may have a non-closure predicate.
+	tree.Node.(*ast.BuiltinNode).Arguments[1] = &ast.IntegerNode{Value: 1}
+
+	_, err = checker.Check(tree, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "predicate should has one input and one output param")
+}
@@ -0,0 +1,88 @@
+package checker
+
+import (
+	"math"
+
+	"github.com/expr-lang/expr/ast"
+)
+
+// constInt returns the value of the integer constant expression, like
+// `-(2 * 3)`. Expressions which overflow or divide by zero are not constant.
+func constInt(node ast.Node) (int, bool) {
+	switch n := node.(type) {
+	case *ast.IntegerNode:
+		return n.Value, true
+	case *ast.UnaryNode:
+		if x, ok := constInt(n.Node); ok {
+			return intArithmetic(n.Operator, 0, x)
+		}
+	case *ast.BinaryNode:
+		if x, ok := constInt(n.Left); ok {
+			if y, ok := constInt(n.Right); ok {
+				return intArithmetic(n.Operator, x, y)
+			}
+		}
+	}
+	return 0, false
+}
+
+// intArithmetic returns the result of the operation on integer constants.
+// It reports false on overflow, division by zero or unknown operator.
+// Unary operators are applied to y.
+func intArithmetic(op string, x, y int) (int, bool) {
+	switch op {
+	case "+":
+		z := x + y
+		return z, !(y > 0 && z < x || y < 0 && z > x)
+	case "-":
+		z := x - y
+		return z, !(y > 0 && z > x || y < 0 && z < x)
+	case "*":
+		if x == 0 {
+			return 0, true
+		}
+		z := x * y
+		return z, z/x == y && !(x == -1 && y == math.MinInt)
+	case "%":
+		if y == 0 {
+			return 0, false
+		}
+		return x % y, true
+	}
+	return 0, false
+}
+
+// constOverflow returns the error message if the arithmetic on integer
+// constants overflows or divides by zero.
+func constOverflow(node ast.Node) (string, bool) {
+	var op string
+	var x, y int
+	switch n := node.(type) {
+	case *ast.UnaryNode:
+		v, ok := constInt(n.Node)
+		if !ok || n.Operator != "-" {
+			return "", false
+		}
+		op, y = n.Operator, v
+	case *ast.BinaryNode:
+		l, lok := constInt(n.Left)
+		r, rok := constInt(n.Right)
+		if !lok || !rok {
+			return "", false
+		}
+		op, x, y = n.Operator, l, r
+	default:
+		return "", false
+	}
+	switch op {
+	case "+", "-", "*":
+		if _, ok := intArithmetic(op, x, y); !ok {
+			return "constant " + node.String() + " overflows int", true
+		}
+	case "%":
+		if y == 0 {
+			return "integer divide by zero", true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,125 @@
+package checker
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/builtin"
+)
+
+var (
+	stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	errorType    = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// checkBuiltinFormat checks arguments of format() against the verbs
+// of the format string, if the format string is a constant.
+func (v *checker) checkBuiltinFormat(node *ast.BuiltinNode) (reflect.Type, info) {
+	errors := len(v.errors)
+	t, i := v.checkFunction(builtin.Builtins[builtin.Index["format"]], node, node.Arguments)
+	if len(v.errors) > errors || len(node.Arguments) == 0 {
+		return t, i
+	}
+	s, ok := node.Arguments[0].(*ast.StringNode)
+	if !ok {
+		return t, i
+	}
+
+	verbs, ok := formatVerbs(s.Value)
+	if !ok {
+		// Explicit argument indexes are not checked.
+		return t, i
+	}
+	for _, verb := range verbs {
+		if verb == utf8.RuneError {
+			return v.error(s, "format %q ends with incomplete verb", s.Value)
+		}
+	}
+	args := node.Arguments[1:]
+	if len(verbs) != len(args) {
+		return v.error(node, "format %q expects %d arguments, got %d", s.Value, len(verbs), len(args))
+	}
+	for n, verb := range verbs {
+		argType := args[n].Type()
+		if !formatVerbAccepts(verb, argType) {
+			return v.error(args[n], "verb %%%c of format %q cannot be used with %v", verb, s.Value, argType)
+		}
+	}
+	return t, i
+}
+
+// formatVerbs returns verbs of the format string, one per argument.
+// Verbs with * width or precision consume an extra integer argument,
+// which is reported as 'd' verb.
+func formatVerbs(format string) ([]rune, bool) {
+	var verbs []rune
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		// Flags, width and precision.
+		for i < len(format) && strings.IndexByte("+-# 0123456789.*[]", format[i]) >= 0 {
+			switch format[i] {
+			case '*':
+				verbs = append(verbs, 'd')
+			case '[':
+				return nil, false
+			}
+			i++
+		}
+		if i >= len(format) {
+			verbs = append(verbs, utf8.RuneError)
+			break
+		}
+		verb, size := utf8.DecodeRuneInString(format[i:])
+		i += size - 1
+		if verb == '%' {
+			continue
+		}
+		verbs = append(verbs, verb)
+	}
+	return verbs, true
+}
+
+func formatVerbAccepts(verb rune, t reflect.Type) bool {
+	if t == nil || isAny(t) {
+		return true
+	}
+	switch verb {
+	case 'v', 'T':
+		return true
+	case 't':
+		return isBool(t)
+	case 'd', 'c', 'U', 'o', 'O':
+		return isInteger(t)
+	case 'b':
+		return isNumber(t)
+	case 'x', 'X':
+		return isNumber(t) || isString(t) || isByteSlice(t)
+	case 'e', 'E', 'f', 'F', 'g', 'G':
+		return isFloat(t)
+	case 'q':
+		return isString(t) || isInteger(t) || isByteSlice(t) || implementsFormatter(t)
+	case 's':
+		return isString(t) || isByteSlice(t) || implementsFormatter(t) ||
+			isArray(t) || isMap(t) || isStruct(t)
+	case 'p':
+		switch kind(t) {
+		case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+			return true
+		}
+	}
+	return false
+}
+
+func isByteSlice(t reflect.Type) bool {
+	return kind(t) == reflect.Slice && t.Elem().Kind() == reflect.Uint8
+}
+
+func implementsFormatter(t reflect.Type) bool {
+	return t.Implements(stringerType) || t.Implements(errorType)
+}
@@ -8,7 +8,12 @@ import (
 	"github.com/expr-lang/expr/vm"
 )
 
-func FieldIndex(types conf.TypesTable, node ast.Node) (bool, []int, string) {
+func FieldIndex(c *conf.Config, node ast.Node) (bool, []int, string) {
+	var types conf.TypesTable
+	var tag string
+	if c != nil {
+		types, tag = c.Types, c.FieldTag
+	}
 	switch n := node.(type) {
 	case *ast.IdentifierNode:
 		if t, ok := types[n.Value]; ok && len(t.FieldIndex) > 0 {
@@ -22,7 +27,13 @@ func FieldIndex(types conf.TypesTable, node ast.Node) (bool, []int, string) {
 		if kind(base) == reflect.Struct {
 			if prop, ok := n.Property.(*ast.StringNode); ok {
 				name := prop.Value
-				if field, ok := fetchField(base, name); ok {
+				if c != nil && c.FieldResolver != nil {
+					// Fields typed by the resolver are not fetched by index.
+					if _, ok := c.FieldResolver(n.Node.Type(), name); ok {
+						return false, nil, ""
+					}
+				}
+				if field, ok := fetchField(base, name, tag); ok {
 					return true, field.Index, name
 				}
 			}
@@ -41,7 +52,7 @@ func MethodIndex(types conf.TypesTable, node ast.Node) (bool, int, string) {
 		if name, ok := n.Property.(*ast.StringNode); ok {
 			base := n.Node.Type()
 			if base != nil && base.Kind() != reflect.Interface {
-				if m, ok := base.MethodByName(name.Value); ok {
+				if m, ok := fetchMethod(base, name.Value); ok {
 					return true, m.Index, name.Value
 				}
 			}
@@ -0,0 +1,123 @@
+package checker
+
+import (
+	"reflect"
+
+	"github.com/expr-lang/expr/ast"
+)
+
+// nilable reports whether the checked node may evaluate to nil, so fetching
+// from it may fail at runtime. Only pointers and interfaces with methods are
+// tracked: values of interfaces without methods, like any, and so map values
+// and elements of []any, are never reported.
+func (v *checker) nilable(node ast.Node) bool {
+	if b, ok := node.(*ast.BinaryNode); ok && b.Operator == "??" {
+		// Operands of ?? are dereferenced, so its type is not a pointer even
+		// if the result may be nil.
+		return v.nilable(b.Right)
+	}
+	t := node.Type()
+	switch kind(t) {
+	case reflect.Ptr:
+	case reflect.Interface:
+		if t.NumMethod() == 0 {
+			return false
+		}
+	default:
+		return false
+	}
+	if v.nonNil[v.fact(node)] > 0 {
+		return false
+	}
+	return true
+}
+
+// nilFact is an expression checked for nil. The same expression means
+// different values in different predicates, like # of nested closures, so
+// it is keyed by the predicate scope its variables are declared in.
+type nilFact struct {
+	expr  string
+	scope int
+}
+
+// fact returns the fact of the node, as seen from the current scope.
+func (v *checker) fact(node ast.Node) nilFact {
+	s := &factScope{checker: v}
+	ast.Walk(&node, s)
+	return nilFact{expr: node.String(), scope: s.scope}
+}
+
+// factScope finds the innermost predicate scope of the variables, like
+// pointers and lets, of the expression. Variables of the env are in scope 0.
+type factScope struct {
+	checker *checker
+	scope   int
+}
+
+func (s *factScope) Visit(node *ast.Node) {
+	depth := 0
+	switch n := (*node).(type) {
+	case *ast.PointerNode:
+		depth = len(s.checker.predicateScopes)
+	case *ast.IdentifierNode:
+		if variable, ok := s.checker.lookupVariable(n.Value); ok {
+			depth = variable.depth
+		}
+	}
+	if depth > s.scope {
+		s.scope = depth
+	}
+}
+
+// visitNonNil visits the node, assuming the expressions are not nil.
+func (v *checker) visitNonNil(node ast.Node, exprs []ast.Node) (reflect.Type, info) {
+	if !v.config.StrictNil || len(exprs) == 0 {
+		return v.visit(node)
+	}
+	if v.nonNil == nil {
+		v.nonNil = make(map[nilFact]int)
+	}
+	facts := make([]nilFact, len(exprs))
+	for i, e := range exprs {
+		facts[i] = v.fact(e)
+		v.nonNil[facts[i]]++
+	}
+	t, i := v.visit(node)
+	for _, f := range facts {
+		v.nonNil[f]--
+	}
+	return t, i
+}
+
+// nilChecks returns expressions which are not nil if the condition
+// evaluates to the given result, like `a` for `a != nil && a.b != nil`.
+func nilChecks(cond ast.Node, result bool) []ast.Node {
+	switch n := cond.(type) {
+	case *ast.UnaryNode:
+		if n.Operator == "!" || n.Operator == "not" {
+			return nilChecks(n.Node, !result)
+		}
+	case *ast.BinaryNode:
+		switch n.Operator {
+		case "!=", "==":
+			if (n.Operator == "!=") != result {
+				return nil
+			}
+			if _, ok := n.Right.(*ast.NilNode); ok {
+				return []ast.Node{n.Left}
+			}
+			if _, ok := n.Left.(*ast.NilNode); ok {
+				return []ast.Node{n.Right}
+			}
+		case "&&", "and":
+			if result {
+				return append(nilChecks(n.Left, true), nilChecks(n.Right, true)...)
+			}
+		case "||", "or":
+			if !result {
+				return append(nilChecks(n.Left, false), nilChecks(n.Right, false)...)
+			}
+		}
+	}
+	return nil
+}
@@ -1,23 +1,29 @@
 package checker
 
 import (
+	"math/big"
 	"reflect"
+	"sync"
 	"time"
 
 	"github.com/expr-lang/expr/conf"
+	"github.com/expr-lang/expr/vm/runtime"
 )
 
 var (
-	nilType      = reflect.TypeOf(nil)
-	boolType     = reflect.TypeOf(true)
-	integerType  = reflect.TypeOf(0)
-	floatType    = reflect.TypeOf(float64(0))
-	stringType   = reflect.TypeOf("")
-	arrayType    = reflect.TypeOf([]any{})
-	mapType      = reflect.TypeOf(map[string]any{})
-	anyType      = reflect.TypeOf(new(any)).Elem()
-	timeType     = reflect.TypeOf(time.Time{})
-	durationType = reflect.TypeOf(time.Duration(0))
+	nilType       = reflect.TypeOf(nil)
+	boolType      = reflect.TypeOf(true)
+	integerType   = reflect.TypeOf(0)
+	floatType     = reflect.TypeOf(float64(0))
+	stringType    = reflect.TypeOf("")
+	arrayType     = reflect.TypeOf([]any{})
+	mapType       = reflect.TypeOf(map[string]any{})
+	anyType       = reflect.TypeOf(new(any)).Elem()
+	timeType      = reflect.TypeOf(time.Time{})
+	durationType  = reflect.TypeOf(time.Duration(0))
+	containerType = reflect.TypeOf((*runtime.Container)(nil)).Elem()
+	comparerType  = reflect.TypeOf((*runtime.Comparer)(nil)).Elem()
+	bigIntType    = reflect.TypeOf(new(big.Int))
 )
 
 func combined(a, b reflect.Type) reflect.Type {
@@ -52,6 +58,10 @@ func or(l, r reflect.Type, fns ...func(reflect.Type) bool) bool {
 	return false
 }
 
+func isComparer(t reflect.Type) bool {
+	return t != nil && t.Implements(comparerType)
+}
+
 func isAny(t reflect.Type) bool {
 	if t != nil {
 		switch t.Kind() {
@@ -88,6 +98,18 @@ func isNumber(t reflect.Type) bool {
 	return isInteger(t) || isFloat(t)
 }
 
+// isBigInt reports whether the type is *big.Int or big.Int, which is
+// the type of dereferenced operands.
+func isBigInt(t reflect.Type) bool {
+	return t == bigIntType || t == bigIntType.Elem()
+}
+
+// isBigIntOperand reports whether values of the type can be operands of
+// arithmetic with big integers.
+func isBigIntOperand(t reflect.Type) bool {
+	return isBigInt(t) || isNumber(t) || isAny(t)
+}
+
 func isTime(t reflect.Type) bool {
 	if t != nil {
 		switch t {
@@ -176,13 +198,61 @@ func isFunc(t reflect.Type) bool {
 	return false
 }
 
-func fetchField(t reflect.Type, name string) (reflect.StructField, bool) {
+// Lookups of fields and methods are cached for the process, as checks of
+// many expressions against the same env repeat them. Only found fields and
+// methods are cached, so the caches are limited by the number of them.
+var (
+	fieldCache  sync.Map // map[fieldKey]reflect.StructField
+	methodCache sync.Map // map[methodKey]reflect.Method
+)
+
+type fieldKey struct {
+	t         reflect.Type
+	name, tag string
+}
+
+type methodKey struct {
+	t    reflect.Type
+	name string
+}
+
+func fetchField(t reflect.Type, name, tag string) (reflect.StructField, bool) {
+	if t == nil {
+		return reflect.StructField{}, false
+	}
+	key := fieldKey{t, name, tag}
+	if cached, ok := fieldCache.Load(key); ok {
+		return cached.(reflect.StructField), true
+	}
+	field, ok := lookupField(t, name, tag)
+	if ok {
+		// Index is shared between callers, so appending to it must copy.
+		field.Index = field.Index[:len(field.Index):len(field.Index)]
+		fieldCache.Store(key, field)
+	}
+	return field, ok
+}
+
+// fetchMethod returns the method of the type, like t.MethodByName.
+func fetchMethod(t reflect.Type, name string) (reflect.Method, bool) {
+	key := methodKey{t, name}
+	if cached, ok := methodCache.Load(key); ok {
+		return cached.(reflect.Method), true
+	}
+	m, ok := t.MethodByName(name)
+	if ok {
+		methodCache.Store(key, m)
+	}
+	return m, ok
+}
+
+func lookupField(t reflect.Type, name, tag string) (reflect.StructField, bool) {
 	if t != nil {
 		// First check all structs fields.
 		for i := 0; i < t.NumField(); i++ {
 			field := t.Field(i)
 			// Search all fields, even embedded structs.
-			if conf.FieldName(field) == name {
+			if conf.TaggedFieldName(field, tag) == name {
 				return field, true
 			}
 		}
@@ -195,7 +265,7 @@ func fetchField(t reflect.Type, name string) (reflect.StructField, bool) {
 				if anonType.Kind() == reflect.Pointer {
 					anonType = anonType.Elem()
 				}
-				if field, ok := fetchField(anonType, name); ok {
+				if field, ok := lookupField(anonType, name, tag); ok {
 					field.Index = append(anon.Index, field.Index...)
 					return field, true
 				}
@@ -221,8 +291,26 @@ func isComparable(l, r reflect.Type) bool {
 		return true
 	case isNumber(l) && isNumber(r):
 		return true
+	case (isBigInt(l) || isNumber(l)) && (isBigInt(r) || isNumber(r)):
+		return true
 	case isAny(l) || isAny(r):
 		return true
 	}
 	return false
 }
+
+// coercion returns the builtin which converts the type to the expected kind,
+// if it does not match already.
+func coercion(t reflect.Type, expect reflect.Kind) (string, bool) {
+	switch expect {
+	case reflect.Bool:
+		return "bool", !isBool(t)
+	case reflect.String:
+		return "string", !isString(t)
+	case reflect.Int, reflect.Int64:
+		return "int", !isNumber(t)
+	case reflect.Float64:
+		return "float", !isNumber(t)
+	}
+	return "", false
+}
@@ -0,0 +1,288 @@
+package checker
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/conf"
+	"github.com/expr-lang/expr/file"
+	"github.com/expr-lang/expr/internal/deref"
+	"github.com/expr-lang/expr/parser"
+	"github.com/expr-lang/expr/vm/runtime"
+)
+
+// Warnings returns suspicious conditions of the checked tree, which are
+// always true or always false, like `1 == 1`, `x > 10 && x < 5` or
+// `x != nil` on a type which can not be nil, and references to deprecated
+// names, and let variables which are never used or shadow identifiers of
+// the environment. Unlike errors, warnings do not prevent compilation.
+func Warnings(tree *parser.Tree, config *conf.Config) file.ErrorList {
+	w := &warnings{inner: make(map[ast.Node]bool)}
+	if config != nil {
+		w.deprecated = config.Deprecated
+		w.resolver = config.IdentifierResolver
+	}
+	ast.Walk(&tree.Node, w)
+	for _, n := range w.conjunctions {
+		if !w.inner[n] {
+			w.ranges(n)
+		}
+	}
+	for _, warning := range w.list {
+		warning.Bind(tree.Source)
+	}
+	return w.list
+}
+
+type warnings struct {
+	list         file.ErrorList
+	conjunctions []*ast.BinaryNode
+	inner        map[ast.Node]bool // conjunctions which are part of another one
+	deprecated   map[string]string
+	resolver     func(name string) (reflect.Type, bool)
+}
+
+func (w *warnings) warn(node ast.Node, format string, args ...any) {
+	w.list = append(w.list, &file.Error{
+		Location: node.Location(),
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (w *warnings) Visit(node *ast.Node) {
+	switch n := (*node).(type) {
+	case *ast.IdentifierNode:
+		w.deprecation(n, n.Value)
+	case *ast.BuiltinNode:
+		w.deprecation(n, n.Name)
+	case *ast.MemberNode:
+		prop, ok := n.Property.(*ast.StringNode)
+		if !ok {
+			return
+		}
+		if id, ok := n.Node.(*ast.IdentifierNode); ok && id.Value == "$env" {
+			w.deprecation(n, prop.Value)
+			return
+		}
+		if base := deref.Type(n.Node.Type()); kind(base) == reflect.Struct {
+			w.deprecation(n, base.Name()+"."+prop.Value)
+		}
+	case *ast.BinaryNode:
+		w.binary(n)
+	case *ast.VariableDeclaratorNode:
+		w.variable(n)
+	}
+}
+
+// variable warns if the let variable is never used or shadows an identifier
+// of the environment, resolved by the IdentifierResolver.
+func (w *warnings) variable(n *ast.VariableDeclaratorNode) {
+	if strings.HasPrefix(n.Name, "$") {
+		return // Parameters of inlined functions.
+	}
+	if w.resolver != nil {
+		if _, ok := w.resolver(n.Name); ok {
+			w.warn(n, "variable %v shadows an identifier of the environment", n.Name)
+		}
+	}
+	uses := &variableUses{name: n.Name}
+	ast.Walk(&n.Expr, uses)
+	if uses.count == 0 {
+		w.warn(n, "variable %v is never used", n.Name)
+	}
+}
+
+type variableUses struct {
+	name  string
+	count int
+}
+
+func (v *variableUses) Visit(node *ast.Node) {
+	if id, ok := (*node).(*ast.IdentifierNode); ok && id.Value == v.name {
+		v.count++
+	}
+}
+
+// deprecation warns if the name is deprecated.
+func (w *warnings) deprecation(node ast.Node, name string) {
+	hint, ok := w.deprecated[name]
+	switch {
+	case !ok:
+	case hint == "":
+		w.warn(node, "%v is deprecated", name)
+	default:
+		w.warn(node, "%v is deprecated: %v", name, hint)
+	}
+}
+
+func (w *warnings) binary(n *ast.BinaryNode) {
+	switch n.Operator {
+	case "==", "!=", "<", ">", "<=", ">=":
+		l, lok := constant(n.Left)
+		r, rok := constant(n.Right)
+		switch {
+		case lok && rok:
+			if result, ok := compare(n.Operator, l, r); ok {
+				w.warn(n, "condition %v is always %v", n, result)
+			}
+		case n.Operator == "==" || n.Operator == "!=":
+			if lok && l == nil && !nilable(n.Right.Type()) {
+				w.warn(n, "condition %v is always %v (%v is never nil)", n, n.Operator == "!=", n.Right)
+			} else if rok && r == nil && !nilable(n.Left.Type()) {
+				w.warn(n, "condition %v is always %v (%v is never nil)", n, n.Operator == "!=", n.Left)
+			}
+		}
+	case "&&", "and":
+		w.conjunctions = append(w.conjunctions, n)
+		w.inner[n.Left] = true
+		w.inner[n.Right] = true
+	}
+}
+
+// ranges warns if comparisons of the same expression with numbers
+// in the conjunction can not be satisfied together.
+func (w *warnings) ranges(n *ast.BinaryNode) {
+	ranges := make(map[string]*bounds)
+	for _, term := range conjunction(n) {
+		key, op, value, ok := comparison(term)
+		if !ok {
+			continue
+		}
+		b, ok := ranges[key]
+		if !ok {
+			b = &bounds{}
+			ranges[key] = b
+		}
+		if b.add(op, value) && !b.empty {
+			b.empty = true
+			w.warn(n, "condition %v is always false (%v can not satisfy all comparisons)", n, key)
+		}
+	}
+}
+
+// constant returns the value of a literal node.
+func constant(node ast.Node) (any, bool) {
+	switch n := node.(type) {
+	case *ast.NilNode:
+		return nil, true
+	case *ast.IntegerNode:
+		return n.Value, true
+	case *ast.FloatNode:
+		return n.Value, true
+	case *ast.StringNode:
+		return n.Value, true
+	case *ast.BoolNode:
+		return n.Value, true
+	}
+	return nil, false
+}
+
+func compare(op string, l, r any) (result bool, ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false // Mismatched types are reported by the checker.
+		}
+	}()
+	switch op {
+	case "==":
+		return runtime.Equal(l, r), true
+	case "!=":
+		return !runtime.Equal(l, r), true
+	case "<":
+		return runtime.Less(l, r), true
+	case ">":
+		return runtime.More(l, r), true
+	case "<=":
+		return runtime.LessOrEqual(l, r), true
+	case ">=":
+		return runtime.MoreOrEqual(l, r), true
+	}
+	return false, false
+}
+
+// nilable reports whether values of the type can be nil.
+func nilable(t reflect.Type) bool {
+	switch kind(t) {
+	case reflect.Bool, reflect.String, reflect.Struct, reflect.Array,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return false
+	}
+	return true
+}
+
+func conjunction(node ast.Node) []ast.Node {
+	if n, ok := node.(*ast.BinaryNode); ok && (n.Operator == "&&" || n.Operator == "and") {
+		return append(conjunction(n.Left), conjunction(n.Right)...)
+	}
+	return []ast.Node{node}
+}
+
+// comparison returns the expression, the operator and the number of a
+// comparison like `x > 10`. Reversed comparisons, like `10 < x`, are
+// normalized.
+func comparison(node ast.Node) (string, string, float64, bool) {
+	n, ok := node.(*ast.BinaryNode)
+	if !ok {
+		return "", "", 0, false
+	}
+	reversed := map[string]string{"==": "==", "<": ">", ">": "<", "<=": ">=", ">=": "<="}
+	op, ok := reversed[n.Operator]
+	if !ok {
+		return "", "", 0, false
+	}
+	if value, ok := number(n.Right); ok && !isConstant(n.Left) && isNumber(n.Left.Type()) {
+		return n.Left.String(), n.Operator, value, true
+	}
+	if value, ok := number(n.Left); ok && !isConstant(n.Right) && isNumber(n.Right.Type()) {
+		return n.Right.String(), op, value, true
+	}
+	return "", "", 0, false
+}
+
+func number(node ast.Node) (float64, bool) {
+	switch n := node.(type) {
+	case *ast.IntegerNode:
+		return float64(n.Value), true
+	case *ast.FloatNode:
+		return n.Value, true
+	}
+	return 0, false
+}
+
+func isConstant(node ast.Node) bool {
+	_, ok := constant(node)
+	return ok
+}
+
+// bounds is the range of numbers satisfying comparisons.
+type bounds struct {
+	lower, upper       float64
+	hasLower, hasUpper bool
+	lowerIncl          bool
+	upperIncl          bool
+	empty              bool
+}
+
+// add narrows the range by the comparison and reports whether it is empty.
+func (b *bounds) add(op string, value float64) bool {
+	if op == ">" || op == ">=" || op == "==" {
+		incl := op != ">"
+		if !b.hasLower || value > b.lower || (value == b.lower && !incl) {
+			b.lower, b.lowerIncl, b.hasLower = value, incl, true
+		}
+	}
+	if op == "<" || op == "<=" || op == "==" {
+		incl := op != "<"
+		if !b.hasUpper || value < b.upper || (value == b.upper && !incl) {
+			b.upper, b.upperIncl, b.hasUpper = value, incl, true
+		}
+	}
+	if !b.hasLower || !b.hasUpper {
+		return false
+	}
+	return b.lower > b.upper || (b.lower == b.upper && !(b.lowerIncl && b.upperIncl))
+}
@@ -0,0 +1,642 @@
+// Package codegen generates Go source code of a function evaluating
+// an expression against a concrete environment struct.
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/checker"
+	"github.com/expr-lang/expr/conf"
+	"github.com/expr-lang/expr/file"
+)
+
+// Config of the generated code.
+type Config struct {
+	Package string        // Package name of the generated file.
+	PkgPath string        // Import path of the generated package, its types are not imported.
+	Func    string        // Name of the generated function.
+	Env     any           // Environment, a struct or a pointer to a struct.
+	Options []expr.Option // Additional compile options, like expr.AsBool().
+}
+
+// Generate returns formatted Go source code of a file with a function
+//
+//	func Func(env Env) (T, error)
+//
+// evaluating the input expression, where T is the type of the expression.
+// Only a subset of the language is supported: literals, fields, methods
+// and functions of the env, operators, variables, conditionals and
+// predicates over slices. Other expressions are reported as errors.
+//
+// Unlike the VM, the generated code does not support negative indexes, and
+// fetching missing keys of maps returns zero values instead of nil. Constant
+// negative indexes are reported as errors.
+func Generate(input string, config Config) (code []byte, err error) {
+	envType := reflect.TypeOf(config.Env)
+	if envType == nil || envType.Kind() != reflect.Struct &&
+		(envType.Kind() != reflect.Ptr || envType.Elem().Kind() != reflect.Struct) {
+		return nil, fmt.Errorf("env must be a struct or a pointer to a struct, got %T", config.Env)
+	}
+
+	c := conf.CreateNew()
+	expr.Env(config.Env)(c)
+	for _, op := range config.Options {
+		op(c)
+	}
+	c.Check()
+
+	tree, err := checker.ParseCheck(input, c)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &generator{
+		config:  c,
+		env:     envType,
+		pkgPath: config.PkgPath,
+		imports: map[string]string{"fmt": "fmt"},
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			if fileError, ok := r.(*file.Error); ok {
+				err = fileError.Bind(tree.Source)
+				return
+			}
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	// Types of nodes may be widened by generating them, like of arithmetic
+	// of small integers, so the type of the result is taken afterwards.
+	gen := g.gen(tree.Node)
+	out := tree.Node.Type()
+	if out == nil {
+		out = anyType
+	}
+	env := g.typeName(envType)
+	body := g.convert(tree.Node, gen, out)
+	result := g.typeName(out)
+
+	var buf bytes.Buffer
+	_, _ = fmt.Fprintf(&buf, "// Code generated by expr codegen. DO NOT EDIT.\n\n")
+	_, _ = fmt.Fprintf(&buf, "package %v\n\n", config.Package)
+	_, _ = fmt.Fprintf(&buf, "import (\n")
+	// Standard packages go first, separated from other packages.
+	var std, other []string
+	for path := range g.imports {
+		if strings.Contains(strings.Split(path, "/")[0], ".") {
+			other = append(other, path)
+		} else {
+			std = append(std, path)
+		}
+	}
+	sort.Strings(std)
+	sort.Strings(other)
+	for _, path := range std {
+		_, _ = fmt.Fprintf(&buf, "\t%q\n", path)
+	}
+	if len(other) > 0 {
+		_, _ = fmt.Fprintf(&buf, "\n")
+	}
+	for _, path := range other {
+		_, _ = fmt.Fprintf(&buf, "\t%q\n", path)
+	}
+	_, _ = fmt.Fprintf(&buf, ")\n\n")
+	for _, v := range g.vars {
+		_, _ = fmt.Fprintf(&buf, "%v\n\n", v)
+	}
+	_, _ = fmt.Fprintf(&buf, "// %v evaluates the expression:\n//\n//\t%v\n", config.Func, strings.ReplaceAll(input, "\n", "\n//\t"))
+	_, _ = fmt.Fprintf(&buf, "func %v(env %v) (_ %v, err error) {\n", config.Func, env, result)
+	_, _ = fmt.Fprintf(&buf, "defer func() {\n")
+	_, _ = fmt.Fprintf(&buf, "if r := recover(); r != nil {\n")
+	_, _ = fmt.Fprintf(&buf, "if e, ok := r.(error); ok {\nerr = e\n} else {\nerr = fmt.Errorf(\"%%v\", r)\n}\n")
+	_, _ = fmt.Fprintf(&buf, "}\n}()\n")
+	_, _ = fmt.Fprintf(&buf, "return %v, nil\n}\n", body)
+
+	code, err = format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("cannot format generated code: %w\n%s", err, buf.Bytes())
+	}
+	return code, nil
+}
+
+type generator struct {
+	config  *conf.Config
+	env     reflect.Type
+	pkgPath string
+	imports map[string]string
+	vars    []string
+	lets    []string
+	scopes  []*scope
+	count   int
+}
+
+// scope of a predicate, like the closure of all().
+type scope struct {
+	item, index         string
+	usedItem, usedIndex bool
+}
+
+func (g *generator) unsupported(node ast.Node, format string, args ...any) {
+	panic(&file.Error{
+		Location: node.Location(),
+		Message:  fmt.Sprintf("cannot generate Go code: "+format, args...),
+	})
+}
+
+func (g *generator) use(path string) string {
+	name := path[strings.LastIndex(path, "/")+1:]
+	g.imports[path] = name
+	return name
+}
+
+func (g *generator) gen(node ast.Node) string {
+	switch n := node.(type) {
+	case *ast.NilNode:
+		return "nil"
+	case *ast.IdentifierNode:
+		return g.identifier(n)
+	case *ast.IntegerNode:
+		if t := n.Type(); t != nil && t != intType {
+			return fmt.Sprintf("%v(%d)", g.typeName(t), n.Value)
+		}
+		return strconv.Itoa(n.Value)
+	case *ast.FloatNode:
+		s := strconv.FormatFloat(n.Value, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eEn") {
+			s += ".0"
+		}
+		if t := n.Type(); t != nil && t != floatType {
+			return fmt.Sprintf("%v(%v)", g.typeName(t), s)
+		}
+		return s
+	case *ast.BoolNode:
+		return strconv.FormatBool(n.Value)
+	case *ast.StringNode:
+		return strconv.Quote(n.Value)
+	case *ast.UnaryNode:
+		return g.unary(n)
+	case *ast.BinaryNode:
+		return g.binary(n)
+	case *ast.ChainNode:
+		return g.gen(n.Node)
+	case *ast.MemberNode:
+		return g.member(n)
+	case *ast.SliceNode:
+		return g.slice(n)
+	case *ast.CallNode:
+		return g.call(n)
+	case *ast.BuiltinNode:
+		return g.builtin(n)
+	case *ast.PointerNode:
+		return g.pointer(n)
+	case *ast.ConditionalNode:
+		t := g.nodeType(n)
+		return fmt.Sprintf("func() %v {\nif %v {\nreturn %v\n}\nreturn %v\n}()",
+			g.typeName(t),
+			g.convert(n.Cond, g.gen(n.Cond), boolType),
+			g.convert(n.Exp1, g.gen(n.Exp1), t),
+			g.convert(n.Exp2, g.gen(n.Exp2), t))
+	case *ast.VariableDeclaratorNode:
+		t := g.nodeType(n)
+		value := g.gen(n.Value)
+		g.lets = append(g.lets, n.Name)
+		body := g.convert(n.Expr, g.gen(n.Expr), t)
+		g.lets = g.lets[:len(g.lets)-1]
+		return fmt.Sprintf("func() %v {\n_%v := %v\n_ = _%v\nreturn %v\n}()", g.typeName(t), n.Name, value, n.Name, body)
+	case *ast.ArrayNode:
+		t := g.nodeType(n)
+		items := make([]string, len(n.Nodes))
+		for i, item := range n.Nodes {
+			items[i] = g.convert(item, g.gen(item), t.Elem())
+		}
+		return fmt.Sprintf("%v{%v}", g.typeName(t), strings.Join(items, ", "))
+	case *ast.MapNode:
+		t := g.nodeType(n)
+		pairs := make([]string, len(n.Pairs))
+		for i, p := range n.Pairs {
+			pair := p.(*ast.PairNode)
+			pairs[i] = fmt.Sprintf("%v: %v",
+				g.convert(pair.Key, g.gen(pair.Key), t.Key()),
+				g.convert(pair.Value, g.gen(pair.Value), t.Elem()))
+		}
+		return fmt.Sprintf("%v{%v}", g.typeName(t), strings.Join(pairs, ", "))
+	}
+	g.unsupported(node, "%v", node)
+	return ""
+}
+
+func (g *generator) nodeType(node ast.Node) reflect.Type {
+	if t := node.Type(); t != nil {
+		return t
+	}
+	return anyType
+}
+
+func (g *generator) identifier(node *ast.IdentifierNode) string {
+	for i := len(g.lets) - 1; i >= 0; i-- {
+		if g.lets[i] == node.Value {
+			return "_" + node.Value
+		}
+	}
+	if node.Value == "$env" {
+		return "env"
+	}
+	if ok, index, _ := checker.FieldIndex(g.config, node); ok {
+		return "env" + g.fieldPath(node, g.env, index)
+	}
+	if ok, _, name := checker.MethodIndex(g.config.Types, node); ok {
+		return "env." + name
+	}
+	g.unsupported(node, "unknown name %v", node.Value)
+	return ""
+}
+
+// fieldPath returns selectors of fields by index, like ".Foo.Bar".
+func (g *generator) fieldPath(node ast.Node, t reflect.Type, index []int) string {
+	var b strings.Builder
+	for _, i := range index {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		field := t.Field(i)
+		if !field.IsExported() {
+			g.unsupported(node, "unexported field %v", field.Name)
+		}
+		b.WriteString(".")
+		b.WriteString(field.Name)
+		t = field.Type
+	}
+	return b.String()
+}
+
+func (g *generator) unary(node *ast.UnaryNode) string {
+	switch node.Operator {
+	case "!", "not":
+		return fmt.Sprintf("!(%v)", g.convert(node.Node, g.gen(node.Node), boolType))
+	case "-":
+		if isNumber(node.Node.Type()) {
+			return fmt.Sprintf("-(%v)", g.gen(node.Node))
+		}
+	case "+":
+		if isNumber(node.Node.Type()) {
+			return g.gen(node.Node)
+		}
+	}
+	g.unsupported(node, "operator %v", node.Operator)
+	return ""
+}
+
+func (g *generator) binary(node *ast.BinaryNode) string {
+	left, right := g.gen(node.Left), g.gen(node.Right)
+	l, r := node.Left.Type(), node.Right.Type()
+
+	switch node.Operator {
+	case "and", "&&", "or", "||":
+		op := "&&"
+		if node.Operator == "or" || node.Operator == "||" {
+			op = "||"
+		}
+		return fmt.Sprintf("(%v %v %v)",
+			g.convert(node.Left, left, boolType), op, g.convert(node.Right, right, boolType))
+
+	case "==", "!=":
+		if l == nil || r == nil {
+			nillable := l
+			if l == nil {
+				nillable, left = r, right
+			}
+			if nillable != nil && !isNillable(nillable) {
+				return strconv.FormatBool(node.Operator == "!=")
+			}
+			return fmt.Sprintf("(%v %v nil)", left, node.Operator)
+		}
+		if t, ok := numberType(l, r); ok {
+			return fmt.Sprintf("(%v %v %v)",
+				g.convert(node.Left, left, t), node.Operator, g.convert(node.Right, right, t))
+		}
+		if l == r && l.Comparable() || l.Kind() == reflect.Interface || r.Kind() == reflect.Interface {
+			return fmt.Sprintf("(%v %v %v)", left, node.Operator, right)
+		}
+
+	case "<", ">", "<=", ">=":
+		if t, ok := numberType(l, r); ok {
+			return fmt.Sprintf("(%v %v %v)",
+				g.convert(node.Left, left, t), node.Operator, g.convert(node.Right, right, t))
+		}
+		if l == stringType && r == stringType {
+			return fmt.Sprintf("(%v %v %v)", left, node.Operator, right)
+		}
+
+	case "+", "-", "*":
+		if node.Operator == "+" && l == stringType && r == stringType {
+			return fmt.Sprintf("(%v + %v)", left, right)
+		}
+		if t := node.Type(); isNumber(l) && isNumber(r) && isNumber(t) {
+			if t, ok := widenedType(l, r); ok {
+				// The VM computes in int or float64, so small integers do
+				// not wrap around.
+				node.SetType(t)
+			}
+			t = node.Type()
+			return fmt.Sprintf("(%v %v %v)",
+				g.convert(node.Left, left, t), node.Operator, g.convert(node.Right, right, t))
+		}
+
+	case "/":
+		if isNumber(l) && isNumber(r) {
+			return fmt.Sprintf("(%v / %v)",
+				g.convert(node.Left, left, floatType), g.convert(node.Right, right, floatType))
+		}
+
+	case "%":
+		if isInteger(l) && isInteger(r) {
+			return fmt.Sprintf("(%v %% %v)",
+				g.convert(node.Left, left, intType), g.convert(node.Right, right, intType))
+		}
+
+	case "**", "^":
+		if isNumber(l) && isNumber(r) {
+			return fmt.Sprintf("%v.Pow(%v, %v)", g.use("math"),
+				g.convert(node.Left, left, floatType), g.convert(node.Right, right, floatType))
+		}
+
+	case "contains", "startsWith", "endsWith":
+		if l == stringType && r == stringType {
+			fn := map[string]string{"contains": "Contains", "startsWith": "HasPrefix", "endsWith": "HasSuffix"}[node.Operator]
+			return fmt.Sprintf("%v.%v(%v, %v)", g.use("strings"), fn, left, right)
+		}
+
+	case "matches":
+		if l == stringType && r == stringType {
+			if pattern, ok := node.Right.(*ast.StringNode); ok {
+				name := fmt.Sprintf("regexp%d", len(g.vars))
+				g.vars = append(g.vars, fmt.Sprintf("var %v = %v.MustCompile(%v)", name, g.use("regexp"), strconv.Quote(pattern.Value)))
+				return fmt.Sprintf("%v.MatchString(%v)", name, left)
+			}
+			return fmt.Sprintf("%v.MustCompile(%v).MatchString(%v)", g.use("regexp"), right, left)
+		}
+
+	case "in":
+		switch kind(r) {
+		case reflect.Slice, reflect.Array:
+			g.count++
+			item := fmt.Sprintf("it%d", g.count)
+			needle := fmt.Sprintf("needle%d", g.count)
+			elem := &ast.IdentifierNode{}
+			elem.SetType(r.Elem())
+			eq := &ast.BinaryNode{Operator: "==", Left: &ast.IdentifierNode{}, Right: elem}
+			eq.Left.SetType(l)
+			eq.SetLocation(node.Location())
+			return fmt.Sprintf("func() bool {\n%v := %v\nfor _, %v := range %v {\nif %v {\nreturn true\n}\n}\nreturn false\n}()",
+				needle, left, item, right, g.compare(eq, needle, item))
+		case reflect.Map:
+			return fmt.Sprintf("func() bool {\n_, ok := %v[%v]\nreturn ok\n}()",
+				right, g.convert(node.Left, left, r.Key()))
+		}
+	}
+	g.unsupported(node, "operator %v with %v and %v", node.Operator, l, r)
+	return ""
+}
+
+// compare generates equality of already generated operands.
+func (g *generator) compare(node *ast.BinaryNode, left, right string) string {
+	l, r := node.Left.Type(), node.Right.Type()
+	if t, ok := numberType(l, r); ok {
+		return fmt.Sprintf("%v == %v", g.convert(node.Left, left, t), g.convert(node.Right, right, t))
+	}
+	if l == r && l.Comparable() || kind(l) == reflect.Interface || kind(r) == reflect.Interface {
+		return fmt.Sprintf("%v == %v", left, right)
+	}
+	g.unsupported(node, "operator in with %v and %v", l, r)
+	return ""
+}
+
+func (g *generator) member(node *ast.MemberNode) string {
+	if node.Optional {
+		g.unsupported(node, "optional chaining")
+	}
+	base := node.Node.Type()
+	if ok, _, name := checker.MethodIndex(g.config.Types, node); ok {
+		return g.gen(node.Node) + "." + name
+	}
+	if ok, index, _ := checker.FieldIndex(g.config, node); ok {
+		return g.gen(node.Node) + g.fieldPath(node, base, index)
+	}
+	switch kind(base) {
+	case reflect.Slice, reflect.Array:
+		if isNegative(node.Property) {
+			g.unsupported(node.Property, "negative index")
+		}
+		return fmt.Sprintf("%v[%v]", g.gen(node.Node), g.convert(node.Property, g.gen(node.Property), intType))
+	case reflect.Map:
+		return fmt.Sprintf("%v[%v]", g.gen(node.Node), g.convert(node.Property, g.gen(node.Property), base.Key()))
+	}
+	g.unsupported(node, "member of %v", base)
+	return ""
+}
+
+func (g *generator) slice(node *ast.SliceNode) string {
+	if k := kind(node.Node.Type()); k != reflect.Slice && k != reflect.Array {
+		g.unsupported(node, "slice of %v", node.Node.Type())
+	}
+	for _, index := range []ast.Node{node.From, node.To} {
+		if index != nil && isNegative(index) {
+			g.unsupported(index, "negative index")
+		}
+	}
+	var from, to string
+	if node.From != nil {
+		from = g.convert(node.From, g.gen(node.From), intType)
+	}
+	if node.To != nil {
+		to = g.convert(node.To, g.gen(node.To), intType)
+	}
+	return fmt.Sprintf("%v[%v:%v]", g.gen(node.Node), from, to)
+}
+
+func (g *generator) call(node *ast.CallNode) string {
+	fn := node.Callee.Type()
+	if kind(fn) != reflect.Func {
+		g.unsupported(node, "call of %v", node.Callee)
+	}
+	if ident, ok := node.Callee.(*ast.IdentifierNode); ok {
+		if _, ok := g.config.Functions[ident.Value]; ok {
+			g.unsupported(node, "function %v", ident.Value)
+		}
+	}
+	callee := g.gen(node.Callee)
+
+	// Methods of the env are typed with the receiver as the first argument.
+	offset := 0
+	if m, ok := node.Callee.(*ast.MemberNode); ok && m.Method || isEnvMethod(g.config, node.Callee) {
+		offset = 1
+	}
+
+	args := make([]string, len(node.Arguments))
+	for i, arg := range node.Arguments {
+		var in reflect.Type
+		switch {
+		case node.Spread && i == len(node.Arguments)-1:
+			in = fn.In(fn.NumIn() - 1)
+		case fn.IsVariadic() && i+offset >= fn.NumIn()-1:
+			in = fn.In(fn.NumIn() - 1).Elem()
+		default:
+			in = fn.In(i + offset)
+		}
+		args[i] = g.convert(arg, g.gen(arg), in)
+	}
+	spread := ""
+	if node.Spread {
+		spread = "..."
+	}
+	call := fmt.Sprintf("%v(%v%v)", callee, strings.Join(args, ", "), spread)
+
+	switch {
+	case fn.NumOut() == 1:
+		return call
+	case fn.NumOut() == 2 && fn.Out(1) == errorType:
+		return fmt.Sprintf("func() %v {\nout, err := %v\nif err != nil {\npanic(err)\n}\nreturn out\n}()", g.typeName(fn.Out(0)), call)
+	}
+	g.unsupported(node, "call of %v", fn)
+	return ""
+}
+
+func isEnvMethod(config *conf.Config, node ast.Node) bool {
+	if ident, ok := node.(*ast.IdentifierNode); ok {
+		return config.Types[ident.Value].Method
+	}
+	return false
+}
+
+func (g *generator) builtin(node *ast.BuiltinNode) string {
+	switch node.Name {
+	case "len":
+		arg := node.Arguments[0]
+		switch kind(arg.Type()) {
+		case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+			return fmt.Sprintf("len(%v)", g.gen(arg))
+		}
+	case "upper", "lower", "trim":
+		if len(node.Arguments) == 1 && node.Arguments[0].Type() == stringType {
+			fn := map[string]string{"upper": "ToUpper", "lower": "ToLower", "trim": "TrimSpace"}[node.Name]
+			return fmt.Sprintf("%v.%v(%v)", g.use("strings"), fn, g.gen(node.Arguments[0]))
+		}
+	case "all", "none", "any", "one", "count", "filter", "map":
+		if len(node.Arguments) == 2 && node.Map == nil {
+			if closure, ok := node.Arguments[1].(*ast.ClosureNode); ok {
+				return g.predicate(node, closure)
+			}
+		}
+	}
+	g.unsupported(node, "builtin %v", node.Name)
+	return ""
+}
+
+func (g *generator) predicate(node *ast.BuiltinNode, closure *ast.ClosureNode) string {
+	array := node.Arguments[0]
+	t := array.Type()
+	if k := kind(t); k != reflect.Slice && k != reflect.Array {
+		g.unsupported(array, "%v of %v", node.Name, t)
+	}
+	g.count++
+	s := &scope{item: fmt.Sprintf("it%d", g.count), index: fmt.Sprintf("i%d", g.count)}
+	source := g.gen(array)
+	g.scopes = append(g.scopes, s)
+	body := g.gen(closure.Node)
+	g.scopes = g.scopes[:len(g.scopes)-1]
+
+	var pred string
+	if node.Name != "map" {
+		pred = g.convert(closure.Node, body, boolType)
+	}
+	// The predicate is generated as a function literal with a loop, and
+	// returns a value of type typ: pre statements, the loop body and result.
+	var typ, pre, loop, result string
+	switch node.Name {
+	case "all":
+		typ, loop, result = "bool", fmt.Sprintf("if !(%v) {\nreturn false\n}", pred), "true"
+	case "none":
+		typ, loop, result = "bool", fmt.Sprintf("if %v {\nreturn false\n}", pred), "true"
+	case "any":
+		typ, loop, result = "bool", fmt.Sprintf("if %v {\nreturn true\n}", pred), "false"
+	case "count":
+		typ, pre, loop, result = "int", "n := 0\n", fmt.Sprintf("if %v {\nn++\n}", pred), "n"
+	case "one":
+		typ, pre, loop, result = "bool", "n := 0\n", fmt.Sprintf("if %v {\nn++\n}", pred), "n == 1"
+	case "filter":
+		out := g.nodeType(node)
+		s.usedItem = true
+		typ = g.typeName(out)
+		pre = fmt.Sprintf("out := %v{}\n", typ)
+		loop = fmt.Sprintf("if %v {\nout = append(out, %v)\n}", pred, g.convert(array, s.item, out.Elem()))
+		result = "out"
+	case "map":
+		out := g.nodeType(node)
+		typ = g.typeName(out)
+		pre = fmt.Sprintf("out := %v{}\n", typ)
+		loop = fmt.Sprintf("out = append(out, %v)", g.convert(closure.Node, body, out.Elem()))
+		result = "out"
+	}
+
+	header := "for range"
+	switch {
+	case s.usedItem && s.usedIndex:
+		header = fmt.Sprintf("for %v, %v := range", s.index, s.item)
+	case s.usedItem:
+		header = fmt.Sprintf("for _, %v := range", s.item)
+	case s.usedIndex:
+		header = fmt.Sprintf("for %v := range", s.index)
+	}
+	return fmt.Sprintf("func() %v {\n%v%v %v {\n%v\n}\nreturn %v\n}()", typ, pre, header, source, loop, result)
+}
+
+func (g *generator) pointer(node *ast.PointerNode) string {
+	if len(g.scopes) == 0 {
+		g.unsupported(node, "pointer outside of predicate")
+	}
+	s := g.scopes[len(g.scopes)-1]
+	switch node.Name {
+	case "":
+		s.usedItem = true
+		return s.item
+	case "index":
+		s.usedIndex = true
+		return s.index
+	}
+	g.unsupported(node, "pointer #%v", node.Name)
+	return ""
+}
+
+// convert converts generated code of the node to the type t.
+func (g *generator) convert(node ast.Node, code string, t reflect.Type) string {
+	from := node.Type()
+	switch {
+	case from == t:
+		return code
+	case from == nil:
+		if isNillable(t) {
+			return "nil"
+		}
+	case t.Kind() == reflect.Interface:
+		if from.Implements(t) {
+			return code
+		}
+	case from.Kind() == reflect.Interface:
+		return fmt.Sprintf("%v.(%v)", code, g.typeName(t))
+	case isNumber(from) && isNumber(t), from.ConvertibleTo(t) && from.Kind() == t.Kind():
+		return fmt.Sprintf("%v(%v)", g.typeName(t), code)
+	case from.AssignableTo(t):
+		return code
+	}
+	g.unsupported(node, "cannot use %v as %v", from, t)
+	return ""
+}
@@ -0,0 +1,190 @@
+package codegen_test
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/codegen"
+	"github.com/expr-lang/expr/internal/testify/assert"
+	"github.com/expr-lang/expr/internal/testify/require"
+	"github.com/expr-lang/expr/test/mock"
+)
+
+func TestGenerate(t *testing.T) {
+	code, err := codegen.Generate(`Int + 1 > Float && String startsWith "a"`, codegen.Config{
+		Package: "rules",
+		Func:    "Eval",
+		Env:     mock.Env{},
+	})
+	require.NoError(t, err)
+
+	want := `// Code generated by expr codegen. DO NOT EDIT.
+
+package rules
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/expr-lang/expr/test/mock"
+)
+
+// Eval evaluates the expression:
+//
+//	Int + 1 > Float && String startsWith "a"
+func Eval(env mock.Env) (_ bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
+		}
+	}()
+	return ((float64((env.Int + 1)) > env.Float) && strings.HasPrefix(env.String, "a")), nil
+}
+`
+	assert.Equal(t, want, string(code))
+}
+
+func TestGenerate_expressions(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`Foo.Bar.Baz`, `return env.Foo.Bar.Baz, nil`},
+		{`Add(1, 2) * 2`, `return (env.Add(1, 2) * 2), nil`},
+		{`FuncTyped("a") / 2`, `return (float64(env.FuncTyped("a")) / float64(2)), nil`},
+		{`Int ** 2`, `math.Pow(float64(env.Int), float64(2))`},
+		{`String matches "^a"`, `var regexp0 = regexp.MustCompile("^a")`},
+		{`len(String)`, `return len(env.String), nil`},
+		{`Int32 + Int32`, `return (int(env.Int32) + int(env.Int32)), nil`},
+		{`ArrayOfInt[1:]`, `return env.ArrayOfInt[1:], nil`},
+		{`MapIntAny[Int]`, `return env.MapIntAny[env.Int], nil`},
+		{`Int > 0 ? "yes" : "no"`, "if env.Int > 0 {\n\t\t\treturn \"yes\"\n\t\t}"},
+		{`let x = Int; x * x`, "_x := env.Int"},
+		{`all(ArrayOfFoo, .Value != "")`, "for _, it1 := range env.ArrayOfFoo {\n\t\t\tif !(it1.Value != \"\") {"},
+		{`map(ArrayOfInt, #index)`, "for i1 := range env.ArrayOfInt {"},
+		{`count(ArrayOfInt, true)`, "for range env.ArrayOfInt {"},
+		{`Int in ArrayOfInt`, "needle1 := env.Int"},
+		{`"a" in MapOfAny`, `_, ok := env.MapOfAny["a"]`},
+		{`IntPtr == nil`, `return (env.IntPtr == nil), nil`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			code, err := codegen.Generate(tt.input, codegen.Config{
+				Package: "rules",
+				Func:    "Eval",
+				Env:     mock.Env{},
+			})
+			require.NoError(t, err)
+			assert.Contains(t, string(code), tt.want)
+		})
+	}
+}
+
+func TestGenerate_local_env(t *testing.T) {
+	code, err := codegen.Generate(`Int > 0`, codegen.Config{
+		Package: "mock",
+		PkgPath: "github.com/expr-lang/expr/test/mock",
+		Func:    "Eval",
+		Env:     &mock.Env{},
+		Options: []expr.Option{expr.AsBool()},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, string(code), "func Eval(env *Env) (_ bool, err error) {")
+	assert.NotContains(t, string(code), `"github.com/expr-lang/expr/test/mock"`)
+}
+
+func TestGenerate_errors(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{`Foo?.Bar`, "cannot generate Go code: optional chaining (1:6)"},
+		{`duration("1h")`, "cannot generate Go code: builtin duration (1:1)"},
+		{`1..3`, "cannot generate Go code: operator .. with int and int (1:2)"},
+		{`reduce(ArrayOfInt, #acc + #)`, "cannot generate Go code: builtin reduce (1:1)"},
+		{`Unknown`, "unknown name Unknown (1:1)"},
+		{`ArrayOfInt[-1]`, "cannot generate Go code: negative index (1:12)"},
+		{`ArrayOfInt[:-1]`, "cannot generate Go code: negative index (1:13)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := codegen.Generate(tt.input, codegen.Config{Package: "rules", Func: "Eval", Env: mock.Env{}})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+
+	_, err := codegen.Generate(`foo`, codegen.Config{Package: "rules", Func: "Eval", Env: map[string]any{"foo": 1}})
+	require.EqualError(t, err, "env must be a struct or a pointer to a struct, got map[string]interface {}")
+}
+
+// TestGenerate_run compiles and runs the generated code, and compares its
+// results with results of the VM.
+func TestGenerate_run(t *testing.T) {
+	if testing.Short() {
+		t.Skip("compiles the generated code")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+	// The env is declared twice: as Go source for the generated program,
+	// and as the value for the VM.
+	envCode := `mock.Env{String: "héllo", Int32: 2000000000, Uint32: 4000000000, Float32: 1.5, ArrayOfInt: []int{1, 2, 3}}`
+	env := mock.Env{String: "héllo", Int32: 2000000000, Uint32: 4000000000, Float32: 1.5, ArrayOfInt: []int{1, 2, 3}}
+
+	inputs := []string{
+		`len(String)`,
+		`Int32 + Int32`,
+		`Uint32 + Uint32`,
+		`Int32 * 2 - 1`,
+		`Float32 + Float32`,
+		`Int32 / 3`,
+		`Int32 % 7`,
+		`-Int32`,
+		`ArrayOfInt[1] + len(ArrayOfInt)`,
+		`filter(ArrayOfInt, # > 1)`,
+		`let x = Int32; x + x > Int32`,
+		`String startsWith "h" ? upper(String) : String`,
+	}
+
+	// The program is built inside the module, so it imports the env, and
+	// in a directory starting with "_", so ./... patterns ignore it.
+	dir, err := os.MkdirTemp(".", "_run")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var main strings.Builder
+	main.WriteString("package main\n\nimport (\n\t\"fmt\"\n\n\t\"github.com/expr-lang/expr/test/mock\"\n)\n\n")
+	main.WriteString("func main() {\n\tenv := " + envCode + "\n")
+	for i, input := range inputs {
+		name := fmt.Sprintf("Eval%d", i)
+		code, err := codegen.Generate(input, codegen.Config{Package: "main", Func: name, Env: mock.Env{}})
+		require.NoError(t, err, input)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name+".go"), code, 0o644))
+		main.WriteString(fmt.Sprintf("\tfmt.Println(%s(env))\n", name))
+	}
+	main.WriteString("}\n")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte(main.String()), 0o644))
+
+	cmd := exec.Command("go", "run", ".")
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(output))
+
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	require.Len(t, lines, len(inputs))
+	for i, input := range inputs {
+		out, err := expr.Eval(input, env)
+		require.NoError(t, err, input)
+		assert.Equal(t, fmt.Sprintln(out, nil), lines[i]+"\n", input)
+	}
+}
@@ -0,0 +1,144 @@
+package codegen
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/expr-lang/expr/ast"
+)
+
+var (
+	anyType    = reflect.TypeOf((*any)(nil)).Elem()
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+	intType    = reflect.TypeOf(0)
+	floatType  = reflect.TypeOf(float64(0))
+	boolType   = reflect.TypeOf(false)
+	stringType = reflect.TypeOf("")
+)
+
+// typeName returns the type as Go source, and imports packages of named types.
+func (g *generator) typeName(t reflect.Type) string {
+	if t == anyType {
+		return "any"
+	}
+	if t.Name() != "" {
+		if t.PkgPath() == "" || t.PkgPath() == g.pkgPath {
+			return t.Name()
+		}
+		g.imports[t.PkgPath()] = t.String()[:strings.Index(t.String(), ".")]
+		return t.String()
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return "*" + g.typeName(t.Elem())
+	case reflect.Slice:
+		return "[]" + g.typeName(t.Elem())
+	case reflect.Array:
+		return fmt.Sprintf("[%d]%v", t.Len(), g.typeName(t.Elem()))
+	case reflect.Map:
+		return fmt.Sprintf("map[%v]%v", g.typeName(t.Key()), g.typeName(t.Elem()))
+	case reflect.Func:
+		in := make([]string, t.NumIn())
+		for i := range in {
+			if t.IsVariadic() && i == len(in)-1 {
+				in[i] = "..." + g.typeName(t.In(i).Elem())
+			} else {
+				in[i] = g.typeName(t.In(i))
+			}
+		}
+		out := make([]string, t.NumOut())
+		for i := range out {
+			out[i] = g.typeName(t.Out(i))
+		}
+		switch len(out) {
+		case 0:
+			return fmt.Sprintf("func(%v)", strings.Join(in, ", "))
+		case 1:
+			return fmt.Sprintf("func(%v) %v", strings.Join(in, ", "), out[0])
+		}
+		return fmt.Sprintf("func(%v) (%v)", strings.Join(in, ", "), strings.Join(out, ", "))
+	case reflect.Interface:
+		if t.NumMethod() == 0 {
+			return "any"
+		}
+	}
+	panic(fmt.Sprintf("cannot generate Go code: type %v", t))
+}
+
+func kind(t reflect.Type) reflect.Kind {
+	if t == nil {
+		return reflect.Invalid
+	}
+	return t.Kind()
+}
+
+func isInteger(t reflect.Type) bool {
+	switch kind(t) {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+func isFloat(t reflect.Type) bool {
+	k := kind(t)
+	return k == reflect.Float32 || k == reflect.Float64
+}
+
+func isNumber(t reflect.Type) bool {
+	return isInteger(t) || isFloat(t)
+}
+
+func isNillable(t reflect.Type) bool {
+	switch kind(t) {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface, reflect.Func, reflect.Chan:
+		return true
+	}
+	return false
+}
+
+// widenedType returns the type the VM computes arithmetic of numbers of
+// builtin types in: float64 if one of them is a float, and int otherwise.
+// Named types, like time.Duration, are not widened.
+func widenedType(l, r reflect.Type) (reflect.Type, bool) {
+	for _, t := range []reflect.Type{l, r} {
+		if t.Name() != t.Kind().String() {
+			return nil, false
+		}
+	}
+	if isFloat(l) || isFloat(r) {
+		return floatType, true
+	}
+	return intType, true
+}
+
+// isNegative reports whether the node is a negative integer constant, like
+// -1, which Go rejects as an index.
+func isNegative(node ast.Node) bool {
+	switch n := node.(type) {
+	case *ast.IntegerNode:
+		return n.Value < 0
+	case *ast.UnaryNode:
+		if i, ok := n.Node.(*ast.IntegerNode); ok && n.Operator == "-" {
+			return i.Value > 0
+		}
+	}
+	return false
+}
+
+// numberType returns the type of numbers compared or combined by operators:
+// the same type for operands of equal types, float64 if one of them is
+// a float, and int otherwise.
+func numberType(l, r reflect.Type) (reflect.Type, bool) {
+	switch {
+	case !isNumber(l) || !isNumber(r):
+		return nil, false
+	case l == r:
+		return l, true
+	case isFloat(l) || isFloat(r):
+		return floatType, true
+	}
+	return intType, true
+}
@@ -0,0 +1,244 @@
+package compiler
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/checker"
+	"github.com/expr-lang/expr/conf"
+	"github.com/expr-lang/expr/file"
+	"github.com/expr-lang/expr/parser"
+	. "github.com/expr-lang/expr/vm"
+)
+
+// CompileBundle compiles checked trees into one program, which returns the
+// results of all expressions as []any, in the same order. Expressions share
+// constants, and subexpressions repeated in several expressions, like
+// lower(User.Name), are evaluated once.
+//
+// Source of the program is the sources of the trees, one per line, so
+// runtime errors point to the line of the failed expression.
+func CompileBundle(trees []*parser.Tree, config *conf.Config) (program *Program, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	var source []rune
+	nodes := make([]ast.Node, len(trees))
+	for i, tree := range trees {
+		if i > 0 {
+			source = append(source, '\n')
+		}
+		ast.Walk(&tree.Node, &shift{offset: len(source)})
+		source = append(source, tree.Source...)
+		nodes[i] = tree.Node
+	}
+
+	decls := hoist(nodes, config)
+
+	var node ast.Node = &ast.ArrayNode{Nodes: nodes}
+	node.SetType(reflect.TypeOf([]any{}))
+	for i := len(decls) - 1; i >= 0; i-- {
+		decls[i].Expr = node
+		node = decls[i]
+	}
+
+	c := newCompiler(config)
+	for i := len(decls) - 1; i >= 0; i-- {
+		c.compile(decls[i].Value)
+		index := c.addVariable(decls[i].Name)
+		c.emit(OpStore, index)
+		c.beginScope(decls[i].Name, index)
+	}
+	for _, n := range nodes {
+		c.compile(n)
+		c.emitCast()
+	}
+	c.emitPush(len(nodes))
+	c.emit(OpArray)
+	return c.program(file.NewSource(string(source)), node), nil
+}
+
+type shift struct {
+	offset int
+}
+
+func (s *shift) Visit(node *ast.Node) {
+	loc := (*node).Location()
+	(*node).SetLocation(file.Location{From: loc.From + s.offset, To: loc.To + s.offset})
+}
+
+// hoist replaces subexpressions, repeated in several places, with variables
+// and returns their declarations. The longest subexpressions are hoisted
+// first, so a declaration may refer only to variables declared after it.
+//
+// Hoisted subexpressions are evaluated before all expressions, so only ones
+// which never fail are hoisted, like string functions of fields of struct
+// env, and only if they are evaluated unconditionally: not in the right side
+// of &&, || or ??, in branches of ?:, or in closures of builtins.
+func hoist(nodes []ast.Node, config *conf.Config) []*ast.VariableDeclaratorNode {
+	var decls []*ast.VariableDeclaratorNode
+	for limit := 1000; limit > 0; limit-- {
+		c := &common{config: config, slots: make(map[string][]*ast.Node), skip: make(map[ast.Node]bool)}
+		for i := range nodes {
+			ast.Walk(&nodes[i], &conditional{skip: c.skip})
+		}
+		for i := range nodes {
+			ast.Walk(&nodes[i], c)
+		}
+		for _, decl := range decls {
+			ast.Walk(&decl.Value, c)
+		}
+
+		keys := make([]string, 0, len(c.slots))
+		for key, slots := range c.slots {
+			if len(slots) > 1 {
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			break
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if len(keys[i]) != len(keys[j]) {
+				return len(keys[i]) > len(keys[j])
+			}
+			return keys[i] < keys[j]
+		})
+
+		slots := c.slots[keys[0]]
+		decl := &ast.VariableDeclaratorNode{
+			Name:  fmt.Sprintf("$common_%d", len(decls)),
+			Value: *slots[0],
+		}
+		for _, slot := range slots {
+			id := &ast.IdentifierNode{Value: decl.Name}
+			id.SetType((*slot).Type())
+			ast.Patch(slot, id)
+		}
+		decls = append(decls, decl)
+	}
+	return decls
+}
+
+// conditional collects nodes which may be not evaluated, or evaluated
+// several times, into skip.
+type conditional struct {
+	skip map[ast.Node]bool
+}
+
+func (c *conditional) Visit(node *ast.Node) {
+	switch n := (*node).(type) {
+	case *ast.BinaryNode:
+		switch n.Operator {
+		case "&&", "||", "and", "or", "??":
+			c.mark(&n.Right)
+		}
+	case *ast.ConditionalNode:
+		c.mark(&n.Exp1)
+		c.mark(&n.Exp2)
+	case *ast.ClosureNode:
+		c.mark(&n.Node)
+	}
+}
+
+func (c *conditional) mark(node *ast.Node) {
+	ast.Walk(node, &marker{skip: c.skip})
+}
+
+type marker struct {
+	skip map[ast.Node]bool
+}
+
+func (m *marker) Visit(node *ast.Node) {
+	m.skip[*node] = true
+}
+
+// common collects subexpressions which never fail by their canonical form.
+type common struct {
+	config *conf.Config
+	slots  map[string][]*ast.Node
+	skip   map[ast.Node]bool // conditionally evaluated nodes
+}
+
+func (c *common) Visit(node *ast.Node) {
+	if c.skip[*node] {
+		return
+	}
+	switch (*node).(type) {
+	case *ast.BinaryNode, *ast.UnaryNode, *ast.BuiltinNode:
+	default:
+		return // Literals and fields are cheap to evaluate again.
+	}
+	if !c.safe(*node) {
+		return
+	}
+	key := (*node).Type().String() + " " + (*node).String()
+	c.slots[key] = append(c.slots[key], node)
+}
+
+// safe reports whether the node never fails and has no side effects.
+func (c *common) safe(node ast.Node) bool {
+	if node.Type() == nil || c.config == nil || c.config.MapEnv {
+		return false
+	}
+	switch n := node.(type) {
+	case *ast.NilNode, *ast.IntegerNode, *ast.FloatNode, *ast.BoolNode, *ast.StringNode:
+		return true
+	case *ast.IdentifierNode:
+		ok, _, _ := checker.FieldIndex(c.config, n)
+		return ok
+	case *ast.MemberNode:
+		if n.Optional || kind(n.Node.Type()) != reflect.Struct {
+			return false
+		}
+		ok, _, _ := checker.FieldIndex(c.config, n)
+		return ok && c.safe(n.Node)
+	case *ast.UnaryNode:
+		switch n.Operator {
+		case "!", "not":
+			return kind(n.Node.Type()) == reflect.Bool && c.safe(n.Node)
+		case "-":
+			// Integer arithmetic fails on overflow with StrictArithmetic.
+			return n.Node.Type() == floatType && c.safe(n.Node)
+		}
+	case *ast.BinaryNode:
+		if !c.safe(n.Left) || !c.safe(n.Right) {
+			return false
+		}
+		l, r := n.Left.Type(), n.Right.Type()
+		switch n.Operator {
+		case "==", "!=":
+			return true
+		case "&&", "||", "and", "or":
+			return kind(l) == reflect.Bool && kind(r) == reflect.Bool
+		case "<", ">", "<=", ">=":
+			return l == r && (isNumeric(l) || l == stringType)
+		case "+", "-", "*":
+			// Integer arithmetic fails on overflow with StrictArithmetic.
+			return l == r && (l == floatType || (n.Operator == "+" && l == stringType))
+		case "contains", "startsWith", "endsWith":
+			return l == stringType && r == stringType
+		}
+	case *ast.BuiltinNode:
+		switch n.Name {
+		case "lower", "upper", "trim", "len":
+			if len(n.Arguments) != 1 || !c.safe(n.Arguments[0]) {
+				return false
+			}
+			switch kind(n.Arguments[0].Type()) {
+			case reflect.String:
+				return true
+			case reflect.Slice, reflect.Array, reflect.Map:
+				return n.Name == "len"
+			}
+		}
+	}
+	return false
+}
+
+var stringType = reflect.TypeOf("")
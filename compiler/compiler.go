@@ -27,28 +27,40 @@ func Compile(tree *parser.Tree, config *conf.Config) (program *Program, err erro
 		}
 	}()
 
-	c := &compiler{
+	c := newCompiler(config)
+	c.compile(tree.Node)
+	c.emitCast()
+	return c.program(tree.Source, tree.Node), nil
+}
+
+func newCompiler(config *conf.Config) *compiler {
+	return &compiler{
 		config:         config,
 		locations:      make([]file.Location, 0),
 		constantsIndex: make(map[any]int),
 		functionsIndex: make(map[string]int),
 		debugInfo:      make(map[string]string),
 	}
+}
 
-	c.compile(tree.Node)
+// emitCast converts the result to the type expected by the config.
+func (c *compiler) emitCast() {
+	if c.config == nil {
+		return
+	}
+	switch c.config.Expect {
+	case reflect.Int:
+		c.emit(OpCast, 0)
+	case reflect.Int64:
+		c.emit(OpCast, 1)
+	case reflect.Float64:
+		c.emit(OpCast, 2)
+	}
+}
 
-	if c.config != nil {
-		switch c.config.Expect {
-		case reflect.Int:
-			c.emit(OpCast, 0)
-		case reflect.Int64:
-			c.emit(OpCast, 1)
-		case reflect.Float64:
-			c.emit(OpCast, 2)
-		}
-		if c.config.Optimize {
-			c.optimize()
-		}
+func (c *compiler) program(source file.Source, node ast.Node) *Program {
+	if c.config != nil && c.config.Optimize {
+		c.optimize()
 	}
 
 	var span *Span
@@ -56,9 +68,9 @@ func Compile(tree *parser.Tree, config *conf.Config) (program *Program, err erro
 		span = c.spans[0]
 	}
 
-	program = NewProgram(
-		tree.Source,
-		tree.Node,
+	return NewProgram(
+		source,
+		node,
 		c.locations,
 		c.variables,
 		c.constants,
@@ -67,8 +79,8 @@ func Compile(tree *parser.Tree, config *conf.Config) (program *Program, err erro
 		c.functions,
 		c.debugInfo,
 		span,
+		c.config.Access(),
 	)
-	return
 }
 
 type compiler struct {
@@ -128,6 +140,9 @@ func (c *compiler) emitPush(value any) int {
 }
 
 func (c *compiler) addConstant(constant any) int {
+	if c.config != nil && c.config.ConstantPool != nil {
+		constant = c.config.ConstantPool.Share(constant)
+	}
 	indexable := true
 	hash := constant
 	switch reflect.TypeOf(constant).Kind() {
@@ -142,6 +157,9 @@ func (c *compiler) addConstant(constant any) int {
 		indexable = true
 		hash = fmt.Sprintf("%v", method)
 	}
+	if re, ok := constant.(*regexp.Regexp); ok {
+		hash = regexpKey(re.String())
+	}
 	if indexable {
 		if p, ok := c.constantsIndex[hash]; ok {
 			return p
@@ -155,6 +173,30 @@ func (c *compiler) addConstant(constant any) int {
 	return p
 }
 
+// regexp compiles the pattern, once per constant pool if there is one.
+func (c *compiler) regexp(pattern string) (*regexp.Regexp, error) {
+	if c.config != nil && c.config.ConstantPool != nil {
+		return c.config.ConstantPool.Regexp(pattern)
+	}
+	return regexp.Compile(pattern)
+}
+
+// regexpKey is the key of regexp constants, so each pattern is added once.
+type regexpKey string
+
+// constantString returns the value of a string literal or a string constant,
+// like the one returned by a ConstExpr function.
+func constantString(node ast.Node) (string, bool) {
+	switch n := node.(type) {
+	case *ast.StringNode:
+		return n.Value, true
+	case *ast.ConstantNode:
+		s, ok := n.Value.(string)
+		return s, ok
+	}
+	return "", false
+}
+
 func (c *compiler) addVariable(name string) int {
 	c.variables++
 	c.debugInfo[fmt.Sprintf("var_%d", c.variables-1)] = name
@@ -163,21 +205,43 @@ func (c *compiler) addVariable(name string) int {
 
 // emitFunction adds builtin.Function.Func to the program.functions and emits call opcode.
 func (c *compiler) emitFunction(fn *builtin.Function, argsLen int) {
+	c.emitCall(fn.Name, fn.Func, argsLen)
+}
+
+func (c *compiler) emitCall(name string, fn Function, argsLen int) {
 	switch argsLen {
 	case 0:
-		c.emit(OpCall0, c.addFunction(fn.Name, fn.Func))
+		c.emit(OpCall0, c.addFunction(name, fn))
 	case 1:
-		c.emit(OpCall1, c.addFunction(fn.Name, fn.Func))
+		c.emit(OpCall1, c.addFunction(name, fn))
 	case 2:
-		c.emit(OpCall2, c.addFunction(fn.Name, fn.Func))
+		c.emit(OpCall2, c.addFunction(name, fn))
 	case 3:
-		c.emit(OpCall3, c.addFunction(fn.Name, fn.Func))
+		c.emit(OpCall3, c.addFunction(name, fn))
 	default:
-		c.emit(OpLoadFunc, c.addFunction(fn.Name, fn.Func))
+		c.emit(OpLoadFunc, c.addFunction(name, fn))
 		c.emit(OpCallN, argsLen)
 	}
 }
 
+// emitOverload binds the overload of the function selected by checker,
+// which is the type of the callee. Calls with arguments of unknown types
+// are dispatched at runtime.
+func (c *compiler) emitOverload(fn *builtin.Function, node *ast.CallNode) bool {
+	for _, arg := range node.Arguments {
+		if k := kind(arg.Type()); k == reflect.Interface || k == reflect.Invalid {
+			return false
+		}
+	}
+	for i, t := range fn.Types {
+		if t == node.Callee.Type() && i < len(fn.Overloads) {
+			c.emitCall(fmt.Sprintf("%v#%d", fn.Name, i), fn.Overloads[i], len(node.Arguments))
+			return true
+		}
+	}
+	return false
+}
+
 // addFunction adds builtin.Function.Func to the program.functions and returns its index.
 func (c *compiler) addFunction(name string, fn Function) int {
 	if fn == nil {
@@ -301,7 +365,7 @@ func (c *compiler) IdentifierNode(node *ast.IdentifierNode) {
 
 	if mapEnv {
 		c.emit(OpLoadFast, c.addConstant(node.Value))
-	} else if ok, index, name := checker.FieldIndex(types, node); ok {
+	} else if ok, index, name := checker.FieldIndex(c.config, node); ok {
 		c.emit(OpLoadField, c.addConstant(&runtime.Field{
 			Index: index,
 			Path:  []string{name},
@@ -461,63 +525,71 @@ func (c *compiler) BinaryNode(node *ast.BinaryNode) {
 		c.derefInNeeded(node.Left)
 		c.compile(node.Right)
 		c.derefInNeeded(node.Right)
-		c.emit(OpLess)
+		c.emit(c.numericOpcode(node, OpLess, OpLessInt, OpLessFloat))
 
 	case ">":
 		c.compile(node.Left)
 		c.derefInNeeded(node.Left)
 		c.compile(node.Right)
 		c.derefInNeeded(node.Right)
-		c.emit(OpMore)
+		c.emit(c.numericOpcode(node, OpMore, OpMoreInt, OpMoreFloat))
 
 	case "<=":
 		c.compile(node.Left)
 		c.derefInNeeded(node.Left)
 		c.compile(node.Right)
 		c.derefInNeeded(node.Right)
-		c.emit(OpLessOrEqual)
+		c.emit(c.numericOpcode(node, OpLessOrEqual, OpLessOrEqualInt, OpLessOrEqualFloat))
 
 	case ">=":
 		c.compile(node.Left)
 		c.derefInNeeded(node.Left)
 		c.compile(node.Right)
 		c.derefInNeeded(node.Right)
-		c.emit(OpMoreOrEqual)
+		c.emit(c.numericOpcode(node, OpMoreOrEqual, OpMoreOrEqualInt, OpMoreOrEqualFloat))
 
 	case "+":
+		if operands := concatOperands(node); len(operands) > 2 {
+			for _, operand := range operands {
+				c.compile(operand)
+				c.derefInNeeded(operand)
+			}
+			c.emit(OpConcat, len(operands))
+			break
+		}
 		c.compile(node.Left)
 		c.derefInNeeded(node.Left)
 		c.compile(node.Right)
 		c.derefInNeeded(node.Right)
-		c.emit(OpAdd)
+		c.emit(c.numericOpcode(node, OpAdd, OpAddInt, OpAddFloat))
 
 	case "-":
 		c.compile(node.Left)
 		c.derefInNeeded(node.Left)
 		c.compile(node.Right)
 		c.derefInNeeded(node.Right)
-		c.emit(OpSubtract)
+		c.emit(c.numericOpcode(node, OpSubtract, OpSubtractInt, OpSubtractFloat))
 
 	case "*":
 		c.compile(node.Left)
 		c.derefInNeeded(node.Left)
 		c.compile(node.Right)
 		c.derefInNeeded(node.Right)
-		c.emit(OpMultiply)
+		c.emit(c.numericOpcode(node, OpMultiply, OpMultiplyInt, OpMultiplyFloat))
 
 	case "/":
 		c.compile(node.Left)
 		c.derefInNeeded(node.Left)
 		c.compile(node.Right)
 		c.derefInNeeded(node.Right)
-		c.emit(OpDivide)
+		c.emit(c.numericOpcode(node, OpDivide, OpDivideInt, OpDivideFloat), c.divisionByZero())
 
 	case "%":
 		c.compile(node.Left)
 		c.derefInNeeded(node.Left)
 		c.compile(node.Right)
 		c.derefInNeeded(node.Right)
-		c.emit(OpModulo)
+		c.emit(OpModulo, c.divisionByZero())
 
 	case "**", "^":
 		c.compile(node.Left)
@@ -534,8 +606,8 @@ func (c *compiler) BinaryNode(node *ast.BinaryNode) {
 		c.emit(OpIn)
 
 	case "matches":
-		if str, ok := node.Right.(*ast.StringNode); ok {
-			re, err := regexp.Compile(str.Value)
+		if pattern, ok := constantString(node.Right); ok {
+			re, err := c.regexp(pattern)
 			if err != nil {
 				panic(err)
 			}
@@ -597,8 +669,8 @@ func (c *compiler) equalBinaryNode(node *ast.BinaryNode) {
 	l := kind(node.Left.Type())
 	r := kind(node.Right.Type())
 
-	leftIsSimple := isSimpleType(node.Left)
-	rightIsSimple := isSimpleType(node.Right)
+	leftIsSimple := isSimpleType(node.Left) && !c.isResolved(node.Left)
+	rightIsSimple := isSimpleType(node.Right) && !c.isResolved(node.Right)
 	leftAndRightAreSimple := leftIsSimple && rightIsSimple
 
 	c.compile(node.Left)
@@ -610,11 +682,117 @@ func (c *compiler) equalBinaryNode(node *ast.BinaryNode) {
 		c.emit(OpEqualInt)
 	} else if l == r && l == reflect.String && leftAndRightAreSimple {
 		c.emit(OpEqualString)
+	} else if l == r && l == reflect.Float64 && leftAndRightAreSimple {
+		c.emit(OpEqualFloat)
 	} else {
 		c.emit(OpEqual)
 	}
 }
 
+// concatOperands returns operands of the chain of string concatenations,
+// like a + b + c, which is compiled into one OpConcat.
+func concatOperands(node ast.Node) []ast.Node {
+	if binary, ok := node.(*ast.BinaryNode); ok && binary.Operator == "+" && kind(binary.Type()) == reflect.String {
+		if kind(binary.Left.Type()) == reflect.String && kind(binary.Right.Type()) == reflect.String {
+			return append(concatOperands(binary.Left), concatOperands(binary.Right)...)
+		}
+	}
+	return []ast.Node{node}
+}
+
+// numericOpcode returns the opcode specialized for int or float64 operands,
+// like OpAddInt, if both operands are known to be of the same type. Such
+// opcodes skip type switches of the runtime helpers.
+func (c *compiler) numericOpcode(node *ast.BinaryNode, op, intOp, floatOp Opcode) Opcode {
+	t := node.Left.Type()
+	if t != node.Right.Type() || !c.isExact(node.Left) || !c.isExact(node.Right) {
+		return op
+	}
+	switch t {
+	case intType:
+		return intOp
+	case floatType:
+		return floatOp
+	}
+	return op
+}
+
+// divisionByZero returns the argument of `/` and `%` opcodes, which is the
+// policy of division by zero.
+func (c *compiler) divisionByZero() int {
+	if c.config == nil {
+		return 0
+	}
+	return int(c.config.DivisionByZero)
+}
+
+// isExact reports whether the value of the node is guaranteed to be of the
+// type inferred by the checker. Types of literals and of fields of structs
+// are enforced by Go, while types of map envs, variables and results of
+// functions are only inferred and may differ at runtime.
+func (c *compiler) isExact(node ast.Node) bool {
+	if node.Type() == nil || c.config == nil {
+		return false
+	}
+	switch n := node.(type) {
+	case *ast.IntegerNode, *ast.FloatNode:
+		return true
+	case *ast.ConstantNode:
+		return reflect.TypeOf(n.Value) == n.Type()
+	case *ast.IdentifierNode:
+		if _, ok := c.lookupVariable(n.Value); ok || c.config.MapEnv {
+			return false
+		}
+		ok, _, _ := checker.FieldIndex(c.config, n)
+		return ok
+	case *ast.MemberNode:
+		if n.Optional || n.Method || c.isResolved(n) {
+			return false
+		}
+		if ok, _, _ := checker.MethodIndex(c.config.Types, n); ok {
+			return false
+		}
+		return c.isExact(n.Node)
+	case *ast.UnaryNode:
+		return (n.Operator == "-" || n.Operator == "+") && isNumeric(n.Type()) && c.isExact(n.Node)
+	case *ast.BinaryNode:
+		switch n.Operator {
+		case "+", "-", "*", "/":
+			return isNumeric(n.Left.Type()) && n.Left.Type() == n.Right.Type() &&
+				c.isExact(n.Left) && c.isExact(n.Right)
+		}
+	}
+	return false
+}
+
+// isResolved reports whether the node is a field typed by the field resolver.
+// Such fields are fetched from maps, which may lack them or hold values of
+// other types, so their types are not exact.
+func (c *compiler) isResolved(node ast.Node) bool {
+	if chain, ok := node.(*ast.ChainNode); ok {
+		node = chain.Node
+	}
+	n, ok := node.(*ast.MemberNode)
+	if !ok || c.config == nil || c.config.FieldResolver == nil {
+		return false
+	}
+	var name string
+	if s, ok := n.Property.(*ast.StringNode); ok {
+		name = s.Value
+	}
+	_, ok = c.config.FieldResolver(n.Node.Type(), name)
+	return ok
+}
+
+var (
+	intType   = reflect.TypeOf(0)
+	floatType = reflect.TypeOf(float64(0))
+)
+
+func isNumeric(t reflect.Type) bool {
+	return t == intType || t == floatType
+}
+
 func isSimpleType(node ast.Node) bool {
 	if node == nil {
 		return false
@@ -654,6 +832,20 @@ func (c *compiler) MemberNode(node *ast.MemberNode) {
 		types = c.config.Types
 	}
 
+	// Fields of $env, like free variables of expanded macros, are loaded
+	// the same way as variables of the env.
+	if env, ok := node.Node.(*ast.IdentifierNode); ok && env.Value == "$env" && !node.Optional {
+		if prop, ok := node.Property.(*ast.StringNode); ok {
+			if ok, index, name := checker.FieldIndex(c.config, &ast.IdentifierNode{Value: prop.Value}); ok {
+				c.emit(OpLoadField, c.addConstant(&runtime.Field{
+					Index: index,
+					Path:  []string{name},
+				}))
+				return
+			}
+		}
+	}
+
 	if ok, index, name := checker.MethodIndex(types, node); ok {
 		c.compile(node.Node)
 		c.emit(OpMethod, c.addConstant(&runtime.Method{
@@ -665,14 +857,14 @@ func (c *compiler) MemberNode(node *ast.MemberNode) {
 	op := OpFetch
 	base := node.Node
 
-	ok, index, nodeName := checker.FieldIndex(types, node)
+	ok, index, nodeName := checker.FieldIndex(c.config, node)
 	path := []string{nodeName}
 
 	if ok {
 		op = OpFetchField
 		for !node.Optional {
 			if ident, isIdent := base.(*ast.IdentifierNode); isIdent {
-				if ok, identIndex, name := checker.FieldIndex(types, ident); ok {
+				if ok, identIndex, name := checker.FieldIndex(c.config, ident); ok {
 					index = append(identIndex, index...)
 					path = append([]string{name}, path...)
 					c.emitLocation(ident.Location(), OpLoadField, c.addConstant(
@@ -683,7 +875,7 @@ func (c *compiler) MemberNode(node *ast.MemberNode) {
 			}
 
 			if member, isMember := base.(*ast.MemberNode); isMember {
-				if ok, memberIndex, name := checker.FieldIndex(types, member); ok {
+				if ok, memberIndex, name := checker.FieldIndex(c.config, member); ok {
 					index = append(memberIndex, index...)
 					path = append([]string{name}, path...)
 					node = member
@@ -749,6 +941,9 @@ func (c *compiler) CallNode(node *ast.CallNode) {
 		}
 		for i, arg := range node.Arguments {
 			c.compile(arg)
+			if node.Spread && i == len(node.Arguments)-1 {
+				break // Elements of the spread slice are converted at runtime.
+			}
 			if k := kind(arg.Type()); k == reflect.Ptr || k == reflect.Interface {
 				var in reflect.Type
 				if fn.IsVariadic() && i >= fnNumIn-1 {
@@ -770,6 +965,14 @@ func (c *compiler) CallNode(node *ast.CallNode) {
 	if ident, ok := node.Callee.(*ast.IdentifierNode); ok {
 		if c.config != nil {
 			if fn, ok := c.config.Functions[ident.Value]; ok {
+				if node.Spread {
+					c.emit(OpLoadFunc, c.addFunction(fn.Name, fn.Func))
+					c.emit(OpCallSpread, len(node.Arguments))
+					return
+				}
+				if len(fn.Overloads) > 0 && c.emitOverload(fn, node) {
+					return
+				}
 				c.emitFunction(fn, len(node.Arguments))
 				return
 			}
@@ -777,6 +980,11 @@ func (c *compiler) CallNode(node *ast.CallNode) {
 	}
 	c.compile(node.Callee)
 
+	if node.Spread {
+		c.emit(OpCallSpread, len(node.Arguments))
+		return
+	}
+
 	isMethod, _, _ := checker.MethodIndex(c.config.Types, node.Callee)
 	if index, ok := checker.TypedFuncIndex(node.Callee.Type(), isMethod); ok {
 		c.emit(OpCallTyped, index)
@@ -792,7 +1000,7 @@ func (c *compiler) BuiltinNode(node *ast.BuiltinNode) {
 	switch node.Name {
 	case "all":
 		c.compile(node.Arguments[0])
-		c.emit(OpBegin)
+		c.emitBegin(node.Arguments[0])
 		var loopBreak int
 		c.emitLoop(func() {
 			c.compile(node.Arguments[1])
@@ -806,7 +1014,7 @@ func (c *compiler) BuiltinNode(node *ast.BuiltinNode) {
 
 	case "none":
 		c.compile(node.Arguments[0])
-		c.emit(OpBegin)
+		c.emitBegin(node.Arguments[0])
 		var loopBreak int
 		c.emitLoop(func() {
 			c.compile(node.Arguments[1])
@@ -821,7 +1029,7 @@ func (c *compiler) BuiltinNode(node *ast.BuiltinNode) {
 
 	case "any":
 		c.compile(node.Arguments[0])
-		c.emit(OpBegin)
+		c.emitBegin(node.Arguments[0])
 		var loopBreak int
 		c.emitLoop(func() {
 			c.compile(node.Arguments[1])
@@ -835,7 +1043,7 @@ func (c *compiler) BuiltinNode(node *ast.BuiltinNode) {
 
 	case "one":
 		c.compile(node.Arguments[0])
-		c.emit(OpBegin)
+		c.emitBegin(node.Arguments[0])
 		c.emitLoop(func() {
 			c.compile(node.Arguments[1])
 			c.emitCond(func() {
@@ -867,6 +1075,49 @@ func (c *compiler) BuiltinNode(node *ast.BuiltinNode) {
 		c.emit(OpArray)
 		return
 
+	case "takeWhile":
+		c.compile(node.Arguments[0])
+		c.emit(OpBegin)
+		var loopBreak int
+		c.emitLoop(func() {
+			c.compile(node.Arguments[1])
+			loopBreak = c.emit(OpJumpIfFalse, placeholder)
+			c.emit(OpPop)
+			c.emit(OpIncrementCount)
+			c.emit(OpPointer)
+		})
+		done := c.emit(OpJump, placeholder)
+		c.patchJump(loopBreak)
+		c.emit(OpPop)
+		c.patchJump(done)
+		c.emit(OpGetCount)
+		c.emit(OpEnd)
+		c.emit(OpArray)
+		return
+
+	case "dropWhile":
+		c.compile(node.Arguments[0])
+		c.emit(OpBegin)
+		var loopBreak int
+		c.emitLoop(func() {
+			c.compile(node.Arguments[1])
+			loopBreak = c.emit(OpJumpIfFalse, placeholder)
+			c.emit(OpPop)
+		})
+		done := c.emit(OpJump, placeholder)
+		c.patchJump(loopBreak)
+		c.emit(OpPop)
+		// Continue from the first element which doesn't match.
+		c.emitLoop(func() {
+			c.emit(OpIncrementCount)
+			c.emit(OpPointer)
+		})
+		c.patchJump(done)
+		c.emit(OpGetCount)
+		c.emit(OpEnd)
+		c.emit(OpArray)
+		return
+
 	case "map":
 		c.compile(node.Arguments[0])
 		c.emit(OpBegin)
@@ -880,7 +1131,7 @@ func (c *compiler) BuiltinNode(node *ast.BuiltinNode) {
 
 	case "count":
 		c.compile(node.Arguments[0])
-		c.emit(OpBegin)
+		c.emitBegin(node.Arguments[0])
 		c.emitLoop(func() {
 			if len(node.Arguments) == 2 {
 				c.compile(node.Arguments[1])
@@ -1017,6 +1268,54 @@ func (c *compiler) BuiltinNode(node *ast.BuiltinNode) {
 		c.emit(OpEnd)
 		return
 
+	case "mapValues", "mapKeys":
+		c.compile(node.Arguments[0])
+		if node.Name == "mapValues" {
+			c.emit(OpBegin)
+		} else {
+			c.emit(OpBegin, 1)
+		}
+		c.emit(OpPush, c.addConstant(node.Type()))
+		c.emit(OpCreate, 6)
+		c.emit(OpSetAcc)
+		c.emitLoop(func() {
+			c.compile(node.Arguments[1])
+			if node.Name == "mapValues" {
+				c.emit(OpMapValues)
+			} else {
+				c.emit(OpMapKeys)
+			}
+		})
+		c.emit(OpGetAcc)
+		c.emit(OpEnd)
+		return
+
+	case "countBy":
+		c.compile(node.Arguments[0])
+		c.emit(OpBegin)
+		c.emit(OpCreate, 4)
+		c.emit(OpSetAcc)
+		c.emitLoop(func() {
+			c.compile(node.Arguments[1])
+			c.emit(OpCountBy)
+		})
+		c.emit(OpGetAcc)
+		c.emit(OpEnd)
+		return
+
+	case "partition":
+		c.compile(node.Arguments[0])
+		c.emit(OpBegin)
+		c.emit(OpCreate, 3)
+		c.emit(OpSetAcc)
+		c.emitLoop(func() {
+			c.compile(node.Arguments[1])
+			c.emit(OpPartition)
+		})
+		c.emit(OpGetAcc)
+		c.emit(OpEnd)
+		return
+
 	case "sortBy":
 		c.compile(node.Arguments[0])
 		c.emit(OpBegin)
@@ -1025,7 +1324,11 @@ func (c *compiler) BuiltinNode(node *ast.BuiltinNode) {
 		} else {
 			c.emit(OpPush, c.addConstant("asc"))
 		}
-		c.emit(OpCreate, 2)
+		if isMultiKeySort(node) {
+			c.emit(OpCreate, 5)
+		} else {
+			c.emit(OpCreate, 2)
+		}
 		c.emit(OpSetAcc)
 		c.emitLoop(func() {
 			c.compile(node.Arguments[1])
@@ -1054,6 +1357,81 @@ func (c *compiler) BuiltinNode(node *ast.BuiltinNode) {
 		c.emit(OpEnd)
 		return
 
+	case "cidr":
+		if str, ok := node.Arguments[0].(*ast.StringNode); ok {
+			cidr, err := builtin.ParseCIDR(str.Value)
+			if err != nil {
+				panic(err)
+			}
+			c.emit(OpPush, c.addConstant(cidr))
+			return
+		}
+
+	case "findAll", "matchGroups":
+		c.compile(node.Arguments[0])
+		if str, ok := node.Arguments[1].(*ast.StringNode); ok {
+			re, err := c.regexp(str.Value)
+			if err != nil {
+				panic(err)
+			}
+			c.emit(OpPush, c.addConstant(re))
+		} else {
+			c.compile(node.Arguments[1])
+		}
+		c.emitFunction(builtin.Builtins[builtin.Index[node.Name]], 2)
+		return
+
+	case "coalesce":
+		var ends []int
+		for _, arg := range node.Arguments[:len(node.Arguments)-1] {
+			c.compile(arg)
+			c.derefInNeeded(arg)
+			ends = append(ends, c.emit(OpJumpIfNotNil, placeholder))
+			c.emit(OpPop)
+		}
+		last := node.Arguments[len(node.Arguments)-1]
+		c.compile(last)
+		c.derefInNeeded(last)
+		for _, end := range ends {
+			c.patchJump(end)
+		}
+		return
+
+	case "containsAny", "startsWithAny", "endsWithAny":
+		c.compile(node.Arguments[0])
+		if strs, ok := constantStrings(node.Arguments[1]); ok {
+			c.emit(OpPush, c.addConstant(builtin.NewMatcher(strs)))
+		} else {
+			c.compile(node.Arguments[1])
+		}
+		c.emitFunction(builtin.Builtins[builtin.Index[node.Name]], 2)
+		return
+
+	case "references":
+		c.compile(node.Arguments[0])
+		c.emit(OpPush, c.addConstant(SafeFunction(references)))
+		c.emit(OpCallSafe, 1)
+		return
+
+	case "unquote":
+		c.compile(node.Arguments[0])
+		c.emit(OpLoadEnv)
+		c.emit(OpPush, c.addConstant(unquote(c.config)))
+		c.emit(OpCallSafe, 2)
+		return
+
+	}
+
+	if access := c.config.Access(); access != nil {
+		// Builtins which fetch fields by names, like pluck(), honor the
+		// options of the program.
+		if fn, ok := builtin.WithAccess(node.Name, access); ok {
+			for _, arg := range node.Arguments {
+				c.compile(arg)
+			}
+			c.emitCall(node.Name, fn, len(node.Arguments))
+			return
+		}
 	}
 
 	if id, ok := builtin.Index[node.Name]; ok {
@@ -1076,6 +1454,17 @@ func (c *compiler) BuiltinNode(node *ast.BuiltinNode) {
 	panic(fmt.Sprintf("unknown builtin %v", node.Name))
 }
 
+// isMultiKeySort reports whether sortBy is called with an array of keys,
+// like sortBy(users, [.LastName, .FirstName]).
+func isMultiKeySort(node *ast.BuiltinNode) bool {
+	closure, ok := node.Arguments[1].(*ast.ClosureNode)
+	if !ok {
+		return false
+	}
+	_, ok = closure.Node.(*ast.ArrayNode)
+	return ok
+}
+
 func (c *compiler) emitCond(body func()) {
 	noop := c.emit(OpJumpIfFalse, placeholder)
 	c.emit(OpPop)
@@ -1088,6 +1477,17 @@ func (c *compiler) emitCond(body func()) {
 	c.patchJump(jmp)
 }
 
+// emitBegin begins iteration over the collection. Strings are iterated by
+// runes only if the collection is known to be a string, so values of
+// interface types are iterated like by other predicates.
+func (c *compiler) emitBegin(collection ast.Node) {
+	if kind(collection.Type()) == reflect.String {
+		c.emit(OpBegin, 2)
+	} else {
+		c.emit(OpBegin)
+	}
+}
+
 func (c *compiler) emitLoop(body func()) {
 	begin := len(c.bytecode)
 	end := c.emit(OpJumpIfEnd, placeholder)
@@ -1127,6 +1527,8 @@ func (c *compiler) PointerNode(node *ast.PointerNode) {
 		c.emit(OpGetIndex)
 	case "acc":
 		c.emit(OpGetAcc)
+	case "key":
+		c.emit(OpGetKey)
 	case "":
 		c.emit(OpPointer)
 	default:
@@ -1181,7 +1583,11 @@ func (c *compiler) ArrayNode(node *ast.ArrayNode) {
 	}
 
 	c.emitPush(len(node.Nodes))
-	c.emit(OpArray)
+	if t := node.Type(); t != nil && t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Interface {
+		c.emit(OpTypedArray, c.addConstant(t))
+	} else {
+		c.emit(OpArray)
+	}
 }
 
 func (c *compiler) MapNode(node *ast.MapNode) {
@@ -1198,6 +1604,42 @@ func (c *compiler) PairNode(node *ast.PairNode) {
 	c.compile(node.Value)
 }
 
+// constantStrings returns strings of a constant array, either an array
+// literal or an array folded by the optimizer.
+func constantStrings(node ast.Node) ([]string, bool) {
+	var values []any
+	switch n := node.(type) {
+	case *ast.ConstantNode:
+		if strs, ok := n.Value.([]string); ok {
+			return strs, true
+		}
+		array, ok := n.Value.([]any)
+		if !ok {
+			return nil, false
+		}
+		values = array
+	case *ast.ArrayNode:
+		for _, item := range n.Nodes {
+			str, ok := item.(*ast.StringNode)
+			if !ok {
+				return nil, false
+			}
+			values = append(values, str.Value)
+		}
+	default:
+		return nil, false
+	}
+	strs := make([]string, len(values))
+	for i, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			return nil, false
+		}
+		strs[i] = s
+	}
+	return strs, true
+}
+
 func (c *compiler) derefInNeeded(node ast.Node) {
 	switch kind(node.Type()) {
 	case reflect.Ptr, reflect.Interface:
@@ -1205,19 +1647,6 @@ func (c *compiler) derefInNeeded(node ast.Node) {
 	}
 }
 
-func (c *compiler) optimize() {
-	for i, op := range c.bytecode {
-		switch op {
-		case OpJumpIfTrue, OpJumpIfFalse, OpJumpIfNil, OpJumpIfNotNil:
-			target := i + c.arguments[i] + 1
-			for target < len(c.bytecode) && c.bytecode[target] == op {
-				target += c.arguments[target] + 1
-			}
-			c.arguments[i] = target - i - 1
-		}
-	}
-}
-
 func kind(t reflect.Type) reflect.Kind {
 	if t == nil {
 		return reflect.Invalid
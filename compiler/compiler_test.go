@@ -2,12 +2,14 @@ package compiler_test
 
 import (
 	"math"
+	"regexp"
 	"testing"
 
 	"github.com/expr-lang/expr/internal/testify/assert"
 	"github.com/expr-lang/expr/internal/testify/require"
 
 	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/builtin"
 	"github.com/expr-lang/expr/test/mock"
 	"github.com/expr-lang/expr/test/playground"
 	"github.com/expr-lang/expr/vm"
@@ -432,6 +434,65 @@ func TestCompile_FuncTypes_excludes_named_functions(t *testing.T) {
 	require.Equal(t, 1, program.Arguments[2])
 }
 
+func TestCompile_constant_regexp(t *testing.T) {
+	program, err := expr.Compile(`findAll("a1b2", "[0-9]")`)
+	require.NoError(t, err)
+	require.Equal(t, vm.OpPush, program.Bytecode[1])
+	require.IsType(t, &regexp.Regexp{}, program.Constants[program.Arguments[1]])
+}
+
+func TestCompile_matches_constant_regexp(t *testing.T) {
+	env := map[string]any{
+		"name":    "",
+		"pattern": func() string { return "^a" },
+	}
+	tests := []string{
+		`name matches "^a" || name matches "^a"`,
+		`name matches "^" + "a" || name matches "^a"`,
+		`name matches pattern() || name matches "^a"`,
+	}
+	for _, code := range tests {
+		t.Run(code, func(t *testing.T) {
+			program, err := expr.Compile(code, expr.Env(env), expr.ConstExpr("pattern"))
+			require.NoError(t, err)
+
+			var regexps int
+			for i, op := range program.Bytecode {
+				require.NotEqual(t, vm.OpMatches, op)
+				if op == vm.OpMatchesConst {
+					require.IsType(t, &regexp.Regexp{}, program.Constants[program.Arguments[i]])
+					regexps++
+				}
+			}
+			require.Equal(t, 2, regexps)
+
+			var constants int
+			for _, c := range program.Constants {
+				if _, ok := c.(*regexp.Regexp); ok {
+					constants++
+				}
+			}
+			require.Equal(t, 1, constants)
+		})
+	}
+}
+
+func TestCompile_constant_matcher(t *testing.T) {
+	for _, opt := range []expr.Option{expr.Optimize(true), expr.Optimize(false)} {
+		program, err := expr.Compile(`startsWithAny("/api/v1", ["/api", "/internal"])`, opt)
+		require.NoError(t, err)
+		require.Equal(t, vm.OpPush, program.Bytecode[1])
+		require.IsType(t, &builtin.Matcher{}, program.Constants[program.Arguments[1]])
+	}
+}
+
+func TestCompile_constant_cidr(t *testing.T) {
+	program, err := expr.Compile(`cidr("10.0.0.0/8")`)
+	require.NoError(t, err)
+	require.Equal(t, vm.OpPush, program.Bytecode[0])
+	require.IsType(t, builtin.CIDR{}, program.Constants[program.Arguments[0]])
+}
+
 func TestCompile_OpCallFast(t *testing.T) {
 	env := mock.Env{}
 	program, err := expr.Compile("Fast(3, 2, 1)", expr.Env(env))
@@ -440,6 +501,166 @@ func TestCompile_OpCallFast(t *testing.T) {
 	require.Equal(t, 3, program.Arguments[4])
 }
 
+func TestCompile_numeric_opcodes(t *testing.T) {
+	type Env struct {
+		Int   int
+		Float float64
+		Ints  []int
+		Any   any
+		Fn    func() int
+	}
+	tests := []struct {
+		code   string
+		opcode vm.Opcode
+	}{
+		{`Int + 1`, vm.OpAddInt},
+		{`Int - Ints[0]`, vm.OpSubtractInt},
+		{`(Int + 1) * 2`, vm.OpMultiplyInt},
+		{`Int / 2`, vm.OpDivideInt},
+		{`-Int < 0`, vm.OpLessInt},
+		{`Int >= 10`, vm.OpMoreOrEqualInt},
+		{`Float * 1.5`, vm.OpMultiplyFloat},
+		{`Float / 2.0 > 0.5`, vm.OpMoreFloat},
+		{`Float == 0.5`, vm.OpEqualFloat},
+		{`Float + Int`, vm.OpAdd},
+		{`Any + 1`, vm.OpAdd},
+		{`Fn() + 1`, vm.OpAdd},
+		{`max(Int, Float) > 1.5`, vm.OpMore},
+		{`let x = Int; x + 1`, vm.OpAdd},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			program, err := expr.Compile(tt.code, expr.Env(Env{}))
+			require.NoError(t, err)
+			require.Contains(t, program.Bytecode, tt.opcode)
+		})
+	}
+}
+
+func TestCompile_numeric_opcodes_map_env(t *testing.T) {
+	env := map[string]any{"price": 0}
+	program, err := expr.Compile(`price * 2`, expr.Env(env))
+	require.NoError(t, err)
+	require.Contains(t, program.Bytecode, vm.OpMultiply)
+
+	out, err := expr.Run(program, map[string]any{"price": 1.5})
+	require.NoError(t, err)
+	require.Equal(t, 3.0, out)
+}
+
+func TestCompile_concat(t *testing.T) {
+	env := map[string]any{"first": "Ada", "last": "Lovelace", "n": 1}
+	tests := []struct {
+		code     string
+		operands int
+		expected any
+	}{
+		{`first + " " + last`, 3, "Ada Lovelace"},
+		{`"<" + first + (", " + last) + ">"`, 5, "<Ada, Lovelace>"},
+		{`first + last`, 0, "AdaLovelace"},
+		{`first + " " + string(n + 1)`, 3, "Ada 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			program, err := expr.Compile(tt.code, expr.Env(env))
+			require.NoError(t, err)
+
+			operands := 0
+			for i, op := range program.Bytecode {
+				if op == vm.OpConcat {
+					operands = program.Arguments[i]
+				}
+			}
+			assert.Equal(t, tt.operands, operands)
+
+			out, err := expr.Run(program, env)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, out)
+		})
+	}
+}
+
+func TestCompileBundle_common_subexpressions(t *testing.T) {
+	type User struct {
+		Name string
+		Ptr  *User
+	}
+	type Env struct {
+		User User
+		Tags map[string]string
+	}
+	program, err := expr.CompileBundle([]string{
+		`lower(User.Name) == "anna"`,
+		`lower(User.Name) startsWith "a" || lower(Tags.name) == "a"`,
+		`User.Ptr != nil && lower(User.Ptr.Name) == "a"`,
+		`lower(Tags.name) == "b" || lower(User.Ptr.Name) == "b"`,
+	}, expr.Env(Env{}))
+	require.NoError(t, err)
+
+	var calls int
+	for _, op := range program.Bytecode {
+		if op == vm.OpCallBuiltin1 {
+			calls++
+		}
+	}
+	// lower(User.Name) is evaluated once, but lower() of a map value and
+	// of a field of a pointer may fail, so they are not shared.
+	assert.Equal(t, 5, calls)
+
+	out, err := expr.Run(program, Env{User: User{Name: "Anna"}, Tags: map[string]string{"name": "B"}})
+	require.NoError(t, err)
+	assert.Equal(t, []any{true, true, false, true}, out)
+}
+
+func TestCompileBundle_integer_arithmetic(t *testing.T) {
+	type Env struct {
+		Count int
+	}
+	program, err := expr.CompileBundle([]string{`Count * 2 > 10`, `Count * 2 < 100`}, expr.Env(Env{}))
+	require.NoError(t, err)
+
+	// Integer arithmetic may fail with StrictArithmetic, so it is evaluated
+	// only where it is used.
+	var muls int
+	for _, op := range program.Bytecode {
+		if op == vm.OpMultiplyInt {
+			muls++
+		}
+	}
+	assert.Equal(t, 2, muls)
+
+	out, err := vm.Run(program, Env{Count: math.MaxInt}, vm.StrictArithmetic(vm.OverflowSaturate))
+	require.NoError(t, err)
+	assert.Equal(t, []any{true, false}, out)
+}
+
+func TestCompileBundle_conditional_subexpressions(t *testing.T) {
+	type Env struct {
+		Flag bool
+		Name string
+	}
+	program, err := expr.CompileBundle([]string{
+		`Flag && upper(Name) == "A"`,
+		`Flag ? upper(Name) : ""`,
+		`Name ?? upper(Name)`,
+		`all([Name], upper(Name) == "A")`,
+	}, expr.Env(Env{}))
+	require.NoError(t, err)
+
+	// upper(Name) may be not evaluated, so it is not hoisted.
+	var calls int
+	for _, op := range program.Bytecode {
+		if op == vm.OpCallBuiltin1 {
+			calls++
+		}
+	}
+	assert.Equal(t, 4, calls)
+
+	out, err := expr.Run(program, Env{Name: "a"})
+	require.NoError(t, err)
+	assert.Equal(t, []any{false, "", "a", true}, out)
+}
+
 func TestCompile_optimizes_jumps(t *testing.T) {
 	env := map[string]any{
 		"a": true,
@@ -482,7 +703,7 @@ func TestCompile_optimizes_jumps(t *testing.T) {
 		{
 			`a && b || c && d`,
 			`0  OpLoadFast     <0>  a
-1  OpJumpIfFalse  <2>  (4)
+1  OpJumpIfFalse  <3>  (5)
 2  OpPop
 3  OpLoadFast    <1>  b
 4  OpJumpIfTrue  <5>  (10)
@@ -497,36 +718,34 @@ func TestCompile_optimizes_jumps(t *testing.T) {
 			`filter([1, 2, 3, 4, 5], # > 3 && # != 4 && # != 5)`,
 			`0   OpPush  <0>  [1 2 3 4 5]
 1   OpBegin
-2   OpJumpIfEnd  <26>  (29)
+2   OpJumpIfEnd  <24>  (27)
 3   OpPointer
 4   OpDeref
 5   OpPush  <1>  3
 6   OpMore
-7   OpJumpIfFalse  <18>  (26)
+7   OpJumpIfFalse  <16>  (24)
 8   OpPop
 9   OpPointer
 10  OpDeref
 11  OpPush  <2>  4
 12  OpEqual
-13  OpNot
-14  OpJumpIfFalse  <11>  (26)
-15  OpPop
-16  OpPointer
-17  OpDeref
-18  OpPush  <3>  5
-19  OpEqual
-20  OpNot
-21  OpJumpIfFalse  <4>  (26)
-22  OpPop
-23  OpIncrementCount
-24  OpPointer
-25  OpJump  <1>  (27)
-26  OpPop
-27  OpIncrementIndex
-28  OpJumpBackward  <27>  (2)
-29  OpGetCount
-30  OpEnd
-31  OpArray
+13  OpJumpIfTrue  <10>  (24)
+14  OpPop
+15  OpPointer
+16  OpDeref
+17  OpPush  <3>  5
+18  OpEqual
+19  OpJumpIfTrue  <4>  (24)
+20  OpPop
+21  OpIncrementCount
+22  OpPointer
+23  OpJump  <1>  (25)
+24  OpPop
+25  OpIncrementIndex
+26  OpJumpBackward  <25>  (2)
+27  OpGetCount
+28  OpEnd
+29  OpArray
 `,
 		},
 		{
@@ -538,7 +757,7 @@ func TestCompile_optimizes_jumps(t *testing.T) {
 4   OpTrue
 5   OpStore        <2>  baz
 6   OpLoadVar      <0>  foo
-7   OpJumpIfFalse  <2>  (10)
+7   OpJumpIfFalse  <3>  (11)
 8   OpPop
 9   OpLoadVar     <1>  bar
 10  OpJumpIfTrue  <2>  (13)
@@ -574,18 +793,48 @@ func TestCompile_optimizes_jumps(t *testing.T) {
 9   OpMap
 10  OpStore      <0>  m
 11  OpLoadVar    <0>  m
-12  OpJumpIfNil  <8>  (21)
+12  OpJumpIfNil  <9>  (22)
 13  OpPush       <0>  a
 14  OpFetch
-15  OpJumpIfNil  <5>  (21)
+15  OpJumpIfNil  <6>  (22)
 16  OpPush       <1>  b
 17  OpFetch
-18  OpJumpIfNil  <2>  (21)
+18  OpJumpIfNil  <3>  (22)
 19  OpPush       <2>  c
 20  OpFetch
 21  OpJumpIfNotNil  <2>  (24)
 22  OpPop
 23  OpNil
+`,
+		},
+		{
+			`a ? (b ? 1 : 2) : 3`,
+			`0   OpLoadFast     <0>  a
+1   OpJumpIfFalse  <9>  (11)
+2   OpPop
+3   OpLoadFast     <1>  b
+4   OpJumpIfFalse  <3>  (8)
+5   OpPop
+6   OpPush  <2>  1
+7   OpJump  <5>  (13)
+8   OpPop
+9   OpPush  <3>  2
+10  OpJump  <2>  (13)
+11  OpPop
+12  OpPush  <4>  3
+`,
+		},
+		{
+			`a != b ? c : d`,
+			`0  OpLoadFast  <0>  a
+1  OpLoadFast  <1>  b
+2  OpEqual
+3  OpJumpIfTrue  <3>  (7)
+4  OpPop
+5  OpLoadFast  <2>  c
+6  OpJump      <2>  (9)
+7  OpPop
+8  OpLoadFast  <3>  d
 `,
 		},
 		{
@@ -0,0 +1,162 @@
+package compiler
+
+import (
+	. "github.com/expr-lang/expr/vm"
+)
+
+// optimize is a peephole pass over the emitted bytecode. It threads jumps
+// through other jumps, folds negations into conditional jumps and removes
+// instructions which do nothing, like a push followed by a pop.
+func (c *compiler) optimize() {
+	c.threadJumps()
+	removed := make([]bool, len(c.bytecode))
+	targets := c.jumpTargets()
+	for i, op := range c.bytecode {
+		switch op {
+		case OpJump, OpJumpIfTrue, OpJumpIfFalse, OpJumpIfNil, OpJumpIfNotNil:
+			// Conditional jumps only peek at the stack, so a jump to the
+			// next instruction does nothing.
+			if c.arguments[i] == 0 {
+				removed[i] = true
+			}
+
+		case OpNot:
+			// The negated value is popped on both branches, as in
+			// `!(a == b) ? x : y`, so the jump checks the value itself.
+			j := i + 1
+			if j+1 >= len(c.bytecode) || targets[j] {
+				continue
+			}
+			flipped, ok := negated[c.bytecode[j]]
+			if !ok || c.bytecode[j+1] != OpPop || !c.pops(c.jumpTarget(j)) {
+				continue
+			}
+			c.bytecode[j] = flipped
+			removed[i] = true
+
+		case OpPush, OpInt, OpNil, OpTrue, OpFalse, OpLoadVar, OpLoadEnv:
+			j := i + 1
+			if j < len(c.bytecode) && c.bytecode[j] == OpPop && !targets[j] {
+				removed[i] = true
+				removed[j] = true
+			}
+		}
+	}
+	c.remove(removed)
+}
+
+func (c *compiler) pops(i int) bool {
+	return i < len(c.bytecode) && c.bytecode[i] == OpPop
+}
+
+// negated maps conditional jumps to the jumps with the negated condition.
+var negated = map[Opcode]Opcode{
+	OpJumpIfTrue:  OpJumpIfFalse,
+	OpJumpIfFalse: OpJumpIfTrue,
+}
+
+// threadJumps retargets jumps which land on other jumps. An unconditional
+// jump is followed to its target, a jump with the same condition is followed
+// too, and a jump with the opposite condition is never taken, so the target
+// is the instruction after it.
+func (c *compiler) threadJumps() {
+	for i, op := range c.bytecode {
+		switch op {
+		case OpJump, OpJumpIfTrue, OpJumpIfFalse, OpJumpIfNil, OpJumpIfNotNil:
+		default:
+			continue
+		}
+		target := c.jumpTarget(i)
+		for limit := len(c.bytecode); limit > 0 && target < len(c.bytecode); limit-- {
+			next := c.bytecode[target]
+			if next == OpJump || next == op {
+				target = c.jumpTarget(target)
+			} else if opposite(op, next) {
+				target++
+			} else {
+				break
+			}
+		}
+		c.arguments[i] = target - i - 1
+	}
+}
+
+func opposite(a, b Opcode) bool {
+	switch a {
+	case OpJumpIfTrue:
+		return b == OpJumpIfFalse
+	case OpJumpIfFalse:
+		return b == OpJumpIfTrue
+	case OpJumpIfNil:
+		return b == OpJumpIfNotNil
+	case OpJumpIfNotNil:
+		return b == OpJumpIfNil
+	}
+	return false
+}
+
+// jumpTarget returns the index of the instruction the jump at i lands on.
+func (c *compiler) jumpTarget(i int) int {
+	if c.bytecode[i] == OpJumpBackward {
+		return i + 1 - c.arguments[i]
+	}
+	return i + 1 + c.arguments[i]
+}
+
+func isJump(op Opcode) bool {
+	switch op {
+	case OpJump, OpJumpIfTrue, OpJumpIfFalse, OpJumpIfNil, OpJumpIfNotNil, OpJumpIfEnd, OpJumpBackward:
+		return true
+	}
+	return false
+}
+
+// jumpTargets marks instructions which are targets of jumps.
+func (c *compiler) jumpTargets() []bool {
+	targets := make([]bool, len(c.bytecode)+1)
+	for i, op := range c.bytecode {
+		if isJump(op) {
+			targets[c.jumpTarget(i)] = true
+		}
+	}
+	return targets
+}
+
+// remove deletes the marked instructions and adjusts jumps over them. Jumps
+// to a removed instruction land on the next one kept.
+func (c *compiler) remove(removed []bool) {
+	index := make([]int, len(c.bytecode)+1)
+	n := 0
+	for i := range c.bytecode {
+		index[i] = n
+		if !removed[i] {
+			n++
+		}
+	}
+	index[len(c.bytecode)] = n
+	if n == len(c.bytecode) {
+		return
+	}
+
+	for i, op := range c.bytecode {
+		if removed[i] || !isJump(op) {
+			continue
+		}
+		target := index[c.jumpTarget(i)]
+		if op == OpJumpBackward {
+			c.arguments[i] = index[i] + 1 - target
+		} else {
+			c.arguments[i] = target - index[i] - 1
+		}
+	}
+	for i := range c.bytecode {
+		if !removed[i] {
+			c.bytecode[index[i]] = c.bytecode[i]
+			c.arguments[index[i]] = c.arguments[i]
+			c.locations[index[i]] = c.locations[i]
+		}
+	}
+	c.bytecode = c.bytecode[:n]
+	c.arguments = c.arguments[:n]
+	c.locations = c.locations[:n]
+}
@@ -0,0 +1,119 @@
+package compiler
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/checker"
+	"github.com/expr-lang/expr/conf"
+	"github.com/expr-lang/expr/parser"
+	. "github.com/expr-lang/expr/vm"
+)
+
+// references returns sorted names and field paths referenced by the
+// expression, like ["User", "User.Age"] for `User.Age > 18`.
+func references(args ...any) (any, uint, error) {
+	code, ok := args[0].(string)
+	if !ok {
+		return nil, 0, fmt.Errorf("invalid argument for references (type %T)", args[0])
+	}
+	tree, err := parser.Parse(code)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	r := &referencesVisitor{
+		variables: make(map[string]bool),
+		paths:     make(map[string]bool),
+	}
+	// Walk is depth-first, so variables must be collected before paths.
+	ast.Walk(&tree.Node, r)
+	r.collect = true
+	ast.Walk(&tree.Node, r)
+
+	out := make([]string, 0, len(r.paths))
+	for path := range r.paths {
+		out = append(out, path)
+	}
+	sort.Strings(out)
+	return out, uint(len(out)), nil
+}
+
+type referencesVisitor struct {
+	collect   bool
+	variables map[string]bool
+	paths     map[string]bool
+}
+
+func (r *referencesVisitor) Visit(node *ast.Node) {
+	switch n := (*node).(type) {
+	case *ast.VariableDeclaratorNode:
+		r.variables[n.Name] = true
+	case *ast.IdentifierNode, *ast.MemberNode:
+		if !r.collect {
+			return
+		}
+		if path, ok := r.path(n); ok {
+			r.paths[path] = true
+		}
+	}
+}
+
+func (r *referencesVisitor) path(node ast.Node) (string, bool) {
+	path, ok := ast.MemberPath(node)
+	if !ok || r.variables[path[0]] {
+		return "", false
+	}
+	return strings.Join(path, "."), true
+}
+
+// unquoteCacheSize is the maximum number of programs cached by unquote()
+// of one program.
+const unquoteCacheSize = 256
+
+// unquote returns a function which compiles the expression with the config
+// of the outer program and runs it against the same env, with the options of
+// the VM. Compiled programs are cached, as the same rules are usually
+// unquoted over and over again.
+func unquote(config *conf.Config) NestedFunction {
+	if config == nil {
+		config = conf.CreateNew()
+	}
+	c := *config
+	c.Expect = reflect.Invalid
+	c.ExpectAny = false
+
+	var mu sync.Mutex
+	cache := make(map[string]*Program)
+	return func(run func(*Program, any) (any, error), args ...any) (any, uint, error) {
+		code, ok := args[0].(string)
+		if !ok {
+			return nil, 0, fmt.Errorf("invalid argument for unquote (type %T)", args[0])
+		}
+		mu.Lock()
+		program, ok := cache[code]
+		mu.Unlock()
+		if !ok {
+			tree, err := checker.ParseCheck(code, &c)
+			if err != nil {
+				return nil, 0, err
+			}
+			program, err = Compile(tree, &c)
+			if err != nil {
+				return nil, 0, err
+			}
+			mu.Lock()
+			if len(cache) >= unquoteCacheSize {
+				cache = make(map[string]*Program)
+			}
+			cache[code] = program
+			mu.Unlock()
+		}
+		out, err := run(program, args[1])
+		return out, 0, err
+	}
+}
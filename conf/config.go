@@ -6,11 +6,20 @@ import (
 
 	"github.com/expr-lang/expr/ast"
 	"github.com/expr-lang/expr/builtin"
+	"github.com/expr-lang/expr/file"
+	"github.com/expr-lang/expr/vm"
 	"github.com/expr-lang/expr/vm/runtime"
 )
 
 type FunctionsTable map[string]*builtin.Function
 
+// DefaultMaxDepth is the default limit of nesting of expressions.
+const DefaultMaxDepth = 10000
+
+// DefaultInlineBudget is the default number of nodes of inlined functions
+// per expression.
+const DefaultInlineBudget = 1000
+
 type Config struct {
 	Env         any
 	Types       TypesTable
@@ -26,17 +35,96 @@ type Config struct {
 	Functions   FunctionsTable
 	Builtins    FunctionsTable
 	Disabled    map[string]bool // disabled builtins
+	Macros      map[string]*Macro
+
+	// ConstantPool, if set, is shared by programs compiled with the config.
+	ConstantPool *ConstantPool
+
+	// DivisionByZero is the policy of `/` and `%` with a zero divisor.
+	DivisionByZero vm.DivisionByZero
+
+	// PromoteOverflow types integer arithmetic as interface, as it may
+	// return *big.Int on runs with vm.OverflowPromote.
+	PromoteOverflow bool
+
+	// OperatorTypes are additional operand types accepted by operators,
+	// as function types like func(left, right) result.
+	OperatorTypes map[string][]reflect.Type
+
+	// OnWarning is called for conditions which are always true or always
+	// false, like `1 == 1`, and for deprecated names. Warnings do not
+	// prevent compilation.
+	OnWarning func(warning *file.Error)
+
+	// Deprecated maps deprecated names of variables, functions and fields,
+	// like "User.Name", to replacement hints.
+	Deprecated map[string]string
+
+	// ExpectCoerce converts the result to the Expect kind,
+	// instead of failing on a mismatched type.
+	ExpectCoerce bool
+
+	// IdentifierResolver returns the type of a name which is not
+	// defined in the environment, for example a user-defined column.
+	IdentifierResolver func(name string) (reflect.Type, bool)
+
+	// FieldResolver returns the type of the field of the base type.
+	// It is consulted before reflection, so it can type fields of maps.
+	// The name is empty for fields which are not known at compile time,
+	// like `m[key]`, and a nil type reports that there is no such field.
+	FieldResolver func(base reflect.Type, name string) (reflect.Type, bool)
+
+	// StrictNil requires ?., ?? or a nil check before fetching
+	// from pointers and interfaces, which may be nil.
+	StrictNil bool
+
+	// FieldTag is the struct tag which names fields in expressions,
+	// like "json". The "expr" tag is used if empty.
+	FieldTag string
+
+	// FirstMatchThrows makes xs[? predicate] return an error
+	// instead of nil if no element matches the predicate.
+	FirstMatchThrows bool
+
+	// MaxDepth limits nesting of the expression, and MaxNodes limits the
+	// number of nodes, which protects from adversarial expressions.
+	// Zero means no limit.
+	MaxDepth int
+	MaxNodes int
+
+	// InlineBudget is the maximum number of nodes inlined into one
+	// expression by each inlined function. Zero means no limit.
+	InlineBudget int
+
+	// Allowed and Denied are access rules for names of the environment,
+	// functions and builtins, and for members of named types, named by
+	// runtime.MemberName, like "github.com/acme/app.User.Password".
+	// If Allowed is not empty, only allowed names of the environment
+	// are accessible.
+	Allowed map[string]bool
+	Denied  map[string]bool
+}
+
+// Macro is an expression-level function. Its calls are replaced with the
+// body at parse time, so rules can share vocabulary, like isAdult(u), without
+// calling Go functions.
+type Macro struct {
+	Params []string // Names of the parameters used in Body.
+	Body   string   // Expression of the macro, like "u.Age >= 18".
 }
 
 // CreateNew creates new config with default values.
 func CreateNew() *Config {
 	c := &Config{
-		Optimize:  true,
-		Types:     make(TypesTable),
-		ConstFns:  make(map[string]reflect.Value),
-		Functions: make(map[string]*builtin.Function),
-		Builtins:  make(map[string]*builtin.Function),
-		Disabled:  make(map[string]bool),
+		Optimize:     true,
+		Types:        make(TypesTable),
+		ConstFns:     make(map[string]reflect.Value),
+		Functions:    make(map[string]*builtin.Function),
+		Builtins:     make(map[string]*builtin.Function),
+		Disabled:     make(map[string]bool),
+		Macros:       make(map[string]*Macro),
+		MaxDepth:     DefaultMaxDepth,
+		InlineBudget: DefaultInlineBudget,
 	}
 	for _, f := range builtin.Builtins {
 		c.Builtins[f.Name] = f
@@ -63,7 +151,7 @@ func (c *Config) WithEnv(env any) {
 	}
 
 	c.Env = env
-	types := CreateTypesTable(env)
+	types := createTypesTable(env, c.FieldTag)
 	for name, t := range types {
 		c.Types[name] = t
 	}
@@ -72,6 +160,34 @@ func (c *Config) WithEnv(env any) {
 	c.Strict = true
 }
 
+// WithFieldTag changes the struct tag which names fields in expressions.
+// Names of the environment fields are updated, if the environment is set.
+func (c *Config) WithFieldTag(tag string) {
+	for name := range createTypesTable(c.Env, c.FieldTag) {
+		delete(c.Types, name)
+	}
+	c.FieldTag = tag
+	for name, t := range createTypesTable(c.Env, c.FieldTag) {
+		c.Types[name] = t
+	}
+}
+
+// Access returns the options of fetching fields at runtime, or nil if they
+// are the defaults.
+func (c *Config) Access() *runtime.Access {
+	if c == nil {
+		return nil
+	}
+	access := &runtime.Access{Tag: c.FieldTag, Allowed: c.Allowed, Denied: c.Denied}
+	if access.Tag == "expr" {
+		access.Tag = ""
+	}
+	if access.Tag == "" && !access.Restricted() {
+		return nil
+	}
+	return access
+}
+
 func (c *Config) ConstExpr(name string) {
 	if c.Env == nil {
 		panic("no environment is specified for ConstExpr()")
@@ -83,6 +199,17 @@ func (c *Config) ConstExpr(name string) {
 	c.ConstFns[name] = fn
 }
 
+// Pure marks the function as pure, so its calls with constant arguments are
+// evaluated at compile time. The function is either registered with
+// expr.Function() or is a function of the environment, like in ConstExpr().
+func (c *Config) Pure(name string) {
+	if fn, ok := c.Functions[name]; ok {
+		fn.Pure = true
+		return
+	}
+	c.ConstExpr(name)
+}
+
 type Checker interface {
 	Check()
 }
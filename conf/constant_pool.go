@@ -0,0 +1,157 @@
+package conf
+
+import (
+	"hash/maphash"
+	"math"
+	"reflect"
+	"regexp"
+	"sync"
+)
+
+// ConstantPool holds constants shared by programs compiled with it: strings,
+// compiled regexps and sets created for the "in" operator. Programs compiled
+// from similar expressions refer to the same values instead of own copies,
+// which cuts memory when many programs are kept. ConstantPool is safe for
+// concurrent use, and constants are never removed from it.
+type ConstantPool struct {
+	mu      sync.Mutex
+	seed    maphash.Seed
+	strings map[string]string
+	sets    map[setKey][]reflect.Value
+	regexps map[string]*regexp.Regexp
+}
+
+// setKey is the type of elements of sets and the hash of their elements.
+// Sets with the same key are compared element by element.
+type setKey struct {
+	elem reflect.Type
+	hash uint64
+}
+
+// NewConstantPool creates an empty constant pool.
+func NewConstantPool() *ConstantPool {
+	return &ConstantPool{
+		seed:    maphash.MakeSeed(),
+		strings: make(map[string]string),
+		sets:    make(map[setKey][]reflect.Value),
+		regexps: make(map[string]*regexp.Regexp),
+	}
+}
+
+// Share returns the pooled value equal to the constant, adding the constant
+// to the pool if there is none. Constants which are not pooled are returned
+// as is.
+func (p *ConstantPool) Share(constant any) any {
+	if s, ok := constant.(string); ok {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if pooled, ok := p.strings[s]; ok {
+			return pooled
+		}
+		p.strings[s] = s
+		return s
+	}
+
+	set := reflect.ValueOf(constant)
+	if !isSet(set) {
+		return constant
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := setKey{elem: set.Type().Key(), hash: p.hash(set)}
+	for _, pooled := range p.sets[key] {
+		if sameSet(pooled, set) {
+			return pooled.Interface()
+		}
+	}
+	p.sets[key] = append(p.sets[key], set)
+	return constant
+}
+
+// Regexp returns the compiled pattern, compiling it only once per pool.
+func (p *ConstantPool) Regexp(pattern string) (*regexp.Regexp, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if re, ok := p.regexps[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	p.regexps[pattern] = re
+	return re, nil
+}
+
+// Len returns the number of constants in the pool.
+func (p *ConstantPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := len(p.strings) + len(p.regexps)
+	for _, sets := range p.sets {
+		n += len(sets)
+	}
+	return n
+}
+
+// isSet reports whether the value is a set: a map of a predeclared type to
+// struct{}.
+func isSet(v reflect.Value) bool {
+	if v.Kind() != reflect.Map || v.Type().Elem() != reflect.TypeOf(struct{}{}) {
+		return false
+	}
+	k := v.Type().Key()
+	return k.Name() == k.Kind().String()
+}
+
+// hash returns the hash of the elements of the set, which does not depend
+// on the order of iteration.
+func (p *ConstantPool) hash(set reflect.Value) uint64 {
+	var sum uint64
+	var h maphash.Hash
+	h.SetSeed(p.seed)
+	var buf [8]byte
+	iter := set.MapRange()
+	for iter.Next() {
+		h.Reset()
+		key := iter.Key()
+		switch {
+		case key.Kind() == reflect.String:
+			_, _ = h.WriteString(key.String())
+		case key.Kind() == reflect.Bool:
+			if key.Bool() {
+				_ = h.WriteByte(1)
+			}
+		default:
+			var bits uint64
+			switch {
+			case key.CanInt():
+				bits = uint64(key.Int())
+			case key.CanUint():
+				bits = key.Uint()
+			case key.CanFloat():
+				bits = math.Float64bits(key.Float())
+			}
+			for i := range buf {
+				buf[i] = byte(bits >> (8 * i))
+			}
+			_, _ = h.Write(buf[:])
+		}
+		sum += h.Sum64()
+	}
+	return sum
+}
+
+// sameSet reports whether the sets of the same type have the same elements.
+func sameSet(a, b reflect.Value) bool {
+	if a.Type() != b.Type() || a.Len() != b.Len() {
+		return false
+	}
+	iter := b.MapRange()
+	for iter.Next() {
+		if !a.MapIndex(iter.Key()).IsValid() {
+			return false
+		}
+	}
+	return true
+}
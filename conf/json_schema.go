@@ -0,0 +1,238 @@
+package conf
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// jsonSchema is a subset of JSON Schema used to describe the environment.
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref"`
+	Type                 any                    `json:"type"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties any                    `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	Definitions          map[string]*jsonSchema `json:"definitions"`
+	Defs                 map[string]*jsonSchema `json:"$defs"`
+}
+
+// NewFromJSONSchema creates new config with environment described by
+// the JSON Schema document.
+func NewFromJSONSchema(schema []byte) (*Config, error) {
+	c := CreateNew()
+	if err := c.WithJSONSchema(schema); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// WithJSONSchema sets the environment described by the JSON Schema document.
+// The environment is a JSON payload decoded with encoding/json into
+// map[string]any, so properties of the root object are variables.
+//
+// Objects are maps. Properties of objects with properties are typed, so
+// they are checked at compile time, while values of objects without
+// properties are typed by additionalProperties. Arrays are slices, numbers,
+// including integers, are float64, and nullable properties, like
+// ["string", "null"], are any.
+func (c *Config) WithJSONSchema(schema []byte) error {
+	var root jsonSchema
+	if err := json.Unmarshal(schema, &root); err != nil {
+		return fmt.Errorf("invalid JSON Schema: %w", err)
+	}
+	b := &schemaBuilder{
+		root:    &root,
+		objects: make(map[reflect.Type]map[string]reflect.Type),
+		visited: make(map[*jsonSchema]bool),
+	}
+	r, err := b.resolve(&root)
+	if err != nil {
+		return err
+	}
+	if len(r.Properties) == 0 {
+		return fmt.Errorf("JSON Schema must describe an object with properties")
+	}
+
+	c.WithEnv(map[string]any{})
+	for _, name := range sortedKeys(r.Properties) {
+		t, err := b.build(r.Properties[name])
+		if err != nil {
+			return fmt.Errorf("%v: %w", name, err)
+		}
+		c.Types[name] = Tag{Type: t}
+	}
+
+	next := c.FieldResolver
+	c.FieldResolver = func(base reflect.Type, name string) (reflect.Type, bool) {
+		if fields, ok := b.objects[base]; ok {
+			if name == "" {
+				return interfaceType, true
+			}
+			// Unknown properties are reported with nil type.
+			return fields[name], true
+		}
+		if next != nil {
+			return next(base, name)
+		}
+		return nil, false
+	}
+	return nil
+}
+
+type schemaBuilder struct {
+	root *jsonSchema
+	// objects are types of properties of map types created for objects.
+	objects map[reflect.Type]map[string]reflect.Type
+	visited map[*jsonSchema]bool
+}
+
+func (b *schemaBuilder) resolve(s *jsonSchema) (*jsonSchema, error) {
+	for s.Ref != "" {
+		var defs map[string]*jsonSchema
+		var name string
+		switch {
+		case strings.HasPrefix(s.Ref, "#/definitions/"):
+			defs, name = b.root.Definitions, strings.TrimPrefix(s.Ref, "#/definitions/")
+		case strings.HasPrefix(s.Ref, "#/$defs/"):
+			defs, name = b.root.Defs, strings.TrimPrefix(s.Ref, "#/$defs/")
+		default:
+			return nil, fmt.Errorf("unsupported $ref %q", s.Ref)
+		}
+		def, ok := defs[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown $ref %q", s.Ref)
+		}
+		s = def
+	}
+	return s, nil
+}
+
+func (b *schemaBuilder) build(s *jsonSchema) (reflect.Type, error) {
+	s, err := b.resolve(s)
+	if err != nil {
+		return nil, err
+	}
+	if b.visited[s] {
+		// Recursive schemas are not typed deeper.
+		return interfaceType, nil
+	}
+	b.visited[s] = true
+	defer delete(b.visited, s)
+
+	if nullable(s) {
+		// Values of nullable properties may be nil, so they are not typed.
+		return interfaceType, nil
+	}
+
+	switch schemaType(s) {
+	case "string":
+		return reflect.TypeOf(""), nil
+	case "number", "integer":
+		return reflect.TypeOf(float64(0)), nil
+	case "boolean":
+		return reflect.TypeOf(false), nil
+	case "array":
+		elem := interfaceType
+		if s.Items != nil {
+			if elem, err = b.build(s.Items); err != nil {
+				return nil, err
+			}
+		}
+		return reflect.SliceOf(elem), nil
+	case "object":
+		if len(s.Properties) == 0 {
+			elem := interfaceType
+			if additional, ok := s.AdditionalProperties.(map[string]any); ok {
+				data, _ := json.Marshal(additional)
+				var items jsonSchema
+				if err := json.Unmarshal(data, &items); err != nil {
+					return nil, err
+				}
+				if elem, err = b.build(&items); err != nil {
+					return nil, err
+				}
+			}
+			return reflect.MapOf(reflect.TypeOf(""), elem), nil
+		}
+		return b.object(s)
+	}
+	return interfaceType, nil
+}
+
+// object creates a map type for the object with properties. Values of
+// objects are maps decoded from JSON, so the map type is not typed by its
+// elements, which are any, but by the struct type of properties, to tell
+// objects apart in the field resolver.
+func (b *schemaBuilder) object(s *jsonSchema) (reflect.Type, error) {
+	names := sortedKeys(s.Properties)
+	fields := make([]reflect.StructField, len(names))
+	types := make(map[string]reflect.Type, len(names))
+	for i, name := range names {
+		t, err := b.build(s.Properties[name])
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", name, err)
+		}
+		types[name] = t
+		fields[i] = reflect.StructField{
+			Name: fmt.Sprintf("F%d", i),
+			Type: t,
+			Tag:  reflect.StructTag(fmt.Sprintf(`json:%q`, name)),
+		}
+	}
+	t := reflect.MapOf(reflect.TypeOf(""), reflect.StructOf(fields))
+	b.objects[t] = types
+	return t, nil
+}
+
+// nullable reports whether the schema allows null, like ["string", "null"].
+func nullable(s *jsonSchema) bool {
+	if types, ok := s.Type.([]any); ok {
+		for _, each := range types {
+			if each == "null" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// schemaType returns the type of the schema, ignoring "null" of nullable
+// types, like ["string", "null"].
+func schemaType(s *jsonSchema) string {
+	switch t := s.Type.(type) {
+	case string:
+		return t
+	case []any:
+		var out string
+		for _, each := range t {
+			if name, ok := each.(string); ok && name != "null" {
+				if out != "" {
+					return ""
+				}
+				out = name
+			}
+		}
+		return out
+	}
+	if len(s.Properties) > 0 {
+		return "object"
+	}
+	if s.Items != nil {
+		return "array"
+	}
+	return ""
+}
+
+func sortedKeys(m map[string]*jsonSchema) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var interfaceType = reflect.TypeOf((*any)(nil)).Elem()
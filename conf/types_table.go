@@ -4,6 +4,7 @@ import (
 	"reflect"
 
 	"github.com/expr-lang/expr/internal/deref"
+	"github.com/expr-lang/expr/vm/runtime"
 )
 
 type TypesTable map[string]Tag
@@ -22,10 +23,28 @@ type Tag struct {
 //
 // If map is passed, all items will be treated as variables
 // (key as name, value as type).
+//
+// If pointer to interface is passed, like (*Env)(nil), methods of
+// the interface will be treated as functions, so any implementation
+// of the interface, like a mock, can be used as env at runtime.
 func CreateTypesTable(i any) TypesTable {
+	return createTypesTable(i, "")
+}
+
+func createTypesTable(i any, tag string) TypesTable {
 	if i == nil {
 		return nil
 	}
+	if layers, ok := i.(runtime.Layers); ok {
+		// Names of later layers shadow names of earlier ones.
+		types := make(TypesTable)
+		for _, layer := range layers {
+			for name, t := range createTypesTable(layer, tag) {
+				types[name] = t
+			}
+		}
+		return types
+	}
 
 	types := make(TypesTable)
 	v := reflect.ValueOf(i)
@@ -38,7 +57,7 @@ func CreateTypesTable(i any) TypesTable {
 
 	switch d.Kind() {
 	case reflect.Struct:
-		types = FieldsFromStruct(d)
+		types = fieldsFromStruct(d, tag)
 
 		// Methods of struct should be gathered from original struct with pointer,
 		// as methods maybe declared on pointer receiver. Also this method retrieves
@@ -52,6 +71,19 @@ func CreateTypesTable(i any) TypesTable {
 			}
 		}
 
+	case reflect.Interface:
+		// Methods of interface have no receiver, and their indexes differ
+		// between implementations, so methods are typed with the interface
+		// as receiver and are fetched by name at runtime.
+		for i := 0; i < d.NumMethod(); i++ {
+			m := d.Method(i)
+			types[m.Name] = Tag{
+				Type:        methodType(d, m.Type),
+				Method:      true,
+				MethodIndex: -1,
+			}
+		}
+
 	case reflect.Map:
 		for _, key := range v.MapKeys() {
 			value := v.MapIndex(key)
@@ -77,7 +109,25 @@ func CreateTypesTable(i any) TypesTable {
 	return types
 }
 
+// methodType returns type of the method with the receiver as first argument.
+func methodType(receiver, fn reflect.Type) reflect.Type {
+	in := make([]reflect.Type, 0, fn.NumIn()+1)
+	in = append(in, receiver)
+	for i := 0; i < fn.NumIn(); i++ {
+		in = append(in, fn.In(i))
+	}
+	out := make([]reflect.Type, fn.NumOut())
+	for i := range out {
+		out[i] = fn.Out(i)
+	}
+	return reflect.FuncOf(in, out, fn.IsVariadic())
+}
+
 func FieldsFromStruct(t reflect.Type) TypesTable {
+	return fieldsFromStruct(t, "")
+}
+
+func fieldsFromStruct(t reflect.Type, tag string) TypesTable {
 	types := make(TypesTable)
 	t = deref.Type(t)
 	if t == nil {
@@ -90,7 +140,9 @@ func FieldsFromStruct(t reflect.Type) TypesTable {
 			f := t.Field(i)
 
 			if f.Anonymous {
-				for name, typ := range FieldsFromStruct(f.Type) {
+				// Exported fields of embedded structs are promoted,
+				// even if the embedded struct type itself is unexported.
+				for name, typ := range fieldsFromStruct(f.Type, tag) {
 					if _, ok := types[name]; ok {
 						types[name] = Tag{Ambiguous: true}
 					} else {
@@ -99,10 +151,14 @@ func FieldsFromStruct(t reflect.Type) TypesTable {
 					}
 				}
 			}
-			if fn := FieldName(f); fn == "$env" { // Could check for all keywords here
+			if !f.IsExported() {
+				// Unexported fields can not be read via reflection.
+				continue
+			}
+			if fn := TaggedFieldName(f, tag); fn == "$env" { // Could check for all keywords here
 				panic("attempt to misuse env keyword as env struct field tag")
-			} else {
-				types[FieldName(f)] = Tag{
+			} else if fn != "" {
+				types[fn] = Tag{
 					Type:       f.Type,
 					FieldIndex: f.Index,
 				}
@@ -114,8 +170,11 @@ func FieldsFromStruct(t reflect.Type) TypesTable {
 }
 
 func FieldName(field reflect.StructField) string {
-	if taggedName := field.Tag.Get("expr"); taggedName != "" {
-		return taggedName
-	}
-	return field.Name
+	return TaggedFieldName(field, "")
+}
+
+// TaggedFieldName returns the name of the field in expressions, taken from
+// the struct tag, like runtime.TaggedFieldName.
+func TaggedFieldName(field reflect.StructField, tag string) string {
+	return runtime.TaggedFieldName(field, tag)
 }
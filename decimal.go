@@ -0,0 +1,241 @@
+package expr
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/expr-lang/expr/builtin"
+	"github.com/expr-lang/expr/conf"
+	"github.com/expr-lang/expr/patcher"
+	"github.com/expr-lang/expr/vm/runtime"
+)
+
+// DecimalArithmetic is arithmetic of a decimal type of the application,
+// like shopspring/decimal.Decimal, used by programs compiled with Decimal.
+type DecimalArithmetic[D any] interface {
+	Parse(literal string) (D, error) // Parse parses a literal, like "0.1".
+	FromInt(i int) D
+	Add(a, b D) D
+	Sub(a, b D) D
+	Mul(a, b D) D
+	Div(a, b D) (D, error)
+	Mod(a, b D) (D, error)
+	Pow(a, b D) (D, error)
+	Neg(a D) D
+	Round(a D) D    // Round rounds half away from zero to an integer, like round().
+	Cmp(a, b D) int // Cmp returns -1, 0 or +1, like strings.Compare.
+}
+
+// Decimal compiles the program in decimal mode, so money expressions do not
+// suffer rounding of binary floats: float literals are decimals, and
+// arithmetic and comparisons of decimals, like `price * 1.1 > 100`, use the
+// arithmetic. Ints and floats mixed with decimals are converted to decimals,
+// and division of ints is decimal too. Builtins abs, round, max, min, sum and
+// sortBy work with decimals.
+//
+// Operands of type any, like values of map envs, are converted at runtime if
+// one of them is a decimal. Float literals passed to float parameters of
+// functions stay floats.
+//
+//	program, err := expr.Compile(`0.1 + 0.2 == 0.3`, expr.Decimal[decimal.Decimal](arithmetic))
+func Decimal[D any](arithmetic DecimalArithmetic[D]) Option {
+	return func(c *conf.Config) {
+		d := &decimals[D]{arithmetic: arithmetic, t: reflect.TypeOf((*D)(nil)).Elem()}
+
+		functions := make(map[string]string)
+		define := func(key string, in []reflect.Type, variadic bool, out reflect.Type, fn func(args ...any) (any, error)) {
+			name := "$decimal" + key
+			functions[key] = name
+			c.Functions[name] = &builtin.Function{
+				Name:  name,
+				Func:  fn,
+				Types: []reflect.Type{reflect.FuncOf(in, []reflect.Type{out}, variadic)},
+				Pure:  true,
+			}
+		}
+		binary := func(operator string, out reflect.Type, fn func(a, b D) (any, error), generic func(a, b any) any) {
+			define(operator, []reflect.Type{anyType, anyType}, false, out, func(args ...any) (any, error) {
+				_, l := d.value(args[0])
+				_, r := d.value(args[1])
+				if !l && !r && (operator != "/" || !runtime.IsInteger(args[0]) || !runtime.IsInteger(args[1])) {
+					return generic(args[0], args[1]), nil
+				}
+				a, err := d.convert(args[0])
+				if err == nil {
+					var b D
+					if b, err = d.convert(args[1]); err == nil {
+						return fn(a, b)
+					}
+				}
+				if operator == "==" || operator == "!=" {
+					return generic(args[0], args[1]), nil
+				}
+				return nil, err
+			})
+		}
+		binary("+", anyType, func(a, b D) (any, error) { return arithmetic.Add(a, b), nil }, runtime.Add)
+		binary("-", anyType, func(a, b D) (any, error) { return arithmetic.Sub(a, b), nil }, runtime.Subtract)
+		binary("*", anyType, func(a, b D) (any, error) { return arithmetic.Mul(a, b), nil }, runtime.Multiply)
+		binary("/", anyType, func(a, b D) (any, error) { return arithmetic.Div(a, b) }, func(a, b any) any { return runtime.Divide(a, b) })
+		binary("%", anyType, func(a, b D) (any, error) { return arithmetic.Mod(a, b) }, runtime.Remainder)
+		binary("**", anyType, func(a, b D) (any, error) { return arithmetic.Pow(a, b) }, func(a, b any) any { return runtime.Exponent(a, b) })
+		functions["^"] = functions["**"]
+		binary("==", boolType, func(a, b D) (any, error) { return arithmetic.Cmp(a, b) == 0, nil }, func(a, b any) any { return runtime.Equal(a, b) })
+		binary("!=", boolType, func(a, b D) (any, error) { return arithmetic.Cmp(a, b) != 0, nil }, func(a, b any) any { return !runtime.Equal(a, b) })
+		binary("<", boolType, func(a, b D) (any, error) { return arithmetic.Cmp(a, b) < 0, nil }, func(a, b any) any { return runtime.Less(a, b) })
+		binary(">", boolType, func(a, b D) (any, error) { return arithmetic.Cmp(a, b) > 0, nil }, func(a, b any) any { return runtime.More(a, b) })
+		binary("<=", boolType, func(a, b D) (any, error) { return arithmetic.Cmp(a, b) <= 0, nil }, func(a, b any) any { return runtime.LessOrEqual(a, b) })
+		binary(">=", boolType, func(a, b D) (any, error) { return arithmetic.Cmp(a, b) >= 0, nil }, func(a, b any) any { return runtime.MoreOrEqual(a, b) })
+
+		unary := []reflect.Type{anyType}
+		define("neg", unary, false, anyType, func(args ...any) (any, error) {
+			if x, ok := d.value(args[0]); ok {
+				return arithmetic.Neg(x), nil
+			}
+			return runtime.Negate(args[0]), nil
+		})
+		define("abs", unary, false, d.t, func(args ...any) (any, error) {
+			x, err := d.convert(args[0])
+			if err != nil {
+				return nil, err
+			}
+			if arithmetic.Cmp(x, arithmetic.FromInt(0)) < 0 {
+				return arithmetic.Neg(x), nil
+			}
+			return x, nil
+		})
+		define("round", unary, false, d.t, func(args ...any) (any, error) {
+			x, err := d.convert(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return arithmetic.Round(x), nil
+		})
+		variadic := []reflect.Type{reflect.TypeOf([]any{})}
+		define("max", variadic, true, d.t, func(args ...any) (any, error) {
+			return d.extremum(args, 1)
+		})
+		define("min", variadic, true, d.t, func(args ...any) (any, error) {
+			return d.extremum(args, -1)
+		})
+		define("sum", unary, false, d.t, func(args ...any) (any, error) {
+			sum := arithmetic.FromInt(0)
+			v := reflect.ValueOf(args[0])
+			for i := 0; i < v.Len(); i++ {
+				x, err := d.convert(v.Index(i).Interface())
+				if err != nil {
+					return nil, err
+				}
+				sum = arithmetic.Add(sum, x)
+			}
+			return sum, nil
+		})
+		define("key", unary, false, anyType, func(args ...any) (any, error) {
+			x, err := d.convert(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return decimalKey[D]{value: x, cmp: arithmetic.Cmp}, nil
+		})
+
+		c.Visitors = append(c.Visitors, &patcher.Decimal{
+			Type: d.t,
+			Parse: func(literal string) (any, error) {
+				return arithmetic.Parse(literal)
+			},
+			Functions: functions,
+		})
+	}
+}
+
+type decimals[D any] struct {
+	arithmetic DecimalArithmetic[D]
+	t          reflect.Type
+}
+
+// value returns the decimal, if the value is a decimal or a pointer to one.
+func (d *decimals[D]) value(v any) (D, bool) {
+	for {
+		if x, ok := v.(D); ok {
+			return x, true
+		}
+		rv := reflect.ValueOf(v)
+		if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+			v = rv.Elem().Interface()
+			continue
+		}
+		if rv.IsValid() && reflect.PtrTo(rv.Type()) == d.t {
+			p := reflect.New(rv.Type())
+			p.Elem().Set(rv)
+			return p.Interface().(D), true
+		}
+		var zero D
+		return zero, false
+	}
+}
+
+// convert converts decimals, ints and floats to decimals.
+func (d *decimals[D]) convert(v any) (D, error) {
+	if x, ok := d.value(v); ok {
+		return x, nil
+	}
+	switch x := v.(type) {
+	case float32:
+		return d.arithmetic.Parse(strconv.FormatFloat(float64(x), 'f', -1, 32))
+	case float64:
+		return d.arithmetic.Parse(strconv.FormatFloat(x, 'f', -1, 64))
+	}
+	if v != nil && runtime.IsInteger(v) {
+		return d.arithmetic.FromInt(runtime.ToInt(v)), nil
+	}
+	var zero D
+	return zero, fmt.Errorf("cannot convert %T to %v", v, d.t)
+}
+
+// extremum returns the maximum of the arguments and elements of array
+// arguments if sign is 1, or the minimum if sign is -1.
+func (d *decimals[D]) extremum(args []any, sign int) (any, error) {
+	var out any
+	for _, arg := range args {
+		values := []any{arg}
+		if _, ok := d.value(arg); !ok {
+			if v := reflect.ValueOf(arg); v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+				values = make([]any, v.Len())
+				for i := range values {
+					values[i] = v.Index(i).Interface()
+				}
+			}
+		}
+		for _, value := range values {
+			x, err := d.convert(value)
+			if err != nil {
+				return nil, err
+			}
+			if out == nil || d.arithmetic.Cmp(x, out.(D))*sign > 0 {
+				out = x
+			}
+		}
+	}
+	return out, nil
+}
+
+// decimalKey is a decimal key of sortBy, which compares keys with the
+// arithmetic.
+type decimalKey[D any] struct {
+	value D
+	cmp   func(a, b D) int
+}
+
+func (k decimalKey[D]) Compare(other any) int {
+	o, ok := other.(decimalKey[D])
+	if !ok {
+		return 0
+	}
+	return k.cmp(k.value, o.value)
+}
+
+var (
+	anyType  = reflect.TypeOf((*any)(nil)).Elem()
+	boolType = reflect.TypeOf(true)
+)
@@ -220,8 +220,10 @@ appendix:
 			c.Types[name] = a
 		}
 
+		// Unexported fields are already skipped by FieldsFromStruct, but fields
+		// may have a lowercase name set via struct tags.
 		for name, field := range conf.FieldsFromStruct(t) {
-			if isPrivate(name) || isProtobuf(name) || field.Ambiguous {
+			if isProtobuf(name) || field.Ambiguous {
 				continue
 			}
 			a.Fields[Identifier(name)] = c.use(field.Type)
@@ -0,0 +1,133 @@
+package expr
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+
+	"github.com/expr-lang/expr/vm"
+)
+
+// Evaluator compiles, caches and runs expressions with a shared configuration.
+// It is safe for concurrent use.
+type Evaluator interface {
+	// Compile returns the compiled program, reusing a cached one if possible.
+	Compile(input string) (*vm.Program, error)
+	// Eval compiles and runs the expression against the env.
+	Eval(input string, env any) (any, error)
+	// Check reports whether the expression compiles.
+	Check(input string) error
+	// Metrics returns counters collected since the evaluator was created.
+	Metrics() Metrics
+}
+
+// EvaluatorConfig configures an Evaluator.
+type EvaluatorConfig struct {
+	Options    []Option    // Compile options, like Env() or Function().
+	RunOptions []vm.Option // Run options, like vm.MaxStackSize().
+	CacheSize  int         // Number of programs to keep in cache; zero disables caching.
+}
+
+// Metrics of an Evaluator.
+type Metrics struct {
+	Compiles      uint64 // Number of compiled programs.
+	CompileErrors uint64 // Number of failed compilations.
+	CacheHits     uint64 // Number of programs found in cache.
+	Runs          uint64 // Number of runs of programs.
+	RunErrors     uint64 // Number of runs which returned an error.
+}
+
+// NewEvaluator creates an Evaluator with the config.
+func NewEvaluator(config EvaluatorConfig) Evaluator {
+	return &evaluator{
+		config: config,
+		index:  make(map[string]*list.Element),
+		lru:    list.New(),
+	}
+}
+
+type evaluator struct {
+	metrics Metrics // First field to keep 64-bit alignment for atomic operations.
+	config  EvaluatorConfig
+	mu      sync.Mutex
+	index   map[string]*list.Element
+	lru     *list.List
+}
+
+type cacheEntry struct {
+	input   string
+	program *vm.Program
+}
+
+func (e *evaluator) Compile(input string) (*vm.Program, error) {
+	if program, ok := e.load(input); ok {
+		atomic.AddUint64(&e.metrics.CacheHits, 1)
+		return program, nil
+	}
+
+	program, err := Compile(input, e.config.Options...)
+	if err != nil {
+		atomic.AddUint64(&e.metrics.CompileErrors, 1)
+		return nil, err
+	}
+	atomic.AddUint64(&e.metrics.Compiles, 1)
+	e.store(input, program)
+	return program, nil
+}
+
+func (e *evaluator) Eval(input string, env any) (any, error) {
+	program, err := e.Compile(input)
+	if err != nil {
+		return nil, err
+	}
+
+	atomic.AddUint64(&e.metrics.Runs, 1)
+	output, err := Run(program, env, e.config.RunOptions...)
+	if err != nil {
+		atomic.AddUint64(&e.metrics.RunErrors, 1)
+		return nil, err
+	}
+	return output, nil
+}
+
+func (e *evaluator) Check(input string) error {
+	_, err := e.Compile(input)
+	return err
+}
+
+func (e *evaluator) Metrics() Metrics {
+	return Metrics{
+		Compiles:      atomic.LoadUint64(&e.metrics.Compiles),
+		CompileErrors: atomic.LoadUint64(&e.metrics.CompileErrors),
+		CacheHits:     atomic.LoadUint64(&e.metrics.CacheHits),
+		Runs:          atomic.LoadUint64(&e.metrics.Runs),
+		RunErrors:     atomic.LoadUint64(&e.metrics.RunErrors),
+	}
+}
+
+func (e *evaluator) load(input string) (*vm.Program, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if el, ok := e.index[input]; ok {
+		e.lru.MoveToFront(el)
+		return el.Value.(*cacheEntry).program, true
+	}
+	return nil, false
+}
+
+func (e *evaluator) store(input string, program *vm.Program) {
+	if e.config.CacheSize <= 0 {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.index[input]; ok {
+		return
+	}
+	e.index[input] = e.lru.PushFront(&cacheEntry{input: input, program: program})
+	if e.lru.Len() > e.config.CacheSize {
+		oldest := e.lru.Back()
+		e.lru.Remove(oldest)
+		delete(e.index, oldest.Value.(*cacheEntry).input)
+	}
+}
@@ -0,0 +1,61 @@
+package expr_test
+
+import (
+	"testing"
+
+	"github.com/expr-lang/expr/internal/testify/assert"
+	"github.com/expr-lang/expr/internal/testify/require"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+func TestEvaluator(t *testing.T) {
+	env := map[string]any{"a": 1, "b": 2}
+
+	e := expr.NewEvaluator(expr.EvaluatorConfig{
+		Options:    []expr.Option{expr.Env(env)},
+		RunOptions: []vm.Option{vm.MaxStackSize(10)},
+		CacheSize:  2,
+	})
+
+	out, err := e.Eval(`a + b`, env)
+	require.NoError(t, err)
+	assert.Equal(t, 3, out)
+
+	out, err = e.Eval(`a + b`, env)
+	require.NoError(t, err)
+	assert.Equal(t, 3, out)
+
+	require.NoError(t, e.Check(`a * b`))
+	require.Error(t, e.Check(`a + c`))
+
+	_, err = e.Eval(`map(1..100, #)`, env)
+	require.Error(t, err)
+
+	assert.Equal(t, expr.Metrics{
+		Compiles:      3,
+		CompileErrors: 1,
+		CacheHits:     1,
+		Runs:          3,
+		RunErrors:     1,
+	}, e.Metrics())
+}
+
+func TestEvaluator_cache_eviction(t *testing.T) {
+	e := expr.NewEvaluator(expr.EvaluatorConfig{CacheSize: 1})
+
+	p1, err := e.Compile(`1 + 1`)
+	require.NoError(t, err)
+	p2, err := e.Compile(`1 + 1`)
+	require.NoError(t, err)
+	assert.Same(t, p1, p2)
+
+	_, err = e.Compile(`2 + 2`)
+	require.NoError(t, err)
+
+	p3, err := e.Compile(`1 + 1`)
+	require.NoError(t, err)
+	assert.NotSame(t, p1, p3)
+	assert.Equal(t, uint64(3), e.Metrics().Compiles)
+}
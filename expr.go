@@ -3,7 +3,11 @@ package expr
 import (
 	"errors"
 	"fmt"
+	"math/rand"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/expr-lang/expr/ast"
@@ -12,9 +16,12 @@ import (
 	"github.com/expr-lang/expr/compiler"
 	"github.com/expr-lang/expr/conf"
 	"github.com/expr-lang/expr/file"
+	"github.com/expr-lang/expr/internal/deref"
 	"github.com/expr-lang/expr/optimizer"
+	"github.com/expr-lang/expr/parser"
 	"github.com/expr-lang/expr/patcher"
 	"github.com/expr-lang/expr/vm"
+	"github.com/expr-lang/expr/vm/runtime"
 )
 
 // Option for configuring config.
@@ -31,6 +38,109 @@ func Env(env any) Option {
 	}
 }
 
+// Layers is an env composed of layers, like global constants, tenant config
+// and request data. Later layers shadow earlier ones. Programs are compiled
+// with declarations of the layers and are run with their values:
+//
+//	program, err := expr.Compile(code, expr.Env(expr.Layers{Globals{}, Tenant{}, Request{}}))
+//	output, err := expr.Run(program, expr.Layers{globals, tenant, request})
+type Layers = runtime.Layers
+
+// JSONSchema sets the environment described by the JSON Schema document, for
+// JSON payloads decoded into map[string]any. Fields of nested objects are
+// checked at compile time. It panics if the schema is not supported.
+func JSONSchema(schema []byte) Option {
+	return func(c *conf.Config) {
+		if err := c.WithJSONSchema(schema); err != nil {
+			panic(fmt.Sprintf("expr: %v", err))
+		}
+	}
+}
+
+// MaxDepth sets the limit of nesting of expressions, which protects from
+// stack overflow on adversarial expressions. Zero disables the limit.
+// The default is conf.DefaultMaxDepth.
+func MaxDepth(depth int) Option {
+	return func(c *conf.Config) {
+		c.MaxDepth = depth
+	}
+}
+
+// MaxNodes sets the limit of the number of nodes in expressions.
+// Zero, the default, disables the limit.
+func MaxNodes(nodes int) Option {
+	return func(c *conf.Config) {
+		c.MaxNodes = nodes
+	}
+}
+
+// Allow restricts the environment to the names, like "User", and to the
+// members of named types, named by Member. Other names of the environment,
+// and other members of types with allowed members, are rejected with an
+// "access denied" error. Functions and builtins are not restricted by Allow.
+func Allow(names ...string) Option {
+	return func(c *conf.Config) {
+		if c.Allowed == nil {
+			c.Allowed = make(map[string]bool)
+		}
+		for _, name := range names {
+			c.Allowed[name] = true
+		}
+	}
+}
+
+// Deny rejects the names of the environment, functions or builtins, like
+// "Password" or "toJSON", and the members of named types, named by Member,
+// with an "access denied" error. Members are denied by the checker, and at
+// runtime by dynamic keys, builtins like get() and pluck(), and serializers
+// like toJSON().
+func Deny(names ...string) Option {
+	return func(c *conf.Config) {
+		if c.Denied == nil {
+			c.Denied = make(map[string]bool)
+		}
+		for _, name := range names {
+			c.Denied[name] = true
+		}
+	}
+}
+
+// Member returns the name of the field or the method of the type of the
+// value for Allow and Deny, like Member(User{}, "Password").
+func Member(value any, name string) string {
+	return runtime.MemberName(deref.Type(reflect.TypeOf(value)), name)
+}
+
+// FieldTag sets the struct tag which names fields in expressions, like "json",
+// so expressions written against JSON payloads can be checked against Go
+// structs. The "expr" tag is used by default.
+func FieldTag(tag string) Option {
+	return func(c *conf.Config) {
+		c.WithFieldTag(tag)
+	}
+}
+
+// IdentifierResolver sets a function which returns types of names not defined
+// in the env. It lets applications with dynamic schemas, like user-defined
+// columns, check expressions without building the env upfront. Values are
+// still fetched from the env passed to Run.
+func IdentifierResolver(fn func(name string) (reflect.Type, bool)) Option {
+	return func(c *conf.Config) {
+		c.IdentifierResolver = fn
+	}
+}
+
+// FieldResolver sets a function which returns types of fields, like
+// `row.price`. It is consulted before reflection, so fields of maps can be
+// typed as well. The name is empty for fields which are not known at compile
+// time, like `row[column]`, and a nil type reports that there is no such
+// field. Fields typed by the resolver are fetched as map keys at runtime.
+func FieldResolver(fn func(base reflect.Type, name string) (reflect.Type, bool)) Option {
+	return func(c *conf.Config) {
+		c.FieldResolver = fn
+	}
+}
+
 // AllowUndefinedVariables allows to use undefined variables inside expressions.
 // This can be used with expr.Env option to partially define a few variables.
 func AllowUndefinedVariables() Option {
@@ -39,6 +149,17 @@ func AllowUndefinedVariables() Option {
 	}
 }
 
+// StrictNil turns fetching fields and methods of values, which may be nil,
+// into compile errors. Pointers and interfaces must be guarded with ?., ??
+// or a nil check, like `user != nil && user.Name == ""`. Values of
+// interfaces without methods, like any, are not checked, so neither are map
+// values and elements of []any.
+func StrictNil() Option {
+	return func(c *conf.Config) {
+		c.StrictNil = true
+	}
+}
+
 // Operator allows to replace a binary operator with a function.
 func Operator(operator string, fn ...string) Option {
 	return func(c *conf.Config) {
@@ -52,6 +173,31 @@ func Operator(operator string, fn ...string) Option {
 	}
 }
 
+// OperatorTypes makes the type checker accept the binary operator for more
+// operand types, given as function types: func(left, right) result.
+//
+//	expr.OperatorTypes("+", new(func([]byte, []byte) []byte))
+//
+// It only affects type checking, the operator still must be supported at
+// runtime, for example with Operator overloading.
+func OperatorTypes(operator string, types ...any) Option {
+	return func(c *conf.Config) {
+		if c.OperatorTypes == nil {
+			c.OperatorTypes = make(map[string][]reflect.Type)
+		}
+		for _, t := range types {
+			t := reflect.TypeOf(t)
+			if t.Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+			if t.Kind() != reflect.Func || t.NumIn() != 2 || t.NumOut() != 1 {
+				panic(fmt.Sprintf("expr: type for operator %s is not a function of two arguments with one result", operator))
+			}
+			c.OperatorTypes[operator] = append(c.OperatorTypes[operator], t)
+		}
+	}
+}
+
 // ConstExpr defines func expression as constant. If all argument to this function is constants,
 // then it can be replaced by result of this func call on compile step.
 func ConstExpr(fn string) Option {
@@ -60,6 +206,57 @@ func ConstExpr(fn string) Option {
 	}
 }
 
+// Pure marks functions as pure: they return the same result for the same
+// arguments and have no side effects. Calls of pure functions with constant
+// arguments are evaluated at compile time. Functions are either registered
+// with Function(), which must precede this option, or are env functions.
+func Pure(names ...string) Option {
+	return func(c *conf.Config) {
+		for _, name := range names {
+			c.Pure(name)
+		}
+	}
+}
+
+// ConstantPool shares constants of the program, like strings, regexps and
+// sets, with other programs compiled with the same pool.
+//
+//	pool := conf.NewConstantPool()
+//	program, err := expr.Compile(rule, expr.ConstantPool(pool))
+func ConstantPool(pool *conf.ConstantPool) Option {
+	return func(c *conf.Config) {
+		c.ConstantPool = pool
+	}
+}
+
+// DivisionByZero sets the policy of `/` and `%` with a zero divisor for the
+// program: an error, nil or ±Inf and 0, instead of the default, which is
+// ±Inf for `/` and an error for `%`.
+func DivisionByZero(policy vm.DivisionByZero) Option {
+	return func(c *conf.Config) {
+		c.DivisionByZero = policy
+	}
+}
+
+// PromoteOverflow compiles the program for runs with
+// vm.StrictArithmetic(vm.OverflowPromote): results of integer `+`, `-`, `*`
+// and negation are typed as interface, as they may be *big.Int, so they can
+// be used anywhere an int can, like in arrays or arguments of functions.
+func PromoteOverflow() Option {
+	return func(c *conf.Config) {
+		c.PromoteOverflow = true
+	}
+}
+
+// Profile compiles the program with profiling of each AST node: every run
+// adds the time and the number of evaluations of nodes to the profile
+// returned by vm.GetSpan. Profiled programs should not be run concurrently.
+func Profile() Option {
+	return func(c *conf.Config) {
+		c.Profile = true
+	}
+}
+
 // AsAny tells the compiler to expect any result.
 func AsAny() Option {
 	return func(c *conf.Config) {
@@ -107,6 +304,37 @@ func AsFloat64() Option {
 	}
 }
 
+// ExpectCoerce converts the result to the kind expected by AsBool, AsInt,
+// AsInt64, AsFloat64 or AsKind(reflect.String) instead of failing: bool()
+// truthiness for booleans, string() formatting for strings and int() or
+// float() for numbers. It lets loosely written rules compile.
+func ExpectCoerce() Option {
+	return func(c *conf.Config) {
+		c.ExpectCoerce = true
+	}
+}
+
+// OnWarning sets a function which is called for every condition which is
+// always true or always false, like `1 == 1`, `x > 10 && x < 5` or `x != nil`
+// on a type which can not be nil. Warnings do not fail the compilation.
+func OnWarning(fn func(warning *file.Error)) Option {
+	return func(c *conf.Config) {
+		c.OnWarning = fn
+	}
+}
+
+// Deprecated marks the variable, the function or the field (like "User.Name",
+// where User is the name of the struct type) as deprecated. References to it
+// are reported to OnWarning with the hint, like "use User.FullName instead".
+func Deprecated(name, hint string) Option {
+	return func(c *conf.Config) {
+		if c.Deprecated == nil {
+			c.Deprecated = make(map[string]string)
+		}
+		c.Deprecated[name] = hint
+	}
+}
+
 // WarnOnAny tells the compiler to warn if expression return any type.
 func WarnOnAny() Option {
 	return func(c *conf.Config) {
@@ -125,6 +353,8 @@ func Optimize(b bool) Option {
 }
 
 // Patch adds visitor to list of visitors what will be applied before compiling AST to bytecode.
+// Visitors run between parsing and type checking, so they may rewrite names which
+// do not exist in the environment, like user.isVIP into isVIP(user).
 func Patch(visitor ast.Visitor) Option {
 	return func(c *conf.Config) {
 		c.Visitors = append(c.Visitors, visitor)
@@ -153,6 +383,87 @@ func Function(name string, fn func(params ...any) (any, error), types ...any) Op
 	}
 }
 
+// Overload adds several functions with different signatures under one name.
+// The type checker selects the function matching types of arguments, and the
+// compiler binds it.
+//
+//	expr.Overload("format", formatTime, formatFloat)
+func Overload(name string, fns ...any) Option {
+	fn := builtin.Overload(name, fns...)
+	return func(c *conf.Config) {
+		c.Functions[name] = fn
+	}
+}
+
+// Generic adds instantiations of a generic function under one name. The type
+// checker selects the instantiation matching types of arguments.
+//
+//	expr.Generic("max", Max[int], Max[float64])
+func Generic(name string, instantiations ...any) Option {
+	return Overload(name, instantiations...)
+}
+
+// FirstMatchThrows makes xs[? predicate] return an error instead of nil,
+// if no element of the array matches the predicate.
+func FirstMatchThrows() Option {
+	return func(c *conf.Config) {
+		c.FirstMatchThrows = true
+	}
+}
+
+// Inline registers expression-level definition of the function. Calls to the
+// function are replaced with the body at compile time, which eliminates the
+// function call overhead for small helpers. The Go implementation of the
+// function (provided via Env or Function) is still called once the inlining
+// budget of the expression is exhausted.
+//
+//	expr.Inline("isWeekend", `int(t.Weekday()) in [0, 6]`, "t")
+func Inline(name, body string, params ...string) Option {
+	tree, err := parser.Parse(body)
+	if err != nil {
+		panic(fmt.Sprintf("expr: cannot inline %s: %v", name, err))
+	}
+	return func(c *conf.Config) {
+		c.Visitors = append(c.Visitors, &patcher.Inline{
+			Name:   name,
+			Params: params,
+			Body:   body,
+			Node:   tree.Node,
+			Budget: c.InlineBudget,
+		})
+	}
+}
+
+// InlineBudget sets the maximum number of nodes inlined into one expression
+// by each inlined function. Zero disables the limit. The default is
+// conf.DefaultInlineBudget.
+func InlineBudget(nodes int) Option {
+	return func(c *conf.Config) {
+		c.InlineBudget = nodes
+		for _, v := range c.Visitors {
+			if inline, ok := v.(*patcher.Inline); ok {
+				inline.Budget = nodes
+			}
+		}
+	}
+}
+
+// Macro defines an expression-level function. Calls of the macro are replaced
+// with its body at parse time, and arguments are bound to the parameters, so
+// no Go function is needed and no call is made at runtime.
+//
+//	expr.Macro("isAdult", `u.Age >= 18`, "u")
+//
+// Macros may call other macros, but not themselves.
+func Macro(name, body string, params ...string) Option {
+	if _, err := parser.Parse(body); err != nil {
+		panic(fmt.Sprintf("expr: cannot define macro %s: %v", name, err))
+	}
+	return func(c *conf.Config) {
+		c.Macros[name] = &conf.Macro{Params: params, Body: body}
+	}
+}
+
 // DisableAllBuiltins disables all builtins.
 func DisableAllBuiltins() Option {
 	return func(c *conf.Config) {
@@ -194,16 +505,18 @@ func Timezone(name string) Option {
 	})
 }
 
+// RandSource sets the source of random numbers for uuid(), random(), shuffle()
+// and sample() builtin functions. Use a seeded source to make evaluations
+// reproducible, for example in tests.
+func RandSource(source rand.Source) Option {
+	return Patch(patcher.WithRand{
+		Rand: builtin.NewRand(source),
+	})
+}
+
 // Compile parses and compiles given input expression to bytecode program.
 func Compile(input string, ops ...Option) (*vm.Program, error) {
-	config := conf.CreateNew()
-	for _, op := range ops {
-		op(config)
-	}
-	for name := range config.Disabled {
-		delete(config.Builtins, name)
-	}
-	config.Check()
+	config := newConfig(ops)
 
 	tree, err := checker.ParseCheck(input, config)
 	if err != nil {
@@ -229,9 +542,220 @@ func Compile(input string, ops ...Option) (*vm.Program, error) {
 	return program, nil
 }
 
+// CompileBundle compiles the expressions into one program, which evaluates
+// all of them against one env and returns their results as []any, in the
+// same order. Expressions share constants, and their common subexpressions
+// which never fail, like lower(User.Name) of a struct env, are evaluated
+// once. The program fails as a whole if any expression fails.
+func CompileBundle(inputs []string, ops ...Option) (*vm.Program, error) {
+	config := newConfig(ops)
+
+	trees := make([]*parser.Tree, len(inputs))
+	for i, input := range inputs {
+		tree, err := checker.ParseCheck(input, config)
+		if err != nil {
+			return nil, fmt.Errorf("expression %d: %w", i, err)
+		}
+		if config.Optimize {
+			err = optimizer.Optimize(&tree.Node, config)
+			if err != nil {
+				var fileError *file.Error
+				if errors.As(err, &fileError) {
+					err = fileError.Bind(tree.Source)
+				}
+				return nil, fmt.Errorf("expression %d: %w", i, err)
+			}
+		}
+		trees[i] = tree
+	}
+
+	return compiler.CompileBundle(trees, config)
+}
+
+// Specialize partially evaluates the program with known values of some of its
+// variables and returns the residual program. Known variables are replaced
+// with their values, and the optimizer folds the parts of the expression which
+// depend only on them:
+//
+//	program, err := expr.Compile(`tenant == "acme" && amount > limit`, expr.Env(env))
+//	acme, err := expr.Specialize(program, map[string]any{"tenant": "acme", "limit": 100}, expr.Env(env))
+//	acme.Node().String() // amount > 100
+//
+// Pass the same options as for Compile.
+func Specialize(program *vm.Program, known map[string]any, ops ...Option) (*vm.Program, error) {
+	ops = append(ops[:len(ops):len(ops)], Patch(&patcher.Specialize{Values: known}))
+	return Compile(program.Source().String(), ops...)
+}
+
+// UnmarshalProgram decodes the program encoded by vm.Program.MarshalBinary.
+// Custom functions of the program are resolved by name with the options,
+// so pass the same Function options as for Compile.
+func UnmarshalProgram(data []byte, ops ...Option) (*vm.Program, error) {
+	config := newConfig(ops)
+	return vm.UnmarshalProgram(data, func(name string) (vm.Function, bool) {
+		base, overload, isOverload := strings.Cut(name, "#")
+		fn, ok := config.Functions[base]
+		if !ok {
+			return nil, false
+		}
+		if isOverload {
+			i, err := strconv.Atoi(overload)
+			if err != nil || i < 0 || i >= len(fn.Overloads) {
+				return nil, false
+			}
+			return fn.Overloads[i], true
+		}
+		return fn.Func, fn.Func != nil
+	})
+}
+
+// Annotate parses and checks given input expression and returns types inferred
+// for all nodes. Annotations are returned even if the expression has type
+// errors, so editors can show types of the valid parts.
+func Annotate(input string, ops ...Option) ([]checker.Annotation, error) {
+	tree, err := checker.ParseCheck(input, newConfig(ops))
+	return checker.Annotations(tree), err
+}
+
+func newConfig(ops []Option) *conf.Config {
+	config := conf.CreateNew()
+	for _, op := range ops {
+		op(config)
+	}
+	for name := range config.Disabled {
+		delete(config.Builtins, name)
+	}
+	config.Check()
+	return config
+}
+
 // Run evaluates given bytecode program.
-func Run(program *vm.Program, env any) (any, error) {
-	return vm.Run(program, env)
+func Run(program *vm.Program, env any, opts ...vm.Option) (any, error) {
+	return vm.Run(program, env, opts...)
+}
+
+// RunAs evaluates the program and converts the result to T. Numbers are
+// converted if the value is kept, like int 2 to float64, and elements of
+// slices and maps are converted one by one, like []any to []string.
+//
+//	ok, err := expr.RunAs[bool](program, env)
+func RunAs[T any](program *vm.Program, env any, opts ...vm.Option) (T, error) {
+	var result T
+	out, err := Run(program, env, opts...)
+	if err != nil {
+		return result, err
+	}
+	if v, ok := out.(T); ok {
+		return v, nil
+	}
+	t := reflect.TypeOf(&result).Elem()
+	v, ok := convertResult(reflect.ValueOf(out), t)
+	if !ok {
+		if out == nil {
+			return result, fmt.Errorf("cannot convert nil result to %v", t)
+		}
+		return result, fmt.Errorf("cannot convert result %v (%T) to %v", out, out, t)
+	}
+	reflect.ValueOf(&result).Elem().Set(v)
+	return result, nil
+}
+
+func convertResult(v reflect.Value, t reflect.Type) (reflect.Value, bool) {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface, reflect.Func, reflect.Chan:
+			return reflect.Zero(t), true
+		}
+		return reflect.Value{}, false
+	}
+	if v.Type().AssignableTo(t) {
+		return v, true
+	}
+	switch {
+	case runtime.IsNumberKind(v.Kind()) && runtime.IsNumberKind(t.Kind()):
+		out := v.Convert(t)
+		if isFloat(v.Kind()) && isFloat(t.Kind()) {
+			return out, true
+		}
+		if isNegative(v) != isNegative(out) || out.Convert(v.Type()).Interface() != v.Interface() {
+			return reflect.Value{}, false
+		}
+		return out, true
+	case (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && t.Kind() == reflect.Slice:
+		out := reflect.MakeSlice(t, v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			elem, ok := convertResult(v.Index(i), t.Elem())
+			if !ok {
+				return reflect.Value{}, false
+			}
+			out.Index(i).Set(elem)
+		}
+		return out, true
+	case v.Kind() == reflect.Map && t.Kind() == reflect.Map:
+		out := reflect.MakeMapWithSize(t, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, ok := convertResult(iter.Key(), t.Key())
+			if !ok {
+				return reflect.Value{}, false
+			}
+			elem, ok := convertResult(iter.Value(), t.Elem())
+			if !ok {
+				return reflect.Value{}, false
+			}
+			out.SetMapIndex(key, elem)
+		}
+		return out, true
+	}
+	return reflect.Value{}, false
+}
+
+// isNegative reports whether the number is below zero.
+func isNegative(v reflect.Value) bool {
+	switch {
+	case v.CanInt():
+		return v.Int() < 0
+	case v.CanFloat():
+		return v.Float() < 0
+	}
+	return false
+}
+
+func isFloat(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
+
+// Pool runs programs on reused virtual machines, so stacks and scratch
+// buffers are not allocated for each run. Pool is safe for concurrent use.
+type Pool struct {
+	pool sync.Pool
+}
+
+// NewPool creates a pool of virtual machines configured with the options.
+func NewPool(opts ...vm.Option) *Pool {
+	return &Pool{
+		pool: sync.Pool{
+			New: func() any {
+				return vm.New(opts...)
+			},
+		},
+	}
+}
+
+// Run evaluates the program on a virtual machine from the pool.
+func (p *Pool) Run(program *vm.Program, env any) (any, error) {
+	if program == nil {
+		return nil, fmt.Errorf("program is nil")
+	}
+	v := p.pool.Get().(*vm.VM)
+	defer func() {
+		v.Reset()
+		p.pool.Put(v)
+	}()
+	return v.Run(program, env)
 }
 
 // Eval parses, compiles and runs given input.
@@ -1,11 +1,18 @@
 package expr_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"math/big"
 	"os"
 	"reflect"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -15,8 +22,11 @@ import (
 
 	"github.com/expr-lang/expr"
 	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/conf"
 	"github.com/expr-lang/expr/file"
 	"github.com/expr-lang/expr/test/mock"
+	"github.com/expr-lang/expr/vm"
+	"github.com/expr-lang/expr/vm/runtime"
 )
 
 func ExampleEval() {
@@ -1142,6 +1152,18 @@ func TestExpr(t *testing.T) {
 			`find(ArrayOfFoo, .Value == "baz")`,
 			env.ArrayOfFoo[2],
 		},
+		{
+			`ArrayOfFoo[? .Value == "baz"]`,
+			env.ArrayOfFoo[2],
+		},
+		{
+			`ArrayOfFoo[? .Value == "unknown"]`,
+			nil,
+		},
+		{
+			`ArrayOfFoo[? .Value != "foo"].Value`,
+			"bar",
+		},
 		{
 			`findIndex(ArrayOfFoo, .Value == "baz")`,
 			2,
@@ -1414,6 +1436,86 @@ func TestExpr_optional_chaining_array(t *testing.T) {
 	assert.Equal(t, nil, got)
 }
 
+func TestExpr_first_match_throws(t *testing.T) {
+	env := map[string]any{
+		"users": []map[string]any{{"ID": 1}, {"ID": 2}},
+	}
+
+	program, err := expr.Compile(`users[? .ID == 2].ID`, expr.Env(env), expr.FirstMatchThrows())
+	require.NoError(t, err)
+
+	got, err := expr.Run(program, env)
+	require.NoError(t, err)
+	assert.Equal(t, 2, got)
+
+	program, err = expr.Compile(`users[? .ID == 3]`, expr.Env(env), expr.FirstMatchThrows())
+	require.NoError(t, err)
+
+	_, err = expr.Run(program, env)
+	require.Error(t, err)
+}
+
+func TestExpr_quote(t *testing.T) {
+	env := map[string]any{
+		"User":  map[string]any{"Name": "John", "Age": 30},
+		"rules": []string{"User.Age >= 18", "let name = User.Name; name != ''", "$env.Admin"},
+	}
+
+	tests := []struct {
+		code string
+		want any
+	}{
+		{`quote(User.Age >= 18)`, "User.Age >= 18"},
+		{`quote(User.Age >= 18) == rules[0]`, true},
+		{`references(rules[0])`, []string{"User", "User.Age"}},
+		{`references(rules[1])`, []string{"User", "User.Name"}},
+		{`references(rules[2])`, []string{"Admin"}},
+		{`filter(rules, "User.Name" in references(#))`, []any{"let name = User.Name; name != ''"}},
+		{`unquote(rules[0])`, true},
+		{`unquote(quote(User.Age + 1))`, 31},
+		{`map(rules[:2], unquote(#))`, []any{true, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			program, err := expr.Compile(tt.code, expr.Env(env))
+			require.NoError(t, err)
+
+			out, err := expr.Run(program, env)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, out)
+		})
+	}
+
+	_, err := expr.Eval(`unquote("User.")`, env)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unexpected end of expression")
+
+	program, err := expr.Compile(`unquote("sum(1..100)")`, expr.Env(env))
+	require.NoError(t, err)
+	_, err = expr.Run(program, env, vm.MaxSteps(50))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "expression exceeded 50 operations")
+
+	// Nested runs share the budget of the outer run.
+	program, err = expr.Compile(`unquote("1..5 | sum()") + unquote("1..5 | sum()")`, expr.Env(env))
+	require.NoError(t, err)
+	out, err := expr.Run(program, env, vm.MaxSteps(100))
+	require.NoError(t, err)
+	require.Equal(t, 30, out)
+	_, err = expr.Run(program, env, vm.MaxSteps(60))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "expression exceeded 60 operations")
+}
+
+func TestExpr_unquote_itself(t *testing.T) {
+	env := map[string]any{"r": "unquote(r)"}
+
+	_, err := expr.Eval(`unquote(r)`, env)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "expression exceeded 100 nested runs")
+}
+
 func TestExpr_eval_with_env(t *testing.T) {
 	_, err := expr.Eval("true", expr.Env(map[string]any{}))
 	assert.Error(t, err)
@@ -1579,6 +1681,47 @@ func TestConstExpr_error_no_env(t *testing.T) {
 	})
 }
 
+func TestPure(t *testing.T) {
+	calls := 0
+	slug := expr.Function("slug", func(params ...any) (any, error) {
+		calls++
+		return strings.ReplaceAll(strings.ToLower(params[0].(string)), " ", "-"), nil
+	}, new(func(string) string))
+
+	program, err := expr.Compile(`slug("Hello World")`, slug, expr.Pure("slug"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls)
+
+	out, err := expr.Run(program, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "hello-world", out)
+	assert.Equal(t, 1, calls)
+
+	calls = 0
+	program, err = expr.Compile(`slug("Hello World")`, slug)
+	require.NoError(t, err)
+	assert.Equal(t, 0, calls)
+
+	out, err = expr.Run(program, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "hello-world", out)
+	assert.Equal(t, 1, calls)
+}
+
+func TestPure_error_in_dead_branch(t *testing.T) {
+	env := map[string]any{"ok": true}
+
+	program, err := expr.Compile(`ok ? "fine" : duration("bad")`, expr.Env(env))
+	require.NoError(t, err)
+
+	out, err := expr.Run(program, env)
+	require.NoError(t, err)
+	assert.Equal(t, "fine", out)
+
+	_, err = expr.Run(program, map[string]any{"ok": false})
+	require.Error(t, err)
+}
+
 var stringer = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
 
 type stringerPatcher struct{}
@@ -1611,6 +1754,369 @@ func TestPatch(t *testing.T) {
 	require.Equal(t, true, output)
 }
 
+func TestFieldTag(t *testing.T) {
+	type User struct {
+		FirstName string `json:"first_name,omitempty"`
+		Age       int
+		Password  string `json:"-"`
+	}
+	type Env struct {
+		User  User   `json:"user"`
+		Users []User `json:"users"`
+	}
+	env := Env{
+		User:  User{FirstName: "Anton", Age: 30},
+		Users: []User{{FirstName: "Anton"}, {FirstName: "Ilya"}},
+	}
+
+	program, err := expr.Compile(`user.first_name + " " + string(user.Age) + " " + join(map(users, .first_name), ",")`, expr.Env(Env{}), expr.FieldTag("json"))
+	require.NoError(t, err)
+
+	out, err := expr.Run(program, env)
+	require.NoError(t, err)
+	assert.Equal(t, "Anton 30 Anton,Ilya", out)
+
+	_, err = expr.Compile(`user.FirstName`, expr.Env(Env{}), expr.FieldTag("json"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "has no field FirstName")
+
+	_, err = expr.Compile(`user.Password`, expr.FieldTag("json"), expr.Env(Env{}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "has no field Password")
+
+	// Fields fetched at runtime are named by the tag as well.
+	tests := []struct {
+		code string
+		want any
+	}{
+		{`pluck(users, "first_name")`, []string{"Anton", "Ilya"}},
+		{`$env.user.first_name`, "Anton"},
+		{`$env["user"]["first_name"]`, "Anton"},
+		{`"first_name" in user`, true},
+		{`"FirstName" in user`, false},
+		{`get($env, "user.first_name", "none")`, "Anton"},
+		{`get($env, "user.Password", "none")`, "none"},
+		{`has(user, "first_name")`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			program, err := expr.Compile(tt.code, expr.Env(Env{}), expr.FieldTag("json"))
+			require.NoError(t, err)
+
+			out, err := expr.Run(program, env)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, out)
+
+			data, err := program.MarshalBinary()
+			require.NoError(t, err)
+			var decoded vm.Program
+			require.NoError(t, decoded.UnmarshalBinary(data))
+			out, err = expr.Run(&decoded, env)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, out)
+		})
+	}
+}
+
+func TestFieldTag_protobuf(t *testing.T) {
+	// Fields of a message generated by protoc-gen-go.
+	type Event struct {
+		state   struct{}
+		UserId  string   `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+		Score   int32    `protobuf:"varint,2,opt,name=score,proto3" json:"score,omitempty"`
+		Labels  []string `protobuf:"bytes,3,rep,name=labels,proto3" json:"labels,omitempty"`
+		Payload any      `protobuf_oneof:"payload"`
+	}
+
+	program, err := expr.Compile(`user_id + ":" + string(score) + ":" + join(labels, ",")`, expr.Env(&Event{}), expr.FieldTag("protobuf"))
+	require.NoError(t, err)
+
+	out, err := expr.Run(program, &Event{UserId: "u1", Score: 7, Labels: []string{"a", "b"}})
+	require.NoError(t, err)
+	assert.Equal(t, "u1:7:a,b", out)
+
+	_, err = expr.Compile(`UserId`, expr.Env(&Event{}), expr.FieldTag("protobuf"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown name UserId")
+}
+
+func TestDeny_runtime(t *testing.T) {
+	type User struct {
+		Name     string
+		Password string
+	}
+	type Env struct {
+		User  User
+		Users []User
+		Any   any
+		Key   string
+	}
+	env := Env{
+		User:  User{Name: "Anton", Password: "secret"},
+		Users: []User{{Name: "Anton"}, {Name: "Ilya"}},
+		Any:   User{Name: "Anton", Password: "secret"},
+		Key:   "Password",
+	}
+	password := expr.Member(User{}, "Password")
+	assert.Equal(t, "github.com/expr-lang/expr_test.User.Password", password)
+
+	// Members denied by the checker are denied at runtime as well.
+	tests := []struct {
+		code string
+		want any
+		err  string
+	}{
+		{`User.Name`, "Anton", ""},
+		{`pluck(Users, "Name")`, []string{"Anton", "Ilya"}, ""},
+		{`Any.Name`, "Anton", ""},
+		{`Any.Password`, nil, "access denied to User.Password"},
+		{`Any[Key]`, nil, "access denied to User.Password"},
+		{`Any?.Password`, nil, "access denied to User.Password"},
+		{`pluck(Users, Key)`, nil, "access denied to User.Password"},
+		{`get(Any, Key)`, nil, "access denied to User.Password"},
+		{`get(Any, "Password", "none")`, nil, "access denied to User.Password"},
+		{`toJSON(User)`, nil, "access denied to User.Password"},
+		{`string(Users)`, nil, "access denied to User.Password"},
+		{`toJSON(User.Name)`, `"Anton"`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			program, err := expr.Compile(tt.code, expr.Env(Env{}), expr.Deny(password))
+			require.NoError(t, err)
+
+			data, err := program.MarshalBinary()
+			require.NoError(t, err)
+			var decoded vm.Program
+			require.NoError(t, decoded.UnmarshalBinary(data))
+
+			for _, p := range []*vm.Program{program, &decoded} {
+				out, err := expr.Run(p, env)
+				if tt.err != "" {
+					require.Error(t, err)
+					assert.Contains(t, err.Error(), tt.err)
+				} else {
+					require.NoError(t, err)
+					assert.Equal(t, tt.want, out)
+				}
+			}
+		})
+	}
+
+	_, err := expr.Compile(`User.Password`, expr.Env(Env{}), expr.Deny(password))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "access denied to User.Password")
+}
+
+func TestResolvers(t *testing.T) {
+	columns := map[string]reflect.Type{
+		"price":    reflect.TypeOf(float64(0)),
+		"quantity": reflect.TypeOf(0),
+	}
+	options := []expr.Option{
+		expr.Env(map[string]any{}),
+		expr.IdentifierResolver(func(name string) (reflect.Type, bool) {
+			if name == "row" {
+				return reflect.TypeOf(map[string]any{}), true
+			}
+			t, ok := columns[name]
+			return t, ok
+		}),
+		expr.FieldResolver(func(base reflect.Type, name string) (reflect.Type, bool) {
+			if base != reflect.TypeOf(map[string]any{}) {
+				return nil, false
+			}
+			t, ok := columns[name]
+			return t, ok
+		}),
+	}
+	env := map[string]any{
+		"price":    2.5,
+		"quantity": 4,
+		"row":      map[string]any{"price": 1.5, "quantity": 2},
+	}
+
+	program, err := expr.Compile(`price * quantity + row.price * row.quantity`, options...)
+	require.NoError(t, err)
+
+	out, err := expr.Run(program, env)
+	require.NoError(t, err)
+	assert.Equal(t, 13.0, out)
+
+	_, err = expr.Compile(`price + "USD"`, options...)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "mismatched types float64 and string")
+
+	_, err = expr.Compile(`row.quantity > "2"`, options...)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "mismatched types int and string")
+
+	_, err = expr.Compile(`unknown`, options...)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown name unknown")
+}
+
+func TestAnnotate(t *testing.T) {
+	type User struct {
+		Name string
+		Age  int
+	}
+	type Env struct {
+		User User
+	}
+
+	annotations, err := expr.Annotate(`User.Age > 18`, expr.Env(Env{}))
+	require.NoError(t, err)
+
+	var got []string
+	for _, a := range annotations {
+		got = append(got, fmt.Sprintf("%v %v %d:%d", a.Node, a.Type, a.Location.From, a.Location.To))
+	}
+	assert.Equal(t, []string{
+		"User expr_test.User 0:4",
+		`"Age" string 5:8`,
+		"User.Age int 5:8",
+		"18 int 11:13",
+		"User.Age > 18 bool 9:10",
+	}, got)
+
+	annotations, err = expr.Annotate(`User.Name + User.Unknown`, expr.Env(Env{}))
+	require.Error(t, err)
+	require.NotEmpty(t, annotations)
+	assert.Equal(t, reflect.TypeOf(""), annotations[2].Type)
+}
+
+func TestExpectCoerce(t *testing.T) {
+	env := map[string]any{
+		"name":  "Anton",
+		"tags":  []string{},
+		"count": "42",
+		"price": 1.5,
+		"any":   0,
+	}
+	tests := []struct {
+		input  string
+		option expr.Option
+		want   any
+	}{
+		{`name`, expr.AsBool(), true},
+		{`tags`, expr.AsBool(), false},
+		{`any`, expr.AsBool(), false},
+		{`nil`, expr.AsBool(), false},
+		{`name == "Anton"`, expr.AsBool(), true},
+		{`price`, expr.AsKind(reflect.String), "1.5"},
+		{`count`, expr.AsInt(), 42},
+		{`count`, expr.AsInt64(), int64(42)},
+		{`price`, expr.AsInt(), 1},
+		{`count`, expr.AsFloat64(), 42.0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			program, err := expr.Compile(test.input, expr.Env(env), test.option, expr.ExpectCoerce())
+			require.NoError(t, err)
+
+			out, err := expr.Run(program, env)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, out)
+		})
+	}
+
+	_, err := expr.Compile(`name`, expr.Env(env), expr.AsInt())
+	require.Error(t, err)
+}
+
+func TestOperatorTypes(t *testing.T) {
+	env := map[string]any{
+		"a": []byte("foo"),
+		"b": []byte("bar"),
+	}
+	option := expr.OperatorTypes("+", new(func([]byte, []byte) []byte))
+
+	tree, err := expr.Annotate(`a + b`, expr.Env(env), option)
+	require.NoError(t, err)
+	assert.Equal(t, reflect.TypeOf([]byte{}), tree[len(tree)-1].Type)
+
+	_, err = expr.Compile(`a + b`, expr.Env(env))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid operation: + (mismatched types []uint8 and []uint8)")
+
+	_, err = expr.Compile(`a + 1`, expr.Env(env), option)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid operation: + (mismatched types []uint8 and int)")
+}
+
+func TestArrayNode_typed(t *testing.T) {
+	type Env struct {
+		I        int
+		I32      int32
+		M        map[string]int
+		Ints     func(xs []int) int
+		Int32s   func(xs []int32) int32
+		Floats   func(xs []float64) float64
+		Float32s func(xs []float32) float32
+		Anys     func(xs []any) any
+	}
+	env := Env{
+		I:        3,
+		I32:      4,
+		M:        map[string]int{"a": 1},
+		Ints:     func(xs []int) int { return len(xs) },
+		Int32s:   func(xs []int32) int32 { return xs[0] + xs[1] },
+		Floats:   func(xs []float64) float64 { return xs[0] + xs[1] },
+		Float32s: func(xs []float32) float32 { return xs[0] + xs[1] },
+		Anys:     func(xs []any) any { return xs[1] },
+	}
+
+	tests := []struct {
+		code string
+		want any
+	}{
+		{`[1, 2, I]`, []any{1, 2, 3}},
+		{`[I32 + I32]`, []any{8}},
+		{`Ints([1, 2, I])`, 3},
+		{`Ints([])`, 0},
+		{`Int32s([1, I32])`, int32(5)},
+		{`Floats([1, 2])`, 3.0},
+		{`Floats([1.5, -1])`, 0.5},
+		{`Float32s([1.5, -2])`, float32(-0.5)},
+		{`Anys(["a", "b"])`, "b"},
+		{`Anys([I32 + I32, M.a])`, 1},
+		{`sortBy([1, 3, 2], [#, -#])`, []any{1, 2, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			program, err := expr.Compile(tt.code, expr.Env(env))
+			require.NoError(t, err)
+
+			out, err := expr.Run(program, env)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, out)
+		})
+	}
+
+	// Values of map elements are not known exactly, so they are not typed.
+	_, err := expr.Compile(`Ints([M.a])`, expr.Env(env))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot use []interface {} as argument (type []int)")
+}
+
+func TestOnWarning(t *testing.T) {
+	var warnings []string
+	program, err := expr.Compile(`Age > 65 && Age < 18 || 1 == 1`,
+		expr.Env(map[string]any{"Age": 0}),
+		expr.OnWarning(func(warning *file.Error) {
+			warnings = append(warnings, warning.Error())
+		}),
+	)
+	require.NoError(t, err)
+	require.NotNil(t, program)
+	assert.Equal(t, []string{
+		"condition 1 == 1 is always true (1:27)\n | Age > 65 && Age < 18 || 1 == 1\n | ..........................^",
+		"condition Age > 65 && Age < 18 is always false (Age can not satisfy all comparisons) (1:10)\n | Age > 65 && Age < 18 || 1 == 1\n | .........^",
+	}, warnings)
+}
+
 func TestCompile_exposed_error(t *testing.T) {
 	_, err := expr.Compile(`1 == true`)
 	require.Error(t, err)
@@ -1970,31 +2476,1383 @@ func TestFunction(t *testing.T) {
 	assert.Equal(t, 20, out)
 }
 
-// Nil coalescing operator
-func TestRun_NilCoalescingOperator(t *testing.T) {
-	env := map[string]any{
-		"foo": map[string]any{
-			"bar": "value",
-		},
+func genericMax[T int | float64 | string](a, b T) T {
+	if a > b {
+		return a
 	}
+	return b
+}
 
-	t.Run("value", func(t *testing.T) {
-		p, err := expr.Compile(`foo.bar ?? "default"`, expr.Env(env))
-		assert.NoError(t, err)
+type genericBox[T any] struct {
+	Value T
+}
 
-		out, err := expr.Run(p, env)
-		assert.NoError(t, err)
-		assert.Equal(t, "value", out)
-	})
+func (b genericBox[T]) Or(value T) T {
+	return b.Value
+}
 
-	t.Run("default", func(t *testing.T) {
-		p, err := expr.Compile(`foo.baz ?? "default"`, expr.Env(env))
-		assert.NoError(t, err)
+func TestOverload(t *testing.T) {
+	format := expr.Overload("format",
+		func(t time.Time) string { return t.Format("2006-01-02") },
+		func(f float64, precision int) string { return strconv.FormatFloat(f, 'f', precision, 64) },
+		func(s string) (string, error) {
+			if s == "" {
+				return "", fmt.Errorf("empty string")
+			}
+			return strconv.Quote(s), nil
+		},
+	)
+	env := map[string]any{
+		"date":    time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		"unknown": any(1.5),
+		"empty":   "",
+	}
 
-		out, err := expr.Run(p, env)
-		assert.NoError(t, err)
-		assert.Equal(t, "default", out)
-	})
+	tests := []struct {
+		code string
+		want any
+	}{
+		{`format(date)`, "2024-03-01"},
+		{`format(3.14159, 2)`, "3.14"},
+		{`format(3, 1)`, "3.0"},
+		{`format("foo")`, `"foo"`},
+		{`format(unknown, 1)`, "1.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			program, err := expr.Compile(tt.code, expr.Env(env), format)
+			require.NoError(t, err)
+
+			out, err := expr.Run(program, env)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, out)
+		})
+	}
+
+	program, err := expr.Compile(`format(3.14159, 2)`, expr.Env(env), format)
+	require.NoError(t, err)
+	assert.Contains(t, program.Disassemble(), "format#1")
+
+	_, err = expr.Compile(`format(date, 1)`, expr.Env(env), format)
+	require.Error(t, err)
+
+	program, err = expr.Compile(`format(empty)`, expr.Env(env), format)
+	require.NoError(t, err)
+	_, err = expr.Run(program, env)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "empty string")
+}
+
+func TestOverload_invalid(t *testing.T) {
+	assert.PanicsWithValue(t, "expr: overload of log must return a value and an optional error (got func(string))", func() {
+		expr.Overload("log", func(s string) {})
+	})
+	assert.PanicsWithValue(t, "expr: overload of pair must return a value and an optional error (got func() (int, int, error))", func() {
+		expr.Overload("pair", func() (int, int, error) { return 0, 0, nil })
+	})
+	assert.PanicsWithValue(t, "expr: second return value of pair must be an error (got func() (int, int))", func() {
+		expr.Overload("pair", func() (int, int) { return 0, 0 })
+	})
+}
+
+func TestMaxDepth_MaxNodes(t *testing.T) {
+	_, err := expr.Compile(strings.Repeat("-", 100)+"1", expr.MaxDepth(50))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expression is too deeply nested (max depth is 50)")
+
+	_, err = expr.Compile(strings.Repeat("1 + ", 100)+"1", expr.MaxDepth(50))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expression is too deeply nested (max depth is 50)")
+
+	// Chains deep enough to overflow the stack of the checker.
+	_, err = expr.Compile("1" + strings.Repeat("+1", 3000000))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expression is too deeply nested (max depth is 10000)")
+
+	_, err = expr.Compile("m" + strings.Repeat("?.a", 3000000), expr.Env(map[string]any{"m": map[string]any{}}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expression is too deeply nested (max depth is 10000)")
+
+	_, err = expr.Compile(`1 + 2 + 3`, expr.MaxNodes(4))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expression is too complex (max 4 nodes)")
+
+	program, err := expr.Compile(`1 + 2 + 3`, expr.MaxNodes(5))
+	require.NoError(t, err)
+
+	out, err := expr.Run(program, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 6, out)
+}
+
+type spreadEnv struct {
+	Numbers []int
+	Values  []any
+	Names   []string
+}
+
+func (spreadEnv) Sum(xs ...float64) float64 {
+	var sum float64
+	for _, x := range xs {
+		sum += x
+	}
+	return sum
+}
+
+func (spreadEnv) Max(xs ...int) int {
+	max := 0
+	for _, x := range xs {
+		if x > max {
+			max = x
+		}
+	}
+	return max
+}
+
+func (spreadEnv) Join(sep string, xs ...string) string {
+	return strings.Join(xs, sep)
+}
+
+func TestSpread(t *testing.T) {
+	env := spreadEnv{
+		Numbers: []int{1, 2, 3},
+		Values:  []any{4, 5.5},
+		Names:   []string{"a", "b"},
+	}
+	count := expr.Function("count", func(params ...any) (any, error) {
+		return len(params), nil
+	})
+
+	tests := []struct {
+		code string
+		want any
+	}{
+		{`Sum(Numbers...)`, 6.0},
+		{`Sum(1, Values...)`, 10.5},
+		{`Sum([1, 2.5]...)`, 3.5},
+		{`Join("-", Names...)`, "a-b"},
+		{`Join("-", "x", Names...)`, "x-a-b"},
+		{`Join("-", nil...)`, ""},
+		{`count(0, Numbers...)`, 4},
+		{`Max([1.0, 3.0, 2.0]...)`, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			program, err := expr.Compile(tt.code, expr.Env(env), count)
+			require.NoError(t, err)
+
+			out, err := expr.Run(program, env)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, out)
+		})
+	}
+
+	_, err := expr.Compile(`Join("-", Numbers...)`, expr.Env(env))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot use []int as argument (type []string) to call Join")
+
+	program, err := expr.Compile(`Join("-", Values...)`, expr.Env(env))
+	require.NoError(t, err)
+	_, err = expr.Run(program, env)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot use int as argument (type string)")
+
+	program, err = expr.Compile(`Max(Values...)`, expr.Env(env))
+	require.NoError(t, err)
+	_, err = expr.Run(program, env)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot use 5.5 (float64) as argument (type int)")
+}
+
+func TestCompile_cached_fields(t *testing.T) {
+	type Inner struct {
+		Value int
+	}
+	type Middle struct {
+		Name string
+		Inner
+	}
+	type Outer struct {
+		ID int
+		Middle
+	}
+	type Env struct {
+		A Outer
+		B Outer
+	}
+	env := Env{
+		A: Outer{Middle: Middle{Inner: Inner{Value: 1}}},
+		B: Outer{Middle: Middle{Inner: Inner{Value: 2}}},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			program, err := expr.Compile(`A.Value * 10 + B.Inner.Value`, expr.Env(env))
+			assert.NoError(t, err)
+
+			out, err := expr.Run(program, env)
+			assert.NoError(t, err)
+			assert.Equal(t, 12, out)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestConditional_common_type(t *testing.T) {
+	env := map[string]any{
+		"ok":   true,
+		"i":    3,
+		"f":    0.5,
+		"half": func(x float64) float64 { return x / 2 },
+	}
+
+	tests := []struct {
+		code string
+		want any
+	}{
+		{`half(!ok ? i : f)`, 0.25},
+		{`ok ? 1 : 0.5`, 1},
+		{`!ok ? 1 : 0.5`, 0.5},
+		{`(ok ? i : f) + 0.5`, 3.5},
+		{`[ok ? i : f, 0.5]`, []any{3, 0.5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			program, err := expr.Compile(tt.code, expr.Env(env))
+			require.NoError(t, err)
+
+			out, err := expr.Run(program, env)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, out)
+		})
+	}
+}
+
+type marshalEnv struct {
+	Name     string
+	Ints     []int
+	Duration time.Duration
+	User     struct{ Address struct{ City string } }
+}
+
+func (marshalEnv) Add(a, b int) int { return a + b }
+
+func TestProgram_MarshalBinary(t *testing.T) {
+	env := marshalEnv{Name: "alice", Ints: []int{1, 2, 3}, Duration: time.Minute}
+	env.User.Address.City = "Paris"
+	double := expr.Function("double", func(params ...any) (any, error) {
+		return params[0].(int) * 2, nil
+	}, new(func(int) int))
+
+	tests := []string{
+		`Name + "!"`,
+		`User.Address.City`,
+		`Add(1, 2)`,
+		`Name matches "^a.*"`,
+		`2 in [1, 2, 3] && "b" in ["a", "b"]`,
+		`Ints[1:]`,
+		`{"a": 1, "b": [1.5, nil]}`,
+		`map(Ints, # * 2)`,
+		`sum(Ints) + len(Name)`,
+		`upper(Name) startsWith "AL"`,
+		`Duration > duration("30s")`,
+		`let x = 1; x + double(2)`,
+		`Name ?? "bob"`,
+		`"10.1.2.3" in cidr("10.0.0.0/8")`,
+		`startsWithAny(Name, ["al", "bo"])`,
+		`semver("1.2.3-rc.1+build")`,
+		`[semver("1.0.0"), semver("2.0.0")]`,
+		`ip("10.1.2.3")`,
+		`[ip("10.1.2.3"), ip("::1")]`,
+		`cidr("10.0.0.0/8")`,
+		`bigint("123456789012345678901234567890")`,
+		`{"b": 2, "a": 1, "c": [Name]}`,
+	}
+	for _, input := range tests {
+		t.Run(input, func(t *testing.T) {
+			program, err := expr.Compile(input, expr.Env(marshalEnv{}), double)
+			require.NoError(t, err)
+			want, err := expr.Run(program, env)
+			require.NoError(t, err)
+
+			data, err := program.MarshalBinary()
+			require.NoError(t, err)
+			decoded, err := expr.UnmarshalProgram(data, double)
+			require.NoError(t, err)
+			assert.Equal(t, program.Disassemble(), decoded.Disassemble())
+
+			got, err := expr.Run(decoded, env)
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+func TestProgram_UnmarshalBinary_errors(t *testing.T) {
+	double := expr.Function("double", func(params ...any) (any, error) {
+		return params[0].(int) * 2, nil
+	}, new(func(int) int))
+	program, err := expr.Compile(`double(2)`, double)
+	require.NoError(t, err)
+	data, err := program.MarshalBinary()
+	require.NoError(t, err)
+
+	var decoded vm.Program
+	err = decoded.UnmarshalBinary(data)
+	require.EqualError(t, err, "unknown function double")
+
+	err = decoded.UnmarshalBinary(data[:len(data)-3])
+	require.Error(t, err)
+
+	err = decoded.UnmarshalBinary([]byte("{}"))
+	require.EqualError(t, err, "invalid program: unknown format")
+
+	incompatible := append([]byte{}, data...)
+	incompatible[4]++
+	err = decoded.UnmarshalBinary(incompatible)
+	require.EqualError(t, err, "invalid program: compiled by incompatible version of expr")
+
+	fooEnv := map[string]any{"foo": func() mock.Foo { return mock.Foo{} }}
+	program, err = expr.Compile(`foo()`, expr.Env(fooEnv), expr.ConstExpr("foo"))
+	require.NoError(t, err)
+	_, err = program.MarshalBinary()
+	require.EqualError(t, err, "cannot marshal constant of type mock.Foo")
+
+	funcEnv := map[string]any{"fn": func() any { return func() {} }}
+	program, err = expr.Compile(`fn()`, expr.Env(funcEnv), expr.ConstExpr("fn"))
+	require.NoError(t, err)
+	_, err = program.MarshalBinary()
+	require.EqualError(t, err, "cannot marshal constant of type func()")
+
+	// Constants nested too deep, or of too large array types, are rejected.
+	// The program has no bytecode and one constant of tags of slices (18)
+	// and arrays (19) of ints (2).
+	header := len("EXPR")
+	for i := 0; i < 2; i++ {
+		_, n := binary.Uvarint(data[header:])
+		header += n
+	}
+	constant := func(tags ...byte) []byte {
+		out := append([]byte{}, data[:header]...)
+		out = append(out, 0, 0, 0, 0, 1)
+		out = append(out, tags...)
+		return append(out, make([]byte, 256)...)
+	}
+	err = decoded.UnmarshalBinary(constant(bytes.Repeat([]byte{18}, 1000)...))
+	require.EqualError(t, err, "invalid program: too deeply nested")
+	err = decoded.UnmarshalBinary(constant(19, 100, 19, 100, 19, 100, 2))
+	require.EqualError(t, err, "invalid program: array is too large")
+}
+
+func TestProgram_MarshalBinary_deterministic(t *testing.T) {
+	program, err := expr.Compile(`{"a": 1, "b": 2, "c": 3, "d": {"x": 1, "y": 2}}`)
+	require.NoError(t, err)
+	data, err := program.MarshalBinary()
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		again, err := program.MarshalBinary()
+		require.NoError(t, err)
+		require.Equal(t, data, again)
+	}
+}
+
+func TestIn_constant_set(t *testing.T) {
+	type Env struct {
+		Day   time.Weekday
+		Any   any
+		Int   int
+		Float float64
+		Str   string
+		I8    int8
+		Nil   any
+	}
+	env := Env{Day: time.Tuesday, Any: "b", Int: 2, Float: 2, Str: "b", I8: 44}
+
+	tests := []struct {
+		code string
+		want bool
+	}{
+		{`Day in [1, 2]`, false},
+		{`Day in [Day]`, true},
+		{`Int in [1, -2, 1 + 1]`, true},
+		{`Float in [1, 2]`, true},
+		{`Any in [1, 2]`, false},
+		{`Any in ["a", "b"]`, true},
+		{`Str in ["a", "b"]`, true},
+		{`I8 in [300, 45]`, false},
+		{`nil in [1, 2]`, false},
+		{`Nil in [0, 1]`, false},
+		{`Nil in [false, true]`, false},
+		{`Nil in ["", "a"]`, false},
+		{`Nil in [nil, 1]`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			program, err := expr.Compile(tt.code, expr.Env(env))
+			require.NoError(t, err)
+			out, err := expr.Run(program, env)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, out)
+
+			unoptimized, err := expr.Compile(tt.code, expr.Env(env), expr.Optimize(false))
+			require.NoError(t, err)
+			out, err = expr.Run(unoptimized, env)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, out)
+		})
+	}
+}
+
+func TestIn_range(t *testing.T) {
+	env := map[string]any{"n": 500000, "i8": int8(-3), "lo": 1, "hi": 3}
+	tests := []struct {
+		code string
+		want bool
+	}{
+		{`n in 1..1000000`, true},
+		{`n in 1000000..1`, false},
+		{`n not in 1..10`, true},
+		{`i8 in -5..lo`, true},
+		{`hi in lo..hi`, true},
+		{`lo in hi..5`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			program, err := expr.Compile(tt.code, expr.Env(env))
+			require.NoError(t, err)
+			out, err := expr.Run(program, env)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, out)
+		})
+	}
+}
+
+func TestFetchField_nil_embedded(t *testing.T) {
+	type Inner struct{ Level int }
+	type Customer struct {
+		*Inner
+		Name string
+	}
+	type Env struct{ Customer *Customer }
+
+	program, err := expr.Compile(`Customer.Level`, expr.Env(Env{}))
+	require.NoError(t, err)
+
+	_, err = expr.Run(program, Env{})
+	require.ErrorContains(t, err, "cannot get Inner from Customer")
+
+	_, err = expr.Run(program, Env{Customer: &Customer{}})
+	require.ErrorContains(t, err, "cannot get Level from Inner")
+
+	out, err := expr.Run(program, Env{Customer: &Customer{Inner: &Inner{Level: 3}}})
+	require.NoError(t, err)
+	require.Equal(t, 3, out)
+}
+
+func TestCompile_folded_regexp_error(t *testing.T) {
+	_, err := expr.Compile(`"abc" matches "(" + "a"`)
+	require.Error(t, err)
+	require.Equal(t, "error parsing regexp: missing closing ): `(a` (1:7)\n | \"abc\" matches \"(\" + \"a\"\n | ......^", err.Error())
+}
+
+func TestCompileBundle(t *testing.T) {
+	env := map[string]any{
+		"amount": 0,
+		"tenant": "",
+	}
+	program, err := expr.CompileBundle([]string{
+		`amount > 100`,
+		`tenant == "acme"`,
+		`amount * 2`,
+	}, expr.Env(env))
+	require.NoError(t, err)
+
+	out, err := expr.Run(program, map[string]any{"amount": 150, "tenant": "acme"})
+	require.NoError(t, err)
+	assert.Equal(t, []any{true, true, 300}, out)
+}
+
+func TestCompileBundle_errors(t *testing.T) {
+	env := map[string]any{"user": map[string]any{}}
+
+	_, err := expr.CompileBundle([]string{`1 + 1`, `1 +`}, expr.Env(env))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expression 1:")
+
+	program, err := expr.CompileBundle([]string{`1 + 1`, `user.name.first`}, expr.Env(env))
+	require.NoError(t, err)
+
+	_, err = expr.Run(program, env)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "(2:")
+	assert.Contains(t, err.Error(), "user.name.first")
+}
+
+func TestMacro(t *testing.T) {
+	type User struct {
+		Name string
+		Age  int
+	}
+	type Env struct {
+		User  User
+		Users []User
+		Limit int
+	}
+	env := Env{
+		User:  User{Name: "Bob", Age: 20},
+		Users: []User{{Age: 10}, {Age: 30}, {Age: 40}},
+		Limit: 18,
+	}
+	ops := []expr.Option{
+		expr.Env(Env{}),
+		expr.Macro("isAdult", `u.Age >= 18`, "u"),
+		expr.Macro("isAdultNamed", `isAdult(u) && u.Name == name`, "u", "name"),
+		expr.Macro("isAllowed", `let age = u.Age; age >= Limit`, "u"),
+	}
+
+	tests := []struct {
+		code     string
+		expected any
+	}{
+		{`isAdult(User)`, true},
+		{`isAdultNamed(User, "Alice")`, false},
+		{`count(Users, isAdult(#))`, 2},
+		{`User | isAdult()`, true},
+		{`isAllowed(User) && !isAllowed(Users[0])`, true},
+		{`let age = 1; isAllowed(User) && age == 1`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			program, err := expr.Compile(tt.code, ops...)
+			require.NoError(t, err)
+
+			out, err := expr.Run(program, env)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, out)
+		})
+	}
+
+	assert.Panics(t, func() {
+		expr.Macro("broken", `u.Age >=`, "u")
+	})
+}
+
+func TestConstantPool(t *testing.T) {
+	pool := conf.NewConstantPool()
+	env := map[string]any{"name": "", "tag": ""}
+	compile := func(code string) *vm.Program {
+		program, err := expr.Compile(code, expr.Env(env), expr.ConstantPool(pool))
+		require.NoError(t, err)
+		return program
+	}
+	p1 := compile(`name matches "^a+$" && tag in ["x", "y", "z"]`)
+	p2 := compile(`tag in ["z", "y", "x"] || name matches "^a+$"`)
+
+	find := func(program *vm.Program, kind reflect.Kind) any {
+		for _, c := range program.Constants {
+			if reflect.TypeOf(c).Kind() == kind {
+				return c
+			}
+		}
+		t.Fatalf("no %v constant", kind)
+		return nil
+	}
+	assert.Same(t, find(p1, reflect.Ptr), find(p2, reflect.Ptr))
+	assert.Equal(t, reflect.ValueOf(find(p1, reflect.Map)).Pointer(), reflect.ValueOf(find(p2, reflect.Map)).Pointer())
+
+	out, err := expr.Run(p2, map[string]any{"name": "aaa", "tag": "w"})
+	require.NoError(t, err)
+	assert.Equal(t, true, out)
+
+	_, err = expr.Compile(`name matches "("`, expr.Env(env), expr.ConstantPool(pool))
+	require.Error(t, err)
+}
+
+func TestPool(t *testing.T) {
+	program, err := expr.Compile(`sum(map(1..n, # * k))`, expr.Env(map[string]any{"n": 0, "k": 0}))
+	require.NoError(t, err)
+
+	pool := expr.NewPool()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(k int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				out, err := pool.Run(program, map[string]any{"n": 10, "k": k})
+				assert.NoError(t, err)
+				assert.Equal(t, 55*k, out)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	_, err = expr.NewPool(vm.MaxSteps(10)).Run(program, map[string]any{"n": 10, "k": 1})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "expression exceeded 10 operations")
+}
+
+func TestProfile(t *testing.T) {
+	env := map[string]any{"xs": []int{}}
+	program, err := expr.Compile(`len(filter(xs, # > 5)) + 1`, expr.Env(env), expr.Profile())
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		out, err := expr.Run(program, map[string]any{"xs": []int{1, 2, 6, 7}})
+		require.NoError(t, err)
+		assert.Equal(t, 3, out)
+	}
+
+	root := vm.GetSpan(program)
+	require.NotNil(t, root)
+	assert.Equal(t, 2, root.Hits)
+	assert.Greater(t, root.Duration, int64(0))
+
+	predicate := root.Children[0].Children[1]
+	assert.Equal(t, "# > 5", predicate.Expression)
+	assert.Equal(t, 8, predicate.Hits)
+	assert.LessOrEqual(t, predicate.Duration, root.Duration)
+
+	program, err = expr.Compile(`1 + 2`)
+	require.NoError(t, err)
+	assert.Nil(t, vm.GetSpan(program))
+}
+
+func TestRunAs(t *testing.T) {
+	env := map[string]any{"xs": []any{1, 2, 3}, "n": 0, "u": uint64(0)}
+	run := func(code string) *vm.Program {
+		program, err := expr.Compile(code, expr.Env(env))
+		require.NoError(t, err)
+		return program
+	}
+
+	b, err := expr.RunAs[bool](run(`n > 0`), map[string]any{"n": 1})
+	require.NoError(t, err)
+	assert.Equal(t, true, b)
+
+	f, err := expr.RunAs[float64](run(`n * 2`), map[string]any{"n": 2})
+	require.NoError(t, err)
+	assert.Equal(t, 4.0, f)
+
+	ints, err := expr.RunAs[[]int64](run(`filter(xs, # > 1)`), env)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{2, 3}, ints)
+
+	m, err := expr.RunAs[map[string]int](run(`{"a": n}`), map[string]any{"n": 1})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"a": 1}, m)
+
+	p, err := expr.RunAs[*int](run(`nil`), env)
+	require.NoError(t, err)
+	assert.Nil(t, p)
+
+	_, err = expr.RunAs[int](run(`n / 2`), map[string]any{"n": 3})
+	require.EqualError(t, err, "cannot convert result 1.5 (float64) to int")
+
+	_, err = expr.RunAs[uint](run(`-n`), map[string]any{"n": 1})
+	require.Error(t, err)
+
+	_, err = expr.RunAs[int64](run(`u`), map[string]any{"u": uint64(math.MaxUint64)})
+	require.Error(t, err)
+
+	u, err := expr.RunAs[uint8](run(`n * 2`), map[string]any{"n": 100})
+	require.NoError(t, err)
+	require.Equal(t, uint8(200), u)
+
+	_, err = expr.RunAs[string](run(`nil`), env)
+	require.EqualError(t, err, "cannot convert nil result to string")
+
+	_, err = expr.RunAs[bool](run(`xs[5]`), env)
+	require.Error(t, err)
+}
+
+func TestRunBatch(t *testing.T) {
+	type Row struct {
+		Value int
+	}
+	program, err := expr.Compile(`Value > 1 ? Value * 10 : 100 / Value`, expr.Env(Row{}))
+	require.NoError(t, err)
+
+	out, err := expr.RunBatch(program, []Row{{1}, {2}, {3}})
+	require.NoError(t, err)
+	assert.Equal(t, []any{100.0, 20, 30}, out)
+
+	out, err = expr.RunBatch(program, []Row{})
+	require.NoError(t, err)
+	assert.Empty(t, out)
+
+	program, err = expr.Compile(`xs[i]`)
+	require.NoError(t, err)
+	_, err = expr.RunBatch(program, []map[string]any{
+		{"xs": []int{1}, "i": 0},
+		{"xs": []int{1}, "i": 2},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "env 1: index out of range")
+
+	var fileErr *file.Error
+	assert.True(t, errors.As(err, &fileErr))
+}
+
+func TestRunBatchParallel(t *testing.T) {
+	program, err := expr.Compile(`xs[i] * 2`, expr.Env(map[string]any{"xs": []int{}, "i": 0}))
+	require.NoError(t, err)
+
+	xs := []int{1, 2, 3}
+	envs := make([]map[string]any, 1000)
+	want := make([]any, len(envs))
+	for i := range envs {
+		envs[i] = map[string]any{"xs": xs, "i": i % 3}
+		want[i] = xs[i%3] * 2
+	}
+
+	for _, workers := range []int{0, 1, 3, 8, 2000} {
+		out, err := expr.RunBatchParallel(program, envs, workers)
+		require.NoError(t, err)
+		assert.Equal(t, want, out)
+	}
+
+	envs[700]["i"] = 10
+	envs[300]["i"] = 10
+	_, err = expr.RunBatchParallel(program, envs, 4)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "env 300: index out of range")
+
+	out, err := expr.RunBatchParallel(program, []map[string]any{}, 4)
+	require.NoError(t, err)
+	assert.Empty(t, out)
+
+	program, err = expr.Compile(`xs[i] * 2`, expr.Env(map[string]any{"xs": []int{}, "i": 0}), expr.Profile())
+	require.NoError(t, err)
+	_, err = expr.RunBatchParallel(program, envs, 4)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot run program with profiling in parallel")
+}
+
+type featureStore struct {
+	values  map[string]any
+	fetched []string
+}
+
+func (s *featureStore) Fetch(name string) (any, error) {
+	s.fetched = append(s.fetched, name)
+	value, ok := s.values[name]
+	if !ok {
+		return nil, fmt.Errorf("feature %v is not found", name)
+	}
+	return value, nil
+}
+
+func TestGetter(t *testing.T) {
+	type User struct {
+		Age int
+	}
+	type Schema struct {
+		User   User
+		Score  float64
+		Banned bool
+	}
+
+	for _, declaration := range []any{
+		Schema{},
+		map[string]any{"User": User{}, "Score": 0.0, "Banned": false},
+	} {
+		t.Run(fmt.Sprintf("%T", declaration), func(t *testing.T) {
+			program, err := expr.Compile(`Banned || User.Age >= 18 && Score > 0.5`, expr.Env(declaration))
+			require.NoError(t, err)
+
+			store := &featureStore{values: map[string]any{
+				"Banned": false,
+				"User":   User{Age: 16},
+				"Score":  0.9,
+			}}
+			out, err := expr.Run(program, runtime.Getter(store.Fetch))
+			require.NoError(t, err)
+			assert.Equal(t, false, out)
+			assert.Equal(t, []string{"Banned", "User"}, store.fetched)
+
+			store = &featureStore{values: map[string]any{"Banned": false}}
+			_, err = expr.Run(program, runtime.Getter(store.Fetch))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "feature User is not found")
+		})
+	}
+}
+
+type fetchingRow struct {
+	Name string
+}
+
+func (fetchingRow) Fetch(name string) (any, error) {
+	return nil, fmt.Errorf("no row")
+}
+
+func TestGetter_fetch_method(t *testing.T) {
+	// Values with a Fetch method are not getters.
+	type Env struct {
+		fetchingRow
+		Items []any
+	}
+	env := Env{fetchingRow: fetchingRow{Name: "env"}, Items: []any{fetchingRow{Name: "item"}}}
+
+	out, err := expr.Eval(`Name + " " + Items[0].Name`, env)
+	require.NoError(t, err)
+	assert.Equal(t, "env item", out)
+}
+
+type layerGlobals struct {
+	MaxAmount float64
+	Currency  string
+}
+
+func (layerGlobals) Round(x float64) float64 { return float64(int(x)) }
+
+type layerRequest struct {
+	Amount   float64
+	Currency string `expr:"Currency"`
+}
+
+func TestLayers(t *testing.T) {
+	declaration := expr.Layers{
+		layerGlobals{},
+		map[string]any{"MaxAmount": 0.0, "Discount": 0.0},
+		layerRequest{},
+	}
+	program, err := expr.Compile(
+		`Round(Amount * (1 - Discount)) <= MaxAmount && Currency == "EUR"`,
+		expr.Env(declaration),
+	)
+	require.NoError(t, err)
+
+	env := expr.Layers{
+		layerGlobals{MaxAmount: 1000, Currency: "USD"},
+		map[string]any{"MaxAmount": 500.0, "Discount": 0.1},
+		layerRequest{Amount: 550, Currency: "EUR"},
+	}
+	out, err := expr.Run(program, env)
+	require.NoError(t, err)
+	assert.Equal(t, true, out)
+
+	// Without the tenant limit, the limit of globals is used.
+	env[1] = map[string]any{"Discount": 0.0}
+	out, err = expr.Run(program, env)
+	require.NoError(t, err)
+	assert.Equal(t, true, out)
+
+	env[2] = layerRequest{Amount: 1500, Currency: "EUR"}
+	out, err = expr.Run(program, env)
+	require.NoError(t, err)
+	assert.Equal(t, false, out)
+
+	_, err = expr.Compile(`Unknown > 0`, expr.Env(declaration))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown name Unknown")
+}
+
+func TestDivisionByZero(t *testing.T) {
+	type Env struct {
+		A    int
+		Zero int
+		F    float64
+	}
+	env := Env{A: 7, Zero: 0, F: 2.5}
+
+	tests := []struct {
+		code   string
+		policy vm.DivisionByZero
+		want   any
+		err    string
+	}{
+		{`A / Zero`, vm.DivisionDefault, math.Inf(1), ""},
+		{`A % Zero`, vm.DivisionDefault, nil, "integer divide by zero"},
+		{`A / Zero`, vm.DivisionError, nil, "division by zero"},
+		{`F / 0.0`, vm.DivisionError, nil, "division by zero"},
+		{`A % Zero`, vm.DivisionError, nil, "division by zero"},
+		{`A / Zero`, vm.DivisionNil, nil, ""},
+		{`A % Zero`, vm.DivisionNil, nil, ""},
+		{`-F / Zero`, vm.DivisionInf, math.Inf(-1), ""},
+		{`A % Zero`, vm.DivisionInf, 0, ""},
+		{`A / 2`, vm.DivisionError, 3.5, ""},
+		{`A % 4`, vm.DivisionNil, 3, ""},
+		{`7 / 0`, vm.DivisionError, nil, "division by zero"},
+		{`7 % 0`, vm.DivisionError, nil, "division by zero"},
+		{`7 / 0.0`, vm.DivisionNil, nil, ""},
+		{`7 % 0`, vm.DivisionInf, 0, ""},
+		{`(A / Zero) ?? -1`, vm.DivisionNil, -1, ""},
+		{`(A % Zero) ?? -1`, vm.DivisionNil, -1, ""},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%v %v", tt.code, tt.policy), func(t *testing.T) {
+			program, err := expr.Compile(tt.code, expr.Env(Env{}), expr.DivisionByZero(tt.policy))
+			require.NoError(t, err)
+
+			out, err := expr.Run(program, env)
+			if tt.err != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tt.err)
+				if tt.policy == vm.DivisionError {
+					require.ErrorIs(t, err, vm.ErrDivisionByZero)
+				}
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, out)
+		})
+	}
+
+	t.Run("map env", func(t *testing.T) {
+		env := map[string]any{"a": 1, "b": 0}
+		program, err := expr.Compile(`a / b`, expr.Env(env), expr.DivisionByZero(vm.DivisionNil))
+		require.NoError(t, err)
+
+		out, err := expr.Run(program, env)
+		require.NoError(t, err)
+		require.Nil(t, out)
+	})
+}
+
+// ratArithmetic is decimal arithmetic of exact rationals.
+type ratArithmetic struct{}
+
+func (ratArithmetic) Parse(literal string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(literal)
+	if !ok {
+		return nil, fmt.Errorf("invalid decimal %q", literal)
+	}
+	return r, nil
+}
+
+func (ratArithmetic) FromInt(i int) *big.Rat     { return new(big.Rat).SetInt64(int64(i)) }
+func (ratArithmetic) Add(a, b *big.Rat) *big.Rat { return new(big.Rat).Add(a, b) }
+func (ratArithmetic) Sub(a, b *big.Rat) *big.Rat { return new(big.Rat).Sub(a, b) }
+func (ratArithmetic) Mul(a, b *big.Rat) *big.Rat { return new(big.Rat).Mul(a, b) }
+func (ratArithmetic) Neg(a *big.Rat) *big.Rat    { return new(big.Rat).Neg(a) }
+func (ratArithmetic) Cmp(a, b *big.Rat) int      { return a.Cmp(b) }
+
+func (ratArithmetic) Div(a, b *big.Rat) (*big.Rat, error) {
+	if b.Sign() == 0 {
+		return nil, errors.New("division by zero")
+	}
+	return new(big.Rat).Quo(a, b), nil
+}
+
+func (r ratArithmetic) Mod(a, b *big.Rat) (*big.Rat, error) {
+	q, err := r.Div(a, b)
+	if err != nil {
+		return nil, err
+	}
+	n := new(big.Int).Quo(q.Num(), q.Denom())
+	return r.Sub(a, r.Mul(b, new(big.Rat).SetInt(n))), nil
+}
+
+func (r ratArithmetic) Pow(a, b *big.Rat) (*big.Rat, error) {
+	if !b.IsInt() || b.Sign() < 0 {
+		return nil, fmt.Errorf("unsupported exponent %v", b.RatString())
+	}
+	z := big.NewRat(1, 1)
+	for i := int64(0); i < b.Num().Int64(); i++ {
+		z = r.Mul(z, a)
+	}
+	return z, nil
+}
+
+func (r ratArithmetic) Round(a *big.Rat) *big.Rat {
+	half := big.NewRat(1, 2)
+	if a.Sign() < 0 {
+		half.Neg(half)
+	}
+	n := new(big.Int).Quo(r.Add(a, half).Num(), r.Add(a, half).Denom())
+	return new(big.Rat).SetInt(n)
+}
+
+func TestDecimal(t *testing.T) {
+	type Item struct {
+		Name string
+		Cost big.Rat
+	}
+	type Env struct {
+		Price    *big.Rat
+		Quantity int
+		Rate     float64
+		Name     string
+		Items    []Item
+		Half     func(float64) float64
+	}
+	env := Env{
+		Price:    big.NewRat(1999, 100),
+		Quantity: 3,
+		Rate:     0.2,
+		Items:    []Item{{"b", *big.NewRat(5, 2)}, {"a", *big.NewRat(3, 2)}},
+		Half:     func(x float64) float64 { return x / 2 },
+	}
+	rat := func(s string) *big.Rat {
+		r, _ := new(big.Rat).SetString(s)
+		return r
+	}
+
+	tests := []struct {
+		code string
+		want any
+	}{
+		{`0.1 + 0.2 == 0.3`, true},
+		{`0.1 + 0.2`, rat("0.3")},
+		{`Price * Quantity`, rat("59.97")},
+		{`Price * Quantity * (1 - Rate)`, rat("47.976")},
+		{`7 / 2`, rat("3.5")},
+		{`-Price + 20`, rat("0.01")},
+		{`Price % 5`, rat("4.99")},
+		{`Price > 19.98 && Price <= 19.99`, true},
+		{`Price != 19.99`, false},
+		{`Quantity * 2`, 6},
+		{`let total = Price * Quantity; total > 50.0 ? total - 10 : total`, rat("49.97")},
+		{`Price ** 2`, rat("399.6001")},
+		{`abs(-Price)`, rat("19.99")},
+		{`round(Price)`, rat("20")},
+		{`max(Price, 25, 1.5)`, rat("25")},
+		{`min([Price, 10.5])`, rat("10.5")},
+		{`sum([Price, 0.01])`, rat("20")},
+		{`sum(Items, .Cost)`, rat("4")},
+		{`Items[0].Cost + 0.1`, rat("2.6")},
+		{`sortBy(Items, .Cost)[0].Name`, "a"},
+		{`sortBy(Items, [.Cost, .Name], ["desc", "asc"])[0].Name`, "b"},
+		{`Half(0.5)`, 0.25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			program, err := expr.Compile(tt.code, expr.Env(Env{}), expr.Decimal[*big.Rat](ratArithmetic{}))
+			require.NoError(t, err)
+
+			out, err := expr.Run(program, env)
+			require.NoError(t, err)
+			if want, ok := tt.want.(*big.Rat); ok {
+				require.IsType(t, want, out)
+				require.Equal(t, want.String(), out.(*big.Rat).String())
+			} else {
+				require.Equal(t, tt.want, out)
+			}
+		})
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := expr.Compile(`Price + Name`, expr.Env(Env{}), expr.Decimal[*big.Rat](ratArithmetic{}))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid operation: + (mismatched types big.Rat and string)")
+
+		program, err := expr.Compile(`Price / (Quantity - 3)`, expr.Env(Env{}), expr.Decimal[*big.Rat](ratArithmetic{}))
+		require.NoError(t, err)
+		_, err = expr.Run(program, env)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "division by zero")
+	})
+
+	t.Run("map env", func(t *testing.T) {
+		env := map[string]any{"price": big.NewRat(1999, 100), "a": 1, "b": 2, "name": "x"}
+		tests := []struct {
+			code string
+			want any
+		}{
+			{`price + 0.01`, rat("20")},
+			{`price * a`, rat("19.99")},
+			{`price == 19.99`, true},
+			{`-price`, rat("-19.99")},
+			{`a + b`, 3},
+			{`a / b`, rat("0.5")},
+			{`name + "y"`, "xy"},
+		}
+		for _, tt := range tests {
+			program, err := expr.Compile(tt.code, expr.Env(env), expr.Decimal[*big.Rat](ratArithmetic{}))
+			require.NoError(t, err, tt.code)
+
+			out, err := expr.Run(program, env)
+			require.NoError(t, err, tt.code)
+			if want, ok := tt.want.(*big.Rat); ok {
+				require.IsType(t, want, out, tt.code)
+				require.Equal(t, want.String(), out.(*big.Rat).String(), tt.code)
+			} else {
+				require.Equal(t, tt.want, out, tt.code)
+			}
+		}
+	})
+}
+
+func TestBigInt(t *testing.T) {
+	type Env struct {
+		Amount *big.Int
+		Max    int
+		Any    any
+		Name   string
+	}
+	amount, _ := new(big.Int).SetString("1000000000000000000000", 10)
+	env := Env{Amount: amount, Max: math.MaxInt, Any: big.NewInt(10)}
+
+	tests := []struct {
+		code string
+		want any
+	}{
+		{`bigint("123456789012345678901234567890") + 1`, "123456789012345678901234567891"},
+		{`bigint("0xff") == 255`, true},
+		{`bigint(1.9)`, "1"},
+		{`Amount * 3 - 1`, "2999999999999999999999"},
+		{`-Amount`, "-1000000000000000000000"},
+		{`Amount % 7`, "6"},
+		{`Amount / 4`, 2.5e20},
+		{`Amount > Max`, true},
+		{`Amount == Amount + 0`, true},
+		{`Any * Any`, "100"},
+		{`int(bigint(42))`, 42},
+		{`float(Amount)`, 1e21},
+		{`bigint(1) == 1.0`, true},
+		{`bigint(1) ** 2`, 1.0},
+		{`bigint(5) in [5]`, true},
+		{`Amount + 0.5`, 1e21},
+		{`Amount < 1.5e21`, true},
+		{`abs(-Amount)`, "1000000000000000000000"},
+		{`max(Amount, 1)`, "1000000000000000000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			program, err := expr.Compile(tt.code, expr.Env(Env{}))
+			require.NoError(t, err)
+
+			out, err := expr.Run(program, env)
+			require.NoError(t, err)
+			if n, ok := out.(*big.Int); ok {
+				out = n.String()
+			}
+			require.Equal(t, tt.want, out)
+		})
+	}
+
+	t.Run("promote", func(t *testing.T) {
+		type Env struct {
+			Max int
+			One int
+			Id  func(int) int
+		}
+		env := Env{Max: math.MaxInt, One: 1, Id: func(x int) int { return x }}
+
+		tests := []struct {
+			code string
+			want any
+		}{
+			{`(Max + 1) * 2 > Max`, true},
+			{`Max * Max`, "85070591730234615847396907784232501249"},
+			{`[Max + 1]`, []any{"9223372036854775808"}},
+			{`Id(Max - One)`, math.MaxInt - 1},
+			{`abs(-Max - 2)`, "9223372036854775809"},
+			{`max(Max + 1, One)`, "9223372036854775808"},
+			{`Max + 1 + 1.5`, float64(math.MaxInt) + 2.5},
+			{`1..(One + 1)`, []int{1, 2}},
+			{`-(-Max - 1)`, "9223372036854775808"},
+		}
+		for _, tt := range tests {
+			program, err := expr.Compile(tt.code, expr.Env(Env{}), expr.PromoteOverflow())
+			require.NoError(t, err, tt.code)
+
+			out, err := vm.Run(program, env, vm.StrictArithmetic(vm.OverflowPromote))
+			require.NoError(t, err, tt.code)
+			switch x := out.(type) {
+			case *big.Int:
+				out = x.String()
+			case []any:
+				out = []any{x[0].(*big.Int).String()}
+			}
+			require.Equal(t, tt.want, out, tt.code)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := expr.Compile(`Amount + Name`, expr.Env(Env{}))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid operation: + (mismatched types big.Int and string)")
+
+		_, err = expr.Eval(`bigint("12x")`, nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `invalid big integer "12x"`)
+	})
+}
+
+func TestJSONSchema(t *testing.T) {
+	schema := []byte(`{
+		"type": "object",
+		"properties": {
+			"user": {"$ref": "#/$defs/user"},
+			"tags": {"type": "array", "items": {"type": "string"}},
+			"items": {
+				"type": "array",
+				"items": {"type": "object", "properties": {"price": {"type": "number"}, "qty": {"type": "integer"}}}
+			},
+			"meta": {"type": "object", "additionalProperties": {"type": "string"}},
+			"note": {"type": ["string", "null"]}
+		},
+		"$defs": {
+			"user": {
+				"type": "object",
+				"properties": {
+					"name": {"type": "string"},
+					"age": {"type": ["number", "null"]},
+					"email": {"type": "string"},
+					"address": {"type": "object", "properties": {"city": {"type": "string"}}}
+				}
+			}
+		}
+	}`)
+
+	var env map[string]any
+	err := json.Unmarshal([]byte(`{
+		"user": {"name": "Ann", "age": null, "address": {"city": "Oslo"}},
+		"tags": ["a", "b"],
+		"items": [{"price": 2.5, "qty": 2}, {"price": 1, "qty": 3}],
+		"meta": {"k": "v"},
+		"note": null
+	}`), &env)
+	require.NoError(t, err)
+
+	tests := []struct {
+		code string
+		want any
+	}{
+		{`user.name + "!"`, "Ann!"},
+		{`user.address.city`, "Oslo"},
+		{`user?.address?.city`, "Oslo"},
+		{`"a" in tags`, true},
+		{`sum(items, .price * .qty)`, 8.0},
+		{`items[1].qty > 2`, true},
+		{`meta.k`, "v"},
+		{`note ?? "none"`, "none"},
+		{`note == "x"`, false},
+		{`user.age == 1.5`, false},
+		{`user.email == "x"`, false},
+		{`[user, user][1].name`, "Ann"},
+		{`user[lower("NAME")]`, "Ann"},
+		{`len(user)`, 3},
+		{`user.name in ["Ann"]`, true},
+		{`"name" in user`, true},
+		{`items[0].price == 2.5`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			program, err := expr.Compile(tt.code, expr.JSONSchema(schema))
+			require.NoError(t, err)
+
+			out, err := expr.Run(program, env)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, out)
+		})
+	}
+
+	errors := []struct {
+		code string
+		err  string
+	}{
+		{`missing`, "unknown name missing"},
+		{`user.address.zip`, "has no field zip"},
+		{`user.name + 1`, "invalid operation: + (mismatched types string and int)"},
+		{`items[0].price + "x"`, "invalid operation: + (mismatched types float64 and string)"},
+	}
+
+	for _, tt := range errors {
+		t.Run(tt.code, func(t *testing.T) {
+			_, err := expr.Compile(tt.code, expr.JSONSchema(schema))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestGeneric(t *testing.T) {
+	env := map[string]any{
+		"max": genericMax[int],
+		"box": genericBox[string]{Value: "foo"},
+		"X":   2,
+		"I32": int32(3),
+		"I64": int64(math.MaxInt32 + 1),
+	}
+	option := expr.Generic("gmax", genericMax[int], genericMax[float64], genericMax[string])
+
+	tests := []struct {
+		code string
+		want any
+	}{
+		{`max(1, 2)`, 2},
+		{`box.Or("bar")`, "foo"},
+		{`box.Value + "bar"`, "foobar"},
+		{`gmax(1, 2)`, 2},
+		{`gmax(1.5, 0.5)`, 1.5},
+		{`gmax("a", "b")`, "b"},
+		{`gmax(1, 2) + max(3, 4)`, 6},
+		{`gmax(1.5, X)`, 2.0},
+		{`gmax(X, 0.5)`, 2.0},
+		{`gmax(I32, I32)`, 3},
+		{`gmax(I32, X)`, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			program, err := expr.Compile(tt.code, expr.Env(env), option)
+			require.NoError(t, err)
+
+			out, err := expr.Run(program, env)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, out)
+		})
+	}
+
+	_, err := expr.Compile(`box.Or(1)`, expr.Env(env))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot use int as argument (type string) to call Or")
+
+	// Integers which don't fit the parameter of the instantiation are
+	// rejected at runtime.
+	int32s := expr.Overload("gmax32", func(a, b int32) int32 {
+		if a > b {
+			return a
+		}
+		return b
+	})
+	program, err := expr.Compile(`gmax32(I64, I32)`, expr.Env(env), int32s)
+	require.NoError(t, err)
+	_, err = expr.Run(program, env)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot call gmax32 with arguments [int64 int32]")
+
+	_, err = expr.Compile(`gmax(1, "a")`, expr.Env(env), option)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot use int as argument (type string) to call gmax")
+}
+
+// Nil coalescing operator
+func TestRun_NilCoalescingOperator(t *testing.T) {
+	env := map[string]any{
+		"foo": map[string]any{
+			"bar": "value",
+		},
+	}
+
+	t.Run("value", func(t *testing.T) {
+		p, err := expr.Compile(`foo.bar ?? "default"`, expr.Env(env))
+		assert.NoError(t, err)
+
+		out, err := expr.Run(p, env)
+		assert.NoError(t, err)
+		assert.Equal(t, "value", out)
+	})
+
+	t.Run("default", func(t *testing.T) {
+		p, err := expr.Compile(`foo.baz ?? "default"`, expr.Env(env))
+		assert.NoError(t, err)
+
+		out, err := expr.Run(p, env)
+		assert.NoError(t, err)
+		assert.Equal(t, "default", out)
+	})
 
 	t.Run("default with chain", func(t *testing.T) {
 		p, err := expr.Compile(`foo?.bar ?? "default"`, expr.Env(env))
@@ -2047,6 +3905,75 @@ func TestEval_nil_in_maps(t *testing.T) {
 
 // Test the use of env keyword.  Forms env[] and env[”] are valid.
 // The enclosed identifier must be in the expression env.
+type interfaceEnv interface {
+	Price(sku string) float64
+	Discount(user string, percents ...int) (int, error)
+}
+
+type interfaceEnvMock struct{}
+
+func (interfaceEnvMock) Extra() int { return 0 }
+
+func (interfaceEnvMock) Price(sku string) float64 { return float64(len(sku)) }
+
+func (interfaceEnvMock) Discount(user string, percents ...int) (int, error) {
+	if user == "" {
+		return 0, fmt.Errorf("unknown user")
+	}
+	return len(percents), nil
+}
+
+func TestEnv_interface(t *testing.T) {
+	tests := []struct {
+		code string
+		want any
+	}{
+		{`Price("apple") * 2`, 10.0},
+		{`Discount("bob", 10, 20)`, 2},
+		{`Discount("bob") + Price("")`, 0.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			program, err := expr.Compile(tt.code, expr.Env((*interfaceEnv)(nil)))
+			require.NoError(t, err)
+
+			out, err := expr.Run(program, interfaceEnvMock{})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, out)
+
+			out, err = expr.Run(program, &interfaceEnvMock{})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, out)
+		})
+	}
+
+	errors := []struct {
+		code string
+		err  string
+	}{
+		{`Price(42)`, "cannot use int as argument (type string) to call Price"},
+		{`Price()`, "not enough arguments to call Price"},
+		{`Discount("bob", "10")`, "cannot use string as argument (type int) to call Discount"},
+		{`Price("apple") + "$"`, "invalid operation: + (mismatched types float64 and string)"},
+		{`Extra()`, "unknown name Extra"},
+	}
+
+	for _, tt := range errors {
+		t.Run(tt.code, func(t *testing.T) {
+			_, err := expr.Compile(tt.code, expr.Env((*interfaceEnv)(nil)))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+
+	program, err := expr.Compile(`Discount("", 10)`, expr.Env((*interfaceEnv)(nil)))
+	require.NoError(t, err)
+	_, err = expr.Run(program, interfaceEnvMock{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown user")
+}
+
 func TestEnv_keyword(t *testing.T) {
 	env := map[string]any{
 		"space test":                       "ok",
@@ -20,18 +20,8 @@ func (e *Error) Error() string {
 }
 
 func (e *Error) Bind(source Source) *Error {
-	e.Line = 1
-	for i, r := range source {
-		if i == e.From {
-			break
-		}
-		if r == '\n' {
-			e.Line++
-			e.Column = 0
-		} else {
-			e.Column++
-		}
-	}
+	pos := source.Position(e.From)
+	e.Line, e.Column = pos.Line, pos.Column
 	if snippet, found := source.Snippet(e.Line); found {
 		snippet := strings.Replace(snippet, "\t", " ", -1)
 		srcLine := "\n | " + snippet
@@ -79,3 +69,23 @@ func (e *Error) format() string {
 		e.Snippet,
 	)
 }
+
+// ErrorList is a list of errors found in one expression.
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	messages := make([]string, len(l))
+	for i, err := range l {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Unwrap returns errors of the list, so errors.As can find any of them.
+func (l ErrorList) Unwrap() []error {
+	errs := make([]error, len(l))
+	for i, err := range l {
+		errs[i] = err
+	}
+	return errs
+}
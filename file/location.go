@@ -4,3 +4,9 @@ type Location struct {
 	From int `json:"from"`
 	To   int `json:"to"`
 }
+
+// Position is a line and a column in the source.
+type Position struct {
+	Line   int `json:"line"`   // Line, starting from 1.
+	Column int `json:"column"` // Column in runes, starting from 0.
+}
@@ -37,6 +37,23 @@ func (s Source) Snippet(line int) (string, bool) {
 	return string(s[charStart:]), true
 }
 
+// Position returns the line and the column of the offset in the source.
+func (s Source) Position(offset int) Position {
+	pos := Position{Line: 1}
+	for i, r := range s {
+		if i == offset {
+			break
+		}
+		if r == '\n' {
+			pos.Line++
+			pos.Column = 0
+		} else {
+			pos.Column++
+		}
+	}
+	return pos
+}
+
 func getLineOffset(lineOffsets []int, line int) (int, bool) {
 	if line == 1 {
 		return 0, true
@@ -6,6 +6,8 @@ import (
 	"strings"
 
 	. "github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/builtin"
+	"github.com/expr-lang/expr/conf"
 	"github.com/expr-lang/expr/file"
 )
 
@@ -15,6 +17,7 @@ type constExpr struct {
 	applied bool
 	err     error
 	fns     map[string]reflect.Value
+	config  *conf.Config
 }
 
 func (c *constExpr) Visit(node *Node) {
@@ -35,51 +38,105 @@ func (c *constExpr) Visit(node *Node) {
 		Patch(node, newNode)
 	}
 
-	if call, ok := (*node).(*CallNode); ok {
-		if name, ok := call.Callee.(*IdentifierNode); ok {
-			fn, ok := c.fns[name.Value]
-			if ok {
-				in := make([]reflect.Value, len(call.Arguments))
-				for i := 0; i < len(call.Arguments); i++ {
-					arg := call.Arguments[i]
-					var param any
+	switch n := (*node).(type) {
+	case *CallNode:
+		name, ok := n.Callee.(*IdentifierNode)
+		if !ok || n.Spread {
+			return
+		}
+		if fn, ok := c.fns[name.Value]; ok {
+			in, ok := constArguments(n.Arguments)
+			if !ok {
+				return // Const expr optimization not applicable.
+			}
+			out := fn.Call(in)
+			value := out[0].Interface()
+			if len(out) == 2 && out[1].Type() == errorType && !out[1].IsNil() {
+				c.err = out[1].Interface().(error)
+				return
+			}
+			patch(&ConstantNode{Value: value})
+			return
+		}
+		if c.config != nil {
+			if fn, ok := c.config.Functions[name.Value]; ok && fn.Pure {
+				c.call(n.Arguments, fn, patch)
+			}
+		}
 
-					switch a := arg.(type) {
-					case *NilNode:
-						param = nil
-					case *IntegerNode:
-						param = a.Value
-					case *FloatNode:
-						param = a.Value
-					case *BoolNode:
-						param = a.Value
-					case *StringNode:
-						param = a.Value
-					case *ConstantNode:
-						param = a.Value
+	case *BuiltinNode:
+		if c.config != nil {
+			if fn, ok := c.config.Builtins[n.Name]; ok && fn.Pure {
+				c.call(n.Arguments, fn, patch)
+			}
+		}
+	}
+}
 
-					default:
-						return // Const expr optimization not applicable.
-					}
+// call evaluates the pure function if all arguments are constants. Calls
+// which fail are left for runtime, as they may be in branches which are
+// never evaluated, like "ok ? x : lower(nil)".
+func (c *constExpr) call(arguments []Node, fn *builtin.Function, patch func(Node)) {
+	in, ok := constArguments(arguments)
+	if !ok {
+		return
+	}
+	args := make([]any, len(in))
+	for i, arg := range in {
+		args[i] = arg.Interface()
+	}
+	if value, ok := callPure(fn, args); ok {
+		patch(&ConstantNode{Value: value})
+	}
+}
+
+func callPure(fn *builtin.Function, args []any) (value any, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			ok = false
+		}
+	}()
+	switch {
+	case fn.Fast != nil && len(args) == 1:
+		return fn.Fast(args[0]), true
+	case fn.Func != nil:
+		value, err := fn.Func(args...)
+		return value, err == nil
+	}
+	return nil, false
+}
 
-					if param == nil && reflect.TypeOf(param) == nil {
-						// In case of nil value and nil type use this hack,
-						// otherwise reflect.Call will panic on zero value.
-						in[i] = reflect.ValueOf(&param).Elem()
-					} else {
-						in[i] = reflect.ValueOf(param)
-					}
-				}
+// constArguments returns values of arguments, if all of them are constants.
+func constArguments(arguments []Node) ([]reflect.Value, bool) {
+	in := make([]reflect.Value, len(arguments))
+	for i, arg := range arguments {
+		var param any
 
-				out := fn.Call(in)
-				value := out[0].Interface()
-				if len(out) == 2 && out[1].Type() == errorType && !out[1].IsNil() {
-					c.err = out[1].Interface().(error)
-					return
-				}
-				constNode := &ConstantNode{Value: value}
-				patch(constNode)
-			}
+		switch a := arg.(type) {
+		case *NilNode:
+			param = nil
+		case *IntegerNode:
+			param = a.Value
+		case *FloatNode:
+			param = a.Value
+		case *BoolNode:
+			param = a.Value
+		case *StringNode:
+			param = a.Value
+		case *ConstantNode:
+			param = a.Value
+
+		default:
+			return nil, false
+		}
+
+		if param == nil && reflect.TypeOf(param) == nil {
+			// In case of nil value and nil type use this hack,
+			// otherwise reflect.Call will panic on zero value.
+			in[i] = reflect.ValueOf(&param).Elem()
+		} else {
+			in[i] = reflect.ValueOf(param)
 		}
 	}
+	return in, true
 }
@@ -0,0 +1,102 @@
+package optimizer
+
+import (
+	"reflect"
+
+	. "github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/conf"
+)
+
+// exponent replaces x ** 2 and x ** 3 with multiplications, which are
+// cheaper than math.Pow. The result is float64, the same as of **, so
+// integers are converted with float() before the multiplication.
+type exponent struct {
+	config *conf.Config
+}
+
+func (e *exponent) Visit(node *Node) {
+	n, ok := (*node).(*BinaryNode)
+	if !ok || (n.Operator != "**" && n.Operator != "^") {
+		return
+	}
+	power, ok := n.Right.(*IntegerNode)
+	if !ok || (power.Value != 2 && power.Value != 3) {
+		return
+	}
+
+	base := n.Left
+	switch base.Type() {
+	case floatType:
+	case integerType:
+		if e.config == nil || e.config.Builtins["float"] == nil {
+			return
+		}
+		float := &BuiltinNode{Name: "float", Arguments: []Node{base}}
+		float.SetType(floatType)
+		float.SetLocation(base.Location())
+		base = float
+	default:
+		return
+	}
+
+	operand := func() Node { return clone(base) }
+	var let *VariableDeclaratorNode
+	if !isCheap(base) {
+		// The base is evaluated once and stored in a variable.
+		let = &VariableDeclaratorNode{Name: "$pow", Value: base}
+		operand = func() Node {
+			id := &IdentifierNode{Value: let.Name}
+			id.SetType(floatType)
+			id.SetLocation(n.Location())
+			return id
+		}
+	}
+
+	var product Node = operand()
+	for i := 1; i < power.Value; i++ {
+		product = &BinaryNode{Operator: "*", Left: product, Right: operand()}
+		product.SetType(floatType)
+		product.SetLocation(n.Location())
+	}
+	if let != nil {
+		let.Expr = product
+		let.SetType(floatType)
+		product = let
+	}
+	Patch(node, product)
+}
+
+// clone copies the cheap node, so it is not shared by several parents.
+func clone(node Node) Node {
+	var out Node
+	switch n := node.(type) {
+	case *IdentifierNode:
+		out = &IdentifierNode{Value: n.Value}
+	case *PointerNode:
+		out = &PointerNode{Name: n.Name}
+	case *StringNode:
+		out = &StringNode{Value: n.Value}
+	case *MemberNode:
+		out = &MemberNode{Node: clone(n.Node), Property: clone(n.Property), Optional: n.Optional, Method: n.Method}
+	default:
+		return node
+	}
+	out.SetType(node.Type())
+	out.SetLocation(node.Location())
+	return out
+}
+
+// isCheap reports whether the node is cheap to evaluate more than once and
+// has no side effects, like a variable or a field.
+func isCheap(node Node) bool {
+	switch n := node.(type) {
+	case *IdentifierNode, *PointerNode:
+		return true
+	case *MemberNode:
+		if _, ok := n.Property.(*StringNode); !ok || n.Optional {
+			return false
+		}
+		return n.Node.Type() != nil && n.Node.Type().Kind() == reflect.Struct && isCheap(n.Node)
+	}
+	return false
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math"
 	"reflect"
+	"regexp"
 
 	. "github.com/expr-lang/expr/ast"
 	"github.com/expr-lang/expr/file"
@@ -18,6 +19,7 @@ var (
 type fold struct {
 	applied bool
 	err     *file.Error
+	keep    bool // Keep division by zero to the policy of the program.
 }
 
 func (fold *fold) Visit(node *Node) {
@@ -159,6 +161,9 @@ func (fold *fold) Visit(node *Node) {
 				}
 			}
 		case "/":
+			if fold.keep && isZero(n.Right) {
+				return
+			}
 			{
 				a := toInteger(n.Left)
 				b := toInteger(n.Right)
@@ -188,6 +193,9 @@ func (fold *fold) Visit(node *Node) {
 				}
 			}
 		case "%":
+			if fold.keep && isZero(n.Right) {
+				return
+			}
 			if a, ok := n.Left.(*IntegerNode); ok {
 				if b, ok := n.Right.(*IntegerNode); ok {
 					if b.Value == 0 {
@@ -273,6 +281,18 @@ func (fold *fold) Visit(node *Node) {
 					patch(&BoolNode{Value: a.Value == b.Value})
 				}
 			}
+		case "matches":
+			// Patterns folded from constants, like "^a" + "b", are not
+			// checked by the checker, so they are checked here.
+			if pattern, ok := n.Right.(*StringNode); ok {
+				if _, err := regexp.Compile(pattern.Value); err != nil {
+					fold.err = &file.Error{
+						Location: (*node).Location(),
+						Message:  err.Error(),
+					}
+					return
+				}
+			}
 		}
 
 	case *ArrayNode:
@@ -298,6 +318,15 @@ func (fold *fold) Visit(node *Node) {
 					value[i] = b.Value
 				}
 			}
+			if t := n.Type(); t != nil && t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Interface {
+				// Keep the type of array literal, like []int, inferred by checker.
+				typed := reflect.MakeSlice(t, len(value), len(value))
+				for i, v := range value {
+					typed.Index(i).Set(reflect.ValueOf(v).Convert(t.Elem()))
+				}
+				patch(&ConstantNode{Value: typed.Interface()})
+				return
+			}
 			patch(&ConstantNode{Value: value})
 		}
 
@@ -348,6 +377,16 @@ func toFloat(n Node) *FloatNode {
 	return nil
 }
 
+func isZero(n Node) bool {
+	if a := toInteger(n); a != nil {
+		return a.Value == 0
+	}
+	if a := toFloat(n); a != nil {
+		return a.Value == 0
+	}
+	return false
+}
+
 func toBool(n Node) *BoolNode {
 	switch a := n.(type) {
 	case *BoolNode:
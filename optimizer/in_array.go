@@ -4,61 +4,133 @@ import (
 	"reflect"
 
 	. "github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/vm/runtime"
 )
 
+// inArray replaces constant arrays on the right side of the "in" operator
+// with sets, so membership is checked by a map lookup instead of a scan.
 type inArray struct{}
 
 func (*inArray) Visit(node *Node) {
-	switch n := (*node).(type) {
-	case *BinaryNode:
-		if n.Operator == "in" {
-			if array, ok := n.Right.(*ArrayNode); ok {
-				if len(array.Nodes) > 0 {
-					t := n.Left.Type()
-					if t == nil || t.Kind() != reflect.Int {
-						// This optimization can be only performed if left side is int type,
-						// as runtime.in func uses reflect.Map.MapIndex and keys of map must,
-						// be same as checked value type.
-						goto string
-					}
+	n, ok := (*node).(*BinaryNode)
+	if !ok || n.Operator != "in" {
+		return
+	}
+	values, ok := constantValues(n.Right)
+	if !ok || len(values) == 0 {
+		return
+	}
+	set, ok := setOf(n.Left.Type(), values)
+	if !ok {
+		return
+	}
+	Patch(node, &BinaryNode{
+		Operator: n.Operator,
+		Left:     n.Left,
+		Right:    &ConstantNode{Value: set.Interface()},
+	})
+}
 
-					for _, a := range array.Nodes {
-						if _, ok := a.(*IntegerNode); !ok {
-							goto string
-						}
-					}
-					{
-						value := make(map[int]struct{})
-						for _, a := range array.Nodes {
-							value[a.(*IntegerNode).Value] = struct{}{}
-						}
-						Patch(node, &BinaryNode{
-							Operator: n.Operator,
-							Left:     n.Left,
-							Right:    &ConstantNode{Value: value},
-						})
-					}
+// constantValues returns values of an array literal or a folded constant array.
+func constantValues(node Node) ([]any, bool) {
+	switch n := node.(type) {
+	case *ArrayNode:
+		values := make([]any, len(n.Nodes))
+		for i, a := range n.Nodes {
+			switch b := a.(type) {
+			case *IntegerNode:
+				values[i] = b.Value
+			case *FloatNode:
+				values[i] = b.Value
+			case *StringNode:
+				values[i] = b.Value
+			case *BoolNode:
+				values[i] = b.Value
+			default:
+				return nil, false
+			}
+		}
+		return values, true
+	case *ConstantNode:
+		v := reflect.ValueOf(n.Value)
+		if v.Kind() != reflect.Slice {
+			return nil, false
+		}
+		values := make([]any, v.Len())
+		for i := range values {
+			values[i] = v.Index(i).Interface()
+		}
+		return values, true
+	}
+	return nil, false
+}
+
+// setOf creates a map[T]struct{} of the values, where T is the type of
+// the needle. If the type of the needle is unknown, T is the common type
+// of the values, and needles of other types are converted by runtime.In.
+// Values which can not be equal to any value of T, like 300 for int8, are
+// skipped.
+func setOf(needle reflect.Type, values []any) (reflect.Value, bool) {
+	t := needle
+	if t == nil || t.Kind() == reflect.Interface {
+		if t = commonType(values); t == nil {
+			return reflect.Value{}, false
+		}
+	}
+	if !isSetKey(t) || t.Name() != t.Kind().String() {
+		// Values of named types, like time.Weekday, are not equal to
+		// values of predeclared types, so they are scanned.
+		return reflect.Value{}, false
+	}
 
-				string:
-					for _, a := range array.Nodes {
-						if _, ok := a.(*StringNode); !ok {
-							return
-						}
-					}
-					{
-						value := make(map[string]struct{})
-						for _, a := range array.Nodes {
-							value[a.(*StringNode).Value] = struct{}{}
-						}
-						Patch(node, &BinaryNode{
-							Operator: n.Operator,
-							Left:     n.Left,
-							Right:    &ConstantNode{Value: value},
-						})
-					}
+	set := reflect.MakeMapWithSize(reflect.MapOf(t, reflect.TypeOf(struct{}{})), len(values))
+	for _, value := range values {
+		v := reflect.ValueOf(value)
+		if !isSetKey(v.Type()) || isNumber(v.Type()) != isNumber(t) {
+			return reflect.Value{}, false
+		}
+		if !v.Type().ConvertibleTo(t) || v.Kind() == reflect.String && t.Kind() != reflect.String {
+			return reflect.Value{}, false
+		}
+		key := v.Convert(t)
+		if key.Convert(v.Type()).Interface() != value {
+			continue
+		}
+		set.SetMapIndex(key, reflect.ValueOf(struct{}{}))
+	}
+	return set, true
+}
 
-				}
-			}
+// commonType returns int, float64, string or bool if all values are of
+// this type. Mixed ints and floats are float64.
+func commonType(values []any) reflect.Type {
+	var t reflect.Type
+	for _, value := range values {
+		vt := reflect.TypeOf(value)
+		switch {
+		case t == nil || t == vt:
+			t = vt
+		case isNumber(t) && isNumber(vt):
+			t = reflect.TypeOf(float64(0))
+		default:
+			return nil
 		}
 	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Float64, reflect.String, reflect.Bool:
+		return t
+	}
+	return nil
+}
+
+func isSetKey(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.String:
+		return true
+	}
+	return isNumber(t)
+}
+
+func isNumber(t reflect.Type) bool {
+	return runtime.IsNumberKind(t.Kind())
 }
@@ -6,38 +6,62 @@ import (
 	. "github.com/expr-lang/expr/ast"
 )
 
+// inRange replaces "x in a..b" with "x >= a and x <= b", so the range is
+// not created at runtime. The needle and the bounds are evaluated twice,
+// so only integers without side effects, like variables, are rewritten.
 type inRange struct{}
 
 func (*inRange) Visit(node *Node) {
-	switch n := (*node).(type) {
-	case *BinaryNode:
-		if n.Operator == "in" {
-			t := n.Left.Type()
-			if t == nil {
-				return
-			}
-			if t.Kind() != reflect.Int {
-				return
-			}
-			if rangeOp, ok := n.Right.(*BinaryNode); ok && rangeOp.Operator == ".." {
-				if from, ok := rangeOp.Left.(*IntegerNode); ok {
-					if to, ok := rangeOp.Right.(*IntegerNode); ok {
-						Patch(node, &BinaryNode{
-							Operator: "and",
-							Left: &BinaryNode{
-								Operator: ">=",
-								Left:     n.Left,
-								Right:    from,
-							},
-							Right: &BinaryNode{
-								Operator: "<=",
-								Left:     n.Left,
-								Right:    to,
-							},
-						})
-					}
-				}
-			}
-		}
+	n, ok := (*node).(*BinaryNode)
+	if !ok || n.Operator != "in" {
+		return
 	}
+	rangeOp, ok := n.Right.(*BinaryNode)
+	if !ok || rangeOp.Operator != ".." {
+		return
+	}
+	if !isPureInteger(n.Left) || !isPureInteger(rangeOp.Left) || !isPureInteger(rangeOp.Right) {
+		return
+	}
+	Patch(node, &BinaryNode{
+		Operator: "and",
+		Left: &BinaryNode{
+			Operator: ">=",
+			Left:     n.Left,
+			Right:    rangeOp.Left,
+		},
+		Right: &BinaryNode{
+			Operator: "<=",
+			Left:     n.Left,
+			Right:    rangeOp.Right,
+		},
+	})
+}
+
+// isPureInteger reports whether the node is an integer of a predeclared type
+// which can be evaluated more than once: a literal, a variable or a field.
+func isPureInteger(node Node) bool {
+	t := node.Type()
+	if t == nil || t.Name() != t.Kind().String() {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		return false
+	}
+	return isPure(node)
+}
+
+func isPure(node Node) bool {
+	switch n := node.(type) {
+	case *IntegerNode, *StringNode, *IdentifierNode, *PointerNode:
+		return true
+	case *UnaryNode:
+		return isPure(n.Node)
+	case *MemberNode:
+		return !n.Method && !n.Optional && isPure(n.Node) && isPure(n.Property)
+	}
+	return false
 }
@@ -3,12 +3,12 @@ package optimizer
 import (
 	. "github.com/expr-lang/expr/ast"
 	"github.com/expr-lang/expr/conf"
+	"github.com/expr-lang/expr/vm"
 )
 
 func Optimize(node *Node, config *conf.Config) error {
-	Walk(node, &inArray{})
 	for limit := 1000; limit >= 0; limit-- {
-		fold := &fold{}
+		fold := &fold{keep: config != nil && config.DivisionByZero != vm.DivisionDefault}
 		Walk(node, fold)
 		if fold.err != nil {
 			return fold.err
@@ -17,10 +17,11 @@ func Optimize(node *Node, config *conf.Config) error {
 			break
 		}
 	}
-	if config != nil && len(config.ConstFns) > 0 {
+	if config != nil {
 		for limit := 100; limit >= 0; limit-- {
 			constExpr := &constExpr{
-				fns: config.ConstFns,
+				fns:    config.ConstFns,
+				config: config,
 			}
 			Walk(node, constExpr)
 			if constExpr.err != nil {
@@ -31,6 +32,8 @@ func Optimize(node *Node, config *conf.Config) error {
 			}
 		}
 	}
+	Walk(node, &exponent{config: config})
+	Walk(node, &inArray{})
 	Walk(node, &inRange{})
 	Walk(node, &filterMap{})
 	Walk(node, &filterLen{})
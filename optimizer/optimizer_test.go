@@ -2,9 +2,11 @@ package optimizer_test
 
 import (
 	"fmt"
+	"math"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/expr-lang/expr/internal/testify/assert"
 	"github.com/expr-lang/expr/internal/testify/require"
@@ -153,6 +155,46 @@ func TestOptimize_in_array(t *testing.T) {
 	assert.Equal(t, ast.Dump(expected), ast.Dump(tree.Node))
 }
 
+func TestOptimize_in_array_set(t *testing.T) {
+	env := map[string]any{
+		"day":  time.Monday,
+		"any":  any(nil),
+		"i8":   int8(0),
+		"name": "",
+	}
+	tests := []struct {
+		code string
+		want any
+	}{
+		{`day in [1, 2]`, nil},
+		{`any in [1, -2, 1 + 2]`, map[int]struct{}{1: {}, -2: {}, 3: {}}},
+		{`any in [1, 2.5]`, map[float64]struct{}{1: {}, 2.5: {}}},
+		{`i8 in [1, 300]`, map[int8]struct{}{1: {}}},
+		{`name in ["a", "b"]`, map[string]struct{}{"a": {}, "b": {}}},
+		{`any in ["a", 1]`, nil},
+		{`name in []`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			tree, err := checker.ParseCheck(tt.code, conf.New(env))
+			require.NoError(t, err)
+
+			err = optimizer.Optimize(&tree.Node, nil)
+			require.NoError(t, err)
+
+			right := tree.Node.(*ast.BinaryNode).Right
+			if tt.want == nil {
+				if c, ok := right.(*ast.ConstantNode); ok {
+					assert.Equal(t, reflect.Slice, reflect.TypeOf(c.Value).Kind())
+				}
+				return
+			}
+			require.IsType(t, &ast.ConstantNode{}, right)
+			assert.Equal(t, tt.want, right.(*ast.ConstantNode).Value)
+		})
+	}
+}
+
 func TestOptimize_in_range(t *testing.T) {
 	tree, err := parser.Parse(`age in 18..31`)
 	require.NoError(t, err)
@@ -187,6 +229,39 @@ func TestOptimize_in_range(t *testing.T) {
 	assert.Equal(t, ast.Dump(expected), ast.Dump(tree.Node))
 }
 
+func TestOptimize_in_range_rewrite(t *testing.T) {
+	env := map[string]any{
+		"i8":  int8(5),
+		"lo":  1,
+		"hi":  10,
+		"day": time.Monday,
+		"arr": []int{1, 2},
+		"fn":  func() int { return 1 },
+	}
+	tests := []struct {
+		code    string
+		rewrite bool
+	}{
+		{`i8 in 1..1000000`, true},
+		{`lo in -5..hi`, true},
+		{`arr[0] in lo..hi`, true},
+		{`any(arr, # in 1..lo)`, true},
+		{`day in 1..3`, false},
+		{`fn() in 1..3`, false},
+		{`lo in 1..fn()`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			tree, err := checker.ParseCheck(tt.code, conf.New(env))
+			require.NoError(t, err)
+
+			err = optimizer.Optimize(&tree.Node, nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.rewrite, !strings.Contains(tree.Node.String(), ".."), tree.Node.String())
+		})
+	}
+}
+
 func TestOptimize_in_range_with_floats(t *testing.T) {
 	out, err := expr.Eval(`f in 1..3`, map[string]any{"f": 1.5})
 	require.NoError(t, err)
@@ -214,6 +289,102 @@ func TestOptimize_const_expr(t *testing.T) {
 	assert.Equal(t, ast.Dump(expected), ast.Dump(tree.Node))
 }
 
+func TestOptimize_pure_builtins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`lower("ADMIN")`, "admin"},
+		{`duration("1h")`, time.Hour},
+		{`max(1, 2, 3)`, 3},
+		{`hasPrefix(trim("  abc  "), "ab")`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tree, err := parser.Parse(tt.input)
+			require.NoError(t, err)
+
+			config := conf.CreateNew()
+			_, err = checker.Check(tree, config)
+			require.NoError(t, err)
+
+			err = optimizer.Optimize(&tree.Node, config)
+			require.NoError(t, err)
+
+			expected := &ast.ConstantNode{Value: tt.expected}
+			assert.Equal(t, ast.Dump(expected), ast.Dump(tree.Node))
+		})
+	}
+}
+
+func TestOptimize_pure_builtins_with_variables(t *testing.T) {
+	tree, err := parser.Parse(`lower(name)`)
+	require.NoError(t, err)
+
+	config := conf.New(map[string]any{"name": "ADMIN"})
+	_, err = checker.Check(tree, config)
+	require.NoError(t, err)
+
+	err = optimizer.Optimize(&tree.Node, config)
+	require.NoError(t, err)
+
+	_, ok := tree.Node.(*ast.BuiltinNode)
+	assert.True(t, ok)
+}
+
+func TestOptimize_exponent(t *testing.T) {
+	env := map[string]any{"x": 1.5}
+	tree, err := parser.Parse(`x ** 2`)
+	require.NoError(t, err)
+
+	config := conf.New(env)
+	_, err = checker.Check(tree, config)
+	require.NoError(t, err)
+
+	err = optimizer.Optimize(&tree.Node, config)
+	require.NoError(t, err)
+
+	expected := &ast.BinaryNode{
+		Operator: "*",
+		Left:     &ast.IdentifierNode{Value: "x"},
+		Right:    &ast.IdentifierNode{Value: "x"},
+	}
+	assert.Equal(t, ast.Dump(expected), ast.Dump(tree.Node))
+}
+
+func TestOptimize_exponent_results(t *testing.T) {
+	type Env struct {
+		F float64
+		I int
+		A []float64
+	}
+	env := Env{F: -1.7, I: 1 << 40, A: []float64{0.1, 3}}
+	tests := []struct {
+		code     string
+		expected float64
+	}{
+		{`F ** 2`, math.Pow(env.F, 2)},
+		{`F ^ 3`, math.Pow(env.F, 3)},
+		{`I ** 2`, math.Pow(float64(env.I), 2)},
+		{`I ** 3`, math.Pow(float64(env.I), 3)},
+		{`(F + 1) ** 3`, math.Pow(env.F+1, 3)},
+		{`sum(map(A, # ** 2))`, math.Pow(0.1, 2) + 9},
+		{`F ** 4`, math.Pow(env.F, 4)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			program, err := expr.Compile(tt.code, expr.Env(env))
+			require.NoError(t, err)
+			assert.Equal(t, strings.Contains(tt.code, "4"), strings.Contains(program.Disassemble(), "OpExponent"))
+
+			out, err := expr.Run(program, env)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, out)
+		})
+	}
+}
+
 func TestOptimize_filter_len(t *testing.T) {
 	tree, err := parser.Parse(`len(filter(users, .Name == "Bob"))`)
 	require.NoError(t, err)
@@ -0,0 +1,130 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+
+	. "github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/file"
+)
+
+// Expand replaces a call of the expression-level function, like a macro or
+// an inlined function, with a copy of its body, and returns the expansion
+// and the number of nodes of the body.
+//
+// Arguments are bound to parameters with let declarations, so each argument
+// is evaluated only once, the same way as for the regular function call. The
+// expansion is hygienic: lets of the body are renamed, and other variables of
+// the body are read from $env, so the body neither sees nor shadows variables
+// declared at the call site.
+func Expand(name string, params []string, body Node, args []Node, loc file.Location) (Node, int) {
+	body = copyNode(body)
+	prefix := fmt.Sprintf("$%s_", name)
+
+	h := &hygiene{prefix: prefix, loc: loc, bound: make(map[*IdentifierNode]bool)}
+	Walk(&body, h)
+	for _, param := range params {
+		h.bind(&body, param)
+	}
+	Walk(&body, &freeVariables{bound: h.bound})
+
+	for i := len(params) - 1; i >= 0; i-- {
+		let := &VariableDeclaratorNode{
+			Name:  prefix + params[i],
+			Value: args[i],
+			Expr:  body,
+		}
+		let.SetLocation(loc)
+		body = let
+	}
+	return body, h.size
+}
+
+type hygiene struct {
+	prefix string
+	loc    file.Location
+	bound  map[*IdentifierNode]bool // renamed variables and callees
+	size   int
+}
+
+func (h *hygiene) Visit(node *Node) {
+	h.size++
+	// Errors should point to the call site, not into the body.
+	(*node).SetLocation(h.loc)
+	switch n := (*node).(type) {
+	case *CallNode:
+		if callee, ok := n.Callee.(*IdentifierNode); ok {
+			h.bound[callee] = true
+		}
+	case *VariableDeclaratorNode:
+		// Walk is post-order, so variables of nested lets with the same
+		// name are already bound when the outer let is visited.
+		h.bind(&n.Expr, n.Name)
+		n.Name = h.prefix + n.Name
+	}
+}
+
+// bind renames the free variable of the node.
+func (h *hygiene) bind(node *Node, name string) {
+	Walk(node, &binding{name: name, to: h.prefix + name, bound: h.bound})
+}
+
+type binding struct {
+	name  string
+	to    string
+	bound map[*IdentifierNode]bool
+}
+
+func (v *binding) Visit(node *Node) {
+	if id, ok := (*node).(*IdentifierNode); ok && id.Value == v.name && !v.bound[id] {
+		id.Value = v.to
+		v.bound[id] = true
+	}
+}
+
+// freeVariables replaces variables which are not bound in the body with
+// fields of $env.
+type freeVariables struct {
+	bound map[*IdentifierNode]bool
+}
+
+func (v *freeVariables) Visit(node *Node) {
+	id, ok := (*node).(*IdentifierNode)
+	if !ok || v.bound[id] || id.Value == "$env" {
+		return
+	}
+	env := &IdentifierNode{Value: "$env"}
+	env.SetLocation(id.Location())
+	property := &StringNode{Value: id.Value}
+	property.SetLocation(id.Location())
+	Patch(node, &MemberNode{Node: env, Property: property})
+}
+
+var nodeType = reflect.TypeOf((*Node)(nil)).Elem()
+
+// copyNode deeply copies the tree, so each expansion patches its own nodes.
+func copyNode(node Node) Node {
+	if node == nil {
+		return nil
+	}
+	v := reflect.ValueOf(node).Elem()
+	out := reflect.New(v.Type())
+	out.Elem().Set(v)
+	for i := 0; i < v.NumField(); i++ {
+		field := out.Elem().Field(i)
+		if !field.CanSet() {
+			continue
+		}
+		switch {
+		case field.Type() == nodeType && !field.IsNil():
+			field.Set(reflect.ValueOf(copyNode(field.Interface().(Node))))
+		case field.Kind() == reflect.Slice && field.Type().Elem() == nodeType && !field.IsNil():
+			nodes := make([]Node, field.Len())
+			for j := range nodes {
+				nodes[j] = copyNode(field.Index(j).Interface().(Node))
+			}
+			field.Set(reflect.ValueOf(nodes))
+		}
+	}
+	return out.Interface().(Node)
+}
@@ -165,6 +165,17 @@ func TestLex(t *testing.T) {
 				{Kind: EOF},
 			},
 		},
+		{
+			`foo(bar...)`,
+			[]Token{
+				{Kind: Identifier, Value: "foo"},
+				{Kind: Bracket, Value: "("},
+				{Kind: Identifier, Value: "bar"},
+				{Kind: Operator, Value: "..."},
+				{Kind: Bracket, Value: ")"},
+				{Kind: EOF},
+			},
+		},
 		{
 			`1..5`,
 			[]Token{
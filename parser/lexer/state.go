@@ -113,7 +113,9 @@ func dot(l *lexer) stateFn {
 		l.backup()
 		return number
 	}
-	l.accept(".")
+	if l.accept(".") {
+		l.accept(".") // Spread operator.
+	}
 	l.emit(Operator)
 	return root
 }
@@ -32,6 +32,8 @@ var predicates = map[string]struct {
 	"any":           {[]arg{expr, closure}},
 	"one":           {[]arg{expr, closure}},
 	"filter":        {[]arg{expr, closure}},
+	"takeWhile":     {[]arg{expr, closure}},
+	"dropWhile":     {[]arg{expr, closure}},
 	"map":           {[]arg{expr, closure}},
 	"count":         {[]arg{expr, closure | optional}},
 	"sum":           {[]arg{expr, closure | optional}},
@@ -40,6 +42,10 @@ var predicates = map[string]struct {
 	"findLast":      {[]arg{expr, closure}},
 	"findLastIndex": {[]arg{expr, closure}},
 	"groupBy":       {[]arg{expr, closure}},
+	"partition":     {[]arg{expr, closure}},
+	"countBy":       {[]arg{expr, closure}},
+	"mapValues":     {[]arg{expr, closure}},
+	"mapKeys":       {[]arg{expr, closure}},
 	"sortBy":        {[]arg{expr, closure, expr | optional}},
 	"reduce":        {[]arg{expr, closure, expr | optional}},
 }
@@ -50,7 +56,9 @@ type parser struct {
 	pos     int
 	err     *file.Error
 	depth   int // closure call depth
+	nesting int // depth of the expression
 	config  *conf.Config
+	macros  []string // macros being expanded
 }
 
 type Tree struct {
@@ -61,6 +69,7 @@ type Tree struct {
 func Parse(input string) (*Tree, error) {
 	return ParseWithConfig(input, &conf.Config{
 		Disabled: map[string]bool{},
+		MaxDepth: conf.DefaultMaxDepth,
 	})
 }
 
@@ -83,6 +92,17 @@ func ParseWithConfig(input string, config *conf.Config) (*Tree, error) {
 	if !p.current.Is(EOF) {
 		p.error("unexpected token %v", p.current)
 	}
+	if p.err == nil && config != nil && config.MaxNodes > 0 {
+		// Macros are expanded, so the nodes are counted after parsing.
+		counter := &nodeCounter{max: config.MaxNodes}
+		Walk(&node, counter)
+		if counter.exceeded != nil {
+			p.err = &file.Error{
+				Location: counter.exceeded.Location(),
+				Message:  fmt.Sprintf("expression is too complex (max %d nodes)", config.MaxNodes),
+			}
+		}
+	}
 
 	tree := &Tree{
 		Node:   node,
@@ -96,6 +116,31 @@ func ParseWithConfig(input string, config *conf.Config) (*Tree, error) {
 	return tree, nil
 }
 
+// enter increases nesting of the expression. It reports false if nesting
+// exceeds the limit, which protects from stack overflow on adversarial input.
+func (p *parser) enter() bool {
+	p.nesting++
+	if p.config != nil && p.config.MaxDepth > 0 && p.nesting > p.config.MaxDepth {
+		p.error("expression is too deeply nested (max depth is %d)", p.config.MaxDepth)
+		return false
+	}
+	return true
+}
+
+// nodeCounter finds the first node which exceeds the limit of nodes.
+type nodeCounter struct {
+	max      int
+	count    int
+	exceeded Node
+}
+
+func (v *nodeCounter) Visit(node *Node) {
+	v.count++
+	if v.count == v.max+1 {
+		v.exceeded = *node
+	}
+}
+
 func (p *parser) error(format string, args ...any) {
 	p.errorAt(p.current, format, args...)
 }
@@ -129,6 +174,12 @@ func (p *parser) expect(kind Kind, values ...string) {
 // parse functions
 
 func (p *parser) parseExpression(precedence int) Node {
+	nesting := p.nesting
+	defer func() { p.nesting = nesting }()
+	if !p.enter() {
+		return &NilNode{}
+	}
+
 	if precedence == 0 && p.current.Is(Operator, "let") {
 		return p.parseVariableDeclaration()
 	}
@@ -207,6 +258,11 @@ func (p *parser) parseExpression(precedence int) Node {
 		break
 
 	next:
+		// Each operator nests the left side one level deeper, as walkers
+		// of the tree recurse into it.
+		if !p.enter() {
+			break
+		}
 		prevOperator = opToken.Value
 		opToken = p.current
 	}
@@ -237,7 +293,7 @@ func (p *parser) parseVariableDeclaration() Node {
 
 func (p *parser) parseConditional(node Node) Node {
 	var expr1, expr2 Node
-	for p.current.Is(Operator, "?") && p.err == nil {
+	for p.current.Is(Operator, "?") && p.err == nil && p.enter() {
 		p.next()
 
 		if !p.current.Is(Operator, ":") {
@@ -423,7 +479,29 @@ func (p *parser) parseCall(token Token, arguments []Node, checkOverrides bool) N
 	isOverridden := p.config.IsOverridden(token.Value)
 	isOverridden = isOverridden && checkOverrides
 
-	if b, ok := predicates[token.Value]; ok && !isOverridden {
+	if macro, ok := p.config.Macros[token.Value]; ok {
+		node = p.expandMacro(token, macro, arguments)
+	} else if token.Value == "quote" && !isOverridden && !p.config.Disabled[token.Value] {
+		// The argument of quote() is not evaluated, instead
+		// its source code is used as a string value.
+		if len(arguments) > 0 {
+			p.error("quote() cannot be used with pipe operator")
+			return nil
+		}
+		p.expect(Bracket, "(")
+		arg := p.parseExpression(0)
+		p.expect(Bracket, ")")
+		if p.err != nil {
+			return nil
+		}
+
+		node = &StringNode{Value: arg.String()}
+		node.SetLocation(token.Location)
+	} else if b, ok := predicates[token.Value]; ok && !isOverridden {
+		if p.config.Disabled[token.Value] {
+			p.errorAt(token, "builtin %v is disabled", token.Value)
+			return nil
+		}
 		p.expect(Bracket, "(")
 
 		// In case of the pipe operator, the first argument is the left-hand side
@@ -462,28 +540,83 @@ func (p *parser) parseCall(token Token, arguments []Node, checkOverrides bool) N
 		}
 		node.SetLocation(token.Location)
 	} else if _, ok := builtin.Index[token.Value]; ok && !p.config.Disabled[token.Value] && !isOverridden {
+		args, spread := p.parseArguments(arguments)
+		if spread {
+			p.errorAt(token, "cannot spread arguments of builtin %v", token.Value)
+		}
 		node = &BuiltinNode{
 			Name:      token.Value,
-			Arguments: p.parseArguments(arguments),
+			Arguments: args,
 		}
 		node.SetLocation(token.Location)
 	} else {
 		callee := &IdentifierNode{Value: token.Value}
 		callee.SetLocation(token.Location)
+		args, spread := p.parseArguments(arguments)
 		node = &CallNode{
 			Callee:    callee,
-			Arguments: p.parseArguments(arguments),
+			Arguments: args,
+			Spread:    spread,
 		}
 		node.SetLocation(token.Location)
 	}
 	return node
 }
 
-func (p *parser) parseArguments(arguments []Node) []Node {
+// expandMacro replaces the call of the macro with its body, see Expand.
+func (p *parser) expandMacro(token Token, macro *conf.Macro, arguments []Node) Node {
+	args, spread := p.parseArguments(arguments)
+	if p.err != nil {
+		return nil
+	}
+	if spread {
+		p.errorAt(token, "cannot spread arguments of macro %v", token.Value)
+		return nil
+	}
+	if len(args) != len(macro.Params) {
+		p.errorAt(token, "macro %v expects %d arguments, got %d", token.Value, len(macro.Params), len(args))
+		return nil
+	}
+	for _, name := range p.macros {
+		if name == token.Value {
+			p.errorAt(token, "macro %v is recursive", token.Value)
+			return nil
+		}
+	}
+
+	tokens, err := Lex(file.NewSource(macro.Body))
+	if err != nil {
+		p.errorAt(token, "macro %v: %v", token.Value, err.(*file.Error).Message)
+		return nil
+	}
+	body := &parser{
+		tokens:  tokens,
+		current: tokens[0],
+		config:  p.config,
+		macros:  append(p.macros[:len(p.macros):len(p.macros)], token.Value),
+		nesting: p.nesting,
+	}
+	node := body.parseExpression(0)
+	if body.err == nil && !body.current.Is(EOF) {
+		body.error("unexpected token %v", body.current)
+	}
+	if body.err != nil {
+		p.errorAt(token, "macro %v: %v", token.Value, body.err.Message)
+		return nil
+	}
+
+	node, _ = Expand(token.Value, macro.Params, node, args, token.Location)
+	return node
+}
+
+// parseArguments parses arguments of the call, and reports whether
+// the last argument is spread, like in `foo(xs...)`.
+func (p *parser) parseArguments(arguments []Node) ([]Node, bool) {
 	// If pipe operator is used, the first argument is the left-hand side
 	// of the operator, so we do not parse it as an argument inside brackets.
 	offset := len(arguments)
 
+	spread := false
 	p.expect(Bracket, "(")
 	for !p.current.Is(Bracket, ")") && p.err == nil {
 		if len(arguments) > offset {
@@ -491,10 +624,15 @@ func (p *parser) parseArguments(arguments []Node) []Node {
 		}
 		node := p.parseExpression(0)
 		arguments = append(arguments, node)
+		if p.current.Is(Operator, "...") {
+			p.next()
+			spread = true
+			break // Spread argument must be the last one.
+		}
 	}
 	p.expect(Bracket, ")")
 
-	return arguments
+	return arguments, spread
 }
 
 func (p *parser) parseClosure() Node {
@@ -589,8 +727,11 @@ end:
 }
 
 func (p *parser) parsePostfixExpression(node Node) Node {
+	nesting := p.nesting
+	defer func() { p.nesting = nesting }()
+
 	postfixToken := p.current
-	for (postfixToken.Is(Operator) || postfixToken.Is(Bracket)) && p.err == nil {
+	for (postfixToken.Is(Operator) || postfixToken.Is(Bracket)) && p.err == nil && p.enter() {
 		optional := postfixToken.Value == "?."
 	parseToken:
 		if postfixToken.Value == "." || postfixToken.Value == "?." {
@@ -628,9 +769,11 @@ func (p *parser) parsePostfixExpression(node Node) Node {
 
 			if p.current.Is(Bracket, "(") {
 				memberNode.Method = true
+				args, spread := p.parseArguments([]Node{})
 				node = &CallNode{
 					Callee:    memberNode,
-					Arguments: p.parseArguments([]Node{}),
+					Arguments: args,
+					Spread:    spread,
 				}
 				node.SetLocation(propertyToken.Location)
 			} else {
@@ -645,7 +788,17 @@ func (p *parser) parsePostfixExpression(node Node) Node {
 			p.next()
 			var from, to Node
 
-			if p.current.Is(Operator, ":") { // slice without from [:1]
+			if p.current.Is(Operator, "?") { // first match xs[? predicate]
+				p.next()
+				node = &BuiltinNode{
+					Name:      "find",
+					Arguments: []Node{node, p.parseClosure()},
+					Throws:    p.config.FirstMatchThrows,
+				}
+				node.SetLocation(postfixToken.Location)
+				p.expect(Bracket, "]")
+
+			} else if p.current.Is(Operator, ":") { // slice without from [:1]
 				p.next()
 
 				if !p.current.Is(Bracket, "]") { // slice without from and to [:]
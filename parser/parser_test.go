@@ -9,6 +9,7 @@ import (
 	"github.com/expr-lang/expr/internal/testify/require"
 
 	. "github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/conf"
 	"github.com/expr-lang/expr/parser"
 )
 
@@ -165,6 +166,21 @@ world`},
 					&IntegerNode{Value: 2},
 					&BoolNode{Value: true}}},
 		},
+		{
+			`foo(1, bar...)`,
+			&CallNode{Callee: &IdentifierNode{Value: "foo"},
+				Arguments: []Node{&IntegerNode{Value: 1},
+					&IdentifierNode{Value: "bar"}},
+				Spread: true},
+		},
+		{
+			`foo.bar(baz...)`,
+			&CallNode{Callee: &MemberNode{Node: &IdentifierNode{Value: "foo"},
+				Property: &StringNode{Value: "bar"},
+				Method:   true},
+				Arguments: []Node{&IdentifierNode{Value: "baz"}},
+				Spread:    true},
+		},
 		{
 			"foo.bar",
 			&MemberNode{Node: &IdentifierNode{Value: "foo"},
@@ -444,6 +460,23 @@ world`},
 								Property: &StringNode{Value: "Price"}},
 							Right: &IntegerNode{Value: 0}}}}},
 		},
+		{
+			"Tickets[? .Price > 0]",
+			&BuiltinNode{
+				Name: "find",
+				Arguments: []Node{
+					&IdentifierNode{Value: "Tickets"},
+					&ClosureNode{
+						Node: &BinaryNode{
+							Operator: ">",
+							Left: &MemberNode{Node: &PointerNode{},
+								Property: &StringNode{Value: "Price"}},
+							Right: &IntegerNode{Value: 0}}}}},
+		},
+		{
+			"quote(User.Age  >  18)",
+			&StringNode{Value: "User.Age > 18"},
+		},
 		{
 			"one(Tickets, {#.Price > 0})",
 			&BuiltinNode{
@@ -767,6 +800,16 @@ invalid float literal: strconv.ParseFloat: parsing "1E": invalid syntax (1:2)
 unexpected token Operator("==") (1:7)
  | 1 not == [1, 2, 5]
  | ......^
+
+foo(bar..., 1)
+unexpected token Operator(",") (1:11)
+ | foo(bar..., 1)
+ | ..........^
+
+len(foo...)
+cannot spread arguments of builtin len (1:1)
+ | len(foo...)
+ | ^
 `
 
 func TestParse_error(t *testing.T) {
@@ -914,3 +957,63 @@ func TestParse_pipe_operator(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, Dump(expect), Dump(actual.Node))
 }
+
+func TestParse_max_depth(t *testing.T) {
+	_, err := parser.Parse(strings.Repeat("(", 20000) + "1" + strings.Repeat(")", 20000))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expression is too deeply nested (max depth is 10000)")
+
+	config := conf.CreateNew()
+	config.MaxDepth = 3
+	_, err = parser.ParseWithConfig("((1))", config)
+	require.NoError(t, err)
+
+	_, err = parser.ParseWithConfig("(((1)))", config)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expression is too deeply nested (max depth is 3)")
+
+	_, err = parser.ParseWithConfig("1 + 2 + 3", config)
+	require.NoError(t, err)
+
+	_, err = parser.ParseWithConfig("1 + 2 + 3 + 4", config)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expression is too deeply nested (max depth is 3)")
+
+	_, err = parser.ParseWithConfig("a.b.c.d.e", config)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expression is too deeply nested (max depth is 3)")
+
+	config.MaxNodes = 4
+	_, err = parser.ParseWithConfig("1 + 2 + 3", config)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expression is too complex (max 4 nodes)")
+}
+
+func TestParse_macro(t *testing.T) {
+	config := conf.CreateNew()
+	config.Macros["isAdult"] = &conf.Macro{Params: []string{"u"}, Body: `u.Age >= 18`}
+	config.Macros["loop"] = &conf.Macro{Params: []string{"x"}, Body: `loop(x)`}
+
+	tree, err := parser.ParseWithConfig(`isAdult(user)`, config)
+	require.NoError(t, err)
+	assert.Equal(t, `let $isAdult_u = user; $isAdult_u.Age >= 18`, tree.Node.String())
+
+	config.Macros["isAllowed"] = &conf.Macro{Params: []string{"u"}, Body: `let age = u.Age; age >= limit`}
+	tree, err = parser.ParseWithConfig(`let limit = 1; isAllowed(user)`, config)
+	require.NoError(t, err)
+	assert.Equal(t, `let limit = 1; let $isAllowed_u = user; let $isAllowed_age = $isAllowed_u.Age; $isAllowed_age >= $env.limit`, tree.Node.String())
+
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{`isAdult(a, b)`, "macro isAdult expects 1 arguments, got 2"},
+		{`isAdult(a...)`, "cannot spread arguments of macro isAdult"},
+		{`loop(1)`, "macro loop: macro loop is recursive"},
+	}
+	for _, tt := range tests {
+		_, err := parser.ParseWithConfig(tt.input, config)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), tt.err)
+	}
+}
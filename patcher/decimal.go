@@ -0,0 +1,256 @@
+package patcher
+
+import (
+	"reflect"
+	"strconv"
+
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/internal/deref"
+)
+
+// Decimal replaces float literals with decimal constants, and arithmetic
+// and comparisons of decimals with calls of functions, which implement them.
+// Ints and floats mixed with decimals are converted by the functions, and
+// division of ints is decimal too. Operands of unknown type are converted at
+// runtime. Float literals passed to float parameters of functions are kept.
+//
+// Builtins abs, round, max, min and sum of decimals are replaced with the
+// functions too, and decimal keys of sortBy are wrapped by the "key" function
+// into comparable values.
+type Decimal struct {
+	Type      reflect.Type                      // Type of decimals.
+	Parse     func(literal string) (any, error) // Parses float literals.
+	Functions map[string]string                 // Functions by operator or builtin, like "+" or "abs"; "neg" is unary minus.
+	applied   bool
+	literals  map[*ast.ConstantNode]*ast.FloatNode
+	floats    map[*ast.FloatNode]bool // Literals kept as floats.
+}
+
+func (d *Decimal) Visit(node *ast.Node) {
+	switch n := (*node).(type) {
+	case *ast.FloatNode:
+		if d.floats[n] {
+			return
+		}
+		value, err := d.Parse(strconv.FormatFloat(n.Value, 'f', -1, 64))
+		if err != nil {
+			return
+		}
+		constant := &ast.ConstantNode{Value: value}
+		constant.SetType(d.Type)
+		ast.Patch(node, constant)
+		if d.literals == nil {
+			d.literals = make(map[*ast.ConstantNode]*ast.FloatNode)
+		}
+		d.literals[constant] = n
+		d.applied = true
+
+	case *ast.CallNode:
+		for i, arg := range n.Arguments {
+			constant, ok := arg.(*ast.ConstantNode)
+			if !ok || d.literals[constant] == nil {
+				continue
+			}
+			if k := kind(param(n, i)); k != reflect.Float32 && k != reflect.Float64 {
+				continue
+			}
+			literal := d.literals[constant]
+			if d.floats == nil {
+				d.floats = make(map[*ast.FloatNode]bool)
+			}
+			d.floats[literal] = true
+			ast.Patch(&n.Arguments[i], literal)
+		}
+
+	case *ast.UnaryNode:
+		fn, ok := d.Functions["neg"]
+		if !ok || n.Operator != "-" {
+			return
+		}
+		switch t := n.Node.Type(); {
+		case d.isDecimal(t):
+			d.call(node, fn, d.Type, n.Node)
+		case kind(t) == reflect.Interface:
+			d.call(node, fn, t, n.Node)
+		}
+
+	case *ast.BinaryNode:
+		fn, ok := d.Functions[n.Operator]
+		if !ok {
+			return
+		}
+		l, r := n.Left.Type(), n.Right.Type()
+		if !d.isNumber(l) || !d.isNumber(r) {
+			return
+		}
+		decimal := d.isDecimal(l) || d.isDecimal(r)
+		dynamic := kind(l) == reflect.Interface || kind(r) == reflect.Interface
+		if !decimal && !dynamic && (n.Operator != "/" || !isInteger(l) || !isInteger(r)) {
+			return
+		}
+		out := d.Type
+		switch n.Operator {
+		case "==", "!=", "<", ">", "<=", ">=":
+			out = boolType
+		default:
+			if !decimal && dynamic {
+				out = anyType // Decimals are known only at runtime.
+			}
+		}
+		d.call(node, fn, out, n.Left, n.Right)
+
+	case *ast.BuiltinNode:
+		name := n.Name
+		if name == "sortBy" {
+			name = "key"
+		}
+		fn, ok := d.Functions[name]
+		if !ok || len(n.Arguments) == 0 {
+			return
+		}
+		switch n.Name {
+		case "abs", "round":
+			if len(n.Arguments) == 1 && d.isDecimal(n.Arguments[0].Type()) {
+				d.call(node, fn, d.Type, n.Arguments[0])
+			}
+		case "max", "min":
+			for _, arg := range n.Arguments {
+				if d.hasDecimals(arg) {
+					d.call(node, fn, d.Type, n.Arguments...)
+					return
+				}
+			}
+		case "sum":
+			collection := n.Arguments[0]
+			if len(n.Arguments) == 2 {
+				closure, ok := n.Arguments[1].(*ast.ClosureNode)
+				if !ok || !d.isDecimal(closure.Node.Type()) {
+					return
+				}
+				collection = &ast.BuiltinNode{Name: "map", Arguments: n.Arguments}
+			} else if !d.hasDecimals(collection) {
+				return
+			}
+			d.call(node, fn, d.Type, collection)
+		case "sortBy":
+			if len(n.Arguments) < 2 {
+				return
+			}
+			closure, ok := n.Arguments[1].(*ast.ClosureNode)
+			if !ok {
+				return
+			}
+			keys := []*ast.Node{&closure.Node}
+			if array, ok := closure.Node.(*ast.ArrayNode); ok {
+				keys = keys[:0]
+				for i := range array.Nodes {
+					keys = append(keys, &array.Nodes[i])
+				}
+			}
+			for _, key := range keys {
+				if d.isDecimal((*key).Type()) {
+					d.call(key, fn, anyType, *key)
+				}
+			}
+		}
+	}
+}
+
+// ShouldRepeat reports whether the tree was changed, so types of variables,
+// like `let x = 1.5`, are checked again and their uses are patched too.
+func (d *Decimal) ShouldRepeat() bool {
+	applied := d.applied
+	d.applied = false
+	return applied
+}
+
+func (d *Decimal) call(node *ast.Node, fn string, out reflect.Type, args ...ast.Node) {
+	call := &ast.CallNode{
+		Callee:    &ast.IdentifierNode{Value: fn},
+		Arguments: args,
+	}
+	call.SetType(out)
+	ast.Patch(node, call)
+	d.applied = true
+}
+
+// isDecimal reports whether values of the type are decimals, or pointers to
+// decimals.
+func (d *Decimal) isDecimal(t reflect.Type) bool {
+	return t != nil && deref.Type(t) == deref.Type(d.Type)
+}
+
+// hasDecimals reports whether the node is a decimal, or an array of decimals.
+func (d *Decimal) hasDecimals(node ast.Node) bool {
+	if d.isDecimal(node.Type()) || d.isDecimal(elem(node.Type())) {
+		return true
+	}
+	if array, ok := node.(*ast.ArrayNode); ok {
+		for _, n := range array.Nodes {
+			if d.isDecimal(n.Type()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isNumber reports whether values of the type are converted to decimals.
+func (d *Decimal) isNumber(t reflect.Type) bool {
+	if d.isDecimal(t) || isInteger(t) {
+		return true
+	}
+	switch kind(t) {
+	case reflect.Float32, reflect.Float64, reflect.Interface:
+		return true
+	}
+	return false
+}
+
+var (
+	anyType  = reflect.TypeOf((*any)(nil)).Elem()
+	boolType = reflect.TypeOf(true)
+)
+
+func isInteger(t reflect.Type) bool {
+	switch kind(t) {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+func kind(t reflect.Type) reflect.Kind {
+	if t == nil {
+		return reflect.Invalid
+	}
+	return t.Kind()
+}
+
+func elem(t reflect.Type) reflect.Type {
+	switch kind(t) {
+	case reflect.Array, reflect.Slice:
+		return t.Elem()
+	}
+	return nil
+}
+
+// param returns the type of the parameter of the function for the argument
+// of the call, or nil if it is unknown.
+func param(call *ast.CallNode, i int) reflect.Type {
+	t := call.Callee.Type()
+	if kind(t) != reflect.Func {
+		return nil
+	}
+	if !t.IsVariadic() && t.NumIn() == len(call.Arguments)+1 {
+		i++ // Receiver of a method of the env.
+	}
+	if t.IsVariadic() && i >= t.NumIn()-1 {
+		return t.In(t.NumIn() - 1).Elem()
+	}
+	if i < t.NumIn() {
+		return t.In(i)
+	}
+	return nil
+}
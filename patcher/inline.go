@@ -0,0 +1,46 @@
+package patcher
+
+import (
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/parser"
+)
+
+// Inline replaces calls of the function with its expression-level definition,
+// the same way as macros are expanded, see parser.Expand.
+//
+// Inlining stops once Budget nodes were inlined into the expression; the rest
+// of the calls are left to the Go implementation of the function.
+type Inline struct {
+	Name   string   // Name of the function to inline.
+	Params []string // Names of the parameters used in Body.
+	Body   string   // Expression-level definition of the function.
+	Node   ast.Node // Parsed Body, parsed on the first call if nil.
+	Budget int      // Maximum number of nodes to inline into one expression.
+	size   int
+}
+
+func (p *Inline) Visit(node *ast.Node) {
+	call, ok := (*node).(*ast.CallNode)
+	if !ok || call.Spread {
+		return
+	}
+	callee, ok := call.Callee.(*ast.IdentifierNode)
+	if !ok || callee.Value != p.Name || len(call.Arguments) != len(p.Params) {
+		return
+	}
+
+	if p.Node == nil {
+		tree, err := parser.Parse(p.Body)
+		if err != nil {
+			return
+		}
+		p.Node = tree.Node
+	}
+
+	body, size := parser.Expand(p.Name, p.Params, p.Node, call.Arguments, call.Location())
+	if p.Budget > 0 && p.size+size > p.Budget {
+		return
+	}
+	p.size += size
+	ast.Patch(node, body)
+}
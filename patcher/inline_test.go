@@ -0,0 +1,116 @@
+package patcher_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/expr-lang/expr/internal/testify/require"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/patcher"
+	"github.com/expr-lang/expr/vm"
+)
+
+func TestInline(t *testing.T) {
+	calls := 0
+	env := map[string]any{
+		"isWeekend": func(t time.Time) bool {
+			calls++
+			return t.Weekday() == time.Saturday || t.Weekday() == time.Sunday
+		},
+		"saturday": time.Date(2024, 5, 4, 0, 0, 0, 0, time.UTC),
+		"monday":   time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC),
+	}
+
+	program, err := expr.Compile(
+		`isWeekend(saturday) && !isWeekend(monday)`,
+		expr.Env(env),
+		expr.Inline("isWeekend", `int(t.Weekday()) in [0, 6]`, "t"),
+	)
+	require.NoError(t, err)
+
+	out, err := expr.Run(program, env)
+	require.NoError(t, err)
+	require.Equal(t, true, out)
+	require.Equal(t, 0, calls)
+}
+
+func TestInline_arguments_evaluated_once(t *testing.T) {
+	calls := 0
+	env := map[string]any{
+		"double": func(x int) int { return x * 2 },
+		"next": func() int {
+			calls++
+			return calls
+		},
+	}
+
+	out, err := expr.Eval(`double(next())`, env)
+	require.NoError(t, err)
+	require.Equal(t, 2, out)
+
+	calls = 0
+	program, err := expr.Compile(`double(next())`, expr.Env(env), expr.Inline("double", `x + x`, "x"))
+	require.NoError(t, err)
+
+	out, err = vm.Run(program, env)
+	require.NoError(t, err)
+	require.Equal(t, 2, out)
+	require.Equal(t, 1, calls)
+}
+
+func TestInline_budget(t *testing.T) {
+	env := map[string]any{
+		"inc": func(x int) int { return x + 1 },
+	}
+
+	program, err := expr.Compile(`inc(inc(1))`, expr.Env(env), expr.Patch(&patcher.Inline{
+		Name:   "inc",
+		Params: []string{"x"},
+		Body:   `x + 1`,
+		Budget: 3,
+	}))
+	require.NoError(t, err)
+	require.Contains(t, program.Disassemble(), "OpCall")
+
+	out, err := expr.Run(program, env)
+	require.NoError(t, err)
+	require.Equal(t, 3, out)
+
+	inc := expr.Inline("inc", `x + 1`, "x")
+	program, err = expr.Compile(`inc(inc(1))`, expr.Env(env), inc, expr.InlineBudget(3))
+	require.NoError(t, err)
+	require.Contains(t, program.Disassemble(), "OpCall")
+
+	program, err = expr.Compile(`inc(inc(1))`, expr.Env(env), inc)
+	require.NoError(t, err)
+	require.NotContains(t, program.Disassemble(), "OpCall")
+}
+
+func TestInline_error_location(t *testing.T) {
+	env := map[string]any{
+		"half": func(x int) int { return x / 2 },
+	}
+
+	_, err := expr.Compile(`1 + half("str")`, expr.Env(env), expr.Inline("half", `x / 2`, "x"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "(1:5)")
+}
+
+func TestInline_hygiene(t *testing.T) {
+	env := map[string]any{
+		"limit":   10,
+		"isLarge": func(x int) bool { return x > 10 },
+	}
+
+	program, err := expr.Compile(
+		`let n = 1; isLarge(20) && n == 1`,
+		expr.Env(env),
+		expr.Inline("isLarge", `let n = x; n > limit`, "x"),
+	)
+	require.NoError(t, err)
+
+	out, err := vm.Run(program, env)
+	require.NoError(t, err)
+	require.Equal(t, true, out)
+}
@@ -0,0 +1,69 @@
+package patcher
+
+import (
+	"reflect"
+
+	"github.com/expr-lang/expr/ast"
+)
+
+// Specialize replaces variables with their known values, so the optimizer
+// folds the parts of the expression which depend only on them. Variables
+// shadowed by let declarations are left untouched, and so are functions.
+type Specialize struct {
+	Values map[string]any // Known values of variables.
+
+	// replaced maps nodes created for known values to the identifiers they
+	// replaced, so shadowed variables can be restored.
+	replaced map[ast.Node]*ast.IdentifierNode
+}
+
+func (s *Specialize) Visit(node *ast.Node) {
+	switch n := (*node).(type) {
+	case *ast.IdentifierNode:
+		value, ok := s.Values[n.Value]
+		if !ok || reflect.ValueOf(value).Kind() == reflect.Func {
+			return
+		}
+		if s.replaced == nil {
+			s.replaced = make(map[ast.Node]*ast.IdentifierNode)
+		}
+		literal := literalOf(value)
+		s.replaced[literal] = n
+		ast.Patch(node, literal)
+
+	case *ast.VariableDeclaratorNode:
+		if _, ok := s.Values[n.Name]; ok {
+			ast.Walk(&n.Expr, &restore{name: n.Name, replaced: s.replaced})
+		}
+	}
+}
+
+type restore struct {
+	name     string
+	replaced map[ast.Node]*ast.IdentifierNode
+}
+
+func (r *restore) Visit(node *ast.Node) {
+	if id, ok := r.replaced[*node]; ok && id.Value == r.name {
+		*node = id
+	}
+}
+
+// literalOf returns the literal node for values of predeclared types, so
+// they are folded like literals of the expression, and the constant node
+// for the rest of values.
+func literalOf(value any) ast.Node {
+	switch v := value.(type) {
+	case nil:
+		return &ast.NilNode{}
+	case bool:
+		return &ast.BoolNode{Value: v}
+	case int:
+		return &ast.IntegerNode{Value: v}
+	case float64:
+		return &ast.FloatNode{Value: v}
+	case string:
+		return &ast.StringNode{Value: v}
+	}
+	return &ast.ConstantNode{Value: value}
+}
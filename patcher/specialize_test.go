@@ -0,0 +1,81 @@
+package patcher_test
+
+import (
+	"testing"
+
+	"github.com/expr-lang/expr/internal/testify/assert"
+	"github.com/expr-lang/expr/internal/testify/require"
+
+	"github.com/expr-lang/expr"
+)
+
+type specializeEnv struct {
+	Tenant string
+	Amount int
+	Limit  int
+}
+
+func TestSpecialize(t *testing.T) {
+	env := specializeEnv{}
+	program, err := expr.Compile(`Tenant == "acme" && Amount > Limit`, expr.Env(env))
+	require.NoError(t, err)
+
+	tests := []struct {
+		known    map[string]any
+		residual string
+	}{
+		{map[string]any{"Tenant": "acme", "Limit": 100}, `Amount > 100`},
+		{map[string]any{"Tenant": "other"}, `false`},
+		{map[string]any{"Limit": 5}, `Tenant == "acme" && Amount > 5`},
+		{map[string]any{}, `Tenant == "acme" && Amount > Limit`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.residual, func(t *testing.T) {
+			specialized, err := expr.Specialize(program, tt.known, expr.Env(env))
+			require.NoError(t, err)
+			assert.Equal(t, tt.residual, specialized.Node().String())
+
+			input := specializeEnv{Tenant: "acme", Amount: 200, Limit: 100}
+			for name, value := range tt.known {
+				switch name {
+				case "Tenant":
+					input.Tenant = value.(string)
+				case "Limit":
+					input.Limit = value.(int)
+				}
+			}
+			want, err := expr.Run(program, input)
+			require.NoError(t, err)
+			got, err := expr.Run(specialized, input)
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+func TestSpecialize_shadowed(t *testing.T) {
+	program, err := expr.Compile(`let limit = limit * 2; amount > limit`)
+	require.NoError(t, err)
+
+	specialized, err := expr.Specialize(program, map[string]any{"limit": 10})
+	require.NoError(t, err)
+	assert.Equal(t, `let limit = 20; amount > limit`, specialized.Node().String())
+
+	out, err := expr.Run(specialized, map[string]any{"amount": 15})
+	require.NoError(t, err)
+	assert.Equal(t, false, out)
+}
+
+func TestSpecialize_constant(t *testing.T) {
+	env := map[string]any{"tags": []string{}, "tag": ""}
+	program, err := expr.Compile(`tag in tags`, expr.Env(env))
+	require.NoError(t, err)
+
+	specialized, err := expr.Specialize(program, map[string]any{"tags": []string{"a", "b"}}, expr.Env(env))
+	require.NoError(t, err)
+
+	out, err := expr.Run(specialized, map[string]any{"tag": "b"})
+	require.NoError(t, err)
+	assert.Equal(t, true, out)
+}
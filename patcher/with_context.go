@@ -40,6 +40,7 @@ func (w WithContext) Visit(node *ast.Node) {
 			Arguments: append([]ast.Node{
 				&ast.IdentifierNode{Value: w.Name},
 			}, call.Arguments...),
+			Spread: call.Spread,
 		})
 	}
 }
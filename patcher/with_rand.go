@@ -0,0 +1,25 @@
+package patcher
+
+import (
+	"math/rand"
+
+	"github.com/expr-lang/expr/ast"
+)
+
+// WithRand passes Rand to uuid(), random(), shuffle() and sample() functions.
+type WithRand struct {
+	Rand *rand.Rand
+}
+
+func (t WithRand) Visit(node *ast.Node) {
+	if btin, ok := (*node).(*ast.BuiltinNode); ok {
+		switch btin.Name {
+		case "uuid", "random", "shuffle", "sample":
+			r := &ast.ConstantNode{Value: t.Rand}
+			ast.Patch(node, &ast.BuiltinNode{
+				Name:      btin.Name,
+				Arguments: append([]ast.Node{r}, btin.Arguments...),
+			})
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package patcher_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/expr-lang/expr/internal/testify/require"
+
+	"github.com/expr-lang/expr"
+)
+
+func TestWithRand(t *testing.T) {
+	program, err := expr.Compile(`random(1000000)`, expr.RandSource(rand.NewSource(1)))
+	require.NoError(t, err)
+
+	out, err := expr.Run(program, nil)
+	require.NoError(t, err)
+	require.Equal(t, rand.New(rand.NewSource(1)).Intn(1000000), out)
+}
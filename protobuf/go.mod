@@ -0,0 +1,10 @@
+module github.com/expr-lang/expr/protobuf
+
+go 1.20
+
+require (
+	github.com/expr-lang/expr v0.0.0
+	google.golang.org/protobuf v1.34.2
+)
+
+replace github.com/expr-lang/expr => ../
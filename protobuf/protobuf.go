@@ -0,0 +1,294 @@
+// Package protobuf builds environments of expressions from protobuf message
+// descriptors, so expressions can be checked against schemas which are known
+// only at runtime, like ones loaded from a FileDescriptorSet, and evaluated
+// against generated messages or dynamicpb values.
+package protobuf
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+const (
+	timestampName protoreflect.FullName = "google.protobuf.Timestamp"
+	durationName  protoreflect.FullName = "google.protobuf.Duration"
+)
+
+var (
+	mapType      = reflect.TypeOf(map[string]any{})
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// Schema is the environment of a protobuf message. Fields of the message are
+// variables named by their names in the .proto file, like user_id.
+//
+// Fields are typed by the Go types of protoc-gen-go, except enums, which are
+// strings with names of their values, nested messages, which are pointers to
+// structs and are nil if unset, and google.protobuf.Timestamp and Duration,
+// which are time.Time and time.Duration. Messages nested in themselves, like
+// trees, are maps of type map[string]any at the level of the recursion.
+type Schema struct {
+	desc protoreflect.MessageDescriptor
+	typ  reflect.Type
+}
+
+// New returns the schema of the message descriptor.
+func New(desc protoreflect.MessageDescriptor) *Schema {
+	b := &builder{
+		types:    make(map[protoreflect.FullName]reflect.Type),
+		building: make(map[protoreflect.FullName]bool),
+	}
+	return &Schema{desc: desc, typ: b.message(desc)}
+}
+
+// FromFileDescriptorSet returns the schema of the message with the full name,
+// like "events.Event", from the set, like one produced by
+// `protoc --descriptor_set_out --include_imports`.
+func FromFileDescriptorSet(set *descriptorpb.FileDescriptorSet, message string) (*Schema, error) {
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, err
+	}
+	d, err := files.FindDescriptorByName(protoreflect.FullName(message))
+	if err != nil {
+		return nil, err
+	}
+	desc, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%v is not a message", message)
+	}
+	return New(desc), nil
+}
+
+// Descriptor returns the descriptor of the message.
+func (s *Schema) Descriptor() protoreflect.MessageDescriptor {
+	return s.desc
+}
+
+// Env returns the option, which declares fields of the message as variables.
+func (s *Schema) Env() expr.Option {
+	return expr.Env(reflect.Zero(s.typ).Interface())
+}
+
+// Value converts the message, a generated one or a dynamicpb one, to the env
+// of programs compiled with Env.
+func (s *Schema) Value(msg proto.Message) (any, error) {
+	m := msg.ProtoReflect()
+	if name := m.Descriptor().FullName(); name != s.desc.FullName() {
+		return nil, fmt.Errorf("cannot use %v as %v", name, s.desc.FullName())
+	}
+	return s.message(m, s.typ).Interface(), nil
+}
+
+// Run runs the program compiled with Env against the message.
+func (s *Schema) Run(program *vm.Program, msg proto.Message) (any, error) {
+	env, err := s.Value(msg)
+	if err != nil {
+		return nil, err
+	}
+	return expr.Run(program, env)
+}
+
+type builder struct {
+	types    map[protoreflect.FullName]reflect.Type
+	building map[protoreflect.FullName]bool // messages which types are being built
+}
+
+// message returns the struct type with a field per field of the message.
+// Go names of fields are numbers of the fields, and expr tags hold their
+// names.
+func (b *builder) message(desc protoreflect.MessageDescriptor) reflect.Type {
+	if t, ok := b.types[desc.FullName()]; ok {
+		return t
+	}
+	b.building[desc.FullName()] = true
+	defer delete(b.building, desc.FullName())
+
+	fields := desc.Fields()
+	out := make([]reflect.StructField, fields.Len())
+	for i := range out {
+		fd := fields.Get(i)
+		out[i] = reflect.StructField{
+			Name: fmt.Sprintf("F%d", fd.Number()),
+			Type: b.field(fd),
+			Tag:  reflect.StructTag(fmt.Sprintf(`expr:"%s"`, fd.Name())),
+		}
+	}
+	t := reflect.StructOf(out)
+	b.types[desc.FullName()] = t
+	return t
+}
+
+func (b *builder) field(fd protoreflect.FieldDescriptor) reflect.Type {
+	switch {
+	case fd.IsList():
+		return reflect.SliceOf(b.single(fd))
+	case fd.IsMap():
+		return reflect.MapOf(b.single(fd.MapKey()), b.single(fd.MapValue()))
+	}
+	return b.single(fd)
+}
+
+func (b *builder) single(fd protoreflect.FieldDescriptor) reflect.Type {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		switch name := fd.Message().FullName(); {
+		case name == timestampName:
+			return timeType
+		case name == durationName:
+			return durationType
+		case b.building[name]:
+			return mapType
+		}
+		return reflect.PtrTo(b.message(fd.Message()))
+	case protoreflect.EnumKind:
+		return reflect.TypeOf("")
+	}
+	return scalarType(fd.Kind())
+}
+
+func scalarType(kind protoreflect.Kind) reflect.Type {
+	switch kind {
+	case protoreflect.BoolKind:
+		return reflect.TypeOf(false)
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return reflect.TypeOf(int32(0))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return reflect.TypeOf(int64(0))
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return reflect.TypeOf(uint32(0))
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return reflect.TypeOf(uint64(0))
+	case protoreflect.FloatKind:
+		return reflect.TypeOf(float32(0))
+	case protoreflect.DoubleKind:
+		return reflect.TypeOf(float64(0))
+	case protoreflect.StringKind:
+		return reflect.TypeOf("")
+	case protoreflect.BytesKind:
+		return reflect.TypeOf([]byte(nil))
+	}
+	panic(fmt.Sprintf("unknown kind %v", kind))
+}
+
+// message converts the message to the struct type built for it.
+func (s *Schema) message(m protoreflect.Message, t reflect.Type) reflect.Value {
+	out := reflect.New(t).Elem()
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.Message() != nil && !fd.IsList() && !fd.IsMap() && !m.Has(fd) {
+			// Unset messages are left nil.
+			continue
+		}
+		out.Field(i).Set(s.field(fd, m.Get(fd), out.Field(i).Type()))
+	}
+	return out
+}
+
+func (s *Schema) field(fd protoreflect.FieldDescriptor, v protoreflect.Value, t reflect.Type) reflect.Value {
+	switch {
+	case fd.IsList():
+		list := v.List()
+		out := reflect.MakeSlice(t, list.Len(), list.Len())
+		for i := 0; i < list.Len(); i++ {
+			out.Index(i).Set(s.single(fd, list.Get(i), t.Elem()))
+		}
+		return out
+	case fd.IsMap():
+		out := reflect.MakeMapWithSize(t, v.Map().Len())
+		v.Map().Range(func(key protoreflect.MapKey, value protoreflect.Value) bool {
+			out.SetMapIndex(
+				s.single(fd.MapKey(), key.Value(), t.Key()),
+				s.single(fd.MapValue(), value, t.Elem()))
+			return true
+		})
+		return out
+	}
+	return s.single(fd, v, t)
+}
+
+func (s *Schema) single(fd protoreflect.FieldDescriptor, v protoreflect.Value, t reflect.Type) reflect.Value {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		m := v.Message()
+		switch {
+		case t == timeType:
+			return reflect.ValueOf(time.Unix(int64Field(m, "seconds"), int64Field(m, "nanos")).UTC())
+		case t == durationType:
+			return reflect.ValueOf(time.Duration(int64Field(m, "seconds"))*time.Second + time.Duration(int64Field(m, "nanos")))
+		case t == mapType:
+			return reflect.ValueOf(s.dynamic(m))
+		}
+		ptr := reflect.New(t.Elem())
+		ptr.Elem().Set(s.message(m, t.Elem()))
+		return ptr
+	case protoreflect.EnumKind:
+		if value := fd.Enum().Values().ByNumber(v.Enum()); value != nil {
+			return reflect.ValueOf(string(value.Name()))
+		}
+		return reflect.ValueOf(strconv.Itoa(int(v.Enum())))
+	}
+	return reflect.ValueOf(v.Interface()).Convert(t)
+}
+
+// dynamic converts the message nested in itself to a map. Its nested
+// messages are maps as well.
+func (s *Schema) dynamic(m protoreflect.Message) map[string]any {
+	out := make(map[string]any)
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.Message() != nil && !fd.IsList() && !fd.IsMap() && !m.Has(fd) {
+			out[string(fd.Name())] = nil
+			continue
+		}
+		out[string(fd.Name())] = s.field(fd, m.Get(fd), dynamicType(fd)).Interface()
+	}
+	return out
+}
+
+// dynamicType returns the type of the field of a message converted to a map.
+func dynamicType(fd protoreflect.FieldDescriptor) reflect.Type {
+	single := func(fd protoreflect.FieldDescriptor) reflect.Type {
+		switch fd.Kind() {
+		case protoreflect.MessageKind, protoreflect.GroupKind:
+			switch fd.Message().FullName() {
+			case timestampName:
+				return timeType
+			case durationName:
+				return durationType
+			}
+			return mapType
+		case protoreflect.EnumKind:
+			return reflect.TypeOf("")
+		}
+		return scalarType(fd.Kind())
+	}
+	switch {
+	case fd.IsList():
+		return reflect.SliceOf(single(fd))
+	case fd.IsMap():
+		return reflect.MapOf(single(fd.MapKey()), single(fd.MapValue()))
+	}
+	return single(fd)
+}
+
+func int64Field(m protoreflect.Message, name protoreflect.Name) int64 {
+	fd := m.Descriptor().Fields().ByName(name)
+	if fd == nil {
+		return 0
+	}
+	return m.Get(fd).Int()
+}
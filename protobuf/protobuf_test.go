@@ -0,0 +1,184 @@
+package protobuf_test
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/internal/testify/assert"
+	"github.com/expr-lang/expr/internal/testify/require"
+	"github.com/expr-lang/expr/protobuf"
+)
+
+// eventSet is the descriptor set of the file:
+//
+//	syntax = "proto3";
+//	package events;
+//	import "google/protobuf/timestamp.proto";
+//
+//	enum Status { UNKNOWN = 0; ACTIVE = 1; }
+//	message User { string name = 1; User manager = 2; }
+//	message Event {
+//	  string user_id = 1;
+//	  int32 score = 2;
+//	  repeated string labels = 3;
+//	  Status status = 4;
+//	  User user = 5;
+//	  map<string, int64> counts = 6;
+//	  google.protobuf.Timestamp at = 7;
+//	}
+func eventSet() *descriptorpb.FileDescriptorSet {
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     typ.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	labels := field("labels", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING, "")
+	labels.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	counts := field("counts", 6, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".events.Event.CountsEntry")
+	counts.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("events.proto"),
+		Package:    proto.String("events"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/timestamp.proto"},
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Status"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("UNKNOWN"), Number: proto.Int32(0)},
+				{Name: proto.String("ACTIVE"), Number: proto.Int32(1)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("User"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+					field("manager", 2, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".events.User"),
+				},
+			},
+			{
+				Name: proto.String("Event"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("user_id", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+					field("score", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, ""),
+					labels,
+					field("status", 4, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".events.Status"),
+					field("user", 5, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".events.User"),
+					counts,
+					field("at", 7, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.Timestamp"),
+				},
+				NestedType: []*descriptorpb.DescriptorProto{{
+					Name: proto.String("CountsEntry"),
+					Field: []*descriptorpb.FieldDescriptorProto{
+						field("key", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+						field("value", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64, ""),
+					},
+					Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+				}},
+			},
+		},
+	}
+	return &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{
+		protodesc.ToFileDescriptorProto(timestamppb.File_google_protobuf_timestamp_proto),
+		file,
+	}}
+}
+
+func TestFromFileDescriptorSet(t *testing.T) {
+	schema, err := protobuf.FromFileDescriptorSet(eventSet(), "events.Event")
+	require.NoError(t, err)
+
+	desc := schema.Descriptor()
+	user := dynamicpb.NewMessage(desc.Fields().ByName("user").Message())
+	user.Set(user.Descriptor().Fields().ByName("name"), protoreflect.ValueOfString("ann"))
+	manager := dynamicpb.NewMessage(user.Descriptor())
+	manager.Set(manager.Descriptor().Fields().ByName("name"), protoreflect.ValueOfString("bob"))
+	user.Set(user.Descriptor().Fields().ByName("manager"), protoreflect.ValueOfMessage(manager))
+
+	event := dynamicpb.NewMessage(desc)
+	event.Set(desc.Fields().ByName("user_id"), protoreflect.ValueOfString("u1"))
+	event.Set(desc.Fields().ByName("score"), protoreflect.ValueOfInt32(7))
+	labels := event.Mutable(desc.Fields().ByName("labels")).List()
+	labels.Append(protoreflect.ValueOfString("a"))
+	labels.Append(protoreflect.ValueOfString("b"))
+	event.Set(desc.Fields().ByName("status"), protoreflect.ValueOfEnum(1))
+	event.Set(desc.Fields().ByName("user"), protoreflect.ValueOfMessage(user))
+	event.Mutable(desc.Fields().ByName("counts")).Map().Set(protoreflect.ValueOfString("clicks").MapKey(), protoreflect.ValueOfInt64(3))
+	at := time.Date(2024, 5, 4, 12, 0, 0, 0, time.UTC)
+	event.Set(desc.Fields().ByName("at"), protoreflect.ValueOfMessage(timestamppb.New(at).ProtoReflect()))
+
+	tests := []struct {
+		code string
+		want any
+	}{
+		{`user_id`, "u1"},
+		{`score + 1`, 8},
+		{`join(labels, ",")`, "a,b"},
+		{`status == "ACTIVE"`, true},
+		{`user.name`, "ann"},
+		{`user.manager.name`, "bob"},
+		{`counts["clicks"]`, int64(3)},
+		{`at.Year()`, 2024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			program, err := expr.Compile(tt.code, schema.Env())
+			require.NoError(t, err)
+
+			out, err := schema.Run(program, event)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, out)
+		})
+	}
+
+	_, err = expr.Compile(`user.age > 18`, schema.Env())
+	require.Error(t, err)
+
+	_, err = expr.Compile(`score + "1"`, schema.Env())
+	require.Error(t, err)
+
+	program, err := expr.Compile(`user?.name ?? "none"`, schema.Env())
+	require.NoError(t, err)
+	out, err := schema.Run(program, dynamicpb.NewMessage(desc))
+	require.NoError(t, err)
+	assert.Equal(t, "none", out)
+
+	_, err = protobuf.FromFileDescriptorSet(eventSet(), "events.Status")
+	require.EqualError(t, err, "events.Status is not a message")
+}
+
+func TestNew_generated_message(t *testing.T) {
+	field := &descriptorpb.FieldDescriptorProto{
+		Name:   proto.String("id"),
+		Number: proto.Int32(3),
+		Label:  descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+	}
+	schema := protobuf.New(field.ProtoReflect().Descriptor())
+
+	program, err := expr.Compile(`name == "id" && number > 1 && label == "LABEL_REPEATED"`, schema.Env())
+	require.NoError(t, err)
+
+	out, err := schema.Run(program, field)
+	require.NoError(t, err)
+	assert.Equal(t, true, out)
+
+	_, err = schema.Run(program, &descriptorpb.FileDescriptorProto{})
+	require.EqualError(t, err, "cannot use google.protobuf.FileDescriptorProto as google.protobuf.FieldDescriptorProto")
+}
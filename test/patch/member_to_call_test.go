@@ -0,0 +1,59 @@
+package patch_test
+
+import (
+	"testing"
+
+	"github.com/expr-lang/expr/internal/testify/assert"
+	"github.com/expr-lang/expr/internal/testify/require"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/ast"
+)
+
+type vipUser struct {
+	Name  string
+	Spent int
+}
+
+type vipEnv struct {
+	User  vipUser
+	IsVIP func(vipUser) bool
+}
+
+// memberToCall rewrites user.isVIP into IsVIP(user). The property does not
+// exist on the type, so the rewrite must happen before the types are checked.
+type memberToCall struct{}
+
+func (memberToCall) Visit(node *ast.Node) {
+	member, ok := (*node).(*ast.MemberNode)
+	if !ok {
+		return
+	}
+	if property, ok := member.Property.(*ast.StringNode); ok && property.Value == "isVIP" {
+		ast.Patch(node, &ast.CallNode{
+			Callee:    &ast.IdentifierNode{Value: "IsVIP"},
+			Arguments: []ast.Node{member.Node},
+		})
+	}
+}
+
+func TestPatch_member_to_call(t *testing.T) {
+	env := vipEnv{
+		User: vipUser{Name: "Anna", Spent: 1500},
+		IsVIP: func(u vipUser) bool {
+			return u.Spent > 1000
+		},
+	}
+
+	program, err := expr.Compile(`User.isVIP && User.Name == "Anna"`, expr.Env(env), expr.Patch(memberToCall{}))
+	require.NoError(t, err)
+
+	out, err := expr.Run(program, env)
+	require.NoError(t, err)
+	assert.Equal(t, true, out)
+}
+
+func TestPatch_member_to_call_without_patch(t *testing.T) {
+	_, err := expr.Compile(`User.isVIP`, expr.Env(vipEnv{}))
+	require.Error(t, err)
+}
@@ -2,6 +2,7 @@ package time_test
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 	"time"
 
@@ -144,3 +145,32 @@ func TestTime_date(t *testing.T) {
 		})
 	}
 }
+
+func TestTime_builtins_types(t *testing.T) {
+	tests := []struct {
+		input string
+		want  any
+	}{
+		{`date("2024-01-02") - date("2024-01-01")`, 24 * time.Hour},
+		{`date("2024-01-02") + duration("1h30m")`, time.Date(2024, 1, 2, 1, 30, 0, 0, time.UTC)},
+		{`duration("1h") + duration("30m")`, 90 * time.Minute},
+		{`duration("1h") * 2`, 2 * time.Hour},
+		{`3 * duration("1m")`, 3 * time.Minute},
+		{`-duration("1h")`, -time.Hour},
+		{`date("2024-01-02") - date("2024-01-01") > duration("1h")`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tree, err := parser.Parse(tt.input)
+			require.NoError(t, err)
+
+			typ, err := checker.Check(tree, conf.CreateNew())
+			require.NoError(t, err)
+			require.Equal(t, reflect.TypeOf(tt.want), typ)
+
+			out, err := expr.Eval(tt.input, nil)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, out)
+		})
+	}
+}
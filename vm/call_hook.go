@@ -0,0 +1,166 @@
+package vm
+
+import (
+	"fmt"
+	"reflect"
+	runtimedebug "runtime/debug"
+
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/file"
+)
+
+// Call is an invocation of a function of the environment, of a method or of
+// a function registered with expr.Function. Builtins are not reported.
+type Call struct {
+	Name     string        // Name of the function as written, like "user.Greet".
+	Args     []any         // Arguments of the call.
+	Location file.Location // Location of the call in the expression.
+}
+
+// CallHook wraps invocations of functions. The hook calls next to invoke the
+// function and returns its result, so it can measure the duration, log the
+// arguments and the error, or deny the call. An error returned by the hook
+// fails the run. The next function does not use the VM, so the hook may call
+// it in another goroutine to give up waiting on a timeout.
+type CallHook func(call Call, next func() (any, error)) (any, error)
+
+// WithCallHook wraps each invocation of a function with the hook, like a
+// middleware, to enforce timeouts and rate limits or to audit calls of
+// functions which reach external systems.
+func WithCallHook(hook CallHook) Option {
+	return func(vm *VM) {
+		vm.callHook = hook
+	}
+}
+
+// RecoverCalls recovers panics of functions of the environment, methods and
+// functions registered with expr.Function. A panic fails the run with
+// *PanicError, which names the function, instead of a bare panic value.
+// Calls are slower, as with a call hook.
+func RecoverCalls() Option {
+	return func(vm *VM) {
+		vm.recoverCalls = true
+	}
+}
+
+// PanicError is a panic of a called function, recovered by the VM with
+// RecoverCalls or a call hook.
+type PanicError struct {
+	Function string // Name of the function, like "user.Greet".
+	Value    any    // Value passed to panic.
+	Stack    []byte // Stack trace of the panicking goroutine.
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic in %v: %v", e.Function, e.Value)
+}
+
+// Unwrap returns the panic value, if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// hookCall executes the call instruction through the call hook. Arguments
+// are converted like by the call instruction, and the function is called
+// by next, which does not use the VM.
+func (vm *VM) hookCall(program *Program, op Opcode, arg int) {
+	var args []any
+	var call func() (any, error)
+	switch op {
+	case OpCall0, OpCall1, OpCall2, OpCall3:
+		fn := program.functions[arg]
+		args = vm.popArgs(int(op - OpCall0))
+		call = func() (any, error) { return fn(args...) }
+	case OpCallN:
+		fn := vm.pop().(Function)
+		args = vm.popArgs(arg)
+		call = func() (any, error) { return fn(args...) }
+	case OpCallFast:
+		fn := vm.pop().(func(...any) any)
+		args = vm.popArgs(arg)
+		call = func() (any, error) { return fn(args...), nil }
+	case OpCallTyped:
+		fn := vm.pop()
+		args = vm.popArgs(reflect.TypeOf(fn).NumIn())
+		f, in := vm.arguments(fn, args)
+		call = func() (any, error) { return results(f.Call(in)) }
+	case OpCallSpread:
+		fn := vm.pop()
+		spread := vm.pop()
+		args, call = vm.spread(fn, vm.popArgs(arg-1), spread)
+	default:
+		fn := vm.pop()
+		args = vm.popArgs(arg)
+		f, in := vm.arguments(fn, args)
+		call = func() (any, error) { return results(f.Call(in)) }
+	}
+
+	var loc file.Location
+	if vm.ip-1 < len(program.locations) {
+		loc = program.locations[vm.ip-1]
+	}
+	name := program.calls[loc]
+	next := func() (any, error) {
+		out, err := protect(call)
+		if p, ok := err.(*PanicError); ok {
+			p.Function = name
+		}
+		return out, err
+	}
+	var out any
+	var err error
+	if vm.callHook != nil {
+		out, err = vm.callHook(Call{Name: name, Args: args, Location: loc}, next)
+	} else {
+		out, err = next()
+	}
+	if err != nil {
+		panic(err)
+	}
+	switch op {
+	case OpCall0, OpCall1, OpCall2, OpCall3, OpCallN:
+		vm.allocResult(out)
+	}
+	vm.push(out)
+}
+
+func (vm *VM) popArgs(size int) []any {
+	args := make([]any, size)
+	for i := size - 1; i >= 0; i-- {
+		args[i] = vm.pop()
+	}
+	return args
+}
+
+// protect calls the function and returns its panic as *PanicError.
+func protect(call func() (any, error)) (out any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: runtimedebug.Stack()}
+		}
+	}()
+	return call()
+}
+
+// callNames maps locations of calls in the node to names of the called
+// functions, or returns nil if there are no calls.
+func callNames(node ast.Node) map[file.Location]string {
+	if node == nil {
+		return nil
+	}
+	var names callLocations
+	ast.Walk(&node, &names)
+	return names
+}
+
+type callLocations map[file.Location]string
+
+func (v *callLocations) Visit(node *ast.Node) {
+	if n, ok := (*node).(*ast.CallNode); ok {
+		if *v == nil {
+			*v = make(callLocations)
+		}
+		(*v)[n.Location()] = n.Callee.String()
+	}
+}
@@ -0,0 +1,129 @@
+package vm
+
+import (
+	"reflect"
+
+	"github.com/expr-lang/expr/vm/runtime"
+)
+
+// Converter converts a value to another type, like a decimal to float64.
+type Converter func(value any) (any, error)
+
+type conversion struct {
+	to      reflect.Type
+	convert Converter
+}
+
+// WithConverter registers the converter of values of type from to type to.
+// The VM consults converters when types mismatch at runtime, instead of
+// failing: arguments are converted to the types of parameters of called
+// functions, and an operand of a binary operator, like `price * 1.1`, is
+// converted to the type of the other operand. If there is no converter to
+// the type of a numeric operand, the first registered converter to a number
+// type is used, and so it is for operands of the same type which operators
+// do not support, like `price * quantity` of decimals. An error of the
+// converter fails the run.
+//
+// Converters are consulted only at runtime, so values of converted types
+// should be declared as any in the environment to pass the type checker.
+func WithConverter(from, to reflect.Type, convert Converter) Option {
+	return func(vm *VM) {
+		if vm.converters == nil {
+			vm.converters = make(map[reflect.Type][]conversion)
+		}
+		vm.converters[from] = append(vm.converters[from], conversion{to: to, convert: convert})
+	}
+}
+
+// convert converts the value to the type with the registered converter.
+// Values which need no conversion or have no converter are returned as is.
+func (vm *VM) convert(value any, to reflect.Type, numeric bool) any {
+	from := reflect.TypeOf(value)
+	if from == nil || to == nil || from.AssignableTo(to) {
+		return value
+	}
+	conversions := vm.converters[from]
+	for _, c := range conversions {
+		if c.to == to {
+			return vm.apply(c, value)
+		}
+	}
+	if numeric && runtime.IsNumberKind(to.Kind()) {
+		if c, ok := vm.numeric(from); ok {
+			return vm.apply(c, value)
+		}
+	}
+	return value
+}
+
+// numeric returns the first registered conversion of the type to a number.
+func (vm *VM) numeric(from reflect.Type) (conversion, bool) {
+	for _, c := range vm.converters[from] {
+		if runtime.IsNumberKind(c.to.Kind()) {
+			return c, true
+		}
+	}
+	return conversion{}, false
+}
+
+func (vm *VM) apply(c conversion, value any) any {
+	out, err := c.convert(value)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// convertArg converts the i-th argument of a call of the function of type t.
+func (vm *VM) convertArg(t reflect.Type, i int, value any) any {
+	fixed := t.NumIn()
+	if t.IsVariadic() {
+		fixed--
+	}
+	switch {
+	case i < fixed:
+		return vm.convert(value, t.In(i), false)
+	case t.IsVariadic():
+		return vm.convert(value, t.In(fixed).Elem(), false)
+	}
+	return value
+}
+
+// convertOperands converts one of the operands of mismatched types to the
+// type of the other one. Operands of the same type, which operators do not
+// support, like two decimals, are both converted to a number.
+func (vm *VM) convertOperands(a, b any) (any, any) {
+	ta, tb := reflect.TypeOf(a), reflect.TypeOf(b)
+	if ta == nil || tb == nil {
+		return a, b
+	}
+	if ta == tb {
+		if ta.Kind() == reflect.String || runtime.IsNumberKind(ta.Kind()) {
+			return a, b
+		}
+		if c, ok := vm.numeric(ta); ok {
+			return vm.apply(c, a), vm.apply(c, b)
+		}
+		return a, b
+	}
+	if _, ok := vm.converters[ta]; ok {
+		return vm.convert(a, tb, true), b
+	}
+	if _, ok := vm.converters[tb]; ok {
+		return a, vm.convert(b, ta, true)
+	}
+	return a, b
+}
+
+// convertTyped converts arguments of the typed function call, which are
+// on the stack under the function.
+func (vm *VM) convertTyped(fn any) {
+	t := reflect.TypeOf(fn)
+	args := vm.Stack[len(vm.Stack)-1-t.NumIn() : len(vm.Stack)-1]
+	if vm.tracer != nil {
+		vm.traceChange(len(vm.Stack) - 1 - t.NumIn())
+	}
+	for i := range args {
+		args[i] = vm.convertArg(t, i, args[i])
+	}
+}
@@ -0,0 +1,76 @@
+package vm
+
+import (
+	"errors"
+	"math/big"
+)
+
+// DivisionByZero is the policy of the `/` and `%` operators with a zero
+// divisor. The policy is selected per program at compile time.
+type DivisionByZero int
+
+const (
+	// DivisionDefault returns ±Inf (NaN for 0 / 0) for `/` and fails the
+	// run for `%`.
+	DivisionDefault DivisionByZero = iota
+	// DivisionError fails the run with ErrDivisionByZero.
+	DivisionError
+	// DivisionNil returns nil, so a bad record in the env does not abort
+	// evaluation of a batch.
+	DivisionNil
+	// DivisionInf returns ±Inf (NaN for 0 / 0) for `/` and 0 for `%`.
+	DivisionInf
+)
+
+// ErrDivisionByZero is returned by programs compiled with DivisionError.
+var ErrDivisionByZero = errors.New("division by zero")
+
+// divideByZero returns the result of the operator with a zero divisor,
+// if the policy defines it.
+func divideByZero(policy DivisionByZero, operator string, divisor any) (any, bool) {
+	if policy == DivisionDefault || !isZero(divisor) {
+		return nil, false
+	}
+	switch policy {
+	case DivisionError:
+		panic(ErrDivisionByZero)
+	case DivisionInf:
+		if operator == "%" {
+			return 0, true
+		}
+		return nil, false
+	}
+	return nil, true
+}
+
+func isZero(v any) bool {
+	switch x := v.(type) {
+	case int:
+		return x == 0
+	case int8:
+		return x == 0
+	case int16:
+		return x == 0
+	case int32:
+		return x == 0
+	case int64:
+		return x == 0
+	case uint:
+		return x == 0
+	case uint8:
+		return x == 0
+	case uint16:
+		return x == 0
+	case uint32:
+		return x == 0
+	case uint64:
+		return x == 0
+	case float32:
+		return x == 0
+	case float64:
+		return x == 0
+	case *big.Int:
+		return x.Sign() == 0
+	}
+	return false
+}
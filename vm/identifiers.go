@@ -0,0 +1,113 @@
+package vm
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/expr-lang/expr/ast"
+)
+
+// Identifiers lists names of the environment referenced by a program.
+type Identifiers struct {
+	Variables []string // Variables of the environment, like "user".
+	Members   []string // Member paths, like "user.Address.City".
+	Functions []string // Functions and methods, like "now" or "user.Greet".
+}
+
+// Identifiers returns variables, member paths and functions of the
+// environment which the program references, so the data needed to run it can
+// be fetched beforehand. Variables declared with let, builtins and elements
+// of predicates are not listed. Only the longest member paths are listed:
+// for "user.Address.City" and "user.Address" it is the former one. Member
+// access with a dynamic property, like user[key], ends the path.
+//
+// Identifiers are collected from the AST of the program, so programs decoded
+// with UnmarshalBinary have none.
+func (program *Program) Identifiers() Identifiers {
+	if program.node == nil {
+		return Identifiers{}
+	}
+	v := &identifiers{
+		lets:    make(map[string]bool),
+		callees: make(map[ast.Node]bool),
+		paths:   make(map[*ast.MemberNode][]string),
+	}
+	node := program.node
+	ast.Walk(&node, v)
+
+	variables := make(map[string]bool)
+	members := make(map[string]bool)
+	functions := make(map[string]bool)
+	for _, id := range v.idents {
+		if v.lets[id.Value] {
+			continue
+		}
+		if v.callees[id] {
+			functions[id.Value] = true
+		} else {
+			variables[id.Value] = true
+		}
+	}
+	for member, path := range v.paths {
+		if v.lets[path[0]] {
+			continue
+		}
+		switch {
+		case v.callees[member]:
+			functions[strings.Join(path, ".")] = true
+			if len(path) > 1 {
+				variables[path[0]] = true
+			}
+		case len(path) > 1:
+			members[strings.Join(path, ".")] = true
+			fallthrough
+		default:
+			variables[path[0]] = true
+		}
+	}
+	for path := range members {
+		for prefix := path; strings.Contains(prefix, "."); {
+			prefix = prefix[:strings.LastIndex(prefix, ".")]
+			delete(members, prefix)
+		}
+	}
+
+	return Identifiers{
+		Variables: sortedKeys(variables),
+		Members:   sortedKeys(members),
+		Functions: sortedKeys(functions),
+	}
+}
+
+type identifiers struct {
+	idents  []*ast.IdentifierNode
+	lets    map[string]bool
+	callees map[ast.Node]bool
+	paths   map[*ast.MemberNode][]string
+}
+
+func (v *identifiers) Visit(node *ast.Node) {
+	switch n := (*node).(type) {
+	case *ast.IdentifierNode:
+		if n.Value != "$env" {
+			v.idents = append(v.idents, n)
+		}
+	case *ast.VariableDeclaratorNode:
+		v.lets[n.Name] = true
+	case *ast.CallNode:
+		v.callees[n.Callee] = true
+	case *ast.MemberNode:
+		if path, ok := ast.MemberPath(n); ok {
+			v.paths[n] = path
+		}
+	}
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,743 @@
+package vm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/big"
+	"net/netip"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/expr-lang/expr/builtin"
+	"github.com/expr-lang/expr/file"
+	"github.com/expr-lang/expr/vm/runtime"
+)
+
+// Binary format of programs starts with the magic, the version of the format
+// and the fingerprint of opcodes, typed functions and builtins, as bytecode
+// refers to them by index.
+const (
+	binaryMagic   = "EXPR"
+	binaryVersion = 2
+
+	// maxDecodeDepth limits nesting of decoded types and values, and
+	// maxDecodeArray limits the size of decoded array types in bytes.
+	maxDecodeDepth = 64
+	maxDecodeArray = 1 << 20
+)
+
+// Tags of types and constants in the binary format.
+const (
+	tagNil byte = iota
+	tagBool
+	tagInt
+	tagInt8
+	tagInt16
+	tagInt32
+	tagInt64
+	tagUint
+	tagUint8
+	tagUint16
+	tagUint32
+	tagUint64
+	tagFloat32
+	tagFloat64
+	tagString
+	tagAny
+	tagDuration
+	tagTime
+	tagSlice
+	tagArray
+	tagMap
+	tagEmptyStruct
+	tagField
+	tagMethod
+	tagRegexp
+	tagError
+	tagType
+	tagCIDR
+	tagMatcher
+	tagSafeBuiltin
+	tagVersion
+	tagAddr
+	tagBigInt
+)
+
+var basicTypes = map[byte]reflect.Type{
+	tagBool:     reflect.TypeOf(false),
+	tagInt:      reflect.TypeOf(0),
+	tagInt8:     reflect.TypeOf(int8(0)),
+	tagInt16:    reflect.TypeOf(int16(0)),
+	tagInt32:    reflect.TypeOf(int32(0)),
+	tagInt64:    reflect.TypeOf(int64(0)),
+	tagUint:     reflect.TypeOf(uint(0)),
+	tagUint8:    reflect.TypeOf(uint8(0)),
+	tagUint16:   reflect.TypeOf(uint16(0)),
+	tagUint32:   reflect.TypeOf(uint32(0)),
+	tagUint64:   reflect.TypeOf(uint64(0)),
+	tagFloat32:  reflect.TypeOf(float32(0)),
+	tagFloat64:  reflect.TypeOf(float64(0)),
+	tagString:   reflect.TypeOf(""),
+	tagAny:      reflect.TypeOf((*any)(nil)).Elem(),
+	tagDuration: reflect.TypeOf(time.Duration(0)),
+	tagTime:     reflect.TypeOf(time.Time{}),
+	tagCIDR:     reflect.TypeOf(builtin.CIDR{}),
+	tagVersion:  reflect.TypeOf(builtin.Version{}),
+	tagAddr:     reflect.TypeOf(netip.Addr{}),
+	tagBigInt:   reflect.TypeOf((*big.Int)(nil)),
+
+	tagEmptyStruct: reflect.TypeOf(struct{}{}),
+}
+
+var basicTags = func() map[reflect.Type]byte {
+	tags := make(map[reflect.Type]byte, len(basicTypes))
+	for tag, t := range basicTypes {
+		tags[t] = tag
+	}
+	return tags
+}()
+
+// fingerprint changes if opcodes, typed functions or builtins are changed.
+var fingerprint = func() uint64 {
+	h := fnv.New64a()
+	for op := Opcode(0); op <= OpEnd; op++ {
+		_, _ = fmt.Fprintf(h, "%v;", op)
+	}
+	for _, fn := range FuncTypes {
+		_, _ = fmt.Fprintf(h, "%T;", fn)
+	}
+	for _, fn := range builtin.Builtins {
+		_, _ = fmt.Fprintf(h, "%s;", fn.Name)
+	}
+	return h.Sum64()
+}()
+
+// digest encodes the bytecode and the constants of the program like
+// MarshalBinary, but without the source and the locations. Constants which
+// can not be encoded, like structs, are encoded by their Go syntax, and
+// functions by their types.
+func (program *Program) digest() []byte {
+	e := &encoder{}
+	e.uvarint(uint64(program.variables))
+	e.uvarint(uint64(len(program.Bytecode)))
+	for i, op := range program.Bytecode {
+		e.buf = append(e.buf, byte(op))
+		e.varint(int64(program.Arguments[i]))
+	}
+	e.uvarint(uint64(len(program.Constants)))
+	for _, c := range program.Constants {
+		size := len(e.buf)
+		if err := e.constant(c); err != nil {
+			e.buf = e.buf[:size]
+			if reflect.TypeOf(c).Kind() == reflect.Func {
+				e.string(fmt.Sprintf("%T", c))
+			} else {
+				e.string(fmt.Sprintf("%#v", c))
+			}
+		}
+	}
+	e.uvarint(uint64(len(program.functions)))
+	for i := range program.functions {
+		e.string(program.debugInfo[fmt.Sprintf("func_%d", i)])
+	}
+	return e.buf
+}
+
+// MarshalBinary encodes the program, so it can be stored and run later
+// without parsing and checking of the expression again. Constants of
+// custom types, like results of ConstExpr functions of struct types,
+// and programs compiled with profiling can not be encoded.
+func (program *Program) MarshalBinary() ([]byte, error) {
+	if program.span != nil {
+		return nil, fmt.Errorf("cannot marshal program with profiling")
+	}
+	e := &encoder{buf: []byte(binaryMagic)}
+	e.uvarint(binaryVersion)
+	e.uvarint(fingerprint)
+
+	e.string(string(program.source))
+	e.uvarint(uint64(program.variables))
+
+	e.uvarint(uint64(len(program.Bytecode)))
+	for i, op := range program.Bytecode {
+		e.buf = append(e.buf, byte(op))
+		e.varint(int64(program.Arguments[i]))
+	}
+
+	e.uvarint(uint64(len(program.locations)))
+	for _, loc := range program.locations {
+		e.varint(int64(loc.From))
+		e.varint(int64(loc.To))
+	}
+
+	e.uvarint(uint64(len(program.Constants)))
+	for _, c := range program.Constants {
+		if err := e.constant(c); err != nil {
+			return nil, err
+		}
+	}
+
+	access := program.access
+	if access == nil {
+		access = &runtime.Access{}
+	}
+	e.string(access.Tag)
+	e.set(access.Allowed)
+	e.set(access.Denied)
+
+	e.uvarint(uint64(len(program.functions)))
+	for i := range program.functions {
+		e.string(program.debugInfo[fmt.Sprintf("func_%d", i)])
+	}
+
+	keys := make([]string, 0, len(program.debugInfo))
+	for key := range program.debugInfo {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	e.uvarint(uint64(len(keys)))
+	for _, key := range keys {
+		e.string(key)
+		e.string(program.debugInfo[key])
+	}
+	return e.buf, nil
+}
+
+// UnmarshalBinary decodes the program encoded by MarshalBinary. Only
+// builtin functions are resolved, use UnmarshalProgram to resolve
+// custom functions. The AST of the expression is not restored.
+func (program *Program) UnmarshalBinary(data []byte) error {
+	p, err := UnmarshalProgram(data, nil)
+	if err != nil {
+		return err
+	}
+	*program = *p
+	return nil
+}
+
+// UnmarshalProgram decodes the program encoded by MarshalBinary. Functions
+// of the program are resolved by name with resolve, and then by builtins.
+func UnmarshalProgram(data []byte, resolve func(name string) (Function, bool)) (_ *Program, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid program: %v", r)
+		}
+	}()
+
+	if !strings.HasPrefix(string(data), binaryMagic) {
+		return nil, fmt.Errorf("invalid program: unknown format")
+	}
+	d := &decoder{buf: data[len(binaryMagic):]}
+	if d.uvarint() != binaryVersion || d.uvarint() != fingerprint {
+		return nil, fmt.Errorf("invalid program: compiled by incompatible version of expr")
+	}
+
+	program := &Program{debugInfo: make(map[string]string)}
+	program.source = file.NewSource(d.string())
+	program.variables = int(d.uvarint())
+
+	size := d.len()
+	program.Bytecode = make([]Opcode, size)
+	program.Arguments = make([]int, size)
+	for i := 0; i < size; i++ {
+		program.Bytecode[i] = Opcode(d.byte())
+		program.Arguments[i] = int(d.varint())
+	}
+
+	program.locations = make([]file.Location, d.len())
+	for i := range program.locations {
+		program.locations[i] = file.Location{From: int(d.varint()), To: int(d.varint())}
+	}
+
+	program.Constants = make([]any, d.len())
+	for i := range program.Constants {
+		program.Constants[i] = d.constant()
+	}
+
+	access := &runtime.Access{Tag: d.string(), Allowed: d.set(), Denied: d.set()}
+	if access.Tag != "" || access.Restricted() {
+		program.access = access
+	}
+
+	program.functions = make([]Function, d.len())
+	for i := range program.functions {
+		name := d.string()
+		fn, ok := resolveFunction(name, resolve, program.access)
+		if !ok {
+			return nil, fmt.Errorf("unknown function %v", name)
+		}
+		program.functions[i] = fn
+	}
+
+	for i, size := 0, d.len(); i < size; i++ {
+		key := d.string()
+		program.debugInfo[key] = d.string()
+	}
+	if len(d.buf) > 0 {
+		return nil, fmt.Errorf("invalid program: unexpected data at the end")
+	}
+	return program, nil
+}
+
+func resolveFunction(name string, resolve func(name string) (Function, bool), access *runtime.Access) (Function, bool) {
+	if resolve != nil {
+		if fn, ok := resolve(name); ok {
+			return fn, true
+		}
+	}
+	if access != nil {
+		if fn, ok := builtin.WithAccess(name, access); ok {
+			return fn, true
+		}
+	}
+	// Overloads are named by the index of the overload, like "name#1".
+	base, overload, isOverload := strings.Cut(name, "#")
+	id, ok := builtin.Index[base]
+	if !ok {
+		return nil, false
+	}
+	fn := builtin.Builtins[id]
+	if isOverload {
+		i, err := strconv.Atoi(overload)
+		if err != nil || i < 0 || i >= len(fn.Overloads) {
+			return nil, false
+		}
+		return fn.Overloads[i], true
+	}
+	return fn.Func, fn.Func != nil
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) uvarint(x uint64) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf = append(e.buf, b[:binary.PutUvarint(b[:], x)]...)
+}
+
+func (e *encoder) varint(x int64) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf = append(e.buf, b[:binary.PutVarint(b[:], x)]...)
+}
+
+func (e *encoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// set encodes the names of the set in sorted order.
+func (e *encoder) set(set map[string]bool) {
+	names := make([]string, 0, len(set))
+	for name, ok := range set {
+		if ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	e.uvarint(uint64(len(names)))
+	for _, name := range names {
+		e.string(name)
+	}
+}
+
+func (e *encoder) constant(c any) error {
+	switch c := c.(type) {
+	case nil:
+		e.buf = append(e.buf, tagNil)
+	case *runtime.Field:
+		e.buf = append(e.buf, tagField)
+		e.uvarint(uint64(len(c.Index)))
+		for _, i := range c.Index {
+			e.varint(int64(i))
+		}
+		e.uvarint(uint64(len(c.Path)))
+		for _, name := range c.Path {
+			e.string(name)
+		}
+	case *runtime.Method:
+		e.buf = append(e.buf, tagMethod)
+		e.varint(int64(c.Index))
+		e.string(c.Name)
+	case *regexp.Regexp:
+		e.buf = append(e.buf, tagRegexp)
+		e.string(c.String())
+	case reflect.Type:
+		e.buf = append(e.buf, tagType)
+		return e.typ(c)
+	case *builtin.Matcher:
+		e.buf = append(e.buf, tagMatcher)
+		strs := c.Strings()
+		e.uvarint(uint64(len(strs)))
+		for _, s := range strs {
+			e.string(s)
+		}
+	case SafeFunction:
+		for _, fn := range builtin.Builtins {
+			if fn.Safe != nil && reflect.ValueOf(fn.Safe).Pointer() == reflect.ValueOf(c).Pointer() {
+				e.buf = append(e.buf, tagSafeBuiltin)
+				e.string(fn.Name)
+				return nil
+			}
+		}
+		return fmt.Errorf("cannot marshal function constant")
+	case error:
+		e.buf = append(e.buf, tagError)
+		e.string(c.Error())
+	default:
+		v := reflect.ValueOf(c)
+		if err := e.typ(v.Type()); err != nil {
+			return err
+		}
+		return e.value(v)
+	}
+	return nil
+}
+
+func (e *encoder) typ(t reflect.Type) error {
+	if tag, ok := basicTags[t]; ok {
+		e.buf = append(e.buf, tag)
+		return nil
+	}
+	if t.Name() != "" {
+		return fmt.Errorf("cannot marshal constant of type %v", t)
+	}
+	switch t.Kind() {
+	case reflect.Slice:
+		e.buf = append(e.buf, tagSlice)
+		return e.typ(t.Elem())
+	case reflect.Array:
+		e.buf = append(e.buf, tagArray)
+		e.uvarint(uint64(t.Len()))
+		return e.typ(t.Elem())
+	case reflect.Map:
+		e.buf = append(e.buf, tagMap)
+		if err := e.typ(t.Key()); err != nil {
+			return err
+		}
+		return e.typ(t.Elem())
+	}
+	return fmt.Errorf("cannot marshal constant of type %v", t)
+}
+
+func (e *encoder) value(v reflect.Value) error {
+	switch t := v.Type(); {
+	case t == basicTypes[tagTime]:
+		data, err := v.Interface().(time.Time).MarshalBinary()
+		if err != nil {
+			return err
+		}
+		e.string(string(data))
+		return nil
+	case t == basicTypes[tagCIDR]:
+		e.string(v.Interface().(builtin.CIDR).Prefix.String())
+		return nil
+	case t == basicTypes[tagVersion]:
+		version := v.Interface().(builtin.Version)
+		e.uvarint(version.Major)
+		e.uvarint(version.Minor)
+		e.uvarint(version.Patch)
+		e.string(version.Prerelease)
+		e.string(version.Build)
+		return nil
+	case t == basicTypes[tagAddr]:
+		data, err := v.Interface().(netip.Addr).MarshalBinary()
+		if err != nil {
+			return err
+		}
+		e.string(string(data))
+		return nil
+	case t == basicTypes[tagBigInt]:
+		var text string
+		if !v.IsNil() {
+			text = v.Interface().(*big.Int).String()
+		}
+		e.string(text)
+		return nil
+	case t.Kind() == reflect.Interface:
+		if v.IsNil() {
+			return e.constant(nil)
+		}
+		return e.constant(v.Elem().Interface())
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, 1)
+		} else {
+			e.buf = append(e.buf, 0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.varint(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		e.uvarint(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		e.uvarint(math.Float64bits(v.Float()))
+	case reflect.String:
+		e.string(v.String())
+	case reflect.Slice, reflect.Array:
+		e.uvarint(uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			if err := e.value(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		// Entries are sorted by encoded keys, so equal maps are encoded
+		// to equal bytes.
+		type entry struct{ key, value []byte }
+		entries := make([]entry, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key := &encoder{}
+			if err := key.value(iter.Key()); err != nil {
+				return err
+			}
+			value := &encoder{}
+			if err := value.value(iter.Value()); err != nil {
+				return err
+			}
+			entries = append(entries, entry{key.buf, value.buf})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return bytes.Compare(entries[i].key, entries[j].key) < 0
+		})
+		e.uvarint(uint64(len(entries)))
+		for _, entry := range entries {
+			e.buf = append(e.buf, entry.key...)
+			e.buf = append(e.buf, entry.value...)
+		}
+	case reflect.Struct:
+		// Only empty structs are accepted by typ.
+	default:
+		return fmt.Errorf("cannot marshal constant of type %v", v.Type())
+	}
+	return nil
+}
+
+// decoder panics on invalid data, the panic is recovered by UnmarshalProgram.
+type decoder struct {
+	buf   []byte
+	depth int
+}
+
+// enter counts nesting of decoded types and values, which is limited,
+// as invalid data can nest them deep enough to exhaust the stack.
+func (d *decoder) enter() {
+	d.depth++
+	if d.depth > maxDecodeDepth {
+		panic("too deeply nested")
+	}
+}
+
+func (d *decoder) leave() {
+	d.depth--
+}
+
+func (d *decoder) byte() byte {
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+func (d *decoder) uvarint() uint64 {
+	x, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		panic("invalid varint")
+	}
+	d.buf = d.buf[n:]
+	return x
+}
+
+func (d *decoder) varint() int64 {
+	x, n := binary.Varint(d.buf)
+	if n <= 0 {
+		panic("invalid varint")
+	}
+	d.buf = d.buf[n:]
+	return x
+}
+
+// len reads the length of a list, which can not be longer than the data.
+func (d *decoder) len() int {
+	n := d.uvarint()
+	if n > uint64(len(d.buf)) {
+		panic("invalid length")
+	}
+	return int(n)
+}
+
+func (d *decoder) string() string {
+	n := d.len()
+	s := string(d.buf[:n])
+	d.buf = d.buf[n:]
+	return s
+}
+
+func (d *decoder) set() map[string]bool {
+	size := d.len()
+	if size == 0 {
+		return nil
+	}
+	set := make(map[string]bool, size)
+	for i := 0; i < size; i++ {
+		set[d.string()] = true
+	}
+	return set
+}
+
+func (d *decoder) constant() any {
+	switch tag := d.byte(); tag {
+	case tagNil:
+		return nil
+	case tagField:
+		field := &runtime.Field{Index: make([]int, d.len())}
+		for i := range field.Index {
+			field.Index[i] = int(d.varint())
+		}
+		field.Path = make([]string, d.len())
+		for i := range field.Path {
+			field.Path[i] = d.string()
+		}
+		return field
+	case tagMethod:
+		index := int(d.varint())
+		return &runtime.Method{Index: index, Name: d.string()}
+	case tagRegexp:
+		return regexp.MustCompile(d.string())
+	case tagType:
+		return d.typ(d.byte())
+	case tagMatcher:
+		strs := make([]string, d.len())
+		for i := range strs {
+			strs[i] = d.string()
+		}
+		return builtin.NewMatcher(strs)
+	case tagSafeBuiltin:
+		name := d.string()
+		id, ok := builtin.Index[name]
+		if !ok || builtin.Builtins[id].Safe == nil {
+			panic(fmt.Sprintf("unknown builtin %v", name))
+		}
+		return SafeFunction(builtin.Builtins[id].Safe)
+	case tagError:
+		return errors.New(d.string())
+	default:
+		return d.value(d.typ(tag)).Interface()
+	}
+}
+
+func (d *decoder) typ(tag byte) reflect.Type {
+	if t, ok := basicTypes[tag]; ok {
+		return t
+	}
+	d.enter()
+	defer d.leave()
+	switch tag {
+	case tagSlice:
+		return reflect.SliceOf(d.typ(d.byte()))
+	case tagArray:
+		n := d.len()
+		elem := d.typ(d.byte())
+		if uint64(n)*uint64(elem.Size()) > maxDecodeArray {
+			panic("array is too large")
+		}
+		return reflect.ArrayOf(n, elem)
+	case tagMap:
+		key := d.typ(d.byte())
+		return reflect.MapOf(key, d.typ(d.byte()))
+	}
+	panic(fmt.Sprintf("unknown tag %v", tag))
+}
+
+func (d *decoder) value(t reflect.Type) reflect.Value {
+	d.enter()
+	defer d.leave()
+	v := reflect.New(t).Elem()
+	switch {
+	case t == basicTypes[tagTime]:
+		var tm time.Time
+		if err := tm.UnmarshalBinary([]byte(d.string())); err != nil {
+			panic(err)
+		}
+		v.Set(reflect.ValueOf(tm))
+		return v
+	case t == basicTypes[tagCIDR]:
+		cidr, err := builtin.ParseCIDR(d.string())
+		if err != nil {
+			panic(err)
+		}
+		v.Set(reflect.ValueOf(cidr))
+		return v
+	case t == basicTypes[tagVersion]:
+		version := builtin.Version{Major: d.uvarint(), Minor: d.uvarint(), Patch: d.uvarint()}
+		version.Prerelease = d.string()
+		version.Build = d.string()
+		v.Set(reflect.ValueOf(version))
+		return v
+	case t == basicTypes[tagAddr]:
+		var addr netip.Addr
+		if err := addr.UnmarshalBinary([]byte(d.string())); err != nil {
+			panic(err)
+		}
+		v.Set(reflect.ValueOf(addr))
+		return v
+	case t == basicTypes[tagBigInt]:
+		if text := d.string(); text != "" {
+			n, ok := new(big.Int).SetString(text, 10)
+			if !ok {
+				panic("invalid big integer")
+			}
+			v.Set(reflect.ValueOf(n))
+		}
+		return v
+	case t.Kind() == reflect.Interface:
+		if c := d.constant(); c != nil {
+			v.Set(reflect.ValueOf(c))
+		}
+		return v
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		v.SetBool(d.byte() == 1)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(d.varint())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(d.uvarint())
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(math.Float64frombits(d.uvarint()))
+	case reflect.String:
+		v.SetString(d.string())
+	case reflect.Slice:
+		n := d.len()
+		v.Set(reflect.MakeSlice(t, n, n))
+		for i := 0; i < n; i++ {
+			v.Index(i).Set(d.value(t.Elem()))
+		}
+	case reflect.Array:
+		if n := d.len(); n != t.Len() {
+			panic("invalid array length")
+		}
+		for i := 0; i < t.Len(); i++ {
+			v.Index(i).Set(d.value(t.Elem()))
+		}
+	case reflect.Map:
+		n := d.len()
+		v.Set(reflect.MakeMapWithSize(t, n))
+		for i := 0; i < n; i++ {
+			key := d.value(t.Key())
+			v.SetMapIndex(key, d.value(t.Elem()))
+		}
+	}
+	return v
+}
@@ -1,5 +1,7 @@
 package vm
 
+import "fmt"
+
 type Opcode byte
 
 const (
@@ -44,12 +46,30 @@ const (
 	OpDivide
 	OpModulo
 	OpExponent
+	OpEqualFloat
+	OpLessInt
+	OpMoreInt
+	OpLessOrEqualInt
+	OpMoreOrEqualInt
+	OpAddInt
+	OpSubtractInt
+	OpMultiplyInt
+	OpDivideInt
+	OpLessFloat
+	OpMoreFloat
+	OpLessOrEqualFloat
+	OpMoreOrEqualFloat
+	OpAddFloat
+	OpSubtractFloat
+	OpMultiplyFloat
+	OpDivideFloat
 	OpRange
 	OpMatches
 	OpMatchesConst
 	OpContains
 	OpStartsWith
 	OpEndsWith
+	OpConcat
 	OpSlice
 	OpCall
 	OpCall0
@@ -60,8 +80,10 @@ const (
 	OpCallFast
 	OpCallSafe
 	OpCallTyped
+	OpCallSpread
 	OpCallBuiltin1
 	OpArray
+	OpTypedArray
 	OpMap
 	OpLen
 	OpCast
@@ -81,8 +103,131 @@ const (
 	OpGroupBy
 	OpSortBy
 	OpSort
+	OpPartition
+	OpCountBy
+	OpGetKey
+	OpMapValues
+	OpMapKeys
 	OpProfileStart
 	OpProfileEnd
 	OpBegin
 	OpEnd // This opcode must be at the end of this list.
 )
+
+var opcodeNames = [...]string{
+	OpInvalid:          "OpInvalid",
+	OpPush:             "OpPush",
+	OpInt:              "OpInt",
+	OpPop:              "OpPop",
+	OpStore:            "OpStore",
+	OpLoadVar:          "OpLoadVar",
+	OpLoadConst:        "OpLoadConst",
+	OpLoadField:        "OpLoadField",
+	OpLoadFast:         "OpLoadFast",
+	OpLoadMethod:       "OpLoadMethod",
+	OpLoadFunc:         "OpLoadFunc",
+	OpLoadEnv:          "OpLoadEnv",
+	OpFetch:            "OpFetch",
+	OpFetchField:       "OpFetchField",
+	OpMethod:           "OpMethod",
+	OpTrue:             "OpTrue",
+	OpFalse:            "OpFalse",
+	OpNil:              "OpNil",
+	OpNegate:           "OpNegate",
+	OpNot:              "OpNot",
+	OpEqual:            "OpEqual",
+	OpEqualInt:         "OpEqualInt",
+	OpEqualString:      "OpEqualString",
+	OpJump:             "OpJump",
+	OpJumpIfTrue:       "OpJumpIfTrue",
+	OpJumpIfFalse:      "OpJumpIfFalse",
+	OpJumpIfNil:        "OpJumpIfNil",
+	OpJumpIfNotNil:     "OpJumpIfNotNil",
+	OpJumpIfEnd:        "OpJumpIfEnd",
+	OpJumpBackward:     "OpJumpBackward",
+	OpIn:               "OpIn",
+	OpLess:             "OpLess",
+	OpMore:             "OpMore",
+	OpLessOrEqual:      "OpLessOrEqual",
+	OpMoreOrEqual:      "OpMoreOrEqual",
+	OpAdd:              "OpAdd",
+	OpSubtract:         "OpSubtract",
+	OpMultiply:         "OpMultiply",
+	OpDivide:           "OpDivide",
+	OpModulo:           "OpModulo",
+	OpExponent:         "OpExponent",
+	OpEqualFloat:       "OpEqualFloat",
+	OpLessInt:          "OpLessInt",
+	OpMoreInt:          "OpMoreInt",
+	OpLessOrEqualInt:   "OpLessOrEqualInt",
+	OpMoreOrEqualInt:   "OpMoreOrEqualInt",
+	OpAddInt:           "OpAddInt",
+	OpSubtractInt:      "OpSubtractInt",
+	OpMultiplyInt:      "OpMultiplyInt",
+	OpDivideInt:        "OpDivideInt",
+	OpLessFloat:        "OpLessFloat",
+	OpMoreFloat:        "OpMoreFloat",
+	OpLessOrEqualFloat: "OpLessOrEqualFloat",
+	OpMoreOrEqualFloat: "OpMoreOrEqualFloat",
+	OpAddFloat:         "OpAddFloat",
+	OpSubtractFloat:    "OpSubtractFloat",
+	OpMultiplyFloat:    "OpMultiplyFloat",
+	OpDivideFloat:      "OpDivideFloat",
+	OpRange:            "OpRange",
+	OpMatches:          "OpMatches",
+	OpMatchesConst:     "OpMatchesConst",
+	OpContains:         "OpContains",
+	OpStartsWith:       "OpStartsWith",
+	OpEndsWith:         "OpEndsWith",
+	OpConcat:           "OpConcat",
+	OpSlice:            "OpSlice",
+	OpCall:             "OpCall",
+	OpCall0:            "OpCall0",
+	OpCall1:            "OpCall1",
+	OpCall2:            "OpCall2",
+	OpCall3:            "OpCall3",
+	OpCallN:            "OpCallN",
+	OpCallFast:         "OpCallFast",
+	OpCallSafe:         "OpCallSafe",
+	OpCallTyped:        "OpCallTyped",
+	OpCallSpread:       "OpCallSpread",
+	OpCallBuiltin1:     "OpCallBuiltin1",
+	OpArray:            "OpArray",
+	OpTypedArray:       "OpTypedArray",
+	OpMap:              "OpMap",
+	OpLen:              "OpLen",
+	OpCast:             "OpCast",
+	OpDeref:            "OpDeref",
+	OpIncrementIndex:   "OpIncrementIndex",
+	OpDecrementIndex:   "OpDecrementIndex",
+	OpIncrementCount:   "OpIncrementCount",
+	OpGetIndex:         "OpGetIndex",
+	OpGetCount:         "OpGetCount",
+	OpGetLen:           "OpGetLen",
+	OpGetAcc:           "OpGetAcc",
+	OpSetAcc:           "OpSetAcc",
+	OpSetIndex:         "OpSetIndex",
+	OpPointer:          "OpPointer",
+	OpThrow:            "OpThrow",
+	OpCreate:           "OpCreate",
+	OpGroupBy:          "OpGroupBy",
+	OpSortBy:           "OpSortBy",
+	OpSort:             "OpSort",
+	OpPartition:        "OpPartition",
+	OpCountBy:          "OpCountBy",
+	OpGetKey:           "OpGetKey",
+	OpMapValues:        "OpMapValues",
+	OpMapKeys:          "OpMapKeys",
+	OpProfileStart:     "OpProfileStart",
+	OpProfileEnd:       "OpProfileEnd",
+	OpBegin:            "OpBegin",
+	OpEnd:              "OpEnd",
+}
+
+// String returns the name of the opcode, like "OpPush".
+func (op Opcode) String() string {
+	if int(op) < len(opcodeNames) {
+		return opcodeNames[op]
+	}
+	return fmt.Sprintf("%#x", byte(op))
+}
@@ -0,0 +1,71 @@
+package vm
+
+import "fmt"
+
+// Option configures the VM for a run of a program.
+type Option func(vm *VM)
+
+// MaxStackSize limits the number of values on the VM stack.
+func MaxStackSize(n int) Option {
+	return func(vm *VM) {
+		vm.maxStackSize = n
+	}
+}
+
+// MaxScopeDepth limits the depth of nested predicates, like
+// map() called inside a closure of filter().
+func MaxScopeDepth(n int) Option {
+	return func(vm *VM) {
+		vm.maxScopeDepth = n
+	}
+}
+
+// MaxSteps limits the number of instructions executed in a run, so
+// untrusted expressions can not run for too long. Loops of predicates,
+// like filter(), count every instruction of each iteration.
+func MaxSteps(n int) Option {
+	return func(vm *VM) {
+		vm.maxSteps = n
+	}
+}
+
+// MaxMemory limits the number of bytes allocated in a run by operations
+// which build strings, slices and maps, like `s + s`, `1..n` or map(), so
+// untrusted expressions can not exhaust the memory of the host. Sizes are
+// estimated and checked before the allocation where it is possible.
+func MaxMemory(bytes int) Option {
+	return func(vm *VM) {
+		vm.maxMemory = bytes
+	}
+}
+
+// NilSafe makes indexing and slicing of nil values return nil instead of
+// an error. For example, with NilSafe `tags[0]` is nil if tags is a nil slice.
+func NilSafe() Option {
+	return func(vm *VM) {
+		vm.nilSafe = true
+	}
+}
+
+// GracefulNil makes the VM treat missing data as nil, which suits
+// expressions over heterogeneous data, like JSON events. Missing fields,
+// absent keys and out of range indexes are nil instead of an error, and
+// ordering comparisons with nil, like `event.size > 10`, are false.
+// GracefulNil implies NilSafe.
+func GracefulNil() Option {
+	return func(vm *VM) {
+		vm.nilSafe = true
+		vm.gracefulNil = true
+	}
+}
+
+// LimitError is returned by the VM when a program exceeds one of the limits
+// configured via options.
+type LimitError struct {
+	Resource string // Resource which was exhausted, like "stack slots".
+	Limit    int    // Configured limit of the resource.
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("expression exceeded %d %s", e.Limit, e.Resource)
+}
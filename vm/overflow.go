@@ -0,0 +1,137 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+
+	"github.com/expr-lang/expr/vm/runtime"
+)
+
+// Overflow is the policy of integer overflow in arithmetic.
+type Overflow int
+
+const (
+	// OverflowWrap wraps the result around, like Go does.
+	OverflowWrap Overflow = iota
+	// OverflowError fails the run with ErrIntegerOverflow.
+	OverflowError
+	// OverflowSaturate clamps the result to the largest or the smallest int.
+	OverflowSaturate
+	// OverflowPromote returns the exact result as *big.Int.
+	OverflowPromote
+)
+
+// ErrIntegerOverflow is returned by runs with OverflowError if the result of
+// integer arithmetic does not fit into int.
+var ErrIntegerOverflow = errors.New("integer overflow")
+
+// StrictArithmetic sets the policy of integer overflow on `+`, `-`, `*` and
+// negation, so rules like billing never use a wrapped around result. Results
+// must fit the type of the operands, like int8 for `Int8 + Int8`, and int. The `**`
+// operator always returns float64 and does not wrap. OverflowPromote needs
+// programs compiled with expr.PromoteOverflow, which do not assume results
+// of integer arithmetic are ints.
+func StrictArithmetic(policy Overflow) Option {
+	return func(vm *VM) {
+		vm.overflow = policy
+	}
+}
+
+// checked applies the integer operator, like "+", with the overflow policy.
+// The result overflows if it does not fit the type of the operands, or int
+// if types of the operands differ, like `Int8 + Int8` above 127.
+func (vm *VM) checked(operator string, a, b any) any {
+	if x, ok := a.(int); ok {
+		if y, ok := b.(int); ok {
+			var c int
+			var overflow bool
+			switch operator {
+			case "+":
+				c, overflow = runtime.AddInt(x, y)
+			case "-":
+				c, overflow = runtime.SubtractInt(x, y)
+			case "*":
+				c, overflow = runtime.MultiplyInt(x, y)
+			}
+			if !overflow {
+				return c
+			}
+		}
+	}
+	x, y := runtime.ToBigInt(a), runtime.ToBigInt(b)
+	z := new(big.Int)
+	switch operator {
+	case "+":
+		z.Add(x, y)
+	case "-":
+		z.Sub(x, y)
+	case "*":
+		z.Mul(x, y)
+	}
+	return vm.fit(z, a, b, func() error {
+		return fmt.Errorf("%w: %v %v %v", ErrIntegerOverflow, a, operator, b)
+	})
+}
+
+// negated negates the integer with the overflow policy, like `-Int8` of -128.
+func (vm *VM) negated(a any) any {
+	z := new(big.Int).Neg(runtime.ToBigInt(a))
+	return vm.fit(z, a, a, func() error {
+		return fmt.Errorf("%w: -(%v)", ErrIntegerOverflow, a)
+	})
+}
+
+// fit returns the exact result of arithmetic on the operands as int, if it
+// fits their type, or applies the overflow policy.
+func (vm *VM) fit(z *big.Int, a, b any, overflow func() error) any {
+	lo, hi := bounds(a, b)
+	if z.Cmp(lo) >= 0 && z.Cmp(hi) <= 0 {
+		return int(z.Int64())
+	}
+	switch vm.overflow {
+	case OverflowPromote:
+		if z.Cmp(minInt) >= 0 && z.Cmp(maxInt) <= 0 {
+			return int(z.Int64())
+		}
+		return z
+	case OverflowSaturate:
+		if z.Sign() > 0 {
+			return int(hi.Int64())
+		}
+		return int(lo.Int64())
+	}
+	panic(overflow())
+}
+
+var (
+	minInt = big.NewInt(math.MinInt)
+	maxInt = big.NewInt(math.MaxInt)
+)
+
+// bounds returns the smallest and the largest values of the integer type of
+// both operands, or of int if types differ. Results of arithmetic are ints,
+// so bounds are capped by bounds of int, like for uint64.
+func bounds(a, b any) (lo, hi *big.Int) {
+	lo, hi = minInt, maxInt
+	t := reflect.TypeOf(a)
+	if t != reflect.TypeOf(b) {
+		return lo, hi
+	}
+	switch t.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		lo = big.NewInt(-1 << (t.Bits() - 1))
+		hi = big.NewInt(1<<(t.Bits()-1) - 1)
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		lo = new(big.Int)
+		hi = new(big.Int).SetUint64(1<<t.Bits() - 1)
+	case reflect.Uint, reflect.Uint64:
+		lo = new(big.Int)
+	}
+	if hi.Cmp(maxInt) > 0 {
+		hi = maxInt
+	}
+	return lo, hi
+}
@@ -2,10 +2,13 @@ package vm
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"reflect"
 	"regexp"
+	"sort"
 	"strings"
 	"text/tabwriter"
 
@@ -28,6 +31,8 @@ type Program struct {
 	functions []Function
 	debugInfo map[string]string
 	span      *Span
+	access    *runtime.Access
+	calls     map[file.Location]string // names of called functions
 }
 
 // NewProgram returns a new Program. It's used by the compiler.
@@ -42,6 +47,7 @@ func NewProgram(
 	functions []Function,
 	debugInfo map[string]string,
 	span *Span,
+	access *runtime.Access,
 ) *Program {
 	return &Program{
 		source:    source,
@@ -54,6 +60,8 @@ func NewProgram(
 		functions: functions,
 		debugInfo: debugInfo,
 		span:      span,
+		access:    access,
+		calls:     callNames(node),
 	}
 }
 
@@ -67,11 +75,40 @@ func (program *Program) Node() ast.Node {
 	return program.node
 }
 
+// Hash returns a stable digest of the program. It is computed from the
+// serialized bytecode and constants, without the source, so expressions
+// which differ only in whitespace or formatting, like "a+b" and "a + b",
+// have the same hash, and programs decoded with UnmarshalBinary have the
+// hash of the encoded program. The hash does not cover options the program
+// was compiled with, other than ones changing the bytecode.
+func (program *Program) Hash() string {
+	sum := sha256.Sum256(program.digest())
+	return hex.EncodeToString(sum[:])
+}
+
 // Locations returns a slice of bytecode's locations.
 func (program *Program) Locations() []file.Location {
 	return program.locations
 }
 
+// SourceMap returns the line and the column in the source of each opcode,
+// so runtime errors and profilers can point to the part of the expression
+// which produced the opcode.
+func (program *Program) SourceMap() []file.Position {
+	lines := []int{0}
+	for i, r := range program.source {
+		if r == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	out := make([]file.Position, len(program.locations))
+	for ip, loc := range program.locations {
+		line := sort.SearchInts(lines, loc.From+1)
+		out[ip] = file.Position{Line: line, Column: loc.From - lines[line-1]}
+	}
+	return out
+}
+
 // Disassemble returns opcodes as a string.
 func (program *Program) Disassemble() string {
 	var buf bytes.Buffer
@@ -83,300 +120,108 @@ func (program *Program) Disassemble() string {
 
 // DisassembleWriter takes a writer and writes opcodes to it.
 func (program *Program) DisassembleWriter(w io.Writer) {
-	ip := 0
-	for ip < len(program.Bytecode) {
-		pp := ip
-		op := program.Bytecode[ip]
-		arg := program.Arguments[ip]
-		ip += 1
-
-		code := func(label string) {
-			_, _ = fmt.Fprintf(w, "%v\t%v\n", pp, label)
-		}
-		jump := func(label string) {
-			_, _ = fmt.Fprintf(w, "%v\t%v\t<%v>\t(%v)\n", pp, label, arg, ip+arg)
-		}
-		jumpBack := func(label string) {
-			_, _ = fmt.Fprintf(w, "%v\t%v\t<%v>\t(%v)\n", pp, label, arg, ip-arg)
-		}
-		argument := func(label string) {
-			_, _ = fmt.Fprintf(w, "%v\t%v\t<%v>\n", pp, label, arg)
-		}
-		argumentWithInfo := func(label string, prefix string) {
-			_, _ = fmt.Fprintf(w, "%v\t%v\t<%v>\t%v\n", pp, label, arg, program.debugInfo[fmt.Sprintf("%s_%d", prefix, arg)])
-		}
-		constant := func(label string) {
-			var c any
-			if arg < len(program.Constants) {
-				c = program.Constants[arg]
-			} else {
-				c = "out of range"
-			}
-			if r, ok := c.(*regexp.Regexp); ok {
-				c = r.String()
-			}
-			if field, ok := c.(*runtime.Field); ok {
-				c = fmt.Sprintf("{%v %v}", strings.Join(field.Path, "."), field.Index)
-			}
-			if method, ok := c.(*runtime.Method); ok {
-				c = fmt.Sprintf("{%v %v}", method.Name, method.Index)
-			}
-			_, _ = fmt.Fprintf(w, "%v\t%v\t<%v>\t%v\n", pp, label, arg, c)
-		}
-		builtinArg := func(label string) {
-			_, _ = fmt.Fprintf(w, "%v\t%v\t<%v>\t%v\n", pp, label, arg, builtin.Builtins[arg].Name)
-		}
-
-		switch op {
-		case OpInvalid:
-			code("OpInvalid")
-
-		case OpPush:
-			constant("OpPush")
-
-		case OpInt:
-			argument("OpInt")
-
-		case OpPop:
-			code("OpPop")
-
-		case OpStore:
-			argumentWithInfo("OpStore", "var")
-
-		case OpLoadVar:
-			argumentWithInfo("OpLoadVar", "var")
-
-		case OpLoadConst:
-			constant("OpLoadConst")
-
-		case OpLoadField:
-			constant("OpLoadField")
-
-		case OpLoadFast:
-			constant("OpLoadFast")
-
-		case OpLoadMethod:
-			constant("OpLoadMethod")
-
-		case OpLoadFunc:
-			argumentWithInfo("OpLoadFunc", "func")
-
-		case OpLoadEnv:
-			code("OpLoadEnv")
-
-		case OpFetch:
-			code("OpFetch")
-
-		case OpFetchField:
-			constant("OpFetchField")
-
-		case OpMethod:
-			constant("OpMethod")
-
-		case OpTrue:
-			code("OpTrue")
-
-		case OpFalse:
-			code("OpFalse")
-
-		case OpNil:
-			code("OpNil")
-
-		case OpNegate:
-			code("OpNegate")
-
-		case OpNot:
-			code("OpNot")
-
-		case OpEqual:
-			code("OpEqual")
-
-		case OpEqualInt:
-			code("OpEqualInt")
-
-		case OpEqualString:
-			code("OpEqualString")
-
-		case OpJump:
-			jump("OpJump")
-
-		case OpJumpIfTrue:
-			jump("OpJumpIfTrue")
+	for _, in := range program.Instructions() {
+		_, _ = fmt.Fprintln(w, in.String())
+	}
+}
 
-		case OpJumpIfFalse:
-			jump("OpJumpIfFalse")
+// Instruction is a disassembled opcode of the program.
+type Instruction struct {
+	Offset      int           // Position of the opcode in the bytecode.
+	Opcode      Opcode        // Opcode.
+	Argument    int           // Argument of the opcode.
+	HasArgument bool          // Whether the opcode uses the argument.
+	Target      int           // Position of the jump destination, or -1.
+	Comment     string        // Constant, variable, function or signature the argument refers to.
+	Location    file.Location // Location of the opcode in the source.
+	Position    file.Position // Line and column of the location.
+}
 
-		case OpJumpIfNil:
-			jump("OpJumpIfNil")
+// String returns the instruction as a tab-separated line.
+func (in Instruction) String() string {
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "%v\t%v", in.Offset, in.Opcode)
+	if int(in.Opcode) >= len(opcodeNames) {
+		b.WriteString(" (unknown)")
+	}
+	if in.HasArgument {
+		_, _ = fmt.Fprintf(&b, "\t<%v>", in.Argument)
+	}
+	if in.Target >= 0 {
+		_, _ = fmt.Fprintf(&b, "\t(%v)", in.Target)
+	} else if in.Comment != "" {
+		_, _ = fmt.Fprintf(&b, "\t%v", in.Comment)
+	}
+	return b.String()
+}
 
-		case OpJumpIfNotNil:
-			jump("OpJumpIfNotNil")
+// Instructions returns opcodes of the program with their arguments
+// resolved to constants, variables and functions.
+func (program *Program) Instructions() []Instruction {
+	out := make([]Instruction, 0, len(program.Bytecode))
+	positions := program.SourceMap()
+	for ip, op := range program.Bytecode {
+		arg := program.Arguments[ip]
+		in := Instruction{Offset: ip, Opcode: op, Argument: arg, Target: -1}
+		if ip < len(program.locations) {
+			in.Location = program.locations[ip]
+			in.Position = positions[ip]
+		}
 
-		case OpJumpIfEnd:
-			jump("OpJumpIfEnd")
+		switch op {
+		case OpJump, OpJumpIfTrue, OpJumpIfFalse, OpJumpIfNil, OpJumpIfNotNil, OpJumpIfEnd:
+			in.HasArgument = true
+			in.Target = ip + 1 + arg
 
 		case OpJumpBackward:
-			jumpBack("OpJumpBackward")
+			in.HasArgument = true
+			in.Target = ip + 1 - arg
 
-		case OpIn:
-			code("OpIn")
+		case OpInt, OpCall, OpCallN, OpCallFast, OpCallSafe, OpCallSpread, OpCast, OpCreate, OpConcat:
+			in.HasArgument = true
 
-		case OpLess:
-			code("OpLess")
-
-		case OpMore:
-			code("OpMore")
-
-		case OpLessOrEqual:
-			code("OpLessOrEqual")
-
-		case OpMoreOrEqual:
-			code("OpMoreOrEqual")
-
-		case OpAdd:
-			code("OpAdd")
-
-		case OpSubtract:
-			code("OpSubtract")
-
-		case OpMultiply:
-			code("OpMultiply")
-
-		case OpDivide:
-			code("OpDivide")
-
-		case OpModulo:
-			code("OpModulo")
-
-		case OpExponent:
-			code("OpExponent")
-
-		case OpRange:
-			code("OpRange")
-
-		case OpMatches:
-			code("OpMatches")
-
-		case OpMatchesConst:
-			constant("OpMatchesConst")
-
-		case OpContains:
-			code("OpContains")
-
-		case OpStartsWith:
-			code("OpStartsWith")
-
-		case OpEndsWith:
-			code("OpEndsWith")
-
-		case OpSlice:
-			code("OpSlice")
-
-		case OpCall:
-			argument("OpCall")
-
-		case OpCall0:
-			argumentWithInfo("OpCall0", "func")
-
-		case OpCall1:
-			argumentWithInfo("OpCall1", "func")
-
-		case OpCall2:
-			argumentWithInfo("OpCall2", "func")
-
-		case OpCall3:
-			argumentWithInfo("OpCall3", "func")
+		case OpBegin:
+			in.HasArgument = arg != 0
 
-		case OpCallN:
-			argument("OpCallN")
+		case OpStore, OpLoadVar:
+			in.HasArgument = true
+			in.Comment = program.debugInfo[fmt.Sprintf("var_%d", arg)]
 
-		case OpCallFast:
-			argument("OpCallFast")
+		case OpLoadFunc, OpCall0, OpCall1, OpCall2, OpCall3:
+			in.HasArgument = true
+			in.Comment = program.debugInfo[fmt.Sprintf("func_%d", arg)]
 
-		case OpCallSafe:
-			argument("OpCallSafe")
+		case OpPush, OpLoadConst, OpLoadField, OpLoadFast, OpLoadMethod,
+			OpFetchField, OpMethod, OpMatchesConst, OpTypedArray:
+			in.HasArgument = true
+			in.Comment = program.constant(arg)
 
 		case OpCallTyped:
-			signature := reflect.TypeOf(FuncTypes[arg]).Elem().String()
-			_, _ = fmt.Fprintf(w, "%v\t%v\t<%v>\t%v\n", pp, "OpCallTyped", arg, signature)
+			in.HasArgument = true
+			in.Comment = reflect.TypeOf(FuncTypes[arg]).Elem().String()
 
 		case OpCallBuiltin1:
-			builtinArg("OpCallBuiltin1")
-
-		case OpArray:
-			code("OpArray")
-
-		case OpMap:
-			code("OpMap")
-
-		case OpLen:
-			code("OpLen")
-
-		case OpCast:
-			argument("OpCast")
-
-		case OpDeref:
-			code("OpDeref")
-
-		case OpIncrementIndex:
-			code("OpIncrementIndex")
-
-		case OpDecrementIndex:
-			code("OpDecrementIndex")
-
-		case OpIncrementCount:
-			code("OpIncrementCount")
-
-		case OpGetIndex:
-			code("OpGetIndex")
-
-		case OpGetCount:
-			code("OpGetCount")
-
-		case OpGetLen:
-			code("OpGetLen")
-
-		case OpGetAcc:
-			code("OpGetAcc")
-
-		case OpSetAcc:
-			code("OpSetAcc")
-
-		case OpSetIndex:
-			code("OpSetIndex")
-
-		case OpPointer:
-			code("OpPointer")
-
-		case OpThrow:
-			code("OpThrow")
-
-		case OpCreate:
-			argument("OpCreate")
-
-		case OpGroupBy:
-			code("OpGroupBy")
-
-		case OpSortBy:
-			code("OpSortBy")
-
-		case OpSort:
-			code("OpSort")
-
-		case OpProfileStart:
-			code("OpProfileStart")
-
-		case OpProfileEnd:
-			code("OpProfileEnd")
-
-		case OpBegin:
-			code("OpBegin")
-
-		case OpEnd:
-			code("OpEnd")
-
-		default:
-			_, _ = fmt.Fprintf(w, "%v\t%#x (unknown)\n", ip, op)
+			in.HasArgument = true
+			in.Comment = builtin.Builtins[arg].Name
 		}
+		out = append(out, in)
+	}
+	return out
+}
+
+// constant returns the constant as a string for the disassembler.
+func (program *Program) constant(i int) string {
+	if i >= len(program.Constants) {
+		return "out of range"
+	}
+	switch c := program.Constants[i].(type) {
+	case *regexp.Regexp:
+		return c.String()
+	case *runtime.Field:
+		return fmt.Sprintf("{%v %v}", strings.Join(c.Path, "."), c.Index)
+	case *runtime.Method:
+		return fmt.Sprintf("{%v %v}", c.Name, c.Index)
+	default:
+		return fmt.Sprintf("%v", c)
 	}
 }
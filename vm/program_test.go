@@ -4,6 +4,10 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/file"
+	"github.com/expr-lang/expr/internal/testify/assert"
+	"github.com/expr-lang/expr/internal/testify/require"
 	"github.com/expr-lang/expr/vm"
 )
 
@@ -20,3 +24,172 @@ func TestProgram_Disassemble(t *testing.T) {
 		}
 	}
 }
+
+func TestProgram_Instructions(t *testing.T) {
+	program, err := expr.Compile(`let x = 1; x > 0 ? "yes" : upper("no")`)
+	require.NoError(t, err)
+
+	instructions := program.Instructions()
+	require.Len(t, instructions, len(program.Bytecode))
+
+	lines := strings.Split(strings.TrimSpace(program.Disassemble()), "\n")
+	for i, in := range instructions {
+		assert.Equal(t, i, in.Offset)
+		assert.Equal(t, program.Bytecode[i], in.Opcode)
+		assert.Equal(t, strings.Fields(lines[i]), strings.Fields(in.String()))
+	}
+
+	var store, jump vm.Instruction
+	for _, in := range instructions {
+		switch in.Opcode {
+		case vm.OpStore:
+			store = in
+		case vm.OpJumpIfFalse:
+			jump = in
+		}
+	}
+	assert.Equal(t, "x", store.Comment)
+	assert.Equal(t, file.Location{From: 4, To: 5}, store.Location)
+	assert.Equal(t, jump.Offset+1+jump.Argument, jump.Target)
+	assert.Equal(t, "OpJumpIfFalse", jump.Opcode.String())
+}
+
+type identifiersAddress struct {
+	City string
+	Zip  string
+}
+
+type identifiersUser struct {
+	Name    string
+	Age     int
+	Address *identifiersAddress
+	Tags    []string
+}
+
+func (identifiersUser) Greet() string {
+	return "hello"
+}
+
+type identifiersEnv struct {
+	User   identifiersUser
+	Users  []identifiersUser
+	Limit  int
+	Key    string
+	Lookup func(string) int
+}
+
+func TestProgram_Identifiers(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected vm.Identifiers
+	}{
+		{
+			`User.Address.City == "Paris" && User.Age > Limit`,
+			vm.Identifiers{
+				Variables: []string{"Limit", "User"},
+				Members:   []string{"User.Address.City", "User.Age"},
+				Functions: []string{},
+			},
+		},
+		{
+			`let name = User.Name; name + User.Greet() + $env.Key + string(Lookup(Key))`,
+			vm.Identifiers{
+				Variables: []string{"Key", "User"},
+				Members:   []string{"User.Name"},
+				Functions: []string{"Lookup", "User.Greet"},
+			},
+		},
+		{
+			`filter(Users, .Age > 18) | map(.Name)`,
+			vm.Identifiers{
+				Variables: []string{"Users"},
+				Members:   []string{},
+				Functions: []string{},
+			},
+		},
+		{
+			`User.Address?.Zip ?? User.Tags[0]`,
+			vm.Identifiers{
+				Variables: []string{"User"},
+				Members:   []string{"User.Address.Zip", "User.Tags"},
+				Functions: []string{},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			program, err := expr.Compile(tt.code, expr.Env(identifiersEnv{}))
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, program.Identifiers())
+		})
+	}
+}
+
+func TestProgram_Hash(t *testing.T) {
+	env := map[string]any{"a": 1, "b": 2, "name": ""}
+	compile := func(code string) *vm.Program {
+		program, err := expr.Compile(code, expr.Env(env))
+		require.NoError(t, err)
+		return program
+	}
+
+	hash := compile(`a + b > 2 && name == "x"`).Hash()
+	assert.Len(t, hash, 64)
+	assert.Equal(t, hash, compile("a+b>2&&name==\"x\"").Hash())
+	assert.Equal(t, hash, compile("(a + b) > 2\n\t&& name == 'x'").Hash())
+	assert.NotEqual(t, hash, compile(`a + b > 2 && name == "y"`).Hash())
+	assert.NotEqual(t, hash, compile(`a - b > 2 && name == "x"`).Hash())
+
+	program := compile(`a in [1, 2, 3] && name matches "^x"`)
+	b, err := program.MarshalBinary()
+	require.NoError(t, err)
+	decoded := &vm.Program{}
+	require.NoError(t, decoded.UnmarshalBinary(b))
+	assert.Equal(t, program.Hash(), decoded.Hash())
+}
+
+func TestProgram_Hash_struct_constants(t *testing.T) {
+	type limit struct{ Max int }
+	env := map[string]any{
+		"x":     5,
+		"limit": func(max int) limit { return limit{Max: max} },
+	}
+	compile := func(code string) *vm.Program {
+		program, err := expr.Compile(code, expr.Env(env), expr.Pure("limit"))
+		require.NoError(t, err)
+		return program
+	}
+
+	low, high := compile(`limit(1).Max < x`), compile(`limit(10).Max < x`)
+	assert.NotEqual(t, low.Hash(), high.Hash())
+
+	out, err := expr.Run(low, env)
+	require.NoError(t, err)
+	assert.Equal(t, true, out)
+	out, err = expr.Run(high, env)
+	require.NoError(t, err)
+	assert.Equal(t, false, out)
+}
+
+func TestProgram_SourceMap(t *testing.T) {
+	source := "a > 1 &&\n  b == \"x\""
+	program, err := expr.Compile(source, expr.Env(map[string]any{"a": 0, "b": ""}))
+	require.NoError(t, err)
+
+	positions := program.SourceMap()
+	require.Len(t, positions, len(program.Bytecode))
+	for ip, in := range program.Instructions() {
+		assert.Equal(t, program.Source().Position(in.Location.From), positions[ip])
+		assert.Equal(t, positions[ip], in.Position)
+	}
+
+	var found bool
+	for ip, op := range program.Bytecode {
+		if op == vm.OpEqualString {
+			assert.Equal(t, file.Position{Line: 2, Column: 4}, positions[ip])
+			found = true
+		}
+	}
+	assert.True(t, found)
+}
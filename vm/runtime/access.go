@@ -0,0 +1,176 @@
+package runtime
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/expr-lang/expr/internal/deref"
+)
+
+// Access holds options of the program which change how fields of structs
+// are fetched at runtime, by dynamic keys like `user[key]` or by builtins
+// like pluck(). A nil Access fetches fields by the "expr" tag.
+type Access struct {
+	Tag string // Tag names fields, like "json".
+
+	// Allowed and Denied are access rules of members of types, named by
+	// MemberName. If a type has allowed members, other members of the type
+	// are denied.
+	Allowed map[string]bool
+	Denied  map[string]bool
+}
+
+// AccessError is an access to a member denied by the access rules.
+type AccessError struct {
+	Name string // Name of the member, like "User.Password".
+}
+
+func (e *AccessError) Error() string {
+	return fmt.Sprintf("access denied to %v", e.Name)
+}
+
+// MemberName returns the name of the field or the method of the type in
+// access rules, like "github.com/acme/app.User.Password". The type is
+// qualified by the path of its package, so types of the same name from
+// different packages are told apart.
+func MemberName(t reflect.Type, member string) string {
+	return t.PkgPath() + "." + t.Name() + "." + member
+}
+
+// Forbids reports whether access to the field or the method of the type
+// is rejected by the access rules.
+func (a *Access) Forbids(t reflect.Type, member string) bool {
+	if a == nil || len(a.Allowed) == 0 && len(a.Denied) == 0 {
+		return false
+	}
+	t = deref.Type(t)
+	if t == nil || t.Name() == "" {
+		return false
+	}
+	name := MemberName(t, member)
+	if a.Denied[name] {
+		return true
+	}
+	if a.Allowed[name] {
+		return false
+	}
+	prefix := MemberName(t, "")
+	for allowed := range a.Allowed {
+		if strings.HasPrefix(allowed, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Restricted reports whether the access has rules of members.
+func (a *Access) Restricted() bool {
+	return a != nil && (len(a.Allowed) > 0 || len(a.Denied) > 0)
+}
+
+// check panics with *AccessError if access to the member is denied.
+func (a *Access) check(t reflect.Type, member string) {
+	if a.Forbids(t, member) {
+		panic(&AccessError{Name: deref.Type(t).Name() + "." + member})
+	}
+}
+
+// CheckValue panics with *AccessError if the value, or any value nested in
+// it, has denied members, so it can't be serialized, like by toJSON().
+func (a *Access) CheckValue(v any) {
+	if a.Restricted() {
+		a.checkValue(reflect.ValueOf(v), make(map[uintptr]bool))
+	}
+}
+
+func (a *Access) checkValue(v reflect.Value, seen map[uintptr]bool) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if !v.IsNil() {
+			a.checkValue(v.Elem(), seen)
+		}
+	case reflect.Ptr:
+		if v.IsNil() || seen[v.Pointer()] {
+			return
+		}
+		seen[v.Pointer()] = true
+		a.checkValue(v.Elem(), seen)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if field := t.Field(i); field.IsExported() {
+				a.check(t, field.Name)
+				a.checkValue(v.Field(i), seen)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			a.checkValue(v.Index(i), seen)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			a.checkValue(iter.Value(), seen)
+		}
+	}
+}
+
+// Fetch fetches the field, the method, the map value or the element, like
+// Fetch, but honors the options.
+func (a *Access) Fetch(from, i any) any {
+	return fetch(a, from, i)
+}
+
+// In reports whether the needle is in the array, like In, but honors the
+// options.
+func (a *Access) In(needle, array any) bool {
+	return in(a, needle, array)
+}
+
+// FieldByName finds the field of the struct type by its name or its tag.
+func (a *Access) FieldByName(t reflect.Type, name string) (reflect.StructField, bool) {
+	return fieldByName(t, name, a.tag())
+}
+
+func (a *Access) tag() string {
+	if a == nil {
+		return ""
+	}
+	return a.Tag
+}
+
+// TaggedFieldName returns the name of the field in expressions, taken from
+// the struct tag, like "first_name" for `json:"first_name,omitempty"`.
+// Fields without the tag keep the Go name, and fields tagged with "-" are
+// hidden and have an empty name. The "expr" tag is used if tag is empty.
+//
+// The "protobuf" tag of generated messages names fields by the name option,
+// like "user_id" for `protobuf:"bytes,1,opt,name=user_id,json=userId"`.
+func TaggedFieldName(field reflect.StructField, tag string) string {
+	if tag == "" {
+		tag = "expr"
+	}
+	name, _, _ := strings.Cut(field.Tag.Get(tag), ",")
+	if tag == "protobuf" {
+		name = protobufFieldName(field.Tag.Get(tag))
+	}
+	switch name {
+	case "":
+		return field.Name
+	case "-":
+		if tag != "expr" {
+			return ""
+		}
+	}
+	return name
+}
+
+func protobufFieldName(tag string) string {
+	for _, option := range strings.Split(tag, ",") {
+		if strings.HasPrefix(option, "name=") {
+			return strings.TrimPrefix(option, "name=")
+		}
+	}
+	return ""
+}
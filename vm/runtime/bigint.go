@@ -0,0 +1,130 @@
+package runtime
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// ToBigInt converts integers, floats and strings, like "0x1f" or
+// "1000000000000000000000", to *big.Int. Floats are truncated.
+func ToBigInt(v any) *big.Int {
+	switch x := v.(type) {
+	case string:
+		n, ok := new(big.Int).SetString(x, 0)
+		if !ok {
+			panic(fmt.Sprintf("invalid big integer %q", x))
+		}
+		return n
+	case float32:
+		return toBigInt(float64(x))
+	case float64:
+		return toBigInt(x)
+	}
+	if n, ok := asBigInt(v); ok {
+		return n
+	}
+	panic(fmt.Sprintf("invalid operation: bigint(%T)", v))
+}
+
+func toBigInt(f float64) *big.Int {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		panic(fmt.Sprintf("cannot convert %v to big integer", f))
+	}
+	n, _ := big.NewFloat(f).Int(nil)
+	return n
+}
+
+// asBigInt returns the integer as *big.Int.
+func asBigInt(v any) (*big.Int, bool) {
+	switch x := v.(type) {
+	case *big.Int:
+		return x, x != nil
+	case big.Int:
+		return &x, true
+	case int:
+		return big.NewInt(int64(x)), true
+	case int8:
+		return big.NewInt(int64(x)), true
+	case int16:
+		return big.NewInt(int64(x)), true
+	case int32:
+		return big.NewInt(int64(x)), true
+	case int64:
+		return big.NewInt(x), true
+	case uint:
+		return new(big.Int).SetUint64(uint64(x)), true
+	case uint8:
+		return big.NewInt(int64(x)), true
+	case uint16:
+		return big.NewInt(int64(x)), true
+	case uint32:
+		return big.NewInt(int64(x)), true
+	case uint64:
+		return new(big.Int).SetUint64(x), true
+	}
+	return nil, false
+}
+
+// bigInts returns the operands as big integers, if one of them is a big
+// integer and the other one is an integer too.
+func bigInts(a, b any) (*big.Int, *big.Int, bool) {
+	if !isBigInt(a) && !isBigInt(b) {
+		return nil, nil, false
+	}
+	x, ok := asBigInt(a)
+	if !ok {
+		return nil, nil, false
+	}
+	y, ok := asBigInt(b)
+	if !ok {
+		return nil, nil, false
+	}
+	return x, y, true
+}
+
+// bigFloats returns the operands as floats, if one of them is a big integer
+// and the other one is a float, so mixed arithmetic is float arithmetic.
+func bigFloats(a, b any) (float64, float64, bool) {
+	if isBigInt(a) && isFloat(b) || isFloat(a) && isBigInt(b) {
+		return ToFloat64(a), ToFloat64(b), true
+	}
+	return 0, 0, false
+}
+
+func isFloat(v any) bool {
+	switch v.(type) {
+	case float32, float64:
+		return true
+	}
+	return false
+}
+
+// Remainder returns the remainder of a % b, like Modulo, and supports big
+// integers.
+func Remainder(a, b any) any {
+	if x, y, ok := bigInts(a, b); ok {
+		return new(big.Int).Rem(x, y)
+	}
+	return Modulo(a, b)
+}
+
+func isBigInt(v any) bool {
+	switch v.(type) {
+	case *big.Int, big.Int:
+		return true
+	}
+	return false
+}
+
+// bigDivide divides big integers as floats, the same as `/` of ints.
+func bigDivide(x, y *big.Int) float64 {
+	if y.Sign() == 0 {
+		if x.Sign() == 0 {
+			return math.NaN()
+		}
+		return math.Inf(x.Sign())
+	}
+	f, _ := new(big.Float).Quo(new(big.Float).SetInt(x), new(big.Float).SetInt(y)).Float64()
+	return f
+}
@@ -143,6 +143,7 @@ package runtime
 
 import (
 	"fmt"
+	"math/big"
 	"reflect"
 	"time"
 )
@@ -171,6 +172,21 @@ func Equal(a, b interface{}) bool {
 		case bool:
 			return x == y
 		}
+	case Comparer:
+		if _, ok := b.(string); ok || reflect.TypeOf(a) == reflect.TypeOf(b) {
+			return x.Compare(b) == 0
+		}
+	}
+	if y, ok := b.(Comparer); ok {
+		if _, ok := a.(string); ok {
+			return y.Compare(a) == 0
+		}
+	}
+	if x, y, ok := bigInts(a, b); ok {
+		return x.Cmp(y) == 0
+	}
+	if x, y, ok := bigFloats(a, b); ok {
+		return x == y
 	}
 	if IsNil(a) && IsNil(b) {
 		return true
@@ -196,6 +212,14 @@ func Less(a, b interface{}) bool {
 		case time.Duration:
 			return x < y
 		}
+	case Comparer:
+		return x.Compare(b) < 0
+	}
+	if x, y, ok := bigInts(a, b); ok {
+		return x.Cmp(y) < 0
+	}
+	if x, y, ok := bigFloats(a, b); ok {
+		return x < y
 	}
 	panic(fmt.Sprintf("invalid operation: %T < %T", a, b))
 }
@@ -218,6 +242,14 @@ func More(a, b interface{}) bool {
 		case time.Duration:
 			return x > y
 		}
+	case Comparer:
+		return x.Compare(b) > 0
+	}
+	if x, y, ok := bigInts(a, b); ok {
+		return x.Cmp(y) > 0
+	}
+	if x, y, ok := bigFloats(a, b); ok {
+		return x > y
 	}
 	panic(fmt.Sprintf("invalid operation: %T > %T", a, b))
 }
@@ -240,6 +272,14 @@ func LessOrEqual(a, b interface{}) bool {
 		case time.Duration:
 			return x <= y
 		}
+	case Comparer:
+		return x.Compare(b) <= 0
+	}
+	if x, y, ok := bigInts(a, b); ok {
+		return x.Cmp(y) <= 0
+	}
+	if x, y, ok := bigFloats(a, b); ok {
+		return x <= y
 	}
 	panic(fmt.Sprintf("invalid operation: %T <= %T", a, b))
 }
@@ -262,6 +302,14 @@ func MoreOrEqual(a, b interface{}) bool {
 		case time.Duration:
 			return x >= y
 		}
+	case Comparer:
+		return x.Compare(b) >= 0
+	}
+	if x, y, ok := bigInts(a, b); ok {
+		return x.Cmp(y) >= 0
+	}
+	if x, y, ok := bigFloats(a, b); ok {
+		return x >= y
 	}
 	panic(fmt.Sprintf("invalid operation: %T >= %T", a, b))
 }
@@ -287,6 +335,12 @@ func Add(a, b interface{}) interface{} {
 			return x + y
 		}
 	}
+	if x, y, ok := bigInts(a, b); ok {
+		return new(big.Int).Add(x, y)
+	}
+	if x, y, ok := bigFloats(a, b); ok {
+		return x + y
+	}
 	panic(fmt.Sprintf("invalid operation: %T + %T", a, b))
 }
 
@@ -306,6 +360,12 @@ func Subtract(a, b interface{}) interface{} {
 			return x - y
 		}
 	}
+	if x, y, ok := bigInts(a, b); ok {
+		return new(big.Int).Sub(x, y)
+	}
+	if x, y, ok := bigFloats(a, b); ok {
+		return x - y
+	}
 	panic(fmt.Sprintf("invalid operation: %T - %T", a, b))
 }
 
@@ -313,6 +373,12 @@ func Multiply(a, b interface{}) interface{} {
 	switch x := a.(type) {
 	{{ cases_with_duration "*" }}
 	}
+	if x, y, ok := bigInts(a, b); ok {
+		return new(big.Int).Mul(x, y)
+	}
+	if x, y, ok := bigFloats(a, b); ok {
+		return x * y
+	}
 	panic(fmt.Sprintf("invalid operation: %T * %T", a, b))
 }
 
@@ -320,6 +386,12 @@ func Divide(a, b interface{}) float64 {
 	switch x := a.(type) {
 	{{ cases "/" }}
 	}
+	if x, y, ok := bigInts(a, b); ok {
+		return bigDivide(x, y)
+	}
+	if x, y, ok := bigFloats(a, b); ok {
+		return x / y
+	}
 	panic(fmt.Sprintf("invalid operation: %T / %T", a, b))
 }
 
@@ -4,6 +4,7 @@ package runtime
 
 import (
 	"fmt"
+	"math/big"
 	"reflect"
 	"time"
 )
@@ -692,6 +693,21 @@ func Equal(a, b interface{}) bool {
 		case bool:
 			return x == y
 		}
+	case Comparer:
+		if _, ok := b.(string); ok || reflect.TypeOf(a) == reflect.TypeOf(b) {
+			return x.Compare(b) == 0
+		}
+	}
+	if y, ok := b.(Comparer); ok {
+		if _, ok := a.(string); ok {
+			return y.Compare(a) == 0
+		}
+	}
+	if x, y, ok := bigInts(a, b); ok {
+		return x.Cmp(y) == 0
+	}
+	if x, y, ok := bigFloats(a, b); ok {
+		return x == y
 	}
 	if IsNil(a) && IsNil(b) {
 		return true
@@ -1040,6 +1056,14 @@ func Less(a, b interface{}) bool {
 		case time.Duration:
 			return x < y
 		}
+	case Comparer:
+		return x.Compare(b) < 0
+	}
+	if x, y, ok := bigInts(a, b); ok {
+		return x.Cmp(y) < 0
+	}
+	if x, y, ok := bigFloats(a, b); ok {
+		return x < y
 	}
 	panic(fmt.Sprintf("invalid operation: %T < %T", a, b))
 }
@@ -1385,6 +1409,14 @@ func More(a, b interface{}) bool {
 		case time.Duration:
 			return x > y
 		}
+	case Comparer:
+		return x.Compare(b) > 0
+	}
+	if x, y, ok := bigInts(a, b); ok {
+		return x.Cmp(y) > 0
+	}
+	if x, y, ok := bigFloats(a, b); ok {
+		return x > y
 	}
 	panic(fmt.Sprintf("invalid operation: %T > %T", a, b))
 }
@@ -1730,6 +1762,14 @@ func LessOrEqual(a, b interface{}) bool {
 		case time.Duration:
 			return x <= y
 		}
+	case Comparer:
+		return x.Compare(b) <= 0
+	}
+	if x, y, ok := bigInts(a, b); ok {
+		return x.Cmp(y) <= 0
+	}
+	if x, y, ok := bigFloats(a, b); ok {
+		return x <= y
 	}
 	panic(fmt.Sprintf("invalid operation: %T <= %T", a, b))
 }
@@ -2075,6 +2115,14 @@ func MoreOrEqual(a, b interface{}) bool {
 		case time.Duration:
 			return x >= y
 		}
+	case Comparer:
+		return x.Compare(b) >= 0
+	}
+	if x, y, ok := bigInts(a, b); ok {
+		return x.Cmp(y) >= 0
+	}
+	if x, y, ok := bigFloats(a, b); ok {
+		return x >= y
 	}
 	panic(fmt.Sprintf("invalid operation: %T >= %T", a, b))
 }
@@ -2423,6 +2471,12 @@ func Add(a, b interface{}) interface{} {
 			return x + y
 		}
 	}
+	if x, y, ok := bigInts(a, b); ok {
+		return new(big.Int).Add(x, y)
+	}
+	if x, y, ok := bigFloats(a, b); ok {
+		return x + y
+	}
 	panic(fmt.Sprintf("invalid operation: %T + %T", a, b))
 }
 
@@ -2765,6 +2819,12 @@ func Subtract(a, b interface{}) interface{} {
 			return x - y
 		}
 	}
+	if x, y, ok := bigInts(a, b); ok {
+		return new(big.Int).Sub(x, y)
+	}
+	if x, y, ok := bigFloats(a, b); ok {
+		return x - y
+	}
 	panic(fmt.Sprintf("invalid operation: %T - %T", a, b))
 }
 
@@ -3148,6 +3208,12 @@ func Multiply(a, b interface{}) interface{} {
 			return time.Duration(x) * time.Duration(y)
 		}
 	}
+	if x, y, ok := bigInts(a, b); ok {
+		return new(big.Int).Mul(x, y)
+	}
+	if x, y, ok := bigFloats(a, b); ok {
+		return x * y
+	}
 	panic(fmt.Sprintf("invalid operation: %T * %T", a, b))
 }
 
@@ -3478,6 +3544,12 @@ func Divide(a, b interface{}) float64 {
 			return float64(x) / float64(y)
 		}
 	}
+	if x, y, ok := bigInts(a, b); ok {
+		return bigDivide(x, y)
+	}
+	if x, y, ok := bigFloats(a, b); ok {
+		return x / y
+	}
 	panic(fmt.Sprintf("invalid operation: %T / %T", a, b))
 }
 
@@ -0,0 +1,50 @@
+package runtime
+
+import (
+	"math"
+	"reflect"
+)
+
+// AddInt returns a + b and reports whether the sum overflowed int.
+func AddInt(a, b int) (int, bool) {
+	c := a + b
+	return c, (b > 0 && c < a) || (b < 0 && c > a)
+}
+
+// SubtractInt returns a - b and reports whether the difference overflowed int.
+func SubtractInt(a, b int) (int, bool) {
+	c := a - b
+	return c, (b < 0 && c < a) || (b > 0 && c > a)
+}
+
+// MultiplyInt returns a * b and reports whether the product overflowed int.
+func MultiplyInt(a, b int) (int, bool) {
+	if a == 0 || b == 0 {
+		return 0, false
+	}
+	c := a * b
+	if (a == -1 && b == math.MinInt) || (b == -1 && a == math.MinInt) {
+		return c, true
+	}
+	return c, c/b != a
+}
+
+// IsInteger reports whether the value is of an integer type.
+func IsInteger(v any) bool {
+	switch v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return true
+	}
+	return false
+}
+
+// IsNumberKind reports whether the kind is an integer or a float kind.
+func IsNumberKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
@@ -5,15 +5,65 @@ package runtime
 import (
 	"fmt"
 	"math"
+	"math/big"
 	"reflect"
+	"strings"
+	"time"
 
 	"github.com/expr-lang/expr/internal/deref"
 )
 
+// Getter is an env, or a value of it, which resolves names lazily, like
+// from a database or a feature store. It is called only for names which
+// the expression uses, when they are evaluated. Values are resolved lazily
+// only if they are of this type, like Getter(store.Fetch), so methods named
+// Fetch of other values are never called.
+type Getter func(name string) (any, error)
+
+// Get fetches the name from the getter and panics with its error.
+func Get(getter Getter, name string) any {
+	value, err := getter(name)
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
+// AsGetter returns the getter of values resolved lazily: getters and layers.
+func AsGetter(v any) (Getter, bool) {
+	switch v := v.(type) {
+	case Getter:
+		return v, v != nil
+	case Layers:
+		return v.Fetch, true
+	}
+	return nil, false
+}
+
+// GetPath fetches the first name of the path from the getter and the rest
+// of names from the fetched values.
+func GetPath(getter Getter, path []string) any {
+	value := Get(getter, path[0])
+	for _, name := range path[1:] {
+		value = Fetch(value, name)
+	}
+	return value
+}
+
 func Fetch(from, i any) any {
+	return fetch(nil, from, i)
+}
+
+func fetch(a *Access, from, i any) any {
+	if getter, ok := AsGetter(from); ok {
+		if name, ok := i.(string); ok {
+			return Get(getter, name)
+		}
+	}
+
 	v := reflect.ValueOf(from)
 	if v.Kind() == reflect.Invalid {
-		panic(fmt.Sprintf("cannot fetch %v from %T", i, from))
+		panic(fmt.Sprintf("cannot fetch %v from nil", i))
 	}
 
 	// Methods can be defined on any type.
@@ -21,6 +71,7 @@ func Fetch(from, i any) any {
 		if methodName, ok := i.(string); ok {
 			method := v.MethodByName(methodName)
 			if method.IsValid() {
+				a.check(v.Type(), methodName)
 				return method.Interface()
 			}
 		}
@@ -33,7 +84,18 @@ func Fetch(from, i any) any {
 	v = deref.Value(v)
 
 	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			panic(fmt.Sprintf("cannot fetch %v from nil %T", i, from))
+		}
+
 	case reflect.Array, reflect.Slice, reflect.String:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			panic(fmt.Sprintf("cannot fetch %v from nil %T", i, from))
+		}
+		if _, ok := i.(string); ok {
+			panic(fmt.Sprintf("cannot fetch %v from %T", i, from))
+		}
 		index := ToInt(i)
 		l := v.Len()
 		if index < 0 {
@@ -62,21 +124,75 @@ func Fetch(from, i any) any {
 		}
 
 	case reflect.Struct:
-		fieldName := i.(string)
-		value := v.FieldByNameFunc(func(name string) bool {
-			field, _ := v.Type().FieldByName(name)
-			if field.Tag.Get("expr") == fieldName {
-				return true
+		field, ok := fieldByName(v.Type(), i.(string), a.tag())
+		if ok {
+			if !field.IsExported() {
+				panic(fmt.Sprintf("cannot fetch unexported field %v from %T", field.Name, from))
 			}
-			return name == fieldName
-		})
-		if value.IsValid() {
-			return value.Interface()
+			a.check(v.Type(), field.Name)
+			return v.FieldByIndex(field.Index).Interface()
 		}
 	}
 	panic(fmt.Sprintf("cannot fetch %v from %T", i, from))
 }
 
+// fieldByName finds the field of the struct by its name or its "expr" tag.
+// If other tag is set, like "json", the field is found by the tag only, as
+// the checker does.
+func fieldByName(t reflect.Type, fieldName, tag string) (reflect.StructField, bool) {
+	return t.FieldByNameFunc(func(name string) bool {
+		field, _ := t.FieldByName(name)
+		if TaggedFieldName(field, tag) == fieldName {
+			return true
+		}
+		return (tag == "" || tag == "expr") && name == fieldName
+	})
+}
+
+// Layers is an env composed of layers, like global constants, tenant
+// config and request data. Layers are maps with string keys or structs,
+// and a name is resolved in the last layer which defines it, so later
+// layers shadow earlier ones.
+type Layers []any
+
+// Fetch returns the value of the name from the last layer defining it.
+func (l Layers) Fetch(name string) (any, error) {
+	for i := len(l) - 1; i >= 0; i-- {
+		if value, ok := lookup(l[i], name); ok {
+			return value, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown name %v", name)
+}
+
+// lookup returns the method, the field or the map value of the layer.
+func lookup(layer any, name string) (any, bool) {
+	v := reflect.ValueOf(layer)
+	if !v.IsValid() {
+		return nil, false
+	}
+	if m := v.MethodByName(name); m.IsValid() {
+		return m.Interface(), true
+	}
+	v = deref.Value(v)
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+		value := v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+		if value.IsValid() {
+			return value.Interface(), true
+		}
+	case reflect.Struct:
+		field, ok := fieldByName(v.Type(), name, "")
+		if ok && field.IsExported() {
+			return v.FieldByIndex(field.Index).Interface(), true
+		}
+	}
+	return nil, false
+}
+
 type Field struct {
 	Index []int
 	Path  []string
@@ -105,11 +221,17 @@ func fieldByIndex(v reflect.Value, field *Field) reflect.Value {
 	if len(field.Index) == 1 {
 		return v.Field(field.Index[0])
 	}
+	root := v.Type()
 	for i, x := range field.Index {
 		if i > 0 {
 			if v.Kind() == reflect.Ptr {
 				if v.IsNil() {
-					panic(fmt.Sprintf("cannot get %v from %v", field.Path[i], field.Path[i-1]))
+					if len(field.Path) == len(field.Index) {
+						panic(fmt.Sprintf("cannot get %v from %v", field.Path[i], field.Path[i-1]))
+					}
+					// Index of promoted fields includes embedded structs,
+					// which are not in the path.
+					panic(fmt.Sprintf("cannot get %v from %v", fieldName(root, field.Index[:i+1]), fieldName(root, field.Index[:i])))
 				}
 				v = v.Elem()
 			}
@@ -119,6 +241,19 @@ func fieldByIndex(v reflect.Value, field *Field) reflect.Value {
 	return v
 }
 
+// fieldName returns the name of the field of the struct type by index.
+func fieldName(t reflect.Type, index []int) string {
+	var f reflect.StructField
+	for _, x := range index {
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		f = t.Field(x)
+		t = f.Type
+	}
+	return f.Name
+}
+
 type Method struct {
 	Index int
 	Name  string
@@ -129,9 +264,15 @@ func FetchMethod(from any, method *Method) any {
 	kind := v.Kind()
 	if kind != reflect.Invalid {
 		// Methods can be defined on any type, no need to dereference.
-		method := v.Method(method.Index)
-		if method.IsValid() {
-			return method.Interface()
+		var m reflect.Value
+		if method.Index < 0 {
+			// Methods of interface env are fetched by name.
+			m = v.MethodByName(method.Name)
+		} else {
+			m = v.Method(method.Index)
+		}
+		if m.IsValid() {
+			return m.Interface()
 		}
 	}
 	panic(fmt.Sprintf("cannot fetch %v from %T", method.Name, from))
@@ -172,15 +313,64 @@ func Slice(array, from, to any) any {
 		if value.IsValid() {
 			return Slice(value.Interface(), from, to)
 		}
+		panic(fmt.Sprintf("cannot slice nil %T", array))
 
+	case reflect.Invalid:
+		panic("cannot slice nil")
 	}
-	panic(fmt.Sprintf("cannot slice %v", from))
+	panic(fmt.Sprintf("cannot slice %T", array))
+}
+
+// Comparer is implemented by types which define ordering for comparison
+// operators, like versions returned by the semver() builtin. Compare returns
+// a negative number, zero or a positive number if the value is less than,
+// equal to or greater than the other value.
+type Comparer interface {
+	Compare(other any) int
+}
+
+// mapKey converts the needle to the key type of a map. Numbers of predeclared
+// types are converted if they are equal after conversion, like in Equal.
+// Needles of other types are never equal to keys, so ok is false.
+func mapKey(needle any, key reflect.Type) (reflect.Value, bool) {
+	n := reflect.ValueOf(needle)
+	if n.Type().AssignableTo(key) {
+		return n, true
+	}
+	if x, ok := needle.(*big.Int); ok && x.IsInt64() && isNumber(key) {
+		return mapKey(x.Int64(), key)
+	}
+	if isNumber(n.Type()) && isNumber(key) {
+		k := n.Convert(key)
+		if k.Convert(n.Type()).Interface() == needle {
+			return k, true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// isNumber reports whether t is a predeclared number type, like int.
+func isNumber(t reflect.Type) bool {
+	return t.Name() == t.Kind().String() && IsNumberKind(t.Kind())
+}
+
+// Container is implemented by types which define the "in" operator, like
+// networks returned by the cidr() builtin.
+type Container interface {
+	Contains(needle any) bool
 }
 
 func In(needle any, array any) bool {
+	return in(nil, needle, array)
+}
+
+func in(a *Access, needle any, array any) bool {
 	if array == nil {
 		return false
 	}
+	if c, ok := array.(Container); ok {
+		return c.Contains(needle)
+	}
 	v := reflect.ValueOf(array)
 
 	switch v.Kind() {
@@ -199,9 +389,14 @@ func In(needle any, array any) bool {
 	case reflect.Map:
 		var value reflect.Value
 		if needle == nil {
-			value = v.MapIndex(reflect.Zero(v.Type().Key()))
-		} else {
-			value = v.MapIndex(reflect.ValueOf(needle))
+			// Only keys of nilable types, like pointers, can be nil, so
+			// nil is not in sets of ints, like constant sets of `in`.
+			switch v.Type().Key().Kind() {
+			case reflect.Interface, reflect.Ptr, reflect.Chan:
+				value = v.MapIndex(reflect.Zero(v.Type().Key()))
+			}
+		} else if key, ok := mapKey(needle, v.Type().Key()); ok {
+			value = v.MapIndex(key)
 		}
 		if value.IsValid() {
 			return true
@@ -213,16 +408,13 @@ func In(needle any, array any) bool {
 		if !n.IsValid() || n.Kind() != reflect.String {
 			panic(fmt.Sprintf("cannot use %T as field name of %T", needle, array))
 		}
-		value := v.FieldByName(n.String())
-		if value.IsValid() {
-			return true
-		}
-		return false
+		_, ok := fieldByName(v.Type(), n.String(), a.tag())
+		return ok
 
 	case reflect.Ptr:
 		value := v.Elem()
 		if value.IsValid() {
-			return In(needle, value.Interface())
+			return in(a, needle, value.Interface())
 		}
 		return false
 	}
@@ -266,6 +458,12 @@ func Negate(i any) any {
 		return -v
 	case uint64:
 		return -v
+	case time.Duration:
+		return -v
+	case *big.Int:
+		return new(big.Int).Neg(v)
+	case big.Int:
+		return new(big.Int).Neg(&v)
 	default:
 		panic(fmt.Sprintf("invalid operation: - %T", v))
 	}
@@ -275,6 +473,29 @@ func Exponent(a, b any) float64 {
 	return math.Pow(ToFloat64(a), ToFloat64(b))
 }
 
+// Concat joins the strings with one allocation. Values other than strings
+// are added one by one, the same way as with the + operator.
+func Concat(values []any) any {
+	size := 0
+	for _, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			out := values[0]
+			for _, v := range values[1:] {
+				out = Add(out, v)
+			}
+			return out
+		}
+		size += len(s)
+	}
+	var b strings.Builder
+	b.Grow(size)
+	for _, v := range values {
+		b.WriteString(v.(string))
+	}
+	return b.String()
+}
+
 func MakeRange(min, max int) []int {
 	size := max - min + 1
 	if size <= 0 {
@@ -375,6 +596,12 @@ func ToFloat64(a any) float64 {
 		return float64(x)
 	case uint64:
 		return float64(x)
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(x).Float64()
+		return f
+	case big.Int:
+		f, _ := new(big.Float).SetInt(&x).Float64()
+		return f
 	default:
 		panic(fmt.Sprintf("invalid operation: float(%T)", x))
 	}
@@ -4,6 +4,11 @@ type SortBy struct {
 	Desc   bool
 	Array  []any
 	Values []any
+	// Multi is set for sorting by multiple keys, in which case each value
+	// is an array of keys. Order of a key can be set in KeysDesc, otherwise
+	// Desc is used.
+	Multi    bool
+	KeysDesc []bool
 }
 
 func (s *SortBy) Len() int {
@@ -17,12 +22,31 @@ func (s *SortBy) Swap(i, j int) {
 
 func (s *SortBy) Less(i, j int) bool {
 	a, b := s.Values[i], s.Values[j]
+	if s.Multi {
+		return s.lessKeys(a.([]any), b.([]any))
+	}
 	if s.Desc {
 		return Less(b, a)
 	}
 	return Less(a, b)
 }
 
+func (s *SortBy) lessKeys(a, b []any) bool {
+	for k := range a {
+		desc := s.Desc
+		if k < len(s.KeysDesc) {
+			desc = s.KeysDesc[k]
+		}
+		if Less(a[k], b[k]) {
+			return !desc
+		}
+		if Less(b[k], a[k]) {
+			return desc
+		}
+	}
+	return false
+}
+
 type Sort struct {
 	Desc  bool
 	Array []any
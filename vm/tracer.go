@@ -0,0 +1,105 @@
+package vm
+
+import (
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/file"
+)
+
+// Tracer is notified of each instruction executed by the VM, so a run can
+// be explained step by step, like in audit logs of decisions.
+type Tracer interface {
+	Trace(step Step)
+}
+
+// TracerFunc is a function used as a Tracer.
+type TracerFunc func(step Step)
+
+func (f TracerFunc) Trace(step Step) {
+	f(step)
+}
+
+// Step is an instruction executed by the VM. Only the slots of the stack
+// changed by the instruction are recorded: the instruction replaced the
+// Popped slots above the first Depth ones with the Pushed slots.
+type Step struct {
+	Index    int           // Index of the instruction in the bytecode.
+	Opcode   Opcode        // Opcode of the instruction.
+	Argument int           // Argument of the instruction.
+	Location file.Location // Location of the node the instruction belongs to.
+	Node     ast.Node      // Node the instruction belongs to, if known.
+	Depth    int           // Number of slots the instruction left untouched.
+	Popped   []any         // Slots before the instruction, the top is the last.
+	Pushed   []any         // Slots after the instruction, the top is the last.
+}
+
+// Result returns the value pushed by the instruction, like the result of a
+// comparison, or nil if the instruction pushed nothing.
+func (s Step) Result() any {
+	if len(s.Pushed) == 0 {
+		return nil
+	}
+	return s.Pushed[len(s.Pushed)-1]
+}
+
+// WithTracer calls the tracer after each executed instruction. Tracing
+// copies the changed slots of the stack, so it slows the run down.
+func WithTracer(t Tracer) Option {
+	return func(vm *VM) {
+		vm.tracer = t
+	}
+}
+
+// traceStart marks the stack as untouched before the instruction.
+func (vm *VM) traceStart() {
+	vm.traceDepth = len(vm.Stack)
+	vm.tracePopped = nil
+}
+
+// traceChange records slots of the stack from the index up, before the
+// instruction pops or overwrites them. Slots below the depth are still the
+// ones from before the instruction, as pushes go above it.
+func (vm *VM) traceChange(from int) {
+	if from >= vm.traceDepth {
+		return
+	}
+	popped := make([]any, 0, vm.traceDepth-from+len(vm.tracePopped))
+	popped = append(popped, vm.Stack[from:vm.traceDepth]...)
+	vm.tracePopped = append(popped, vm.tracePopped...)
+	vm.traceDepth = from
+}
+
+// trace reports the instruction, which was just executed, to the tracer.
+func (vm *VM) trace(program *Program, nodes map[file.Location]ast.Node, index int) {
+	var loc file.Location
+	if index < len(program.locations) {
+		loc = program.locations[index]
+	}
+	vm.tracer.Trace(Step{
+		Index:    index,
+		Opcode:   program.Bytecode[index],
+		Argument: program.Arguments[index],
+		Location: loc,
+		Node:     nodes[loc],
+		Depth:    vm.traceDepth,
+		Popped:   vm.tracePopped,
+		Pushed:   append([]any(nil), vm.Stack[vm.traceDepth:]...),
+	})
+}
+
+// traceNodes maps locations of the program to its nodes. Nodes which share
+// the location, like a call and its callee, are mapped to the outermost one.
+func traceNodes(program *Program) map[file.Location]ast.Node {
+	nodes := make(map[file.Location]ast.Node)
+	if program.node == nil {
+		return nodes
+	}
+	node := program.node
+	ast.Walk(&node, nodeLocations(nodes))
+	return nodes
+}
+
+type nodeLocations map[file.Location]ast.Node
+
+func (v nodeLocations) Visit(node *ast.Node) {
+	v[(*node).Location()] = *node
+}
@@ -1,40 +1,300 @@
 package vm
 
 import (
+	"fmt"
 	"reflect"
 	"time"
+
+	"github.com/expr-lang/expr/internal/deref"
+	"github.com/expr-lang/expr/vm/runtime"
 )
 
 type (
 	Function     = func(params ...any) (any, error)
 	SafeFunction = func(params ...any) (any, uint, error)
+	// NestedFunction is a function, like unquote(), which runs other
+	// programs. It is called with run, which runs them with the options
+	// of the VM running the outer program.
+	NestedFunction = func(run func(program *Program, env any) (any, error), params ...any) (any, uint, error)
 )
 
 var (
 	// MemoryBudget represents an upper limit of memory usage.
 	MemoryBudget uint = 1e6
 
+	// MaxNesting limits the depth of nested runs, like of a rule which
+	// unquotes itself.
+	MaxNesting = 100
+
 	errorType = reflect.TypeOf((*error)(nil)).Elem()
 )
 
 type Scope struct {
-	Array reflect.Value
-	Index int
-	Len   int
-	Count int
-	Acc   any
+	Array  reflect.Value
+	Keys   reflect.Value // Keys of the iterated map.
+	Values reflect.Value // Values of the iterated map.
+	Index  int
+	Len    int
+	Count  int
+	Acc    any
 }
 
 type groupBy = map[any][]any
 
+type countBy = map[any]int
+
+// Span is the profile of an AST node of a program compiled with profiling.
+// Duration and Hits are accumulated across runs.
 type Span struct {
 	Name       string  `json:"name"`
 	Expression string  `json:"expression"`
-	Duration   int64   `json:"duration"`
+	Duration   int64   `json:"duration"` // Total time of evaluations in nanoseconds.
+	Hits       int     `json:"hits"`     // Number of evaluations.
 	Children   []*Span `json:"children"`
 	start      time.Time
 }
 
+// GetSpan returns the profile of the root node of the program, or nil if
+// the program was compiled without profiling.
 func GetSpan(program *Program) *Span {
 	return program.span
 }
+
+func sortOrder(order any) (desc bool) {
+	switch order {
+	case "asc":
+		return false
+	case "desc":
+		return true
+	}
+	panic("unknown order, use asc or desc")
+}
+
+// mapScope creates a scope for iterating over keys and values of the map.
+func mapScope(m reflect.Value) *Scope {
+	keys := reflect.MakeSlice(reflect.SliceOf(m.Type().Key()), 0, m.Len())
+	values := reflect.MakeSlice(reflect.SliceOf(m.Type().Elem()), 0, m.Len())
+	iter := m.MapRange()
+	for iter.Next() {
+		keys = reflect.Append(keys, iter.Key())
+		values = reflect.Append(values, iter.Value())
+	}
+	return &Scope{
+		Keys:   keys,
+		Values: values,
+		Len:    m.Len(),
+	}
+}
+
+func setMapIndex(m, key reflect.Value, value any) {
+	k := convertTo(key, m.Type().Key())
+	v := convertTo(reflect.ValueOf(value), m.Type().Elem())
+	m.SetMapIndex(k, v)
+}
+
+func convertTo(v reflect.Value, t reflect.Type) reflect.Value {
+	if !v.IsValid() {
+		return reflect.Zero(t)
+	}
+	if v.Type() != t && !v.Type().AssignableTo(t) {
+		if !v.Type().ConvertibleTo(t) {
+			panic(fmt.Sprintf("cannot use %v as %v", v.Type(), t))
+		}
+		return v.Convert(t)
+	}
+	return v
+}
+
+// fetchOrNil fetches the value like runtime.Fetch, but returns nil for
+// missing fields and out of range indexes instead of panicking. Other
+// errors, like fetching from a number, still panic.
+func fetchOrNil(access *runtime.Access, from, i any) any {
+	if _, ok := runtime.AsGetter(from); !ok && missing(access, from, i) {
+		return nil
+	}
+	return access.Fetch(from, i)
+}
+
+// missing reports whether the field or the index i is missing in from.
+func missing(access *runtime.Access, from, i any) bool {
+	v := reflect.ValueOf(from)
+	if !v.IsValid() {
+		return true
+	}
+	name, isName := i.(string)
+	if isName && v.NumMethod() > 0 && v.MethodByName(name).IsValid() {
+		return false
+	}
+	v = deref.Value(v)
+	switch v.Kind() {
+	case reflect.Ptr:
+		return v.IsNil()
+	case reflect.Slice, reflect.Array, reflect.String:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return true
+		}
+		if !runtime.IsInteger(i) {
+			return false
+		}
+		index, l := runtime.ToInt(i), v.Len()
+		if index < 0 {
+			index += l
+		}
+		return index < 0 || index >= l
+	case reflect.Struct:
+		if !isName {
+			return false
+		}
+		_, ok := access.FieldByName(v.Type(), name)
+		return !ok
+	}
+	return false
+}
+
+// runes splits the string into one-rune strings, so it can be iterated.
+func runes(s string) []string {
+	out := make([]string, 0, len(s))
+	for _, r := range s {
+		out = append(out, string(r))
+	}
+	return out
+}
+
+// arguments converts the arguments of the call of the function, like the
+// OpCall instruction does, and panics if they do not fit its parameters.
+func (vm *VM) arguments(callee any, args []any) (reflect.Value, []reflect.Value) {
+	fn := reflect.ValueOf(callee)
+	if callee == nil {
+		panic("cannot call nil")
+	}
+	if fn.Kind() != reflect.Func {
+		panic(fmt.Sprintf("cannot call %T", callee))
+	}
+	in := make([]reflect.Value, len(args))
+	for i := range args {
+		if vm.converters != nil {
+			args[i] = vm.convertArg(fn.Type(), i, args[i])
+		}
+		if args[i] == nil {
+			// In case of nil value and nil type use this hack,
+			// otherwise reflect.Call will panic on zero value.
+			in[i] = reflect.ValueOf(&args[i]).Elem()
+		} else {
+			in[i] = reflect.ValueOf(args[i])
+		}
+	}
+	checkArguments(fn.Type(), in)
+	return fn, in
+}
+
+// results returns the result of the function call, and its error, if the
+// function returns one.
+func results(out []reflect.Value) (any, error) {
+	if len(out) == 2 && out[1].Type() == errorType && !out[1].IsNil() {
+		return nil, out[1].Interface().(error)
+	}
+	return out[0].Interface(), nil
+}
+
+// spread returns the arguments of the call like `fn(a, xs...)`, which are
+// the arguments followed by elements of the spread slice, and the call.
+func (vm *VM) spread(fn any, in []any, spread any) ([]any, func() (any, error)) {
+	s := reflect.ValueOf(spread)
+	size := 0
+	switch s.Kind() {
+	case reflect.Invalid:
+	case reflect.Slice, reflect.Array:
+		size = s.Len()
+		vm.memGrow(uint(size))
+		vm.alloc(size * anySize)
+	default:
+		panic(fmt.Sprintf("cannot spread %T", spread))
+	}
+	args := make([]any, len(in), len(in)+size)
+	copy(args, in)
+	for i := 0; i < size; i++ {
+		args = append(args, s.Index(i).Interface())
+	}
+
+	if fn, ok := fn.(Function); ok {
+		return args, func() (any, error) { return fn(args...) }
+	}
+
+	f := reflect.ValueOf(fn)
+	t := f.Type()
+	fixed := t.NumIn() - 1
+	variadic := t.In(fixed)
+	params := make([]reflect.Value, fixed+1)
+	for i := 0; i < fixed; i++ {
+		params[i] = spreadArg(reflect.ValueOf(in[i]), t.In(i))
+	}
+	if len(in) == fixed && s.Kind() == reflect.Slice && s.Type() == variadic {
+		params[fixed] = s
+	} else {
+		// Arguments after the fixed parameters are prepended to elements.
+		rest := reflect.MakeSlice(variadic, 0, len(in)-fixed+size)
+		for _, param := range in[fixed:] {
+			rest = reflect.Append(rest, spreadArg(reflect.ValueOf(param), variadic.Elem()))
+		}
+		for i := 0; i < size; i++ {
+			rest = reflect.Append(rest, spreadArg(s.Index(i), variadic.Elem()))
+		}
+		params[fixed] = rest
+	}
+	return args, func() (any, error) { return results(f.CallSlice(params)) }
+}
+
+// checkArguments panics with a readable error if the arguments do not fit
+// the parameters of the function, instead of the panic of reflect.Call.
+func checkArguments(t reflect.Type, in []reflect.Value) {
+	fixed := t.NumIn()
+	if t.IsVariadic() {
+		fixed--
+	}
+	if len(in) < fixed || len(in) > fixed && !t.IsVariadic() {
+		panic(fmt.Sprintf("invalid number of arguments (expected %d, got %d)", t.NumIn(), len(in)))
+	}
+	for i, arg := range in {
+		var param reflect.Type
+		if i < fixed {
+			param = t.In(i)
+		} else {
+			param = t.In(fixed).Elem()
+		}
+		if !arg.Type().AssignableTo(param) {
+			panic(fmt.Sprintf("cannot use %v as argument (type %v) to call function", arg.Type(), param))
+		}
+	}
+}
+
+// spreadArg converts the argument of the spread call to the parameter type.
+// Only numbers are converted, as other conversions change values.
+func spreadArg(v reflect.Value, t reflect.Type) reflect.Value {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	switch {
+	case !v.IsValid():
+		return reflect.Zero(t)
+	case v.Type().AssignableTo(t):
+		return v
+	case runtime.IsNumberKind(v.Kind()) && runtime.IsNumberKind(t.Kind()):
+		out := v.Convert(t)
+		if negative(v) != negative(out) || out.Convert(v.Type()).Interface() != v.Interface() {
+			panic(fmt.Sprintf("cannot use %v (%v) as argument (type %v)", v.Interface(), v.Type(), t))
+		}
+		return out
+	}
+	panic(fmt.Sprintf("cannot use %v as argument (type %v)", v.Type(), t))
+}
+
+// negative reports whether the number is below zero.
+func negative(v reflect.Value) bool {
+	switch {
+	case v.CanInt():
+		return v.Int() < 0
+	case v.CanFloat():
+		return v.Float() < 0
+	}
+	return false
+}
@@ -10,21 +10,35 @@ import (
 	"strings"
 	"time"
 
+	"github.com/expr-lang/expr/ast"
 	"github.com/expr-lang/expr/builtin"
 	"github.com/expr-lang/expr/file"
 	"github.com/expr-lang/expr/internal/deref"
 	"github.com/expr-lang/expr/vm/runtime"
 )
 
-func Run(program *Program, env any) (any, error) {
+func Run(program *Program, env any, opts ...Option) (any, error) {
 	if program == nil {
 		return nil, fmt.Errorf("program is nil")
 	}
 
-	vm := VM{}
+	vm := VM{options: opts}
+	for _, op := range opts {
+		op(&vm)
+	}
 	return vm.Run(program, env)
 }
 
+// New creates a VM with the options, which can run programs many times.
+// A VM is not safe for concurrent use.
+func New(opts ...Option) *VM {
+	vm := &VM{options: opts}
+	for _, op := range opts {
+		op(vm)
+	}
+	return vm
+}
+
 func Debug() *VM {
 	vm := &VM{
 		debug: true,
@@ -35,15 +49,59 @@ func Debug() *VM {
 }
 
 type VM struct {
-	Stack        []any
-	Scopes       []*Scope
-	Variables    []any
-	ip           int
-	memory       uint
-	memoryBudget uint
-	debug        bool
-	step         chan struct{}
-	curr         chan int
+	Stack         []any
+	Scopes        []*Scope
+	Variables     []any
+	ip            int
+	memory        uint
+	memoryBudget  uint
+	maxStackSize  int
+	maxScopeDepth int
+	maxSteps      int
+	steps         int
+	maxMemory     int
+	allocated     int
+	nilSafe       bool
+	gracefulNil   bool
+	tracer        Tracer
+	traceDepth    int
+	tracePopped   []any
+	callHook      CallHook
+	recoverCalls  bool
+	converters    map[reflect.Type][]conversion
+	overflow      Overflow
+	debug         bool
+	step          chan struct{}
+	curr          chan int
+	options       []Option
+	parent        *VM // VM of the outer run of a nested run.
+	nesting       int
+}
+
+// Reset drops values left by the previous run, so they can be garbage
+// collected, and keeps the allocated stack for the next run. Options of
+// the VM are kept.
+func (vm *VM) Reset() {
+	// Pops shrink the stack without clearing the slots, so the whole
+	// capacity is cleared.
+	vm.Stack = vm.Stack[:cap(vm.Stack)]
+	for i := range vm.Stack {
+		vm.Stack[i] = nil
+	}
+	vm.Stack = vm.Stack[:0]
+	vm.Scopes = vm.Scopes[:cap(vm.Scopes)]
+	for i := range vm.Scopes {
+		vm.Scopes[i] = nil
+	}
+	vm.Scopes = vm.Scopes[:0]
+	for i := range vm.Variables {
+		vm.Variables[i] = nil
+	}
+	vm.memoryBudget = MemoryBudget
+	vm.ip = 0
+	vm.memory = 0
+	vm.steps = 0
+	vm.allocated = 0
 }
 
 func (vm *VM) Run(program *Program, env any) (_ any, err error) {
@@ -66,29 +124,49 @@ func (vm *VM) Run(program *Program, env any) (_ any, err error) {
 
 	if vm.Stack == nil {
 		vm.Stack = make([]any, 0, 2)
-	} else {
-		vm.Stack = vm.Stack[0:0]
 	}
-	if vm.Scopes != nil {
-		vm.Scopes = vm.Scopes[0:0]
+	vm.Reset()
+	if vm.parent != nil {
+		// Nested runs are charged against the budgets of the outer run.
+		vm.steps, vm.memory, vm.allocated = vm.parent.steps, vm.parent.memory, vm.parent.allocated
 	}
 	if len(vm.Variables) < program.variables {
 		vm.Variables = make([]any, program.variables)
 	}
 
-	vm.memoryBudget = MemoryBudget
-	vm.memory = 0
-	vm.ip = 0
+	// Envs which resolve names lazily are typed by a declaration at compile
+	// time, so variables are loaded by name instead of by index.
+	getter, _ := runtime.AsGetter(env)
+
+	var nodes map[file.Location]ast.Node
+	var current int
+	if vm.tracer != nil {
+		nodes = traceNodes(program)
+	}
+	// Calls are intercepted by the call hook or to recover their panics.
+	intercept := vm.callHook != nil || vm.recoverCalls
 
 	for vm.ip < len(program.Bytecode) {
 		if debug && vm.debug {
 			<-vm.step
 		}
 
+		if vm.maxSteps > 0 {
+			vm.steps++
+			if vm.steps > vm.maxSteps {
+				panic(&LimitError{Resource: "operations", Limit: vm.maxSteps})
+			}
+		}
+
 		op := program.Bytecode[vm.ip]
 		arg := program.Arguments[vm.ip]
 		vm.ip += 1
 
+		if vm.tracer != nil {
+			vm.traceStart()
+			current = vm.ip - 1
+		}
+
 		switch op {
 
 		case OpInvalid:
@@ -110,15 +188,31 @@ func (vm *VM) Run(program *Program, env any) (_ any, err error) {
 			vm.push(vm.Variables[arg])
 
 		case OpLoadConst:
-			vm.push(runtime.Fetch(env, program.Constants[arg]))
+			if vm.gracefulNil {
+				vm.push(fetchOrNil(program.access, env, program.Constants[arg]))
+				break
+			}
+			vm.push(program.access.Fetch(env, program.Constants[arg]))
 
 		case OpLoadField:
+			if getter != nil {
+				vm.push(runtime.GetPath(getter, program.Constants[arg].(*runtime.Field).Path))
+				break
+			}
 			vm.push(runtime.FetchField(env, program.Constants[arg].(*runtime.Field)))
 
 		case OpLoadFast:
+			if getter != nil {
+				vm.push(runtime.Get(getter, program.Constants[arg].(string)))
+				break
+			}
 			vm.push(env.(map[string]any)[program.Constants[arg].(string)])
 
 		case OpLoadMethod:
+			if getter != nil {
+				vm.push(runtime.Get(getter, program.Constants[arg].(*runtime.Method).Name))
+				break
+			}
 			vm.push(runtime.FetchMethod(env, program.Constants[arg].(*runtime.Method)))
 
 		case OpLoadFunc:
@@ -127,10 +221,22 @@ func (vm *VM) Run(program *Program, env any) (_ any, err error) {
 		case OpFetch:
 			b := vm.pop()
 			a := vm.pop()
-			vm.push(runtime.Fetch(a, b))
+			if vm.nilSafe && runtime.IsNil(a) {
+				vm.push(nil)
+				break
+			}
+			if vm.gracefulNil {
+				vm.push(fetchOrNil(program.access, a, b))
+				break
+			}
+			vm.push(program.access.Fetch(a, b))
 
 		case OpFetchField:
 			a := vm.pop()
+			if vm.nilSafe && runtime.IsNil(a) {
+				vm.push(nil)
+				break
+			}
 			vm.push(runtime.FetchField(a, program.Constants[arg].(*runtime.Field)))
 
 		case OpLoadEnv:
@@ -150,8 +256,12 @@ func (vm *VM) Run(program *Program, env any) (_ any, err error) {
 			vm.push(nil)
 
 		case OpNegate:
-			v := runtime.Negate(vm.pop())
-			vm.push(v)
+			v := vm.pop()
+			if vm.overflow != OverflowWrap && runtime.IsInteger(v) {
+				vm.push(vm.negated(v))
+				break
+			}
+			vm.push(runtime.Negate(v))
 
 		case OpNot:
 			v := vm.pop().(bool)
@@ -160,16 +270,27 @@ func (vm *VM) Run(program *Program, env any) (_ any, err error) {
 		case OpEqual:
 			b := vm.pop()
 			a := vm.pop()
+			if vm.converters != nil {
+				a, b = vm.convertOperands(a, b)
+			}
 			vm.push(runtime.Equal(a, b))
 
 		case OpEqualInt:
 			b := vm.pop()
 			a := vm.pop()
+			if vm.gracefulNil && (a == nil || b == nil) {
+				vm.push(a == b)
+				break
+			}
 			vm.push(a.(int) == b.(int))
 
 		case OpEqualString:
 			b := vm.pop()
 			a := vm.pop()
+			if vm.gracefulNil && (a == nil || b == nil) {
+				vm.push(a == b)
+				break
+			}
 			vm.push(a.(string) == b.(string))
 
 		case OpJump:
@@ -207,58 +328,244 @@ func (vm *VM) Run(program *Program, env any) (_ any, err error) {
 		case OpIn:
 			b := vm.pop()
 			a := vm.pop()
-			vm.push(runtime.In(a, b))
+			vm.push(program.access.In(a, b))
 
 		case OpLess:
 			b := vm.pop()
 			a := vm.pop()
+			if vm.converters != nil {
+				a, b = vm.convertOperands(a, b)
+			}
+			if vm.gracefulNil && (runtime.IsNil(a) || runtime.IsNil(b)) {
+				vm.push(false)
+				break
+			}
 			vm.push(runtime.Less(a, b))
 
 		case OpMore:
 			b := vm.pop()
 			a := vm.pop()
+			if vm.converters != nil {
+				a, b = vm.convertOperands(a, b)
+			}
+			if vm.gracefulNil && (runtime.IsNil(a) || runtime.IsNil(b)) {
+				vm.push(false)
+				break
+			}
 			vm.push(runtime.More(a, b))
 
 		case OpLessOrEqual:
 			b := vm.pop()
 			a := vm.pop()
+			if vm.converters != nil {
+				a, b = vm.convertOperands(a, b)
+			}
+			if vm.gracefulNil && (runtime.IsNil(a) || runtime.IsNil(b)) {
+				vm.push(false)
+				break
+			}
 			vm.push(runtime.LessOrEqual(a, b))
 
 		case OpMoreOrEqual:
 			b := vm.pop()
 			a := vm.pop()
+			if vm.converters != nil {
+				a, b = vm.convertOperands(a, b)
+			}
+			if vm.gracefulNil && (runtime.IsNil(a) || runtime.IsNil(b)) {
+				vm.push(false)
+				break
+			}
 			vm.push(runtime.MoreOrEqual(a, b))
 
 		case OpAdd:
 			b := vm.pop()
 			a := vm.pop()
+			if vm.converters != nil {
+				a, b = vm.convertOperands(a, b)
+			}
+			if vm.maxMemory > 0 {
+				vm.allocStrings(a, b)
+			}
+			if vm.overflow != OverflowWrap && runtime.IsInteger(a) && runtime.IsInteger(b) {
+				vm.push(vm.checked("+", a, b))
+				break
+			}
 			vm.push(runtime.Add(a, b))
 
 		case OpSubtract:
 			b := vm.pop()
 			a := vm.pop()
+			if vm.converters != nil {
+				a, b = vm.convertOperands(a, b)
+			}
+			if vm.overflow != OverflowWrap && runtime.IsInteger(a) && runtime.IsInteger(b) {
+				vm.push(vm.checked("-", a, b))
+				break
+			}
 			vm.push(runtime.Subtract(a, b))
 
 		case OpMultiply:
 			b := vm.pop()
 			a := vm.pop()
+			if vm.converters != nil {
+				a, b = vm.convertOperands(a, b)
+			}
+			if vm.overflow != OverflowWrap && runtime.IsInteger(a) && runtime.IsInteger(b) {
+				vm.push(vm.checked("*", a, b))
+				break
+			}
 			vm.push(runtime.Multiply(a, b))
 
 		case OpDivide:
 			b := vm.pop()
 			a := vm.pop()
+			if vm.converters != nil {
+				a, b = vm.convertOperands(a, b)
+			}
+			if arg != 0 {
+				if out, ok := divideByZero(DivisionByZero(arg), "/", b); ok {
+					vm.push(out)
+					break
+				}
+			}
 			vm.push(runtime.Divide(a, b))
 
 		case OpModulo:
 			b := vm.pop()
 			a := vm.pop()
-			vm.push(runtime.Modulo(a, b))
+			if vm.converters != nil {
+				a, b = vm.convertOperands(a, b)
+			}
+			if arg != 0 {
+				if out, ok := divideByZero(DivisionByZero(arg), "%", b); ok {
+					vm.push(out)
+					break
+				}
+			}
+			vm.push(runtime.Remainder(a, b))
 
 		case OpExponent:
 			b := vm.pop()
 			a := vm.pop()
+			if vm.converters != nil {
+				a, b = vm.convertOperands(a, b)
+			}
 			vm.push(runtime.Exponent(a, b))
 
+		case OpLessInt:
+			b := vm.pop()
+			a := vm.pop()
+			vm.push(a.(int) < b.(int))
+
+		case OpMoreInt:
+			b := vm.pop()
+			a := vm.pop()
+			vm.push(a.(int) > b.(int))
+
+		case OpLessOrEqualInt:
+			b := vm.pop()
+			a := vm.pop()
+			vm.push(a.(int) <= b.(int))
+
+		case OpMoreOrEqualInt:
+			b := vm.pop()
+			a := vm.pop()
+			vm.push(a.(int) >= b.(int))
+
+		case OpAddInt:
+			b := vm.pop()
+			a := vm.pop()
+			if vm.overflow != OverflowWrap {
+				vm.push(vm.checked("+", a, b))
+				break
+			}
+			vm.push(a.(int) + b.(int))
+
+		case OpSubtractInt:
+			b := vm.pop()
+			a := vm.pop()
+			if vm.overflow != OverflowWrap {
+				vm.push(vm.checked("-", a, b))
+				break
+			}
+			vm.push(a.(int) - b.(int))
+
+		case OpMultiplyInt:
+			b := vm.pop()
+			a := vm.pop()
+			if vm.overflow != OverflowWrap {
+				vm.push(vm.checked("*", a, b))
+				break
+			}
+			vm.push(a.(int) * b.(int))
+
+		case OpDivideInt:
+			b := vm.pop()
+			a := vm.pop()
+			if arg != 0 {
+				if out, ok := divideByZero(DivisionByZero(arg), "/", b); ok {
+					vm.push(out)
+					break
+				}
+			}
+			vm.push(float64(a.(int)) / float64(b.(int)))
+
+		case OpEqualFloat:
+			b := vm.pop()
+			a := vm.pop()
+			if vm.gracefulNil && (a == nil || b == nil) {
+				vm.push(a == b)
+				break
+			}
+			vm.push(a.(float64) == b.(float64))
+
+		case OpLessFloat:
+			b := vm.pop()
+			a := vm.pop()
+			vm.push(a.(float64) < b.(float64))
+
+		case OpMoreFloat:
+			b := vm.pop()
+			a := vm.pop()
+			vm.push(a.(float64) > b.(float64))
+
+		case OpLessOrEqualFloat:
+			b := vm.pop()
+			a := vm.pop()
+			vm.push(a.(float64) <= b.(float64))
+
+		case OpMoreOrEqualFloat:
+			b := vm.pop()
+			a := vm.pop()
+			vm.push(a.(float64) >= b.(float64))
+
+		case OpAddFloat:
+			b := vm.pop()
+			a := vm.pop()
+			vm.push(a.(float64) + b.(float64))
+
+		case OpSubtractFloat:
+			b := vm.pop()
+			a := vm.pop()
+			vm.push(a.(float64) - b.(float64))
+
+		case OpMultiplyFloat:
+			b := vm.pop()
+			a := vm.pop()
+			vm.push(a.(float64) * b.(float64))
+
+		case OpDivideFloat:
+			b := vm.pop()
+			a := vm.pop()
+			if arg != 0 {
+				if out, ok := divideByZero(DivisionByZero(arg), "/", b); ok {
+					vm.push(out)
+					break
+				}
+			}
+			vm.push(a.(float64) / b.(float64))
+
 		case OpRange:
 			b := vm.pop()
 			a := vm.pop()
@@ -269,6 +576,7 @@ func (vm *VM) Run(program *Program, env any) (_ any, err error) {
 				size = 0
 			}
 			vm.memGrow(uint(size))
+			vm.alloc(size * intSize)
 			vm.push(runtime.MakeRange(min, max))
 
 		case OpMatches:
@@ -320,57 +628,84 @@ func (vm *VM) Run(program *Program, env any) (_ any, err error) {
 			}
 			vm.push(strings.HasSuffix(a.(string), b.(string)))
 
+		case OpConcat:
+			if vm.maxMemory > 0 {
+				vm.allocStrings(vm.Stack[len(vm.Stack)-arg:]...)
+			}
+			s := runtime.Concat(vm.Stack[len(vm.Stack)-arg:])
+			if vm.tracer != nil {
+				vm.traceChange(len(vm.Stack) - arg)
+			}
+			vm.Stack = vm.Stack[:len(vm.Stack)-arg]
+			vm.push(s)
+
 		case OpSlice:
 			from := vm.pop()
 			to := vm.pop()
 			node := vm.pop()
+			if vm.nilSafe && runtime.IsNil(node) {
+				vm.push(nil)
+				break
+			}
 			vm.push(runtime.Slice(node, from, to))
 
 		case OpCall:
-			fn := reflect.ValueOf(vm.pop())
-			size := arg
-			in := make([]reflect.Value, size)
-			for i := int(size) - 1; i >= 0; i-- {
-				param := vm.pop()
-				if param == nil && reflect.TypeOf(param) == nil {
-					// In case of nil value and nil type use this hack,
-					// otherwise reflect.Call will panic on zero value.
-					in[i] = reflect.ValueOf(&param).Elem()
-				} else {
-					in[i] = reflect.ValueOf(param)
-				}
+			if intercept {
+				vm.hookCall(program, op, arg)
+				break
 			}
-			out := fn.Call(in)
-			if len(out) == 2 && out[1].Type() == errorType && !out[1].IsNil() {
-				panic(out[1].Interface().(error))
+			callee := vm.pop()
+			fn, in := vm.arguments(callee, vm.popArgs(arg))
+			out, err := results(fn.Call(in))
+			if err != nil {
+				panic(err)
 			}
-			vm.push(out[0].Interface())
+			vm.push(out)
 
 		case OpCall0:
+			if intercept {
+				vm.hookCall(program, op, arg)
+				break
+			}
 			out, err := program.functions[arg]()
 			if err != nil {
 				panic(err)
 			}
+			vm.allocResult(out)
 			vm.push(out)
 
 		case OpCall1:
+			if intercept {
+				vm.hookCall(program, op, arg)
+				break
+			}
 			a := vm.pop()
 			out, err := program.functions[arg](a)
 			if err != nil {
 				panic(err)
 			}
+			vm.allocResult(out)
 			vm.push(out)
 
 		case OpCall2:
+			if intercept {
+				vm.hookCall(program, op, arg)
+				break
+			}
 			b := vm.pop()
 			a := vm.pop()
 			out, err := program.functions[arg](a, b)
 			if err != nil {
 				panic(err)
 			}
+			vm.allocResult(out)
 			vm.push(out)
 
 		case OpCall3:
+			if intercept {
+				vm.hookCall(program, op, arg)
+				break
+			}
 			c := vm.pop()
 			b := vm.pop()
 			a := vm.pop()
@@ -378,9 +713,14 @@ func (vm *VM) Run(program *Program, env any) (_ any, err error) {
 			if err != nil {
 				panic(err)
 			}
+			vm.allocResult(out)
 			vm.push(out)
 
 		case OpCallN:
+			if intercept {
+				vm.hookCall(program, op, arg)
+				break
+			}
 			fn := vm.pop().(Function)
 			size := arg
 			in := make([]any, size)
@@ -391,9 +731,14 @@ func (vm *VM) Run(program *Program, env any) (_ any, err error) {
 			if err != nil {
 				panic(err)
 			}
+			vm.allocResult(out)
 			vm.push(out)
 
 		case OpCallFast:
+			if intercept {
+				vm.hookCall(program, op, arg)
+				break
+			}
 			fn := vm.pop().(func(...any) any)
 			size := arg
 			in := make([]any, size)
@@ -403,37 +748,87 @@ func (vm *VM) Run(program *Program, env any) (_ any, err error) {
 			vm.push(fn(in...))
 
 		case OpCallSafe:
-			fn := vm.pop().(SafeFunction)
+			fn := vm.pop()
 			size := arg
 			in := make([]any, size)
 			for i := int(size) - 1; i >= 0; i-- {
 				in[i] = vm.pop()
 			}
-			out, mem, err := fn(in...)
+			var out any
+			var mem uint
+			var err error
+			if nested, ok := fn.(NestedFunction); ok {
+				out, mem, err = nested(vm.nested, in...)
+			} else {
+				out, mem, err = fn.(SafeFunction)(in...)
+			}
 			if err != nil {
 				panic(err)
 			}
 			vm.memGrow(mem)
+			if s, ok := out.(string); ok {
+				vm.alloc(len(s))
+			} else {
+				vm.alloc(int(mem) * anySize)
+			}
 			vm.push(out)
 
 		case OpCallTyped:
+			if intercept {
+				vm.hookCall(program, op, arg)
+				break
+			}
+			if vm.converters != nil {
+				vm.convertTyped(vm.current())
+			}
 			vm.push(vm.call(vm.pop(), arg))
 
+		case OpCallSpread:
+			if intercept {
+				vm.hookCall(program, op, arg)
+				break
+			}
+			fn := vm.pop()
+			spread := vm.pop()
+			_, call := vm.spread(fn, vm.popArgs(arg-1), spread)
+			out, err := call()
+			if err != nil {
+				panic(err)
+			}
+			vm.push(out)
+
 		case OpCallBuiltin1:
-			vm.push(builtin.Builtins[arg].Fast(vm.pop()))
+			out := builtin.Builtins[arg].Fast(vm.pop())
+			vm.allocResult(out)
+			vm.push(out)
 
 		case OpArray:
 			size := vm.pop().(int)
 			vm.memGrow(uint(size))
+			vm.alloc(size * anySize)
 			array := make([]any, size)
 			for i := size - 1; i >= 0; i-- {
 				array[i] = vm.pop()
 			}
 			vm.push(array)
 
+		case OpTypedArray:
+			size := vm.pop().(int)
+			vm.memGrow(uint(size))
+			t := program.Constants[arg].(reflect.Type)
+			vm.alloc(size * int(t.Elem().Size()))
+			array := reflect.MakeSlice(t, size, size)
+			for i := size - 1; i >= 0; i-- {
+				if v := vm.pop(); v != nil {
+					array.Index(i).Set(reflect.ValueOf(v))
+				}
+			}
+			vm.push(array.Interface())
+
 		case OpMap:
 			size := vm.pop().(int)
 			vm.memGrow(uint(size))
+			vm.alloc(size * entrySize)
 			m := make(map[string]any)
 			for i := size - 1; i >= 0; i-- {
 				value := vm.pop()
@@ -504,20 +899,47 @@ func (vm *VM) Run(program *Program, env any) (_ any, err error) {
 				vm.push(make(groupBy))
 			case 2:
 				scope := vm.scope()
-				var desc bool
-				switch vm.pop().(string) {
-				case "asc":
-					desc = false
-				case "desc":
-					desc = true
-				default:
-					panic("unknown order, use asc or desc")
-				}
 				vm.push(&runtime.SortBy{
-					Desc:   desc,
+					Desc:   sortOrder(vm.pop()),
 					Array:  make([]any, 0, scope.Len),
 					Values: make([]any, 0, scope.Len),
 				})
+			case 3:
+				// Partitions have the same type as the collection.
+				scope := vm.scope()
+				t := scope.Array.Type()
+				if t.Kind() == reflect.Array {
+					t = reflect.SliceOf(t.Elem())
+				}
+				parts := reflect.MakeSlice(reflect.SliceOf(t), 2, 2)
+				parts.Index(0).Set(reflect.MakeSlice(t, 0, 0))
+				parts.Index(1).Set(reflect.MakeSlice(t, 0, 0))
+				vm.push(parts.Interface())
+			case 4:
+				vm.push(make(countBy))
+			case 5:
+				scope := vm.scope()
+				sortBy := &runtime.SortBy{
+					Multi:  true,
+					Array:  make([]any, 0, scope.Len),
+					Values: make([]any, 0, scope.Len),
+				}
+				orders := reflect.ValueOf(vm.pop())
+				if orders.Kind() == reflect.String {
+					sortBy.Desc = sortOrder(orders.Interface())
+				} else {
+					sortBy.KeysDesc = make([]bool, 0, orders.Len())
+					for i := 0; i < orders.Len(); i++ {
+						sortBy.KeysDesc = append(sortBy.KeysDesc, sortOrder(orders.Index(i).Interface()))
+					}
+				}
+				vm.push(sortBy)
+			case 6:
+				// Type of the map is derived by the checker.
+				scope := vm.scope()
+				vm.memGrow(uint(scope.Len))
+				vm.alloc(scope.Len * entrySize)
+				vm.push(reflect.MakeMapWithSize(vm.pop().(reflect.Type), scope.Len).Interface())
 			default:
 				panic(fmt.Sprintf("unknown OpCreate argument %v", arg))
 			}
@@ -533,18 +955,53 @@ func (vm *VM) Run(program *Program, env any) (_ any, err error) {
 			value := vm.pop()
 			item := scope.Array.Index(scope.Index).Interface()
 			sortable := scope.Acc.(*runtime.SortBy)
+			if sortable.Multi && sortable.KeysDesc != nil {
+				// Orders from variables are checked here, literal ones by the checker.
+				if keys := reflect.ValueOf(value).Len(); keys != len(sortable.KeysDesc) {
+					panic(fmt.Sprintf("expected %d orders, got %d", keys, len(sortable.KeysDesc)))
+				}
+			}
 			sortable.Array = append(sortable.Array, item)
 			sortable.Values = append(sortable.Values, value)
 
 		case OpSort:
 			scope := vm.scope()
 			sortable := scope.Acc.(*runtime.SortBy)
-			sort.Sort(sortable)
+			sort.Stable(sortable)
 			vm.memGrow(uint(scope.Len))
+			vm.alloc(scope.Len * anySize)
 			vm.push(sortable.Array)
 
+		case OpPartition:
+			scope := vm.scope()
+			part := reflect.ValueOf(scope.Acc).Index(1)
+			if vm.pop().(bool) {
+				part = reflect.ValueOf(scope.Acc).Index(0)
+			}
+			part.Set(reflect.Append(part, scope.Array.Index(scope.Index)))
+
+		case OpCountBy:
+			scope := vm.scope()
+			scope.Acc.(countBy)[vm.pop()]++
+
+		case OpGetKey:
+			scope := vm.scope()
+			if !scope.Keys.IsValid() {
+				panic(fmt.Sprintf("cannot use #key with %v", scope.Array.Type()))
+			}
+			vm.push(scope.Keys.Index(scope.Index).Interface())
+
+		case OpMapValues:
+			scope := vm.scope()
+			setMapIndex(reflect.ValueOf(scope.Acc), scope.Keys.Index(scope.Index), vm.pop())
+
+		case OpMapKeys:
+			scope := vm.scope()
+			setMapIndex(reflect.ValueOf(scope.Acc), reflect.ValueOf(vm.pop()), scope.Values.Index(scope.Index).Interface())
+
 		case OpProfileStart:
 			span := program.Constants[arg].(*Span)
+			span.Hits++
 			span.start = time.Now()
 
 		case OpProfileEnd:
@@ -552,8 +1009,25 @@ func (vm *VM) Run(program *Program, env any) (_ any, err error) {
 			span.Duration += time.Since(span.start).Nanoseconds()
 
 		case OpBegin:
+			if vm.maxScopeDepth > 0 && len(vm.Scopes) >= vm.maxScopeDepth {
+				panic(&LimitError{Resource: "nested scopes", Limit: vm.maxScopeDepth})
+			}
 			a := vm.pop()
 			array := reflect.ValueOf(a)
+			if array.Kind() == reflect.Map {
+				// Maps are iterated by values, or by keys if arg is 1.
+				scope := mapScope(array)
+				scope.Array = scope.Values
+				if arg == 1 {
+					scope.Array = scope.Keys
+				}
+				vm.Scopes = append(vm.Scopes, scope)
+				break
+			}
+			// Strings are iterated by runes if arg is 2.
+			if arg == 2 && array.Kind() == reflect.String {
+				array = reflect.ValueOf(runes(array.String()))
+			}
 			vm.Scopes = append(vm.Scopes, &Scope{
 				Array: array,
 				Len:   array.Len(),
@@ -563,7 +1037,11 @@ func (vm *VM) Run(program *Program, env any) (_ any, err error) {
 			vm.Scopes = vm.Scopes[:len(vm.Scopes)-1]
 
 		default:
-			panic(fmt.Sprintf("unknown bytecode %#x", op))
+			panic(fmt.Sprintf("unknown bytecode %#x", byte(op)))
+		}
+
+		if vm.tracer != nil {
+			vm.trace(program, nodes, current)
 		}
 
 		if debug && vm.debug {
@@ -584,6 +1062,9 @@ func (vm *VM) Run(program *Program, env any) (_ any, err error) {
 }
 
 func (vm *VM) push(value any) {
+	if vm.maxStackSize > 0 && len(vm.Stack) >= vm.maxStackSize {
+		panic(&LimitError{Resource: "stack slots", Limit: vm.maxStackSize})
+	}
 	vm.Stack = append(vm.Stack, value)
 }
 
@@ -593,6 +1074,9 @@ func (vm *VM) current() any {
 
 func (vm *VM) pop() any {
 	value := vm.Stack[len(vm.Stack)-1]
+	if vm.tracer != nil {
+		vm.traceChange(len(vm.Stack) - 1)
+	}
 	vm.Stack = vm.Stack[:len(vm.Stack)-1]
 	return value
 }
@@ -604,6 +1088,76 @@ func (vm *VM) memGrow(size uint) {
 	}
 }
 
+// Estimated sizes of values stored in slices and maps built by the VM.
+const (
+	intSize   = 8
+	anySize   = 16
+	entrySize = 32 // A string key and an interface value.
+)
+
+// alloc counts bytes allocated by the program, if the memory is limited.
+func (vm *VM) alloc(bytes int) {
+	if vm.maxMemory <= 0 {
+		return
+	}
+	vm.allocated += bytes
+	if vm.allocated > vm.maxMemory {
+		panic(&LimitError{Resource: "bytes of memory", Limit: vm.maxMemory})
+	}
+}
+
+// nested runs the program of a nested function, like unquote(), on a new VM
+// with the options of the VM. Steps and memory of the nested run count
+// towards the limits of the VM.
+func (vm *VM) nested(program *Program, env any) (any, error) {
+	if vm.nesting >= MaxNesting {
+		return nil, &LimitError{Resource: "nested runs", Limit: MaxNesting}
+	}
+	child := New(vm.options...)
+	child.parent = vm
+	child.nesting = vm.nesting + 1
+	defer func() {
+		vm.steps, vm.memory, vm.allocated = child.steps, child.memory, child.allocated
+	}()
+	return child.Run(program, env)
+}
+
+// allocResult counts the bytes of the string, the array or the map returned
+// by a builtin or a function of the program, if the memory is limited.
+func (vm *VM) allocResult(out any) {
+	if vm.maxMemory <= 0 {
+		return
+	}
+	switch x := out.(type) {
+	case string:
+		vm.alloc(len(x))
+	case []any:
+		vm.alloc(len(x) * anySize)
+	default:
+		v := reflect.ValueOf(out)
+		switch v.Kind() {
+		case reflect.Slice, reflect.Array:
+			vm.alloc(v.Len() * int(v.Type().Elem().Size()))
+		case reflect.Map:
+			vm.alloc(v.Len() * entrySize)
+		}
+	}
+}
+
+// allocStrings counts the bytes of the string built from the values, if all
+// of them are strings.
+func (vm *VM) allocStrings(values ...any) {
+	size := 0
+	for _, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			return
+		}
+		size += len(s)
+	}
+	vm.alloc(size)
+}
+
 func (vm *VM) scope() *Scope {
 	return vm.Scopes[len(vm.Scopes)-1]
 }
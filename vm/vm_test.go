@@ -1,17 +1,22 @@
 package vm_test
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/expr-lang/expr/internal/testify/require"
 
 	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/ast"
 	"github.com/expr-lang/expr/checker"
 	"github.com/expr-lang/expr/compiler"
 	"github.com/expr-lang/expr/conf"
+	"github.com/expr-lang/expr/file"
 	"github.com/expr-lang/expr/parser"
 	"github.com/expr-lang/expr/vm"
 )
@@ -35,6 +40,34 @@ func TestRun_ReuseVM(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestVM_Reset(t *testing.T) {
+	program, err := expr.Compile(`let xs = map(1..3, # * 2); xs[10]`)
+	require.NoError(t, err)
+
+	v := vm.New(vm.MaxSteps(1000))
+	_, err = v.Run(program, nil)
+	require.Error(t, err)
+
+	v.Reset()
+	require.Empty(t, v.Stack)
+	require.Empty(t, v.Scopes)
+	for _, value := range v.Stack[:cap(v.Stack)] {
+		require.Nil(t, value)
+	}
+	for _, scope := range v.Scopes[:cap(v.Scopes)] {
+		require.Nil(t, scope)
+	}
+	for _, variable := range v.Variables {
+		require.Nil(t, variable)
+	}
+
+	program, err = expr.Compile(`sum(filter(1..100, # % 2 == 0))`)
+	require.NoError(t, err)
+	_, err = v.Run(program, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "expression exceeded 1000 operations")
+}
+
 func TestRun_ReuseVM_for_different_variables(t *testing.T) {
 	v := vm.VM{}
 
@@ -259,3 +292,511 @@ func TestRun_OpInvalid(t *testing.T) {
 	_, err := vm.Run(program, nil)
 	require.EqualError(t, err, "invalid opcode")
 }
+
+func TestRun_MaxStackSize(t *testing.T) {
+	program, err := expr.Compile(`filter(1..100, # > 10)`)
+	require.NoError(t, err)
+
+	_, err = vm.Run(program, nil, vm.MaxStackSize(100))
+	require.NoError(t, err)
+
+	_, err = vm.Run(program, nil, vm.MaxStackSize(50))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "expression exceeded 50 stack slots")
+
+	var limitErr *vm.LimitError
+	require.True(t, errors.As(err, &limitErr))
+	require.Equal(t, "stack slots", limitErr.Resource)
+	require.Equal(t, 50, limitErr.Limit)
+}
+
+func TestRun_MaxScopeDepth(t *testing.T) {
+	program, err := expr.Compile(`map(1..2, map(1..2, map(1..2, #)))`)
+	require.NoError(t, err)
+
+	_, err = vm.Run(program, nil, vm.MaxScopeDepth(3))
+	require.NoError(t, err)
+
+	_, err = vm.Run(program, nil, vm.MaxScopeDepth(2))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "expression exceeded 2 nested scopes")
+
+	var limitErr *vm.LimitError
+	require.True(t, errors.As(err, &limitErr))
+}
+
+func TestRun_MaxSteps(t *testing.T) {
+	program, err := expr.Compile(`sum(filter(1..100, # % 2 == 0))`)
+	require.NoError(t, err)
+
+	_, err = vm.Run(program, nil, vm.MaxSteps(10000))
+	require.NoError(t, err)
+
+	_, err = vm.Run(program, nil, vm.MaxSteps(100))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "expression exceeded 100 operations")
+
+	var limitErr *vm.LimitError
+	require.True(t, errors.As(err, &limitErr))
+	require.Equal(t, "operations", limitErr.Resource)
+}
+
+func TestRun_MaxMemory(t *testing.T) {
+	tests := []struct {
+		code string
+		err  string
+	}{
+		{`1..10000`, "expression exceeded 1000 bytes of memory"},
+		{`let s2 = s + s; let s4 = s2 + s2; s4 + s4`, "expression exceeded 1000 bytes of memory"},
+		{`map(1..100, [#, #])`, "expression exceeded 1000 bytes of memory"},
+		{`map(1..10, # * 2)`, ""},
+		{`len(join([s, s, s, s, s, s, s, s, s, s, s], ""))`, "expression exceeded 1000 bytes of memory"},
+		{`len(repeat(s, 20))`, "expression exceeded 1000 bytes of memory"},
+		{`[upper(s), lower(s), trim(s), upper(s), lower(s), trim(s), upper(s), lower(s), trim(s), upper(s), lower(s)]`, "expression exceeded 1000 bytes of memory"},
+		{`toJSON(s)`, ""},
+	}
+
+	env := map[string]any{"s": strings.Repeat("x", 100)}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			program, err := expr.Compile(tt.code, expr.Env(env))
+			require.NoError(t, err)
+
+			_, err = vm.Run(program, env)
+			require.NoError(t, err)
+
+			_, err = vm.Run(program, env, vm.MaxMemory(1000))
+			if tt.err == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.err)
+
+			var limitErr *vm.LimitError
+			require.True(t, errors.As(err, &limitErr))
+			require.Equal(t, "bytes of memory", limitErr.Resource)
+		})
+	}
+}
+
+func TestRun_error_locations(t *testing.T) {
+	type User struct {
+		Name string
+	}
+	env := map[string]any{
+		"m":     map[string]any{"a": 1},
+		"user":  (*User)(nil),
+		"s":     "abc",
+		"xs":    []int{1},
+		"fn":    nil,
+		"add":   func(a, b int) int { return a + b },
+		"fail":  func() (int, error) { return 0, errors.New("boom") },
+		"value": nil,
+	}
+
+	tests := []struct {
+		code string
+		err  string
+	}{
+		{"1 +\n m.a.b", "cannot fetch b from int (2:6)\n |  m.a.b\n | .....^"},
+		{`user.Name`, "cannot fetch Name from nil *vm_test.User (1:6)\n | user.Name\n | .....^"},
+		{`int(s)`, "invalid operation: int(abc) (1:1)\n | int(s)\n | ^"},
+		{`xs[0] + fail()`, "boom (1:9)\n | xs[0] + fail()\n | ........^"},
+		{`xs[5]`, "index out of range: 5 (array length is 1) (1:3)\n | xs[5]\n | ..^"},
+		{`s.x()`, "cannot fetch x from string (1:3)\n | s.x()\n | ..^"},
+		{`fn(1)`, "cannot call nil (1:1)\n | fn(1)\n | ^"},
+		{`s(1)`, "cannot call string (1:1)\n | s(1)\n | ^"},
+		{`add(1)`, "invalid number of arguments (expected 2, got 1) (1:1)\n | add(1)\n | ^"},
+		{`add(1, s)`, "cannot use string as argument (type int) to call function (1:1)\n | add(1, s)\n | ^"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			program, err := expr.Compile(tt.code)
+			require.NoError(t, err)
+
+			_, err = vm.Run(program, env)
+			require.Error(t, err)
+			require.Equal(t, tt.err, err.Error())
+
+			var fileErr *file.Error
+			require.True(t, errors.As(err, &fileErr))
+		})
+	}
+}
+
+func TestRun_WithTracer(t *testing.T) {
+	env := map[string]any{"age": 20, "country": "DE"}
+	program, err := expr.Compile(`age >= 18 && country == "NL"`, expr.Env(env))
+	require.NoError(t, err)
+
+	var log []string
+	var steps int
+	var compare vm.Step
+	tracer := vm.TracerFunc(func(step vm.Step) {
+		steps++
+		if n, ok := step.Node.(*ast.BinaryNode); ok && n.Operator != "&&" {
+			log = append(log, fmt.Sprintf("%v = %v", n, step.Result()))
+		}
+		if n, ok := step.Node.(*ast.BinaryNode); ok && n.Operator == ">=" {
+			compare = step
+		}
+	})
+	out, err := vm.Run(program, env, vm.WithTracer(tracer))
+	require.NoError(t, err)
+	require.Equal(t, false, out)
+	require.Equal(t, len(program.Bytecode), steps)
+	require.Equal(t, []string{`age >= 18 = true`, `country == "NL" = false`}, log)
+	require.Equal(t, 0, compare.Depth)
+	require.Equal(t, []any{20, 18}, compare.Popped)
+	require.Equal(t, []any{true}, compare.Pushed)
+}
+
+type hookEnv struct {
+	Prefix string
+}
+
+func (e hookEnv) Greet(name string) string {
+	return e.Prefix + name
+}
+
+func (hookEnv) Sum(xs ...int) int {
+	sum := 0
+	for _, x := range xs {
+		sum += x
+	}
+	return sum
+}
+
+func (hookEnv) Fetch(url string) (string, error) {
+	return "", fmt.Errorf("cannot reach %v", url)
+}
+
+func TestRun_WithCallHook(t *testing.T) {
+	double := expr.Function("double", func(params ...any) (any, error) {
+		return params[0].(int) * 2, nil
+	}, new(func(int) int))
+	program, err := expr.Compile(`Greet("Bob") + string(double(Sum(1, 2)))`, expr.Env(hookEnv{}), double)
+	require.NoError(t, err)
+
+	var calls []string
+	hook := func(call vm.Call, next func() (any, error)) (any, error) {
+		out, err := next()
+		calls = append(calls, fmt.Sprintf("%v%v = %v", call.Name, call.Args, out))
+		return out, err
+	}
+	out, err := vm.Run(program, hookEnv{Prefix: "Hi, "}, vm.WithCallHook(hook))
+	require.NoError(t, err)
+	require.Equal(t, "Hi, Bob6", out)
+	require.Equal(t, []string{"Greet[Bob] = Hi, Bob", "Sum[1 2] = 3", "double[3] = 6"}, calls)
+
+	t.Run("error", func(t *testing.T) {
+		program, err := expr.Compile(`Fetch("http://example.com")`, expr.Env(hookEnv{}))
+		require.NoError(t, err)
+
+		var failed error
+		hook := func(call vm.Call, next func() (any, error)) (any, error) {
+			out, err := next()
+			failed = err
+			return out, err
+		}
+		_, err = vm.Run(program, hookEnv{}, vm.WithCallHook(hook))
+		require.EqualError(t, failed, "cannot reach http://example.com")
+		require.ErrorIs(t, err, failed)
+	})
+
+	t.Run("deny", func(t *testing.T) {
+		denied := errors.New("rate limit exceeded")
+		hook := func(call vm.Call, next func() (any, error)) (any, error) {
+			if call.Name == "Sum" {
+				return nil, denied
+			}
+			return next()
+		}
+		_, err := vm.Run(program, hookEnv{}, vm.WithCallHook(hook))
+		require.ErrorIs(t, err, denied)
+		require.Contains(t, err.Error(), "rate limit exceeded (1:30)")
+	})
+}
+
+type money struct {
+	cents int
+}
+
+func TestRun_WithConverter(t *testing.T) {
+	// Converted values are declared as any to pass the type checker.
+	type Env struct {
+		Price  any
+		Count  any
+		Bad    any
+		Double func(int) int
+		Label  func(float64, string) string
+	}
+	env := Env{
+		Price:  money{cents: 1050},
+		Count:  json.Number("3"),
+		Bad:    json.Number("three"),
+		Double: func(x int) int { return x * 2 },
+		Label:  func(x float64, unit string) string { return fmt.Sprintf("%.2f %v", x, unit) },
+	}
+	options := []vm.Option{
+		vm.WithConverter(reflect.TypeOf(money{}), reflect.TypeOf(0.0), func(value any) (any, error) {
+			return float64(value.(money).cents) / 100, nil
+		}),
+		vm.WithConverter(reflect.TypeOf(json.Number("")), reflect.TypeOf(0), func(value any) (any, error) {
+			n, err := value.(json.Number).Int64()
+			return int(n), err
+		}),
+	}
+
+	tests := []struct {
+		code string
+		want any
+	}{
+		{`Price * 2`, 21.0},
+		{`Price > 10`, true},
+		{`Price == 10.5`, true},
+		{`Price + Price`, 21.0},
+		{`Price * Price > 100`, true},
+		{`Count + 1`, 4},
+		{`Double(Count)`, 6},
+		{`Label(Price, "EUR")`, "10.50 EUR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			program, err := expr.Compile(tt.code, expr.Env(env))
+			require.NoError(t, err)
+
+			out, err := vm.Run(program, env, options...)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, out)
+
+			out, err = vm.Run(program, env)
+			if err == nil {
+				require.NotEqual(t, tt.want, out)
+			}
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		program, err := expr.Compile(`Bad + 1`, expr.Env(env))
+		require.NoError(t, err)
+
+		_, err = vm.Run(program, env, options...)
+		require.EqualError(t, err, "strconv.ParseInt: parsing \"three\": invalid syntax (1:5)\n | Bad + 1\n | ....^")
+	})
+
+	t.Run("call hook", func(t *testing.T) {
+		program, err := expr.Compile(`Label(Price, "EUR") + string(Double(Count))`, expr.Env(env))
+		require.NoError(t, err)
+
+		var args [][]any
+		hook := func(call vm.Call, next func() (any, error)) (any, error) {
+			args = append(args, call.Args)
+			return next()
+		}
+		out, err := vm.Run(program, env, append(options, vm.WithCallHook(hook))...)
+		require.NoError(t, err)
+		require.Equal(t, "10.50 EUR6", out)
+		require.Equal(t, [][]any{{10.5, "EUR"}, {3}}, args)
+	})
+}
+
+func TestRun_StrictArithmetic(t *testing.T) {
+	env := map[string]any{
+		"max":   math.MaxInt,
+		"min":   math.MinInt,
+		"big":   int64(math.MaxInt64),
+		"items": []int{math.MaxInt, 1},
+		"u64":   uint64(math.MaxUint64),
+		"i8":    int8(100),
+		"neg":   int8(math.MinInt8),
+	}
+
+	tests := []struct {
+		code     string
+		saturate int
+		err      string
+	}{
+		{`max + 1`, math.MaxInt, "integer overflow: 9223372036854775807 + 1 (1:5)"},
+		{`min - 1`, math.MinInt, "integer overflow: -9223372036854775808 - 1 (1:5)"},
+		{`max * 2`, math.MaxInt, "integer overflow: 9223372036854775807 * 2 (1:5)"},
+		{`min * -1`, math.MaxInt, "integer overflow: -9223372036854775808 * -1 (1:5)"},
+		{`max * -2`, math.MinInt, "integer overflow: 9223372036854775807 * -2 (1:5)"},
+		{`big + 1`, math.MaxInt, "integer overflow: 9223372036854775807 + 1 (1:5)"},
+		{`items[0] + items[1]`, math.MaxInt, "integer overflow: 9223372036854775807 + 1 (1:10)"},
+		{`max - 1`, math.MaxInt - 1, ""},
+		{`u64 + 0`, math.MaxInt, "integer overflow: 18446744073709551615 + 0 (1:5)"},
+		{`i8 + i8`, math.MaxInt8, "integer overflow: 100 + 100 (1:4)"},
+		{`i8 + 1`, 101, ""},
+		{`-min`, math.MaxInt, "integer overflow: -(-9223372036854775808) (1:1)"},
+		{`-neg`, math.MaxInt8, "integer overflow: -(-128) (1:1)"},
+		{`-i8`, -100, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			program, err := expr.Compile(tt.code, expr.Env(env))
+			require.NoError(t, err)
+
+			_, err = vm.Run(program, env)
+			require.NoError(t, err)
+
+			out, err := vm.Run(program, env, vm.StrictArithmetic(vm.OverflowSaturate))
+			require.NoError(t, err)
+			require.Equal(t, tt.saturate, out)
+
+			out, err = vm.Run(program, env, vm.StrictArithmetic(vm.OverflowError))
+			if tt.err == "" {
+				require.NoError(t, err)
+				require.Equal(t, tt.saturate, out)
+				return
+			}
+			require.ErrorIs(t, err, vm.ErrIntegerOverflow)
+			require.Equal(t, tt.err, strings.Split(err.Error(), "\n")[0])
+		})
+	}
+
+	t.Run("typed", func(t *testing.T) {
+		type Env struct {
+			Max int
+		}
+		program, err := expr.Compile(`Max * 3 - 1`, expr.Env(Env{}))
+		require.NoError(t, err)
+		require.Contains(t, program.Bytecode, vm.OpMultiplyInt)
+
+		out, err := vm.Run(program, Env{Max: math.MaxInt}, vm.StrictArithmetic(vm.OverflowSaturate))
+		require.NoError(t, err)
+		require.Equal(t, math.MaxInt-1, out)
+
+		_, err = vm.Run(program, Env{Max: math.MaxInt}, vm.StrictArithmetic(vm.OverflowError))
+		require.ErrorIs(t, err, vm.ErrIntegerOverflow)
+	})
+}
+
+type panicEnv struct{}
+
+func (panicEnv) Ratio(a, b int) int {
+	return a / b
+}
+
+func TestRun_RecoverCalls(t *testing.T) {
+	errBroken := errors.New("broken")
+	broken := expr.Function("broken", func(params ...any) (any, error) {
+		panic(errBroken)
+	}, new(func() int))
+
+	program, err := expr.Compile(`1 + Ratio(1, 0)`, expr.Env(panicEnv{}))
+	require.NoError(t, err)
+
+	_, err = vm.Run(program, panicEnv{}, vm.RecoverCalls())
+	var p *vm.PanicError
+	require.ErrorAs(t, err, &p)
+	require.Equal(t, "Ratio", p.Function)
+	require.NotEmpty(t, p.Stack)
+	require.Equal(t, "panic in Ratio: runtime error: integer divide by zero (1:5)\n | 1 + Ratio(1, 0)\n | ....^", err.Error())
+
+	program, err = expr.Compile(`broken()`, broken)
+	require.NoError(t, err)
+
+	_, err = vm.Run(program, nil, vm.RecoverCalls())
+	require.ErrorIs(t, err, errBroken)
+	require.Contains(t, err.Error(), "panic in broken: broken")
+}
+
+func TestRun_NilSafe(t *testing.T) {
+	type User struct {
+		Name string
+	}
+	env := map[string]any{
+		"tags":  []string(nil),
+		"attrs": map[string]string(nil),
+		"user":  (*User)(nil),
+		"value": nil,
+	}
+
+	tests := []struct {
+		code string
+		err  string
+	}{
+		{`tags[0]`, "cannot fetch 0 from nil []string (1:5)"},
+		{`tags[1:]`, ""},
+		{`attrs["key"]`, ""},
+		{`user.Name`, "cannot fetch Name from nil *vm_test.User (1:6)"},
+		{`value[0]`, "cannot fetch 0 from nil (1:6)"},
+		{`value[1:2]`, "cannot slice nil (1:6)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			program, err := expr.Compile(tt.code)
+			require.NoError(t, err)
+
+			_, err = vm.Run(program, env)
+			if tt.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				require.Equal(t, tt.err, strings.Split(err.Error(), "\n")[0])
+			}
+
+			out, err := vm.Run(program, env, vm.NilSafe())
+			require.NoError(t, err)
+			require.Nil(t, out)
+		})
+	}
+}
+
+func TestRun_GracefulNil(t *testing.T) {
+	type Event struct {
+		Kind string
+	}
+	env := map[string]any{
+		"event": map[string]any{"kind": "click", "tags": []any{"a"}},
+		"typed": Event{Kind: "click"},
+	}
+
+	tests := []struct {
+		code string
+		want any
+	}{
+		{`event.user.name`, nil},
+		{`event.tags[5]`, nil},
+		{`typed.Missing`, nil},
+		{`event.size > 10`, false},
+		{`event.size <= 10`, false},
+		{`event.size > 10 || event.kind == "click"`, true},
+		{`event.tags[5] ?? "none"`, "none"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			program, err := expr.Compile(tt.code)
+			require.NoError(t, err)
+
+			_, err = vm.Run(program, env)
+			require.Error(t, err)
+
+			out, err := vm.Run(program, env, vm.GracefulNil())
+			require.NoError(t, err)
+			require.Equal(t, tt.want, out)
+		})
+	}
+	program, err := expr.Compile(`event.kind.size`)
+	require.NoError(t, err)
+	_, err = vm.Run(program, env, vm.GracefulNil())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot fetch size from string")
+
+	typedEnv := struct {
+		Event *Event
+	}{}
+	for _, code := range []string{`Event?.Kind == "click"`, `Event?.Kind != Event?.Kind`} {
+		program, err = expr.Compile(code, expr.Env(typedEnv))
+		require.NoError(t, err)
+		out, err := vm.Run(program, typedEnv, vm.GracefulNil())
+		require.NoError(t, err)
+		require.Equal(t, false, out)
+	}
+}